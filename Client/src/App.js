@@ -9,6 +9,8 @@ import {
 } from "react-router-dom";
 import RegisterForm from "./components/RegisterForm";
 import LoginForm from "./components/LoginForm";
+import VerifyEmail from "./components/VerifyEmail";
+import ResetPassword from "./components/ResetPassword";
 import AddProductCategory from "./components/AddProductCategory";
 import AddProduct from "./components/AddProduct";
 import AddServiceCategory from "./components/AddServiceCategory";
@@ -17,7 +19,9 @@ import Shop from "./components/Shop";
 import Cart from "./components/Cart";
 import ServiceBooking from "./components/ServiceBooking";
 import OrderPage from "./components/OrderPage";
+import PaymentResult from "./components/PaymentResult";
 import OrderBookingServiceManagement from "./components/OrderBookingServiceManagement";
+import { logout } from "./auth";
 import "bootstrap/dist/css/bootstrap.min.css";
 import {
   AppBar,
@@ -106,9 +110,8 @@ function AppContent() {
     }
   };
 
-  const handleLogout = () => {
-    localStorage.removeItem("user");
-    localStorage.removeItem("token");
+  const handleLogout = async () => {
+    await logout();
     setUser(null);
     setCartCount(0);
     setCartItems([]);
@@ -193,6 +196,14 @@ function AppContent() {
               <LoginForm setUser={setUser} updateCartCount={updateCartCount} />
             }
           />
+          <Route
+            path="/oauth/callback"
+            element={
+              <LoginForm setUser={setUser} updateCartCount={updateCartCount} />
+            }
+          />
+          <Route path="/verify-email" element={<VerifyEmail />} />
+          <Route path="/reset-password" element={<ResetPassword />} />
 
           <Route
             path="/shop"
@@ -208,6 +219,7 @@ function AppContent() {
             }
           />
           <Route path="/order" element={<OrderPage />} />
+          <Route path="/payment/result" element={<PaymentResult />} />
           <Route path="/service-booking" element={<ServiceBooking />} />
 
           {user && user.role === 0 && (
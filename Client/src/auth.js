@@ -0,0 +1,69 @@
+import axios from "axios";
+
+const API_URL = "http://localhost:8080/api";
+
+let refreshing = null;
+
+// refreshTokens exchanges the stored refresh token for a new token pair.
+// Concurrent callers share a single request, because each refresh token can
+// only be used once.
+export function refreshTokens() {
+  if (!refreshing) {
+    const refreshToken = localStorage.getItem("refreshToken");
+    refreshing = axios
+      .post(`${API_URL}/token/refresh`, { refresh_token: refreshToken }, {
+        skipAuthRefresh: true,
+      })
+      .then((response) => {
+        localStorage.setItem("token", response.data.token);
+        localStorage.setItem("refreshToken", response.data.refresh_token);
+        return response.data.token;
+      })
+      .finally(() => {
+        refreshing = null;
+      });
+  }
+  return refreshing;
+}
+
+export async function logout() {
+  const refreshToken = localStorage.getItem("refreshToken");
+  if (refreshToken) {
+    try {
+      await axios.post(`${API_URL}/logout`, { refresh_token: refreshToken }, {
+        skipAuthRefresh: true,
+      });
+    } catch (error) {
+      console.error("Error logging out:", error);
+    }
+  }
+  localStorage.removeItem("user");
+  localStorage.removeItem("token");
+  localStorage.removeItem("refreshToken");
+}
+
+// Retry requests that failed with an expired access token once, after
+// refreshing it.
+axios.interceptors.response.use(undefined, async (error) => {
+  const { config, response } = error;
+  if (
+    !response ||
+    response.status !== 401 ||
+    !config ||
+    config.skipAuthRefresh ||
+    config._retried ||
+    !localStorage.getItem("refreshToken")
+  ) {
+    return Promise.reject(error);
+  }
+
+  try {
+    const token = await refreshTokens();
+    config._retried = true;
+    config.headers.Authorization = `Bearer ${token}`;
+    return axios(config);
+  } catch (refreshError) {
+    await logout();
+    return Promise.reject(error);
+  }
+});
@@ -0,0 +1,8 @@
+// cartIssueMessages describes the issues the server flags on cart and
+// selected item lines.
+export const cartIssueMessages = {
+  price_changed: "Giá đã thay đổi",
+  out_of_stock: "Đã hết hàng",
+  insufficient_stock: "Không đủ hàng trong kho",
+  unavailable: "Sản phẩm không còn bán",
+};
@@ -23,6 +23,7 @@ import {
   FormControl,
 } from "@mui/material";
 import { FaEdit, FaTrash } from "react-icons/fa";
+import { amountOf, formatMoney } from "../money";
 
 const PRODUCTS_PER_PAGE = 20;
 
@@ -35,6 +36,7 @@ function AddProduct() {
     name: "",
     price: "",
     stock: "",
+    weight: "",
     productcategory: "",
     image: null,
   });
@@ -53,8 +55,8 @@ function AddProduct() {
 
   const fetchProducts = async () => {
     try {
-      const response = await axios.get("http://localhost:8080/api/products");
-      setProducts(response.data || []);
+      const response = await axios.get("http://localhost:8080/api/products?limit=100");
+      setProducts(response.data.items || []);
     } catch (error) {
       console.error("Error fetching products:", error);
       setProducts([]);
@@ -64,9 +66,9 @@ function AddProduct() {
   const fetchProductCategories = async () => {
     try {
       const response = await axios.get(
-        "http://localhost:8080/api/productcategories"
+        "http://localhost:8080/api/productcategories?limit=100"
       );
-      setProductCategories(response.data || []);
+      setProductCategories(response.data.items || []);
     } catch (error) {
       console.error("Error fetching product categories:", error);
       setProductCategories([]);
@@ -92,9 +94,9 @@ function AddProduct() {
     }
 
     if (sortOrder === "asc") {
-      filtered = filtered.sort((a, b) => a.price - b.price);
+      filtered = filtered.sort((a, b) => amountOf(a.price) - amountOf(b.price));
     } else if (sortOrder === "desc") {
-      filtered = filtered.sort((a, b) => b.price - a.price);
+      filtered = filtered.sort((a, b) => amountOf(b.price) - amountOf(a.price));
     }
 
     setFilteredProducts(filtered || []);
@@ -132,6 +134,7 @@ function AddProduct() {
     formDataToSend.append("name", formData.name);
     formDataToSend.append("price", formData.price);
     formDataToSend.append("stock", formData.stock);
+    formDataToSend.append("weight", formData.weight || 0);
     formDataToSend.append("productcategory", formData.productcategory);
     if (formData.image) {
       formDataToSend.append("image", formData.image);
@@ -165,6 +168,7 @@ function AddProduct() {
     formDataToSend.append("name", formData.name);
     formDataToSend.append("price", formData.price);
     formDataToSend.append("stock", formData.stock);
+    formDataToSend.append("weight", formData.weight || 0);
     formDataToSend.append("productcategory", formData.productcategory);
     if (formData.image) {
       formDataToSend.append("image", formData.image);
@@ -200,8 +204,9 @@ function AddProduct() {
   const handleEdit = (product) => {
     setFormData({
       name: product.name,
-      price: product.price,
+      price: amountOf(product.price),
       stock: product.stock,
+      weight: product.weight || "",
       productcategory: product.productcategory,
       image: null,
     });
@@ -246,6 +251,7 @@ function AddProduct() {
       name: "",
       price: "",
       stock: "",
+      weight: "",
       productcategory: "",
       image: null,
     });
@@ -261,7 +267,10 @@ function AddProduct() {
     const { name, value } = e.target;
     setFormData({
       ...formData,
-      [name]: name === "price" || name === "stock" ? parseFloat(value) : value,
+      [name]:
+        name === "price" || name === "stock" || name === "weight"
+          ? parseFloat(value)
+          : value,
     });
   };
 
@@ -295,6 +304,7 @@ function AddProduct() {
             name: "",
             price: "",
             stock: "",
+            weight: "",
             productcategory: "",
             image: null,
           });
@@ -359,7 +369,7 @@ function AddProduct() {
                     {(currentPage - 1) * PRODUCTS_PER_PAGE + index + 1}
                   </TableCell>
                   <TableCell>{product.name}</TableCell>
-                  <TableCell>{product.price}</TableCell>
+                  <TableCell>{formatMoney(product.price)}</TableCell>
                   <TableCell>{product.stock}</TableCell>
                   <TableCell>
                     {category ? category.name : "Danh mục không tồn tại"}
@@ -444,6 +454,14 @@ function AddProduct() {
             fullWidth
             required
           />
+          <TextField
+            margin="dense"
+            label="Khối lượng (gram)"
+            name="weight"
+            value={formData.weight}
+            onChange={handleChange}
+            fullWidth
+          />
           <InputLabel id="productcategory-label">Danh mục sản phẩm</InputLabel>
           <Select
             labelId="productcategory-label"
@@ -36,14 +36,14 @@ function AddProductCategory() {
   const fetchProductCategories = async () => {
     try {
       const response = await axios.get(
-        "http://localhost:8080/api/productcategories",
+        "http://localhost:8080/api/productcategories?limit=100",
         {
           headers: {
             Authorization: `Bearer ${token}`,
           },
         }
       );
-      setProductCategories(response.data);
+      setProductCategories(response.data.items || []);
     } catch (error) {
       console.error("Error fetching product categories:", error);
     }
@@ -23,6 +23,7 @@ import {
   FormControl,
 } from "@mui/material";
 import { FaEdit, FaTrash } from "react-icons/fa";
+import { amountOf, formatMoney } from "../money";
 
 const SERVICES_PER_PAGE = 20;
 
@@ -56,12 +57,12 @@ function AddService() {
 
   const fetchServices = async () => {
     try {
-      const response = await axios.get("http://localhost:8080/api/services", {
+      const response = await axios.get("http://localhost:8080/api/services?limit=100", {
         headers: {
           Authorization: `Bearer ${token}`,
         },
       });
-      setServices(response.data || []);
+      setServices(response.data.items || []);
     } catch (error) {
       console.error("Error fetching services:", error);
       setServices([]);
@@ -71,14 +72,14 @@ function AddService() {
   const fetchServiceCategories = async () => {
     try {
       const response = await axios.get(
-        "http://localhost:8080/api/servicecategories",
+        "http://localhost:8080/api/servicecategories?limit=100",
         {
           headers: {
             Authorization: `Bearer ${token}`,
           },
         }
       );
-      setServiceCategories(response.data || []);
+      setServiceCategories(response.data.items || []);
     } catch (error) {
       console.error("Error fetching service categories:", error);
       setServiceCategories([]);
@@ -104,9 +105,9 @@ function AddService() {
     }
 
     if (sortOrder === "asc") {
-      filtered = filtered.sort((a, b) => a.price - b.price);
+      filtered = filtered.sort((a, b) => amountOf(a.price) - amountOf(b.price));
     } else if (sortOrder === "desc") {
-      filtered = filtered.sort((a, b) => b.price - a.price);
+      filtered = filtered.sort((a, b) => amountOf(b.price) - amountOf(a.price));
     }
 
     setFilteredServices(filtered);
@@ -276,7 +277,7 @@ function AddService() {
   const handleEdit = (service) => {
     setFormData({
       name: service.name,
-      price: service.price,
+      price: amountOf(service.price),
       description: service.description,
       servicecategory: service.servicecategory,
       image: null,
@@ -377,7 +378,7 @@ function AddService() {
                       {(currentPage - 1) * SERVICES_PER_PAGE + index + 1}
                     </TableCell>
                     <TableCell>{service.name}</TableCell>
-                    <TableCell>{service.price}</TableCell>
+                    <TableCell>{formatMoney(service.price)}</TableCell>
                     <TableCell>{service.description}</TableCell>
                     <TableCell>
                       {category ? category.name : "Danh mục không tồn tại"}
@@ -36,9 +36,9 @@ function AddServiceCategory() {
   const fetchServiceCategories = async () => {
     try {
       const response = await axios.get(
-        "http://localhost:8080/api/servicecategories"
+        "http://localhost:8080/api/servicecategories?limit=100"
       );
-      setServiceCategories(response.data);
+      setServiceCategories(response.data.items || []);
     } catch (error) {
       console.error("Error fetching service categories:", error);
     }
@@ -14,9 +14,12 @@ import {
 } from "@mui/material";
 import { Delete } from "@mui/icons-material";
 import axios from "axios";
+import { amountOf } from "../money";
+import { cartIssueMessages } from "../cartIssues";
 
 function Cart({ updateCartCount, setCartCount }) {
   const [cartItems, setCartItems] = useState([]);
+  const [cartHasIssues, setCartHasIssues] = useState(false);
   const [user, setUser] = useState(null);
   const [snackbar, setSnackbar] = useState({
     open: false,
@@ -35,6 +38,7 @@ function Cart({ updateCartCount, setCartCount }) {
         headers: { Authorization: `Bearer ${localStorage.getItem("token")}` },
       });
       setCartItems(response.data.items || []);
+      setCartHasIssues(response.data.has_issues);
       updateCartCount();
 
       const selectedResponse = await axios.get(
@@ -169,9 +173,6 @@ function Cart({ updateCartCount, setCartCount }) {
       const selectedProducts = cartItems.map((item) => ({
         product_id: item.product_id,
         quantity: item.quantity,
-        price: item.price,
-        name: item.name,
-        imageurl: item.imageurl,
       }));
 
       try {
@@ -217,9 +218,6 @@ function Cart({ updateCartCount, setCartCount }) {
           {
             product_id: productId,
             quantity: selectedProduct.quantity,
-            price: selectedProduct.price,
-            name: selectedProduct.name,
-            imageurl: selectedProduct.imageurl,
           },
           {
             headers: {
@@ -238,7 +236,7 @@ function Cart({ updateCartCount, setCartCount }) {
   const calculateTotalPrice = () => {
     const totalPrice = cartItems
       .filter((item) => selectedItems.includes(item.product_id))
-      .reduce((sum, item) => sum + item.price * item.quantity, 0);
+      .reduce((sum, item) => sum + amountOf(item.price) * item.quantity, 0);
     return totalPrice.toLocaleString();
   };
 
@@ -266,6 +264,22 @@ function Cart({ updateCartCount, setCartCount }) {
     }
   };
 
+  const handleRefreshPrices = async () => {
+    try {
+      const response = await axios.post(
+        "http://localhost:8080/api/cart/refresh",
+        {},
+        {
+          headers: { Authorization: `Bearer ${localStorage.getItem("token")}` },
+        }
+      );
+      setCartItems(response.data.items || []);
+      setCartHasIssues(response.data.has_issues);
+    } catch (error) {
+      console.error("Error refreshing cart prices", error);
+    }
+  };
+
   if (!user) {
     return (
       <div>
@@ -282,6 +296,22 @@ function Cart({ updateCartCount, setCartCount }) {
       <h2>Giỏ hàng</h2>
       {cartItems.length > 0 ? (
         <>
+          {cartHasIssues && (
+            <Alert
+              severity="warning"
+              action={
+                <Button
+                  color="inherit"
+                  size="small"
+                  onClick={handleRefreshPrices}
+                >
+                  Cập nhật giá
+                </Button>
+              }
+            >
+              Một số sản phẩm trong giỏ hàng đã thay đổi giá hoặc không đủ hàng.
+            </Alert>
+          )}
           <Table
             sx={{
               borderCollapse: "collapse",
@@ -345,6 +375,11 @@ function Cart({ updateCartCount, setCartCount }) {
                     }}
                   >
                     {item.name}
+                    {(item.issues || []).map((issue) => (
+                      <div key={issue} style={{ color: "red" }}>
+                        {cartIssueMessages[issue]}
+                      </div>
+                    ))}
                   </td>
                   <td
                     style={{
@@ -411,7 +446,7 @@ function Cart({ updateCartCount, setCartCount }) {
                   <td
                     style={{ textAlign: "center", border: "1px solid black" }}
                   >
-                    {(item.price * item.quantity).toLocaleString()} VND
+                    {amountOf(item.line_total).toLocaleString()} VND
                   </td>
                   <td
                     style={{ textAlign: "center", border: "1px solid black" }}
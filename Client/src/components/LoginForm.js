@@ -1,49 +1,214 @@
-import React, { useState } from "react";
+import React, { useEffect, useState } from "react";
 import { Link } from "react-router-dom";
 import { jwtDecode } from "jwt-decode";
 
+const oauthErrors = {
+  cancelled: "Bạn đã hủy đăng nhập",
+  email_unverified: "Tài khoản liên kết chưa có email đã xác nhận",
+  account_unverified:
+    "Email này đã được đăng ký nhưng chưa xác nhận. Vui lòng xác nhận email hoặc đăng nhập bằng mật khẩu",
+};
+
 function LoginForm({ setUser, updateCartCount }) {
   const [email, setEmail] = useState("");
   const [password, setPassword] = useState("");
   const [error, setError] = useState(null);
+  const [challenge, setChallenge] = useState(null);
+  const [setup, setSetup] = useState(null);
+  const [code, setCode] = useState("");
+  const [useRecoveryCode, setUseRecoveryCode] = useState(false);
+  const [recoveryCodes, setRecoveryCodes] = useState(null);
+  const [providers, setProviders] = useState([]);
 
-  const handleSubmit = async (e) => {
-    e.preventDefault();
+  const post = (path, body) =>
+    fetch(`http://localhost:8080/api${path}`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify(body),
+    });
 
-    try {
-      const response = await fetch("http://localhost:8080/api/login", {
-        method: "POST",
-        headers: { "Content-Type": "application/json" },
-        body: JSON.stringify({ email, password }),
-      });
+  const finishLogin = (userData) => {
+    if (userData.token) {
+      localStorage.setItem("token", userData.token);
+      localStorage.setItem("refreshToken", userData.refresh_token);
+
+      const decodedToken = jwtDecode(userData.token);
+      const userRole = decodedToken.role;
 
-      if (response.ok) {
-        const userData = await response.json();
+      localStorage.setItem("userRole", userRole);
+      setUser({ ...userData, role: userRole });
 
-        if (userData.token) {
-          localStorage.setItem("token", userData.token);
+      updateCartCount();
+    }
 
-          const decodedToken = jwtDecode(userData.token);
-          const userRole = decodedToken.role;
+    localStorage.setItem("user", JSON.stringify(userData));
+    setUser(userData);
+    window.location.href = "/";
+  };
 
-          localStorage.setItem("userRole", userRole);
-          setUser({ ...userData, role: userRole });
+  const handleResponse = async (response) => {
+    const data = await response.json();
+    if (!response.ok) {
+      setError(data.error || data.message || "Đăng nhập không thành công");
+      return;
+    }
 
-          updateCartCount();
+    if (data.two_factor_required || data.two_factor_setup_required) {
+      setError(null);
+      setChallenge(data);
+      if (data.two_factor_setup_required) {
+        const setupResponse = await post("/login/2fa/setup", {
+          challenge_token: data.challenge_token,
+        });
+        const setupData = await setupResponse.json();
+        if (!setupResponse.ok) {
+          setError(setupData.error || "Không thể thiết lập xác thực hai bước");
+          return;
         }
-
-        localStorage.setItem("user", JSON.stringify(userData));
-        setUser(userData);
-        window.location.href = "/";
-      } else {
-        const errorData = await response.json();
-        setError(errorData.message || "Đăng nhập không thành công");
+        setSetup(setupData);
       }
+      return;
+    }
+
+    if (data.recovery_codes) {
+      setRecoveryCodes(data);
+      return;
+    }
+    finishLogin(data);
+  };
+
+  useEffect(() => {
+    fetch("http://localhost:8080/api/oauth/providers")
+      .then((response) => response.json())
+      .then((data) => setProviders(data.providers || []))
+      .catch(() => setProviders([]));
+
+    // A social login comes back to /oauth/callback with a one-time token,
+    // or an error code, in the URL fragment.
+    const params = new URLSearchParams(window.location.hash.slice(1));
+    if (params.get("token")) {
+      window.history.replaceState(null, "", window.location.pathname);
+      post("/oauth/login", { token: params.get("token") })
+        .then(handleResponse)
+        .catch(() => setError("Đã xảy ra lỗi"));
+    } else if (params.get("error")) {
+      window.history.replaceState(null, "", window.location.pathname);
+      setError(
+        oauthErrors[params.get("error")] ||
+          "Đăng nhập bằng tài khoản liên kết không thành công"
+      );
+    }
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, []);
+
+  const handleSubmit = async (e) => {
+    e.preventDefault();
+
+    try {
+      await handleResponse(await post("/login", { email, password }));
+    } catch (err) {
+      setError("Đã xảy ra lỗi");
+    }
+  };
+
+  const handleCodeSubmit = async (e) => {
+    e.preventDefault();
+
+    const body = { challenge_token: challenge.challenge_token };
+    if (useRecoveryCode) {
+      body.recovery_code = code;
+    } else {
+      body.code = code;
+    }
+
+    try {
+      const path = setup ? "/login/2fa/enable" : "/login/2fa";
+      await handleResponse(await post(path, body));
     } catch (err) {
       setError("Đã xảy ra lỗi");
     }
   };
 
+  if (recoveryCodes) {
+    return (
+      <div className="container">
+        <h2>Mã khôi phục</h2>
+        <p>
+          Hãy lưu các mã sau ở nơi an toàn. Mỗi mã dùng được một lần để đăng
+          nhập khi bạn không có ứng dụng xác thực.
+        </p>
+        <ul>
+          {recoveryCodes.recovery_codes.map((recoveryCode) => (
+            <li key={recoveryCode}>
+              <code>{recoveryCode}</code>
+            </li>
+          ))}
+        </ul>
+        <button
+          className="btn btn-primary"
+          onClick={() => finishLogin(recoveryCodes)}
+        >
+          Tôi đã lưu mã
+        </button>
+      </div>
+    );
+  }
+
+  if (challenge) {
+    return (
+      <div className="container">
+        <h2>Xác thực hai bước</h2>
+        {error && <div className="alert alert-danger">{error}</div>}
+        {setup && (
+          <div className="mb-3">
+            <p>
+              Tài khoản của bạn yêu cầu xác thực hai bước. Thêm khóa sau vào
+              ứng dụng xác thực, rồi nhập mã 6 số.
+            </p>
+            <p>
+              <code>{setup.secret}</code>
+            </p>
+            <p>
+              <a href={setup.otpauth_uri}>Mở bằng ứng dụng xác thực</a>
+            </p>
+          </div>
+        )}
+        <form onSubmit={handleCodeSubmit}>
+          <div className="mb-3">
+            <label className="form-label">
+              {useRecoveryCode ? "Mã khôi phục" : "Mã xác thực"}
+            </label>
+            <input
+              type="text"
+              className="form-control"
+              value={code}
+              onChange={(e) => setCode(e.target.value)}
+              autoComplete="one-time-code"
+              required
+            />
+          </div>
+          <button type="submit" className="btn btn-primary">
+            Xác nhận
+          </button>
+        </form>
+        {!setup && (
+          <div className="mt-3">
+            <button
+              type="button"
+              className="btn btn-link p-0"
+              onClick={() => {
+                setUseRecoveryCode(!useRecoveryCode);
+                setCode("");
+              }}
+            >
+              {useRecoveryCode ? "Dùng mã xác thực" : "Dùng mã khôi phục"}
+            </button>
+          </div>
+        )}
+      </div>
+    );
+  }
+
   return (
     <div className="container">
       <h2>Đăng nhập</h2>
@@ -74,12 +239,31 @@ function LoginForm({ setUser, updateCartCount }) {
         </button>
       </form>
 
+      {providers.length > 0 && (
+        <div className="mt-3 d-flex gap-2">
+          {providers.map((provider) => (
+            <a
+              key={provider}
+              href={`http://localhost:8080/api/oauth/${provider}/start`}
+              className="btn btn-outline-secondary"
+            >
+              Đăng nhập với {provider.charAt(0).toUpperCase() + provider.slice(1)}
+            </a>
+          ))}
+        </div>
+      )}
+
       <div className="mt-3">
         Bạn chưa có tài khoản?{" "}
         <Link to="/register" className="text-primary">
           Đăng ký ngay!
         </Link>
       </div>
+      <div className="mt-1">
+        <Link to="/reset-password" className="text-primary">
+          Quên mật khẩu?
+        </Link>
+      </div>
     </div>
   );
 }
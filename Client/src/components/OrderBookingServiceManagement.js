@@ -13,6 +13,7 @@ import {
   Select,
   MenuItem,
 } from "@mui/material";
+import { formatMoney } from "../money";
 
 function OrderBookingServiceManagement() {
   const [orders, setOrders] = useState([]);
@@ -26,8 +27,8 @@ function OrderBookingServiceManagement() {
 
   const fetchServices = async () => {
     try {
-      const response = await axios.get("http://localhost:8080/api/services");
-      setServices(response.data || []);
+      const response = await axios.get("http://localhost:8080/api/services?limit=100");
+      setServices(response.data.items || []);
     } catch (error) {
       console.error("Error fetching services:", error);
     }
@@ -37,12 +38,12 @@ function OrderBookingServiceManagement() {
     try {
       const token = localStorage.getItem("token");
       const response = await axios.get(
-        "http://localhost:8080/api/orderbookingservices",
+        "http://localhost:8080/api/admin/orderbookingservices?limit=100",
         {
           headers: { Authorization: `Bearer ${token}` },
         }
       );
-      setOrders(response.data || []);
+      setOrders(response.data.items || []);
       setLoading(false);
     } catch (error) {
       console.error("Error fetching order bookings:", error);
@@ -100,7 +101,7 @@ function OrderBookingServiceManagement() {
                 <TableCell>{index + 1}</TableCell>
                 <TableCell>{getServiceName(order.service_id)}</TableCell>
                 <TableCell>{order.quantity}</TableCell>
-                <TableCell>{order.total_price}</TableCell>
+                <TableCell>{formatMoney(order.total_price)}</TableCell>
                 <TableCell>
                   {new Date(order.booking_date).toLocaleDateString()}
                 </TableCell>
@@ -112,11 +113,11 @@ function OrderBookingServiceManagement() {
                       handleStatusChange(order.id, e.target.value)
                     }
                   >
-                    <MenuItem value="Chờ xác nhận">Chờ xác nhận</MenuItem>
-                    <MenuItem value="Đã xác nhận">Đã xác nhận</MenuItem>
-                    <MenuItem value="Đang tiến hành">Đang tiến hành</MenuItem>
-                    <MenuItem value="Hoàn thành">Hoàn thành</MenuItem>
-                    <MenuItem value="Đã hủy">Đã hủy</MenuItem>
+                    <MenuItem value="pending">Chờ xác nhận</MenuItem>
+                    <MenuItem value="confirmed">Đã xác nhận</MenuItem>
+                    <MenuItem value="in_progress">Đang tiến hành</MenuItem>
+                    <MenuItem value="completed">Hoàn thành</MenuItem>
+                    <MenuItem value="cancelled">Đã hủy</MenuItem>
                   </Select>
                 </TableCell>
               </TableRow>
@@ -9,13 +9,20 @@ import {
   DialogTitle,
   DialogContent,
   DialogActions,
+  TextField,
 } from "@mui/material";
 import axios from "axios";
+import { amountOf, formatMoney } from "../money";
+import { cartIssueMessages } from "../cartIssues";
 
 function OrderPage() {
   const navigate = useNavigate();
   const [selectedProducts, setSelectedProducts] = useState([]);
   const [openDialog, setOpenDialog] = useState(false);
+  const [couponCode, setCouponCode] = useState("");
+  const [appliedCoupon, setAppliedCoupon] = useState("");
+  const [province, setProvince] = useState("");
+  const [quote, setQuote] = useState(null);
   const [snackbar, setSnackbar] = useState({
     open: false,
     message: "",
@@ -35,6 +42,9 @@ function OrderPage() {
         );
         if (response.data.items) {
           setSelectedProducts(response.data.items);
+          setAppliedCoupon(response.data.coupon_code || "");
+          setCouponCode(response.data.coupon_code || "");
+          fetchQuote("");
         } else {
           setSnackbar({
             open: true,
@@ -55,14 +65,73 @@ function OrderPage() {
     fetchSelectedItems();
   }, []);
 
+  // The server prices the order, with shipping and VAT, exactly as it will
+  // when the order is placed.
+  const fetchQuote = async (shippingProvince) => {
+    try {
+      const response = await axios.post(
+        "http://localhost:8080/api/order/quote",
+        { shipping: { province: shippingProvince } },
+        {
+          headers: { Authorization: `Bearer ${localStorage.getItem("token")}` },
+        }
+      );
+      setQuote(response.data);
+    } catch (error) {
+      setQuote(null);
+      if (error.response?.data?.fields?.[0]?.field === "coupon_code") {
+        setSnackbar({
+          open: true,
+          message: error.response.data.fields[0].message,
+          severity: "warning",
+        });
+      }
+    }
+  };
+
   const calculateTotalPrice = () => {
     const totalPrice = selectedProducts.reduce(
-      (sum, item) => sum + item.price * item.quantity,
+      (sum, item) => sum + amountOf(item.price) * item.quantity,
       0
     );
     return totalPrice.toLocaleString();
   };
 
+  const handleApplyCoupon = async () => {
+    try {
+      const response = await axios.post(
+        "http://localhost:8080/api/selecteditems/coupon",
+        { coupon_code: couponCode },
+        {
+          headers: { Authorization: `Bearer ${localStorage.getItem("token")}` },
+        }
+      );
+      setAppliedCoupon(response.data.coupon_code);
+      fetchQuote(province);
+    } catch (error) {
+      setSnackbar({
+        open: true,
+        message:
+          error.response?.data?.fields?.[0]?.message ||
+          "Không áp dụng được mã giảm giá.",
+        severity: "error",
+      });
+    }
+  };
+
+  const handleRemoveCoupon = async () => {
+    try {
+      await axios.delete("http://localhost:8080/api/selecteditems/coupon", {
+        headers: { Authorization: `Bearer ${localStorage.getItem("token")}` },
+      });
+    } catch (error) {
+      console.error("Error removing coupon", error);
+    }
+    setAppliedCoupon("");
+    setCouponCode("");
+    fetchQuote(province);
+  };
+
   const handleOrderConfirmation = () => {
     setOpenDialog(true);
   };
@@ -89,7 +158,7 @@ function OrderPage() {
 
       const orderResponse = await axios.post(
         "http://localhost:8080/api/order",
-        { items: selectedProducts },
+        { shipping: { province } },
         {
           headers: { Authorization: `Bearer ${localStorage.getItem("token")}` },
         }
@@ -106,6 +175,26 @@ function OrderPage() {
           headers: { Authorization: `Bearer ${localStorage.getItem("token")}` },
         });
 
+        // Send the customer to the payment gateway. When online payment is
+        // turned off the order simply stays pending.
+        try {
+          const paymentResponse = await axios.post(
+            `http://localhost:8080/api/order/${orderResponse.data.id}/pay`,
+            {},
+            {
+              headers: {
+                Authorization: `Bearer ${localStorage.getItem("token")}`,
+              },
+            }
+          );
+          window.location.href = paymentResponse.data.payment_url;
+          return;
+        } catch (error) {
+          if (error.response?.status !== 503) {
+            console.error("Error starting payment", error);
+          }
+        }
+
         setOpenDialog(false);
         navigate("/shop");
         window.location.reload();
@@ -167,6 +256,11 @@ function OrderPage() {
                     style={{ textAlign: "center", border: "1px solid black" }}
                   >
                     {item.name}
+                    {(item.issues || []).map((issue) => (
+                      <div key={issue} style={{ color: "red" }}>
+                        {cartIssueMessages[issue]}
+                      </div>
+                    ))}
                   </td>
                   <td
                     style={{ textAlign: "center", border: "1px solid black" }}
@@ -176,14 +270,63 @@ function OrderPage() {
                   <td
                     style={{ textAlign: "center", border: "1px solid black" }}
                   >
-                    {(item.price * item.quantity).toLocaleString()} VND
+                    {amountOf(item.line_total).toLocaleString()} VND
                   </td>
                 </tr>
               ))}
             </tbody>
           </Table>
 
-          <h3>Tổng cộng: {calculateTotalPrice()} VND</h3>
+          <div style={{ marginTop: "20px" }}>
+            <TextField
+              size="small"
+              label="Tỉnh/Thành phố"
+              value={province}
+              onChange={(e) => setProvince(e.target.value)}
+              onBlur={() => fetchQuote(province)}
+              sx={{ marginRight: "20px" }}
+            />
+            <TextField
+              size="small"
+              label="Mã giảm giá"
+              value={couponCode}
+              onChange={(e) => setCouponCode(e.target.value)}
+              disabled={appliedCoupon !== ""}
+            />
+            {appliedCoupon ? (
+              <Button onClick={handleRemoveCoupon} sx={{ marginLeft: "10px" }}>
+                Bỏ mã
+              </Button>
+            ) : (
+              <Button
+                onClick={handleApplyCoupon}
+                disabled={!couponCode.trim()}
+                sx={{ marginLeft: "10px" }}
+              >
+                Áp dụng
+              </Button>
+            )}
+          </div>
+
+          {quote ? (
+            <>
+              <p>Tạm tính: {formatMoney(quote.subtotal)}</p>
+              {amountOf(quote.discount) > 0 && (
+                <p>
+                  Giảm giá ({quote.coupon_code}): -
+                  {formatMoney(quote.discount)}
+                </p>
+              )}
+              <p>Phí vận chuyển: {formatMoney(quote.shipping_fee)}</p>
+              <p>
+                VAT{quote.vat_included ? " (đã gồm trong giá)" : ""}:{" "}
+                {formatMoney(quote.vat)}
+              </p>
+              <h3>Tổng cộng: {formatMoney(quote.total_price)}</h3>
+            </>
+          ) : (
+            <h3>Tổng cộng: {calculateTotalPrice()} VND</h3>
+          )}
 
           <Button
             variant="contained"
@@ -0,0 +1,35 @@
+import React from "react";
+import { Link } from "react-router-dom";
+
+const outcomes = {
+  succeeded: [
+    "success",
+    "Thanh toán thành công, đơn hàng của bạn đang được xử lý.",
+  ],
+  failed: ["danger", "Thanh toán không thành công hoặc đã bị hủy."],
+  invalid: ["danger", "Kết quả thanh toán không hợp lệ."],
+  error: [
+    "warning",
+    "Chưa ghi nhận được kết quả thanh toán, vui lòng kiểm tra lại đơn hàng sau ít phút.",
+  ],
+};
+
+// The server sends the customer here from the payment gateway with the
+// outcome in the URL fragment.
+function PaymentResult() {
+  const params = new URLSearchParams(window.location.hash.slice(1));
+  const [severity, message] = outcomes[params.get("status")] || outcomes.error;
+
+  return (
+    <div className="container">
+      <h2>Kết quả thanh toán</h2>
+      <div className={`alert alert-${severity}`}>{message}</div>
+      {params.get("order") && <p>Mã đơn hàng: {params.get("order")}</p>}
+      <Link to="/shop" className="text-primary">
+        Tiếp tục mua sắm
+      </Link>
+    </div>
+  );
+}
+
+export default PaymentResult;
@@ -0,0 +1,88 @@
+import React, { useState } from "react";
+import { Link, useSearchParams } from "react-router-dom";
+
+function ResetPassword() {
+  const [searchParams] = useSearchParams();
+  const token = searchParams.get("token");
+  const [email, setEmail] = useState("");
+  const [password, setPassword] = useState("");
+  const [message, setMessage] = useState(null);
+  const [error, setError] = useState(null);
+
+  const handleSubmit = async (e) => {
+    e.preventDefault();
+    setMessage(null);
+    setError(null);
+
+    const url = token
+      ? "http://localhost:8080/api/password/reset"
+      : "http://localhost:8080/api/password/forgot";
+    const body = token ? { token, password } : { email };
+
+    try {
+      const response = await fetch(url, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify(body),
+      });
+      const data = await response.json();
+
+      if (response.ok) {
+        setMessage(
+          token
+            ? "Mật khẩu đã được đặt lại. Vui lòng đăng nhập lại."
+            : "Nếu email đã được đăng ký, chúng tôi đã gửi liên kết đặt lại mật khẩu."
+        );
+      } else {
+        setError(data.error || "Yêu cầu không thành công");
+      }
+    } catch (err) {
+      setError("Đã xảy ra lỗi");
+    }
+  };
+
+  return (
+    <div className="container">
+      <h2>{token ? "Đặt lại mật khẩu" : "Quên mật khẩu"}</h2>
+      {message && <div className="alert alert-success">{message}</div>}
+      {error && <div className="alert alert-danger">{error}</div>}
+      <form onSubmit={handleSubmit}>
+        {token ? (
+          <div className="mb-3">
+            <label className="form-label">Mật khẩu mới</label>
+            <input
+              type="password"
+              className="form-control"
+              value={password}
+              onChange={(e) => setPassword(e.target.value)}
+              minLength={8}
+              required
+            />
+          </div>
+        ) : (
+          <div className="mb-3">
+            <label className="form-label">Email</label>
+            <input
+              type="email"
+              className="form-control"
+              value={email}
+              onChange={(e) => setEmail(e.target.value)}
+              required
+            />
+          </div>
+        )}
+        <button type="submit" className="btn btn-primary">
+          Gửi
+        </button>
+      </form>
+
+      <div className="mt-3">
+        <Link to="/login" className="text-primary">
+          Đăng nhập
+        </Link>
+      </div>
+    </div>
+  );
+}
+
+export default ResetPassword;
@@ -23,6 +23,7 @@ import CloseIcon from "@mui/icons-material/Close";
 import axios from "axios";
 
 import districtsData from "../data/districts.json";
+import { formatMoney } from "../money";
 
 function ServiceBooking() {
   const [services, setServices] = useState([]);
@@ -45,8 +46,8 @@ function ServiceBooking() {
   useEffect(() => {
     const fetchServices = async () => {
       try {
-        const response = await axios.get("http://localhost:8080/api/services");
-        setServices(response.data);
+        const response = await axios.get("http://localhost:8080/api/services?limit=100");
+        setServices(response.data.items || []);
       } catch (error) {
         console.error("Error fetching services", error);
       }
@@ -154,7 +155,7 @@ function ServiceBooking() {
                     {service.name}
                   </Typography>
                   <Typography variant="body2" color="text.secondary">
-                    {service.price.toLocaleString()} VND
+                    {formatMoney(service.price)}
                   </Typography>
                   <Button
                     variant="contained"
@@ -16,6 +16,7 @@ import {
 import { useNavigate } from "react-router-dom";
 import axios from "axios";
 import CloseIcon from "@mui/icons-material/Close";
+import { formatMoney } from "../money";
 
 function Shop({ updateCartCount }) {
   const [products, setProducts] = useState([]);
@@ -28,8 +29,8 @@ function Shop({ updateCartCount }) {
   useEffect(() => {
     const fetchProducts = async () => {
       try {
-        const response = await axios.get("http://localhost:8080/api/products");
-        setProducts(response.data);
+        const response = await axios.get("http://localhost:8080/api/products?limit=100");
+        setProducts(response.data.items || []);
       } catch (error) {
         console.error("Error fetching products", error);
       }
@@ -115,7 +116,7 @@ function Shop({ updateCartCount }) {
                     {product.name}
                   </Typography>
                   <Typography variant="body2" color="text.secondary">
-                    {product.price.toLocaleString()} VND
+                    {formatMoney(product.price)}
                   </Typography>
                 </CardContent>
                 <Box sx={{ padding: 2 }}>
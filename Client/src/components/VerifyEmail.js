@@ -0,0 +1,46 @@
+import React, { useEffect, useState } from "react";
+import { Link, useSearchParams } from "react-router-dom";
+
+function VerifyEmail() {
+  const [searchParams] = useSearchParams();
+  const [message, setMessage] = useState("Đang xác nhận email...");
+  const [error, setError] = useState(null);
+
+  useEffect(() => {
+    const verify = async () => {
+      try {
+        const response = await fetch("http://localhost:8080/api/verify-email", {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ token: searchParams.get("token") }),
+        });
+        const data = await response.json();
+
+        if (response.ok) {
+          setMessage("Email của bạn đã được xác nhận.");
+        } else {
+          setMessage(null);
+          setError(data.error || "Xác nhận email không thành công");
+        }
+      } catch (err) {
+        setMessage(null);
+        setError("Đã xảy ra lỗi");
+      }
+    };
+
+    verify();
+  }, [searchParams]);
+
+  return (
+    <div className="container">
+      <h2>Xác nhận email</h2>
+      {message && <div className="alert alert-success">{message}</div>}
+      {error && <div className="alert alert-danger">{error}</div>}
+      <Link to="/" className="text-primary">
+        Về trang chủ
+      </Link>
+    </div>
+  );
+}
+
+export default VerifyEmail;
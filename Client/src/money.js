@@ -0,0 +1,16 @@
+// The server sends amounts as {amount, currency}, with amount in the minor
+// unit of the currency. The dong has no minor unit.
+
+// amountOf returns the amount of money as a number, accepting the bare
+// numbers older responses used.
+export function amountOf(money) {
+  if (money == null) {
+    return 0;
+  }
+  return typeof money === "number" ? money : money.amount;
+}
+
+export function formatMoney(money) {
+  const currency = money?.currency || "VND";
+  return `${amountOf(money).toLocaleString()} ${currency}`;
+}
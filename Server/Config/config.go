@@ -0,0 +1,541 @@
+package Config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Server    ServerConfig    `yaml:"server" toml:"server"`
+	Mongo     MongoConfig     `yaml:"mongo" toml:"mongo"`
+	JWT       JWTConfig       `yaml:"jwt" toml:"jwt"`
+	Storage   StorageConfig   `yaml:"storage" toml:"storage"`
+	Mail      MailConfig      `yaml:"mail" toml:"mail"`
+	RateLimit RateLimitConfig `yaml:"rate_limit" toml:"rate_limit"`
+	TwoFactor TwoFactorConfig `yaml:"two_factor" toml:"two_factor"`
+	OAuth     OAuthConfig     `yaml:"oauth" toml:"oauth"`
+	Payment   PaymentConfig   `yaml:"payment" toml:"payment"`
+	Pricing   PricingConfig   `yaml:"pricing" toml:"pricing"`
+}
+
+type ServerConfig struct {
+	Port        string   `yaml:"port" toml:"port"`
+	CORSOrigins []string `yaml:"cors_origins" toml:"cors_origins"`
+	// TrustedProxies are the addresses or CIDR ranges of the reverse proxies
+	// in front of the server. Only they may set X-Forwarded-For; with none
+	// the client address is the address of the connection.
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+type MongoConfig struct {
+	URI      string `yaml:"uri" toml:"uri"`
+	Database string `yaml:"database" toml:"database"`
+}
+
+type JWTConfig struct {
+	Secret     string   `yaml:"secret" toml:"secret"`
+	AccessTTL  Duration `yaml:"access_ttl" toml:"access_ttl"`
+	RefreshTTL Duration `yaml:"refresh_ttl" toml:"refresh_ttl"`
+}
+
+type StorageConfig struct {
+	// Driver is either "cloudinary" or "local".
+	Driver     string           `yaml:"driver" toml:"driver"`
+	Cloudinary CloudinaryConfig `yaml:"cloudinary" toml:"cloudinary"`
+	Local      LocalConfig      `yaml:"local" toml:"local"`
+}
+
+type CloudinaryConfig struct {
+	CloudName string `yaml:"cloud_name" toml:"cloud_name"`
+	APIKey    string `yaml:"api_key" toml:"api_key"`
+	APISecret string `yaml:"api_secret" toml:"api_secret"`
+}
+
+type LocalConfig struct {
+	Dir     string `yaml:"dir" toml:"dir"`
+	BaseURL string `yaml:"base_url" toml:"base_url"`
+	Secret  string `yaml:"secret" toml:"secret"`
+}
+
+type MailConfig struct {
+	// Driver is either "smtp" or "log".
+	Driver string `yaml:"driver" toml:"driver"`
+	From   string `yaml:"from" toml:"from"`
+	// LinkBaseURL is the client address that verification and password
+	// reset links point to.
+	LinkBaseURL string        `yaml:"link_base_url" toml:"link_base_url"`
+	SMTP        SMTPConfig    `yaml:"smtp" toml:"smtp"`
+	Log         MailLogConfig `yaml:"log" toml:"log"`
+}
+
+type SMTPConfig struct {
+	Host     string `yaml:"host" toml:"host"`
+	Port     string `yaml:"port" toml:"port"`
+	Username string `yaml:"username" toml:"username"`
+	Password string `yaml:"password" toml:"password"`
+}
+
+type MailLogConfig struct {
+	Dir string `yaml:"dir" toml:"dir"`
+}
+
+type RateLimitConfig struct {
+	// Store is "memory" for a single instance or "mongo" to share limits
+	// between instances.
+	Store string `yaml:"store" toml:"store"`
+}
+
+type TwoFactorConfig struct {
+	// Issuer is the name authenticator apps show next to the account.
+	Issuer string `yaml:"issuer" toml:"issuer"`
+}
+
+type OAuthConfig struct {
+	// CallbackBaseURL is the public address of this server. Providers send
+	// users back to {CallbackBaseURL}/api/oauth/{provider}/callback.
+	CallbackBaseURL string `yaml:"callback_base_url" toml:"callback_base_url"`
+	// ClientRedirectURL is the client page that finishes a social login.
+	ClientRedirectURL string                        `yaml:"client_redirect_url" toml:"client_redirect_url"`
+	Providers         map[string]OIDCProviderConfig `yaml:"providers" toml:"providers"`
+}
+
+type OIDCProviderConfig struct {
+	Issuer       string   `yaml:"issuer" toml:"issuer"`
+	ClientID     string   `yaml:"client_id" toml:"client_id"`
+	ClientSecret string   `yaml:"client_secret" toml:"client_secret"`
+	Scopes       []string `yaml:"scopes" toml:"scopes"`
+	// TrustEmail treats every email from the provider as verified, for
+	// providers that only return confirmed addresses but do not send the
+	// email_verified claim.
+	TrustEmail bool `yaml:"trust_email" toml:"trust_email"`
+}
+
+type PaymentConfig struct {
+	// Driver is "vnpay", or "none" to turn online payment off.
+	Driver string `yaml:"driver" toml:"driver"`
+	// CallbackBaseURL is the public address of this server. Customers come
+	// back to {CallbackBaseURL}/api/payments/{driver}/return.
+	CallbackBaseURL string `yaml:"callback_base_url" toml:"callback_base_url"`
+	// ClientReturnURL is the client page that shows the payment result.
+	ClientReturnURL string `yaml:"client_return_url" toml:"client_return_url"`
+	// Expiry is how long the customer has to finish paying.
+	Expiry Duration    `yaml:"expiry" toml:"expiry"`
+	VNPay  VNPayConfig `yaml:"vnpay" toml:"vnpay"`
+}
+
+type VNPayConfig struct {
+	TmnCode    string `yaml:"tmn_code" toml:"tmn_code"`
+	HashSecret string `yaml:"hash_secret" toml:"hash_secret"`
+	PayURL     string `yaml:"pay_url" toml:"pay_url"`
+	APIURL     string `yaml:"api_url" toml:"api_url"`
+}
+
+type PricingConfig struct {
+	// VATRate is the value added tax in percent.
+	VATRate float64 `yaml:"vat_rate" toml:"vat_rate"`
+	// PricesIncludeVAT means product prices already contain VAT, so it is
+	// only shown in the breakdown instead of being added to the total.
+	PricesIncludeVAT bool           `yaml:"prices_include_vat" toml:"prices_include_vat"`
+	Shipping         ShippingConfig `yaml:"shipping" toml:"shipping"`
+}
+
+// ShippingConfig prices delivery. The first province rule listing the
+// shipping province replaces the default rate.
+type ShippingConfig struct {
+	Default   ShippingRate           `yaml:"default" toml:"default"`
+	Provinces []ProvinceShippingRate `yaml:"provinces" toml:"provinces"`
+}
+
+// ShippingRate is Flat plus PerKg for every started kilogram above
+// IncludedWeight grams. Orders reaching FreeOver after discounts ship for
+// free; zero means never. Amounts are whole units of the shop currency.
+type ShippingRate struct {
+	Flat           int64 `yaml:"flat" toml:"flat"`
+	PerKg          int64 `yaml:"per_kg" toml:"per_kg"`
+	IncludedWeight int64 `yaml:"included_weight" toml:"included_weight"`
+	FreeOver       int64 `yaml:"free_over" toml:"free_over"`
+}
+
+type ProvinceShippingRate struct {
+	Provinces []string     `yaml:"provinces" toml:"provinces"`
+	Rate      ShippingRate `yaml:"rate" toml:"rate"`
+}
+
+func defaults() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:        "8080",
+			CORSOrigins: []string{"http://localhost:6969"},
+		},
+		Mongo: MongoConfig{
+			Database: "golang_project",
+		},
+		JWT: JWTConfig{
+			AccessTTL:  Duration(15 * time.Minute),
+			RefreshTTL: Duration(7 * 24 * time.Hour),
+		},
+		Storage: StorageConfig{
+			Driver: "cloudinary",
+			Local: LocalConfig{
+				Dir:     "uploads/images",
+				BaseURL: "http://localhost:8080/uploads/images",
+			},
+		},
+		Mail: MailConfig{
+			Driver:      "log",
+			From:        "no-reply@localhost",
+			LinkBaseURL: "http://localhost:6969",
+			SMTP: SMTPConfig{
+				Port: "587",
+			},
+		},
+		RateLimit: RateLimitConfig{
+			Store: "memory",
+		},
+		TwoFactor: TwoFactorConfig{
+			Issuer: "Golang Project",
+		},
+		OAuth: OAuthConfig{
+			CallbackBaseURL:   "http://localhost:8080",
+			ClientRedirectURL: "http://localhost:6969/oauth/callback",
+		},
+		Payment: PaymentConfig{
+			Driver:          "none",
+			CallbackBaseURL: "http://localhost:8080",
+			ClientReturnURL: "http://localhost:6969/payment/result",
+			Expiry:          Duration(15 * time.Minute),
+			VNPay: VNPayConfig{
+				PayURL: "http://localhost:8090/paymentv2/vpcpay.html",
+				APIURL: "http://localhost:8090/merchant_webapi/api/transaction",
+			},
+		},
+		Pricing: PricingConfig{
+			VATRate:          10,
+			PricesIncludeVAT: true,
+		},
+	}
+}
+
+// Load builds the configuration from defaults, then the optional file at
+// path (YAML or TOML, picked by extension), then environment variables, and
+// validates the result.
+func Load(path string) (*Config, error) {
+	cfg := defaults()
+
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", path, err)
+		}
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func loadFile(path string, cfg *Config) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return yaml.Unmarshal(content, cfg)
+	case ".toml":
+		return toml.Unmarshal(content, cfg)
+	default:
+		return errors.New("unsupported format, use .yaml, .yml or .toml")
+	}
+}
+
+func applyEnv(cfg *Config) error {
+	setString := func(name string, target *string) {
+		if value, ok := os.LookupEnv(name); ok {
+			*target = value
+		}
+	}
+
+	setString("PORT", &cfg.Server.Port)
+	if origins, ok := os.LookupEnv("CORS_ORIGINS"); ok {
+		cfg.Server.CORSOrigins = splitList(origins)
+	}
+	if proxies, ok := os.LookupEnv("TRUSTED_PROXIES"); ok {
+		cfg.Server.TrustedProxies = splitList(proxies)
+	}
+
+	setString("MONGODB_URI", &cfg.Mongo.URI)
+	setString("MONGODB_DATABASE", &cfg.Mongo.Database)
+
+	setString("JWT_SECRET", &cfg.JWT.Secret)
+	for name, target := range map[string]*Duration{
+		"JWT_ACCESS_TTL":  &cfg.JWT.AccessTTL,
+		"JWT_REFRESH_TTL": &cfg.JWT.RefreshTTL,
+		"PAYMENT_EXPIRY":  &cfg.Payment.Expiry,
+	} {
+		if value, ok := os.LookupEnv(name); ok {
+			if err := target.UnmarshalText([]byte(value)); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+
+	setString("IMAGE_STORAGE", &cfg.Storage.Driver)
+	setString("CLOUDINARY_CLOUD_NAME", &cfg.Storage.Cloudinary.CloudName)
+	setString("CLOUDINARY_API_KEY", &cfg.Storage.Cloudinary.APIKey)
+	setString("CLOUDINARY_API_SECRET", &cfg.Storage.Cloudinary.APISecret)
+	setString("LOCAL_STORAGE_DIR", &cfg.Storage.Local.Dir)
+	setString("LOCAL_STORAGE_BASE_URL", &cfg.Storage.Local.BaseURL)
+	setString("LOCAL_STORAGE_SECRET", &cfg.Storage.Local.Secret)
+
+	setString("MAIL_DRIVER", &cfg.Mail.Driver)
+	setString("MAIL_FROM", &cfg.Mail.From)
+	setString("MAIL_LINK_BASE_URL", &cfg.Mail.LinkBaseURL)
+	setString("SMTP_HOST", &cfg.Mail.SMTP.Host)
+	setString("SMTP_PORT", &cfg.Mail.SMTP.Port)
+	setString("SMTP_USERNAME", &cfg.Mail.SMTP.Username)
+	setString("SMTP_PASSWORD", &cfg.Mail.SMTP.Password)
+	setString("MAIL_LOG_DIR", &cfg.Mail.Log.Dir)
+
+	setString("RATE_LIMIT_STORE", &cfg.RateLimit.Store)
+	setString("TOTP_ISSUER", &cfg.TwoFactor.Issuer)
+
+	setString("OAUTH_CALLBACK_BASE_URL", &cfg.OAuth.CallbackBaseURL)
+	setString("OAUTH_CLIENT_REDIRECT_URL", &cfg.OAuth.ClientRedirectURL)
+	// OIDC_PROVIDERS names the providers to set up from OIDC_<NAME>_*
+	// variables, in addition to those in the config file.
+	if names, ok := os.LookupEnv("OIDC_PROVIDERS"); ok {
+		if cfg.OAuth.Providers == nil {
+			cfg.OAuth.Providers = map[string]OIDCProviderConfig{}
+		}
+		for _, name := range splitList(names) {
+			if _, ok := cfg.OAuth.Providers[name]; !ok {
+				cfg.OAuth.Providers[name] = OIDCProviderConfig{}
+			}
+		}
+	}
+	for name, provider := range cfg.OAuth.Providers {
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		setString(prefix+"ISSUER", &provider.Issuer)
+		setString(prefix+"CLIENT_ID", &provider.ClientID)
+		setString(prefix+"CLIENT_SECRET", &provider.ClientSecret)
+		if scopes, ok := os.LookupEnv(prefix + "SCOPES"); ok {
+			provider.Scopes = splitList(scopes)
+		}
+		if value, ok := os.LookupEnv(prefix + "TRUST_EMAIL"); ok {
+			trust, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%sTRUST_EMAIL: %w", prefix, err)
+			}
+			provider.TrustEmail = trust
+		}
+		cfg.OAuth.Providers[name] = provider
+	}
+
+	setString("PAYMENT_DRIVER", &cfg.Payment.Driver)
+	setString("PAYMENT_CALLBACK_BASE_URL", &cfg.Payment.CallbackBaseURL)
+	setString("PAYMENT_CLIENT_RETURN_URL", &cfg.Payment.ClientReturnURL)
+	setString("VNPAY_TMN_CODE", &cfg.Payment.VNPay.TmnCode)
+	setString("VNPAY_HASH_SECRET", &cfg.Payment.VNPay.HashSecret)
+	setString("VNPAY_PAY_URL", &cfg.Payment.VNPay.PayURL)
+	setString("VNPAY_API_URL", &cfg.Payment.VNPay.APIURL)
+
+	if value, ok := os.LookupEnv("VAT_RATE"); ok {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("VAT_RATE: %w", err)
+		}
+		cfg.Pricing.VATRate = rate
+	}
+	for name, target := range map[string]*int64{
+		"SHIPPING_FLAT":            &cfg.Pricing.Shipping.Default.Flat,
+		"SHIPPING_PER_KG":          &cfg.Pricing.Shipping.Default.PerKg,
+		"SHIPPING_INCLUDED_WEIGHT": &cfg.Pricing.Shipping.Default.IncludedWeight,
+		"SHIPPING_FREE_OVER":       &cfg.Pricing.Shipping.Default.FreeOver,
+	} {
+		if value, ok := os.LookupEnv(name); ok {
+			number, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*target = number
+		}
+	}
+	if value, ok := os.LookupEnv("PRICES_INCLUDE_VAT"); ok {
+		included, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("PRICES_INCLUDE_VAT: %w", err)
+		}
+		cfg.Pricing.PricesIncludeVAT = included
+	}
+
+	return nil
+}
+
+// Validate reports every problem with the configuration at once, so a
+// misconfigured deployment can be fixed in one go.
+func (c *Config) Validate() error {
+	var problems []string
+
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port <= 0 || port > 65535 {
+		problems = append(problems, "server.port (PORT) must be a valid TCP port")
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			problems = append(problems, fmt.Sprintf("server.trusted_proxies (TRUSTED_PROXIES): %q is not an IP address or CIDR range", proxy))
+		}
+	}
+	if c.Mongo.URI == "" {
+		problems = append(problems, "mongo.uri (MONGODB_URI) is required")
+	}
+	if c.Mongo.Database == "" {
+		problems = append(problems, "mongo.database (MONGODB_DATABASE) is required")
+	}
+	if len(c.JWT.Secret) < 32 {
+		problems = append(problems, "jwt.secret (JWT_SECRET) must be at least 32 characters")
+	}
+	if c.JWT.AccessTTL <= 0 {
+		problems = append(problems, "jwt.access_ttl (JWT_ACCESS_TTL) must be positive")
+	}
+	if c.JWT.RefreshTTL <= 0 {
+		problems = append(problems, "jwt.refresh_ttl (JWT_REFRESH_TTL) must be positive")
+	}
+
+	switch c.Storage.Driver {
+	case "cloudinary":
+		cld := c.Storage.Cloudinary
+		if cld.CloudName == "" || cld.APIKey == "" || cld.APISecret == "" {
+			problems = append(problems, "storage.cloudinary requires cloud_name, api_key and api_secret (CLOUDINARY_CLOUD_NAME, CLOUDINARY_API_KEY, CLOUDINARY_API_SECRET)")
+		}
+	case "local":
+		if c.Storage.Local.Dir == "" || c.Storage.Local.BaseURL == "" {
+			problems = append(problems, "storage.local requires dir and base_url (LOCAL_STORAGE_DIR, LOCAL_STORAGE_BASE_URL)")
+		}
+	default:
+		problems = append(problems, "storage.driver (IMAGE_STORAGE) must be cloudinary or local")
+	}
+
+	switch c.Mail.Driver {
+	case "smtp":
+		if c.Mail.SMTP.Host == "" || c.Mail.SMTP.Port == "" {
+			problems = append(problems, "mail.smtp requires host and port (SMTP_HOST, SMTP_PORT)")
+		}
+	case "log":
+	default:
+		problems = append(problems, "mail.driver (MAIL_DRIVER) must be smtp or log")
+	}
+	if c.Mail.From == "" {
+		problems = append(problems, "mail.from (MAIL_FROM) is required")
+	}
+	if c.Mail.LinkBaseURL == "" {
+		problems = append(problems, "mail.link_base_url (MAIL_LINK_BASE_URL) is required")
+	}
+
+	if c.RateLimit.Store != "memory" && c.RateLimit.Store != "mongo" {
+		problems = append(problems, "rate_limit.store (RATE_LIMIT_STORE) must be memory or mongo")
+	}
+
+	if c.TwoFactor.Issuer == "" {
+		problems = append(problems, "two_factor.issuer (TOTP_ISSUER) is required")
+	}
+
+	if len(c.OAuth.Providers) > 0 && (c.OAuth.CallbackBaseURL == "" || c.OAuth.ClientRedirectURL == "") {
+		problems = append(problems, "oauth requires callback_base_url and client_redirect_url (OAUTH_CALLBACK_BASE_URL, OAUTH_CLIENT_REDIRECT_URL)")
+	}
+	for name, provider := range c.OAuth.Providers {
+		if !providerNamePattern.MatchString(name) {
+			problems = append(problems, fmt.Sprintf("oauth.providers.%s: name must be lowercase letters, digits and dashes", name))
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			problems = append(problems, fmt.Sprintf("oauth.providers.%s requires issuer and client_id", name))
+		}
+	}
+
+	switch c.Payment.Driver {
+	case "vnpay":
+		vnp := c.Payment.VNPay
+		if vnp.TmnCode == "" || vnp.HashSecret == "" || vnp.PayURL == "" || vnp.APIURL == "" {
+			problems = append(problems, "payment.vnpay requires tmn_code, hash_secret, pay_url and api_url (VNPAY_TMN_CODE, VNPAY_HASH_SECRET, VNPAY_PAY_URL, VNPAY_API_URL)")
+		}
+		if c.Payment.CallbackBaseURL == "" || c.Payment.ClientReturnURL == "" {
+			problems = append(problems, "payment requires callback_base_url and client_return_url (PAYMENT_CALLBACK_BASE_URL, PAYMENT_CLIENT_RETURN_URL)")
+		}
+		if c.Payment.Expiry <= 0 {
+			problems = append(problems, "payment.expiry (PAYMENT_EXPIRY) must be positive")
+		}
+	case "none":
+	default:
+		problems = append(problems, "payment.driver (PAYMENT_DRIVER) must be vnpay or none")
+	}
+
+	if c.Pricing.VATRate < 0 || c.Pricing.VATRate > 100 {
+		problems = append(problems, "pricing.vat_rate (VAT_RATE) must be between 0 and 100")
+	}
+	if problem := c.Pricing.Shipping.Default.problem(); problem != "" {
+		problems = append(problems, "pricing.shipping.default: "+problem)
+	}
+	for i, rule := range c.Pricing.Shipping.Provinces {
+		if len(rule.Provinces) == 0 {
+			problems = append(problems, fmt.Sprintf("pricing.shipping.provinces[%d] must list at least one province", i))
+		}
+		if problem := rule.Rate.problem(); problem != "" {
+			problems = append(problems, fmt.Sprintf("pricing.shipping.provinces[%d].rate: %s", i, problem))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  - " + strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+func (r ShippingRate) problem() string {
+	if r.Flat < 0 || r.PerKg < 0 || r.IncludedWeight < 0 || r.FreeOver < 0 {
+		return "flat, per_kg, included_weight and free_over must not be negative"
+	}
+	return ""
+}
+
+var providerNamePattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// Duration is a time.Duration written as a string such as "15m" in config
+// files.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	duration, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
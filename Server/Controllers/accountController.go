@@ -0,0 +1,291 @@
+package Controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"Server/Mail"
+	"Server/Middleware"
+	"Server/Models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	verifyEmailTokenTTL   = 48 * time.Hour
+	resetPasswordTokenTTL = time.Hour
+	// passwordResetSendTimeout bounds the background send of ForgotPassword.
+	passwordResetSendTimeout = 30 * time.Second
+)
+
+var errInvalidUserToken = errors.New("invalid or expired token")
+
+func (h *Handler) getUserTokenCollection() *mongo.Collection {
+	return h.DB.Collection("user_tokens")
+}
+
+// signUserToken appends an HMAC of the random part and purpose, so tokens
+// that were not issued by this server are rejected without a lookup.
+func (h *Handler) signUserToken(purpose Models.UserTokenPurpose, random string) string {
+	mac := hmac.New(sha256.New, []byte(h.Config.JWT.Secret))
+	mac.Write([]byte(string(purpose) + "." + random))
+	return random + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// issueUserToken replaces any outstanding token of the same purpose for
+// user with a new one and returns it.
+func (h *Handler) issueUserToken(ctx context.Context, userID primitive.ObjectID, purpose Models.UserTokenPurpose, ttl time.Duration) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := h.signUserToken(purpose, base64.RawURLEncoding.EncodeToString(raw))
+
+	collection := h.getUserTokenCollection()
+	if _, err := collection.DeleteMany(ctx, bson.M{"user_id": userID, "purpose": purpose, "used_at": nil}); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	record := Models.UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	if _, err := collection.InsertOne(ctx, record); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeUserToken checks token and marks it used, so each token works once.
+func (h *Handler) consumeUserToken(ctx context.Context, token string, purpose Models.UserTokenPurpose) (Models.UserToken, error) {
+	var record Models.UserToken
+
+	random, _, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(h.signUserToken(purpose, random)), []byte(token)) {
+		return record, errInvalidUserToken
+	}
+
+	now := time.Now()
+	err := h.getUserTokenCollection().FindOneAndUpdate(ctx,
+		bson.M{
+			"token_hash": hashToken(token),
+			"purpose":    purpose,
+			"used_at":    nil,
+			"expires_at": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"used_at": now}},
+	).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return record, errInvalidUserToken
+	}
+	return record, err
+}
+
+func (h *Handler) accountLink(path, token string) string {
+	return strings.TrimSuffix(h.Config.Mail.LinkBaseURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+func (h *Handler) sendVerificationEmail(ctx context.Context, user Models.User) error {
+	token, err := h.issueUserToken(ctx, user.ID, Models.VerifyEmailToken, verifyEmailTokenTTL)
+	if err != nil {
+		return err
+	}
+	return h.Mailer.Send(ctx, Mail.Message{
+		To:      user.Email,
+		Subject: "Xác nhận địa chỉ email",
+		Body: fmt.Sprintf("Xin chào %s,\n\nVui lòng xác nhận địa chỉ email của bạn bằng liên kết sau:\n%s\n\nLiên kết có hiệu lực trong %d giờ.",
+			user.FirstName, h.accountLink("/verify-email", token), int(verifyEmailTokenTTL.Hours())),
+	})
+}
+
+func (h *Handler) sendPasswordResetEmail(ctx context.Context, user Models.User) error {
+	token, err := h.issueUserToken(ctx, user.ID, Models.ResetPasswordToken, resetPasswordTokenTTL)
+	if err != nil {
+		return err
+	}
+	return h.Mailer.Send(ctx, Mail.Message{
+		To:      user.Email,
+		Subject: "Đặt lại mật khẩu",
+		Body: fmt.Sprintf("Xin chào %s,\n\nBạn có thể đặt lại mật khẩu bằng liên kết sau:\n%s\n\nLiên kết có hiệu lực trong %d phút. Nếu bạn không yêu cầu, hãy bỏ qua email này.",
+			user.FirstName, h.accountLink("/reset-password", token), int(resetPasswordTokenTTL.Minutes())),
+	})
+}
+
+// requireVerifiedEmail fails the request with a 403 and returns false
+// unless userID has confirmed their email address.
+func (h *Handler) requireVerifiedEmail(c *gin.Context, userID primitive.ObjectID) bool {
+	var user Models.User
+	err := h.DB.Collection("users").FindOne(context.Background(), bson.M{"_id": userID},
+		options.FindOne().SetProjection(bson.M{"email_verified": 1})).Decode(&user)
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return false
+	}
+	if !user.EmailVerified {
+		Middleware.Fail(c, Middleware.Forbidden(Middleware.CodeEmailNotVerified, "Vui lòng xác nhận email trước khi đặt hàng"))
+		return false
+	}
+	return true
+}
+
+// RequestEmailVerification mails the signed-in user a new verification link.
+func (h *Handler) RequestEmailVerification(c *gin.Context) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var user Models.User
+	if err := h.DB.Collection("users").FindOne(ctx, bson.M{"_id": claims.ID}).Decode(&user); err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy người dùng"))
+		return
+	}
+	if user.EmailVerified {
+		Middleware.Fail(c, Middleware.Conflict("Email đã được xác nhận"))
+		return
+	}
+
+	if err := h.sendVerificationEmail(ctx, user); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
+func (h *Handler) VerifyEmail(c *gin.Context) {
+	var reqBody struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if !bindJSON(c, &reqBody) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	record, err := h.consumeUserToken(ctx, reqBody.Token, Models.VerifyEmailToken)
+	if errors.Is(err, errInvalidUserToken) {
+		Middleware.Fail(c, Middleware.NewError(http.StatusBadRequest, Middleware.CodeInvalidToken, "Liên kết xác nhận không hợp lệ hoặc đã hết hạn"))
+		return
+	}
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	if _, err := h.DB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": record.UserID},
+		bson.M{"$set": bson.M{"email_verified": true}},
+	); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+// ForgotPassword mails a reset link when the email belongs to an account.
+// It answers the same way either way so it cannot be used to probe which
+// emails are registered, and sends the mail in the background so that the
+// time it takes does not tell either.
+func (h *Handler) ForgotPassword(c *gin.Context) {
+	var reqBody struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if !bindJSON(c, &reqBody) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var user Models.User
+	err := h.DB.Collection("users").FindOne(ctx, bson.M{"email": normalizeEmail(reqBody.Email)}).Decode(&user)
+	if err == nil {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), passwordResetSendTimeout)
+			defer cancel()
+			if err := h.sendPasswordResetEmail(ctx, user); err != nil {
+				log.Printf("password reset email for %s: %v", user.ID.Hex(), err)
+			}
+		}()
+	} else if err != mongo.ErrNoDocuments {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the email is registered, a reset link has been sent"})
+}
+
+// ResetPassword sets a new password from a reset link and signs the user
+// out everywhere.
+func (h *Handler) ResetPassword(c *gin.Context) {
+	var reqBody struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required,min=8,max=72"`
+	}
+	if !bindJSON(c, &reqBody) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	record, err := h.consumeUserToken(ctx, reqBody.Token, Models.ResetPasswordToken)
+	if errors.Is(err, errInvalidUserToken) {
+		Middleware.Fail(c, Middleware.NewError(http.StatusBadRequest, Middleware.CodeInvalidToken, "Liên kết đặt lại mật khẩu không hợp lệ hoặc đã hết hạn"))
+		return
+	}
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(reqBody.Password), 10)
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	// Receiving the reset link also proves the user owns the address.
+	if _, err := h.DB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": record.UserID},
+		bson.M{"$set": bson.M{"password": string(hash), "email_verified": true}},
+	); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	if err := h.revokeUserSessions(ctx, record.UserID, primitive.NilObjectID); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+}
+
+var userTokenIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+	{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "purpose", Value: 1}}},
+	{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+}
@@ -0,0 +1,58 @@
+package Controllers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"Server/Mail"
+	"Server/Models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// heldMailer delivers each message only once the test releases it.
+type heldMailer struct {
+	release chan struct{}
+	sent    chan Mail.Message
+}
+
+func (m *heldMailer) Send(ctx context.Context, msg Mail.Message) error {
+	select {
+	case <-m.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	m.sent <- msg
+	return nil
+}
+
+func TestForgotPasswordAnswersBeforeSending(t *testing.T) {
+	user := Models.User{ID: primitive.NewObjectID(), Email: "user@example.com", FirstName: "An"}
+
+	mt := newMockDB(t)
+	mt.Run("registered email", func(mt *mtest.T) {
+		h := newTestHandler(mt)
+		mailer := &heldMailer{release: make(chan struct{}), sent: make(chan Mail.Message, 1)}
+		h.Mailer = mailer
+		mt.AddMockResponses(found(mt, "users", user), updated(0), mtest.CreateSuccessResponse())
+
+		w := serve(h.ForgotPassword, nil, bson.M{"email": user.Email})
+		if w.Code != http.StatusOK {
+			mt.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+
+		close(mailer.release)
+		select {
+		case msg := <-mailer.sent:
+			if msg.To != user.Email {
+				mt.Errorf("mailed %s, want %s", msg.To, user.Email)
+			}
+		case <-time.After(5 * time.Second):
+			mt.Fatal("reset link was never sent")
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package Controllers
+
+import (
+	"context"
+	"net/http"
+
+	"Server/Models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type customerContact struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	FirstName string             `bson:"firstname" json:"firstname"`
+	LastName  string             `bson:"lastname" json:"lastname"`
+	Email     string             `bson:"email" json:"email"`
+	Phone     string             `bson:"phone" json:"phone"`
+	Address   string             `bson:"address" json:"address"`
+}
+
+type adminOrderRow struct {
+	Models.Order `bson:",inline"`
+	Customer     *customerContact `bson:"customer,omitempty" json:"customer,omitempty"`
+}
+
+type adminBookingRow struct {
+	Models.OrderBookingService `bson:",inline"`
+	Customer                   *customerContact `bson:"customer,omitempty" json:"customer,omitempty"`
+}
+
+// customerLookupStages attach the contact details of the user referenced by
+// user_id as "customer".
+var customerLookupStages = []bson.M{
+	{"$lookup": bson.M{
+		"from":         "users",
+		"localField":   "user_id",
+		"foreignField": "_id",
+		"as":           "customer",
+	}},
+	{"$unwind": bson.M{"path": "$customer", "preserveNullAndEmptyArrays": true}},
+	{"$project": bson.M{"customer.password": 0, "customer.cart": 0, "customer.two_factor": 0}},
+}
+
+// customerFilter narrows a listing to the customers matched by the
+// "customer" parameter.
+func (h *Handler) customerFilter(c *gin.Context, match bson.M) error {
+	customer := c.Query("customer")
+	if customer == "" {
+		return nil
+	}
+	customerIDs, err := h.findCustomerIDs(customer)
+	if err != nil {
+		return err
+	}
+	match["user_id"] = bson.M{"$in": customerIDs}
+	return nil
+}
+
+func (h *Handler) AdminGetOrders(c *gin.Context) {
+	spec := orderListSpec
+	spec.Filters = append([]listFilter{h.customerFilter}, orderListSpec.Filters...)
+
+	orders := []adminOrderRow{}
+	page, ok := listPage(c, h.getOrderCollection(), spec, nil, customerLookupStages, &orders)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+func (h *Handler) AdminGetOrderBookingServices(c *gin.Context) {
+	spec := bookingListSpec
+	spec.Filters = append([]listFilter{h.customerFilter}, bookingListSpec.Filters...)
+
+	bookings := []adminBookingRow{}
+	page, ok := listPage(c, h.getOrderBookingServiceCollection(), spec, nil, customerLookupStages, &bookings)
+	if !ok {
+		return
+	}
+	for i := range bookings {
+		bookings[i].StatusLabel = bookings[i].Status.Label()
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// findCustomerIDs resolves the "customer" filter, which is either a user ID
+// or a fragment of the customer's name, email or phone number.
+func (h *Handler) findCustomerIDs(query string) ([]primitive.ObjectID, error) {
+	if id, err := primitive.ObjectIDFromHex(query); err == nil {
+		return []primitive.ObjectID{id}, nil
+	}
+
+	cursor, err := h.DB.Collection("users").Find(context.Background(), userSearchMatch(query))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	ids := []primitive.ObjectID{}
+	for cursor.Next(context.Background()) {
+		var user Models.User
+		if err := cursor.Decode(&user); err != nil {
+			return nil, err
+		}
+		ids = append(ids, user.ID)
+	}
+	return ids, cursor.Err()
+}
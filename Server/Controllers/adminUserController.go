@@ -0,0 +1,336 @@
+package Controllers
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"Server/Middleware"
+	"Server/Models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var userListSpec = listSpec{
+	Filters: []listFilter{userSearchFilter, userRoleFilter, userSuspendedFilter},
+	Sorts: map[string]string{
+		"newest": "-_id",
+		"name":   "firstname",
+		"email":  "email",
+	},
+	DefaultSort: bson.D{{Key: "_id", Value: -1}},
+}
+
+// userListStages keep credentials and carts out of user listings.
+var userListStages = []bson.M{{"$project": profileProjection}}
+
+// userSearchMatch matches users whose name, email or phone number contains
+// query, ignoring case.
+func userSearchMatch(query string) bson.M {
+	pattern := primitive.Regex{Pattern: regexp.QuoteMeta(query), Options: "i"}
+	return bson.M{"$or": []bson.M{
+		{"email": pattern},
+		{"phone": pattern},
+		{"firstname": pattern},
+		{"lastname": pattern},
+	}}
+}
+
+func userSearchFilter(c *gin.Context, match bson.M) error {
+	if query := strings.TrimSpace(c.Query("q")); query != "" {
+		match["$or"] = userSearchMatch(query)["$or"]
+	}
+	return nil
+}
+
+func userRoleFilter(c *gin.Context, match bson.M) error {
+	value := c.Query("role")
+	if value == "" {
+		return nil
+	}
+	role, err := strconv.Atoi(value)
+	if err != nil || role < 0 {
+		return &queryParamError{Param: "role", Code: "number", Message: "Phải là mã vai trò"}
+	}
+	match["role"] = role
+	return nil
+}
+
+func userSuspendedFilter(c *gin.Context, match bson.M) error {
+	value := c.Query("suspended")
+	if value == "" {
+		return nil
+	}
+	suspended, err := strconv.ParseBool(value)
+	if err != nil {
+		return &queryParamError{Param: "suspended", Code: "bool", Message: "Phải là true hoặc false"}
+	}
+	if suspended {
+		match["suspended_at"] = bson.M{"$ne": nil}
+	} else {
+		match["suspended_at"] = nil
+	}
+	return nil
+}
+
+func (h *Handler) getUserAuditCollection() *mongo.Collection {
+	return h.DB.Collection("user_audit")
+}
+
+func (h *Handler) recordUserAudit(ctx context.Context, entry Models.UserAuditEntry) error {
+	entry.CreatedAt = time.Now()
+	_, err := h.getUserAuditCollection().InsertOne(ctx, entry)
+	return err
+}
+
+// adminTargetUser reads the ":id" parameter and refuses to let admins act
+// on their own account, so they cannot lock themselves out.
+func adminTargetUser(c *gin.Context) (primitive.ObjectID, *Middleware.UserClaims, bool) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+
+	userID, ok := paramObjectID(c, "id")
+	if !ok {
+		return userID, claims, false
+	}
+	if userID == claims.ID {
+		Middleware.Fail(c, Middleware.Forbidden(Middleware.CodeForbidden, "Không thể tự thay đổi tài khoản của mình theo cách này"))
+		return userID, claims, false
+	}
+	return userID, claims, true
+}
+
+// canManageRole reports whether the caller holds every permission of role,
+// which granting or taking it away requires. Otherwise it fails the
+// request.
+func canManageRole(c *gin.Context, role Models.RoleDefinition) bool {
+	for _, permission := range role.Permissions {
+		if !Middleware.HasPermission(c, permission) {
+			Middleware.Fail(c, Middleware.Forbidden(Middleware.CodeMissingPermission,
+				"Bạn không thể cấp hoặc thu hồi vai trò có quyền mà bạn không có").WithDetail("permission", permission))
+			return false
+		}
+	}
+	return true
+}
+
+// ChangeUserRole gives another user a role. Callers can only grant and take
+// away roles whose permissions they hold themselves.
+func (h *Handler) ChangeUserRole(c *gin.Context) {
+	userID, claims, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	var reqBody struct {
+		Role   *Models.Role `json:"role" binding:"required"`
+		Reason string       `json:"reason" binding:"max=500"`
+	}
+	if !bindJSON(c, &reqBody) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var target Models.RoleDefinition
+	err := h.getRoleCollection().FindOne(ctx, bson.M{"_id": *reqBody.Role}).Decode(&target)
+	if err == mongo.ErrNoDocuments {
+		Middleware.Fail(c, Middleware.ValidationFailed(Middleware.FieldError{
+			Field:   "role",
+			Code:    "exists",
+			Message: "Vai trò không tồn tại",
+		}))
+		return
+	}
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	if !canManageRole(c, target) {
+		return
+	}
+
+	var previous Models.User
+	err = h.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID},
+		options.FindOne().SetProjection(bson.M{"role": 1})).Decode(&previous)
+	if err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy người dùng"))
+		return
+	}
+	if previous.Role == *reqBody.Role {
+		c.JSON(http.StatusOK, gin.H{"message": "Role unchanged", "role": previous.Role})
+		return
+	}
+
+	// Taking a role away needs the same permissions as granting it, so
+	// nobody can demote a user who holds more than they do.
+	var current Models.RoleDefinition
+	err = h.getRoleCollection().FindOne(ctx, bson.M{"_id": previous.Role}).Decode(&current)
+	if err != nil && err != mongo.ErrNoDocuments {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	if !canManageRole(c, current) {
+		return
+	}
+
+	result, err := h.DB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": userID, "role": previous.Role},
+		bson.M{"$set": bson.M{"role": *reqBody.Role}},
+	)
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	if result.MatchedCount == 0 {
+		Middleware.Fail(c, Middleware.Conflict("Vai trò của người dùng vừa thay đổi, vui lòng thử lại"))
+		return
+	}
+
+	// The role is part of the access token, so existing sessions must go.
+	if err := h.revokeUserSessions(ctx, userID, primitive.NilObjectID); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	if err := h.recordUserAudit(ctx, Models.UserAuditEntry{
+		UserID:   userID,
+		ActorID:  claims.ID,
+		Action:   Models.AuditRoleChanged,
+		FromRole: &previous.Role,
+		ToRole:   reqBody.Role,
+		Reason:   strings.TrimSpace(reqBody.Reason),
+	}); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role changed", "role": *reqBody.Role})
+}
+
+// SuspendUser blocks an account from logging in and ends its sessions. The
+// account and its data are kept so it can be reactivated.
+func (h *Handler) SuspendUser(c *gin.Context) {
+	userID, claims, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	var reqBody struct {
+		Reason string `json:"reason" binding:"max=500"`
+	}
+	if !bindJSON(c, &reqBody) {
+		return
+	}
+
+	h.setUserSuspended(c, userID, claims.ID, true, reqBody.Reason)
+}
+
+func (h *Handler) ReactivateUser(c *gin.Context) {
+	userID, claims, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	var reqBody struct {
+		Reason string `json:"reason" binding:"max=500"`
+	}
+	if !bindOptionalJSON(c, &reqBody) {
+		return
+	}
+
+	h.setUserSuspended(c, userID, claims.ID, false, reqBody.Reason)
+}
+
+func (h *Handler) setUserSuspended(c *gin.Context, userID, actorID primitive.ObjectID, suspend bool, reason string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": userID, "suspended_at": nil}
+	update := bson.M{"$set": bson.M{"suspended_at": time.Now()}}
+	action := Models.AuditSuspended
+	if !suspend {
+		filter["suspended_at"] = bson.M{"$ne": nil}
+		update = bson.M{"$unset": bson.M{"suspended_at": ""}}
+		action = Models.AuditReactivated
+	}
+
+	collection := h.DB.Collection("users")
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	if result.MatchedCount == 0 {
+		count, err := collection.CountDocuments(ctx, bson.M{"_id": userID})
+		switch {
+		case err != nil:
+			Middleware.Fail(c, Middleware.Internal(err))
+		case count == 0:
+			Middleware.Fail(c, Middleware.NotFound("Không tìm thấy người dùng"))
+		case suspend:
+			Middleware.Fail(c, Middleware.Conflict("Tài khoản đã bị khóa"))
+		default:
+			Middleware.Fail(c, Middleware.Conflict("Tài khoản không bị khóa"))
+		}
+		return
+	}
+
+	if suspend {
+		if err := h.revokeUserSessions(ctx, userID, primitive.NilObjectID); err != nil {
+			Middleware.Fail(c, Middleware.Internal(err))
+			return
+		}
+	}
+
+	if err := h.recordUserAudit(ctx, Models.UserAuditEntry{
+		UserID:  userID,
+		ActorID: actorID,
+		Action:  action,
+		Reason:  strings.TrimSpace(reason),
+	}); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	if suspend {
+		c.JSON(http.StatusOK, gin.H{"message": "Account suspended"})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "Account reactivated"})
+	}
+}
+
+// GetUserAudit lists the administrative changes made to a user, newest
+// first.
+func (h *Handler) GetUserAudit(c *gin.Context) {
+	userID, ok := paramObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	spec := listSpec{DefaultSort: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}}
+
+	entries := []Models.UserAuditEntry{}
+	page, ok := listPage(c, h.getUserAuditCollection(), spec, bson.M{"user_id": userID}, nil, &entries)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+var userIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+	{Keys: bson.D{{Key: "role", Value: 1}, {Key: "_id", Value: -1}}},
+}
+
+var userAuditIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+}
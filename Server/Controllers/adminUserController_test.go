@@ -0,0 +1,125 @@
+package Controllers
+
+import (
+	"net/http"
+	"testing"
+
+	"Server/Models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func roleDefinition(role Models.Role) Models.RoleDefinition {
+	for _, definition := range Models.DefaultRoleDefinitions() {
+		if definition.ID == role {
+			return definition
+		}
+	}
+	panic("unknown role")
+}
+
+func TestChangeUserRole(t *testing.T) {
+	userManager := &caller{ID: primitive.NewObjectID(), Role: 3, Permissions: []Models.Permission{Models.PermUsersManage}}
+	staffManager := &caller{ID: primitive.NewObjectID(), Role: 4, Permissions: append(
+		[]Models.Permission{Models.PermUsersManage}, roleDefinition(Models.Staff).Permissions...)}
+	target := primitive.NewObjectID()
+
+	tests := []struct {
+		name     string
+		caller   *caller
+		user     primitive.ObjectID
+		role     Models.Role
+		replies  func(mt *mtest.T) []bson.D
+		status   int
+		wantCode string
+	}{
+		{
+			name:     "own role",
+			caller:   staffManager,
+			user:     staffManager.ID,
+			role:     Models.Admin,
+			status:   http.StatusForbidden,
+			wantCode: "forbidden",
+		},
+		{
+			name:   "grant a role with more permissions",
+			caller: userManager,
+			user:   target,
+			role:   Models.Admin,
+			replies: func(mt *mtest.T) []bson.D {
+				return []bson.D{found(mt, "roles", roleDefinition(Models.Admin))}
+			},
+			status:   http.StatusForbidden,
+			wantCode: "missing_permission",
+		},
+		{
+			name:   "take away a role with more permissions",
+			caller: staffManager,
+			user:   target,
+			role:   Models.Customer,
+			replies: func(mt *mtest.T) []bson.D {
+				return []bson.D{
+					found(mt, "roles", roleDefinition(Models.Customer)),
+					found(mt, "users", bson.M{"_id": target, "role": Models.Admin}),
+					found(mt, "roles", roleDefinition(Models.Admin)),
+				}
+			},
+			status:   http.StatusForbidden,
+			wantCode: "missing_permission",
+		},
+		{
+			name:   "grant a role within own permissions",
+			caller: staffManager,
+			user:   target,
+			role:   Models.Staff,
+			replies: func(mt *mtest.T) []bson.D {
+				return []bson.D{
+					found(mt, "roles", roleDefinition(Models.Staff)),
+					found(mt, "users", bson.M{"_id": target, "role": Models.Customer}),
+					found(mt, "roles", roleDefinition(Models.Customer)),
+					updated(1),
+					found(mt, "sessions"),
+					mtest.CreateSuccessResponse(),
+				}
+			},
+			status: http.StatusOK,
+		},
+		{
+			name:   "role changed meanwhile",
+			caller: staffManager,
+			user:   target,
+			role:   Models.Staff,
+			replies: func(mt *mtest.T) []bson.D {
+				return []bson.D{
+					found(mt, "roles", roleDefinition(Models.Staff)),
+					found(mt, "users", bson.M{"_id": target, "role": Models.Customer}),
+					found(mt, "roles", roleDefinition(Models.Customer)),
+					updated(0),
+				}
+			},
+			status:   http.StatusConflict,
+			wantCode: "conflict",
+		},
+	}
+
+	mt := newMockDB(t)
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			h := newTestHandler(mt)
+			if tt.replies != nil {
+				mt.AddMockResponses(tt.replies(mt)...)
+			}
+
+			w := serveAt("/users/:id/role", "/users/"+tt.user.Hex()+"/role", h.ChangeUserRole, tt.caller,
+				map[string]interface{}{"role": tt.role})
+			if w.Code != tt.status {
+				mt.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.wantCode != "" && errorCode(mt, w) != tt.wantCode {
+				mt.Errorf("error %s, want %s", w.Body, tt.wantCode)
+			}
+		})
+	}
+}
@@ -2,157 +2,212 @@ package Controllers
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"net/http"
-	"os"
 	"time"
 
+	"Server/Middleware"
 	"Server/Models"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
-var jwtRefreshSecret = []byte(os.Getenv("JWT_REFRESH_SECRET"))
+var (
+	errInvalidRefreshToken = errors.New("invalid refresh token")
+	errRefreshTokenReused  = errors.New("refresh token reused")
+)
 
 type TokenResponse struct {
-	AccessToken  string `json:"accessToken"`
-	RefreshToken string `json:"refreshToken"`
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
 }
 
-func Login(c *gin.Context) {
-	var user Models.User
-	var dbUser Models.User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
-		return
-	}
-
-	collection := Database.Collection("users")
-	ctx, cancel := context.WithTimeout(c, 5*time.Second)
-	defer cancel()
-
-	err := collection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&dbUser)
-	if err == mongo.ErrNoDocuments {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
-		return
-	}
+func (h *Handler) getRefreshTokenCollection() *mongo.Collection {
+	return h.DB.Collection("refresh_tokens")
+}
 
-	err = bcrypt.CompareHashAndPassword([]byte(dbUser.Password), []byte(user.Password))
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
-		return
-	}
+// startSession records a new signed-in device for user and issues its first
+// token pair.
+func (h *Handler) startSession(c *gin.Context, user Models.User) (TokenResponse, error) {
+	now := time.Now()
+	session := Models.Session{
+		ID:         primitive.NewObjectID(),
+		UserID:     user.ID,
+		UserAgent:  c.Request.UserAgent(),
+		IP:         c.ClientIP(),
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(h.Config.JWT.RefreshTTL.Duration()),
+	}
+	if _, err := h.getSessionCollection().InsertOne(c, session); err != nil {
+		return TokenResponse{}, err
+	}
+	return h.issueTokens(c, user, session.ID)
+}
 
-	accessToken, err := createToken(dbUser.ID.Hex(), dbUser.Role, 15*time.Minute, jwtSecret)
+// issueTokens signs a new access token for user and pairs it with a refresh
+// token, both bound to sessionID.
+func (h *Handler) issueTokens(ctx context.Context, user Models.User, sessionID primitive.ObjectID) (TokenResponse, error) {
+	accessToken, err := h.Auth.GenerateJWT(user.ID, Middleware.Role(user.Role), sessionID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
-		return
+		return TokenResponse{}, err
 	}
 
-	refreshToken, err := createToken(dbUser.ID.Hex(), dbUser.Role, 7*24*time.Hour, jwtRefreshSecret)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create refresh token"})
-		return
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return TokenResponse{}, err
 	}
+	refreshToken := base64.RawURLEncoding.EncodeToString(raw)
 
-	c.JSON(http.StatusOK, TokenResponse{AccessToken: accessToken, RefreshToken: refreshToken})
-}
-
-func Register(c *gin.Context) {
-	var user Models.User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
-		return
+	now := time.Now()
+	record := Models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  sessionID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: now.Add(h.Config.JWT.RefreshTTL.Duration()),
+		CreatedAt: now,
 	}
-
-	if len(user.Password) < 8 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be at least 8 characters long"})
-		return
+	if _, err := h.getRefreshTokenCollection().InsertOne(ctx, record); err != nil {
+		return TokenResponse{}, err
 	}
 
-	hash, _ := bcrypt.GenerateFromPassword([]byte(user.Password), 10)
-	user.Password = string(hash)
+	return TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(h.Config.JWT.AccessTTL.Duration().Seconds()),
+	}, nil
+}
 
-	collection := Database.Collection("users")
-	ctx, cancel := context.WithTimeout(c, 5*time.Second)
-	defer cancel()
+// rotateRefreshToken exchanges a refresh token for a new token pair. Each
+// refresh token can be used once; presenting one that was already used or
+// revoked means it leaked, so its whole session is revoked.
+func (h *Handler) rotateRefreshToken(ctx context.Context, refreshToken string) (TokenResponse, error) {
+	collection := h.getRefreshTokenCollection()
+	tokenHash := hashToken(refreshToken)
+	now := time.Now()
+
+	var record Models.RefreshToken
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{
+			"token_hash": tokenHash,
+			"used_at":    nil,
+			"revoked_at": nil,
+			"expires_at": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"used_at": now}},
+	).Decode(&record)
 
-	count, _ := collection.CountDocuments(ctx, bson.M{"email": user.Email})
-	if count > 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Email already exists"})
-		return
+	if err == mongo.ErrNoDocuments {
+		var stale Models.RefreshToken
+		if err := collection.FindOne(ctx, bson.M{"token_hash": tokenHash}).Decode(&stale); err != nil {
+			return TokenResponse{}, errInvalidRefreshToken
+		}
+		if stale.UsedAt != nil || stale.RevokedAt != nil {
+			if err := h.revokeSessions(ctx, bson.M{"_id": stale.FamilyID}); err != nil {
+				return TokenResponse{}, err
+			}
+			return TokenResponse{}, errRefreshTokenReused
+		}
+		return TokenResponse{}, errInvalidRefreshToken
 	}
-
-	user.Role = Models.Customer
-	user.ID = primitive.NewObjectID()
-
-	_, err := collection.InsertOne(ctx, user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving user"})
-		return
+		return TokenResponse{}, err
 	}
 
-	accessToken, err := createToken(user.ID.Hex(), user.Role, 15*time.Minute, jwtSecret)
+	result, err := h.getSessionCollection().UpdateOne(ctx,
+		bson.M{"_id": record.FamilyID, "revoked_at": nil},
+		bson.M{"$set": bson.M{
+			"last_used_at": now,
+			"expires_at":   now.Add(h.Config.JWT.RefreshTTL.Duration()),
+		}},
+	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
-		return
+		return TokenResponse{}, err
+	}
+	if result.MatchedCount == 0 {
+		return TokenResponse{}, errInvalidRefreshToken
 	}
 
-	refreshToken, err := createToken(user.ID.Hex(), user.Role, 7*24*time.Hour, jwtRefreshSecret)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create refresh token"})
-		return
+	var user Models.User
+	if err := h.DB.Collection("users").FindOne(ctx, bson.M{"_id": record.UserID}).Decode(&user); err != nil {
+		h.revokeSessions(ctx, bson.M{"_id": record.FamilyID})
+		return TokenResponse{}, errInvalidRefreshToken
 	}
 
-	c.JSON(http.StatusOK, TokenResponse{AccessToken: accessToken, RefreshToken: refreshToken})
+	return h.issueTokens(ctx, user, record.FamilyID)
 }
 
-func RefreshToken(c *gin.Context) {
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (h *Handler) RefreshToken(c *gin.Context) {
 	var reqBody struct {
-		RefreshToken string `json:"refreshToken"`
+		RefreshToken string `json:"refresh_token" binding:"required"`
 	}
-	if err := c.ShouldBindJSON(&reqBody); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+	if !bindJSON(c, &reqBody) {
 		return
 	}
 
-	token, err := jwt.Parse(reqBody.RefreshToken, func(token *jwt.Token) (interface{}, error) {
-		return jwtRefreshSecret, nil
-	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	if err != nil || !token.Valid {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
-		return
+	tokens, err := h.rotateRefreshToken(ctx, reqBody.RefreshToken)
+	switch {
+	case errors.Is(err, errRefreshTokenReused):
+		Middleware.Fail(c, Middleware.Unauthorized(Middleware.CodeSessionRevoked, "Phiên đăng nhập đã bị thu hồi, vui lòng đăng nhập lại"))
+	case errors.Is(err, errInvalidRefreshToken):
+		Middleware.Fail(c, Middleware.Unauthorized(Middleware.CodeInvalidToken, "Phiên đăng nhập không hợp lệ hoặc đã hết hạn"))
+	case err != nil:
+		Middleware.Fail(c, Middleware.Internal(err))
+	default:
+		c.JSON(http.StatusOK, tokens)
 	}
+}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		userId := claims["sub"].(string)
-		role := claims["role"].(float64)
+// Logout revokes the session the given refresh token belongs to, which signs
+// out this device.
+func (h *Handler) Logout(c *gin.Context) {
+	var reqBody struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if !bindJSON(c, &reqBody) {
+		return
+	}
 
-		accessToken, err := createToken(userId, Models.Role(role), 15*time.Minute, jwtSecret)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create new token"})
-			return
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-		c.JSON(http.StatusOK, TokenResponse{AccessToken: accessToken, RefreshToken: reqBody.RefreshToken})
-	} else {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+	var record Models.RefreshToken
+	err := h.getRefreshTokenCollection().FindOne(ctx, bson.M{"token_hash": hashToken(reqBody.RefreshToken)}).Decode(&record)
+	if err == nil {
+		err = h.revokeSessions(ctx, bson.M{"_id": record.FamilyID})
+	}
+	if err != nil && err != mongo.ErrNoDocuments {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
 	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
 }
 
-func createToken(userID string, role Models.Role, duration time.Duration, secret []byte) (string, error) {
-	claims := jwt.MapClaims{
-		"sub":  userID,
-		"role": role,
-		"exp":  time.Now().Add(duration).Unix(),
-	}
+var sessionIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(secret)
+var refreshTokenIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+	{Keys: bson.D{{Key: "family_id", Value: 1}}},
+	{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
 }
@@ -2,6 +2,9 @@ package Controllers
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
 	"time"
 
 	"Server/Middleware"
@@ -9,144 +12,394 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func getCartCollection() *mongo.Collection {
-	return Database.Collection("carts")
+func (h *Handler) getCartCollection() *mongo.Collection {
+	return h.DB.Collection("carts")
 }
 
-func getProductCollection() *mongo.Collection {
-	return Database.Collection("products")
+func (h *Handler) getProductCollection() *mongo.Collection {
+	return h.DB.Collection("products")
 }
 
-func AddToCart(c *gin.Context) {
+// productRef names the product a remove request is about.
+type productRef struct {
+	ProductID primitive.ObjectID `json:"product_id" binding:"required"`
+}
+
+// cartLineRequest is the body of AddToCart and UpdateCart. Prices always
+// come from the product, never from the client.
+type cartLineRequest struct {
+	ProductID primitive.ObjectID `json:"product_id" binding:"required"`
+	Quantity  int                `json:"quantity" binding:"gt=0,lte=100"`
+}
+
+// maxCartQuantity is the most of one product a cart line can hold.
+const maxCartQuantity = 100
+
+// productsByID loads the products with the given IDs. Products that no
+// longer exist are missing from the result.
+func (h *Handler) productsByID(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]Models.Product, error) {
+	products := make(map[primitive.ObjectID]Models.Product, len(ids))
+	if len(ids) == 0 {
+		return products, nil
+	}
+
+	cursor, err := h.getProductCollection().Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	var found []Models.Product
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	for _, product := range found {
+		products[product.ID] = product
+	}
+	return products, nil
+}
+
+// priceCart fills in every line of cart from the current product and flags
+// the lines whose price changed since the customer added them, that are
+// out of stock or whose product was removed.
+func (h *Handler) priceCart(ctx context.Context, cart *Models.Cart) error {
+	var err error
+	cart.Subtotal, cart.HasIssues, err = h.priceCartItems(ctx, cart.Items)
+	return err
+}
+
+// priceCartItems prices and flags items in place for priceCart and
+// priceSelectedItems, and returns their subtotal and whether any line has
+// an issue.
+func (h *Handler) priceCartItems(ctx context.Context, items []Models.CartItem) (Models.Money, bool, error) {
+	ids := make([]primitive.ObjectID, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ProductID)
+	}
+	products, err := h.productsByID(ctx, ids)
+	if err != nil {
+		return Models.Money{}, false, err
+	}
+
+	var subtotal Models.Money
+	hasIssues := false
+	for i := range items {
+		item := &items[i]
+		item.Issues = nil
+
+		product, ok := products[item.ProductID]
+		if !ok {
+			item.Price = Models.Money{}
+			item.LineTotal = Models.Money{}
+			item.Available = 0
+			item.Issues = append(item.Issues, Models.CartUnavailable)
+			hasIssues = true
+			continue
+		}
+
+		item.Name = product.Name
+		item.ImageURL = product.ImageURL
+		item.Price = product.Price
+		item.LineTotal = product.Price.Mul(item.Quantity)
+		item.Available = product.Stock
+		if !item.AddedPrice.IsZero() && item.AddedPrice.Cmp(product.Price) != 0 {
+			item.Issues = append(item.Issues, Models.CartPriceChanged)
+		}
+		switch {
+		case product.Stock <= 0:
+			item.Issues = append(item.Issues, Models.CartOutOfStock)
+		case product.Stock < item.Quantity:
+			item.Issues = append(item.Issues, Models.CartInsufficientStock)
+		}
+		if len(item.Issues) > 0 {
+			hasIssues = true
+		}
+		subtotal = subtotal.Add(item.LineTotal)
+	}
+	return subtotal, hasIssues, nil
+}
+
+// cartQuantityError reports quantity of product as more than a cart line
+// may hold or than there is in stock, or returns nil.
+func cartQuantityError(product Models.Product, quantity int) *Middleware.APIError {
+	if quantity > maxCartQuantity {
+		return Middleware.ValidationFailed(Middleware.FieldError{
+			Field:   "quantity",
+			Code:    "lte",
+			Message: fmt.Sprintf("Tối đa %d sản phẩm mỗi loại trong giỏ hàng", maxCartQuantity),
+		})
+	}
+	if quantity > product.Stock {
+		return Middleware.Conflict("Không đủ hàng trong kho").WithDetail("items", []stockShortage{{
+			ProductID: product.ID,
+			Name:      product.Name,
+			Requested: quantity,
+			Available: product.Stock,
+		}})
+	}
+	return nil
+}
+
+var errCartChanged = errors.New("cart changed concurrently")
+
+// saveCart writes the items of cart, which was read before, unless another
+// request changed the cart since; then it returns errCartChanged. A cart
+// without an ID is created, and one left without items is deleted.
+func (h *Handler) saveCart(ctx context.Context, cart *Models.Cart) error {
+	collection := h.getCartCollection()
+	read := cart.UpdatedAt
+	cart.UpdatedAt = time.Now()
+
+	if cart.ID.IsZero() {
+		cart.CreatedAt = cart.UpdatedAt
+		result, err := collection.UpdateOne(ctx, bson.M{"user_id": cart.UserID},
+			bson.M{"$setOnInsert": bson.M{"items": cart.Items, "created_at": cart.CreatedAt, "updated_at": cart.UpdatedAt}},
+			options.Update().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+		if result.UpsertedID == nil {
+			return errCartChanged
+		}
+		cart.ID = result.UpsertedID.(primitive.ObjectID)
+		return nil
+	}
+
+	unchanged := bson.M{"_id": cart.ID, "updated_at": read}
+	if read.IsZero() {
+		unchanged["updated_at"] = bson.M{"$exists": false}
+	}
+
+	var matched int64
+	if len(cart.Items) == 0 {
+		result, err := collection.DeleteOne(ctx, unchanged)
+		if err != nil {
+			return err
+		}
+		matched = result.DeletedCount
+	} else {
+		result, err := collection.UpdateOne(ctx, unchanged,
+			bson.M{"$set": bson.M{"items": cart.Items, "updated_at": cart.UpdatedAt}})
+		if err != nil {
+			return err
+		}
+		matched = result.MatchedCount
+	}
+	if matched == 0 {
+		return errCartChanged
+	}
+	return nil
+}
+
+// cartWriteError is the response to a failed saveCart.
+func cartWriteError(err error) *Middleware.APIError {
+	if errors.Is(err, errCartChanged) {
+		return Middleware.Conflict("Giỏ hàng vừa thay đổi, vui lòng thử lại")
+	}
+	return Middleware.Internal(err)
+}
+
+func (h *Handler) AddToCart(c *gin.Context) {
 	claims := c.MustGet("user").(*Middleware.UserClaims)
 	userID := claims.ID
 
-	var cartItem Models.CartItem
-	if err := c.BindJSON(&cartItem); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid input"})
+	var reqBody cartLineRequest
+	if !bindJSON(c, &reqBody) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var product Models.Product
+	if err := h.getProductCollection().FindOne(ctx, bson.M{"_id": reqBody.ProductID}).Decode(&product); err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy sản phẩm"))
 		return
 	}
 
-	cartCollection := getCartCollection()
 	var cart Models.Cart
-	err := cartCollection.FindOne(context.Background(), bson.M{"user_id": userID}).Decode(&cart)
-
-	if err == mongo.ErrNoDocuments {
-		cart = Models.Cart{
-			UserID:    userID,
-			Items:     []Models.CartItem{cartItem},
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
+	err := h.getCartCollection().FindOne(ctx, bson.M{"user_id": userID}).Decode(&cart)
+	if err != nil && err != mongo.ErrNoDocuments {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	line := -1
+	for i, item := range cart.Items {
+		if item.ProductID == reqBody.ProductID {
+			line = i
+			break
 		}
-		_, err = cartCollection.InsertOne(context.Background(), cart)
+	}
+	quantity := reqBody.Quantity
+	if line >= 0 {
+		quantity += cart.Items[line].Quantity
+	}
+	if apiErr := cartQuantityError(product, quantity); apiErr != nil {
+		Middleware.Fail(c, apiErr)
+		return
+	}
+
+	// The customer adds at the price shown to them now, so an earlier
+	// price change on this line no longer needs flagging.
+	if line >= 0 {
+		cart.Items[line].Quantity = quantity
+		cart.Items[line].AddedPrice = product.Price
 	} else {
-		exists := false
-		for i, item := range cart.Items {
-			if item.ProductID == cartItem.ProductID {
-				cart.Items[i].Quantity += cartItem.Quantity
-				exists = true
-				break
-			}
-		}
-		if !exists {
-			cart.Items = append(cart.Items, cartItem)
-		}
-		cart.UpdatedAt = time.Now()
-		_, err = cartCollection.UpdateOne(context.Background(), bson.M{"user_id": userID}, bson.M{"$set": cart})
+		cart.Items = append(cart.Items, Models.CartItem{
+			ProductID:  product.ID,
+			Quantity:   quantity,
+			AddedPrice: product.Price,
+		})
 	}
 
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to add to cart"})
+	cart.UserID = userID
+	if err := h.saveCart(ctx, &cart); err != nil {
+		Middleware.Fail(c, cartWriteError(err))
 		return
 	}
 
-	c.JSON(200, cart)
+	if err := h.priceCart(ctx, &cart); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	c.JSON(http.StatusOK, cart)
 }
 
-func GetCart(c *gin.Context) {
+// GetCart returns the cart at current prices, with the lines that changed
+// since they were added flagged.
+func (h *Handler) GetCart(c *gin.Context) {
 	claims := c.MustGet("user").(*Middleware.UserClaims)
 	userID := claims.ID
 
-	cartCollection := getCartCollection()
-	var cart Models.Cart
-	err := cartCollection.FindOne(context.Background(), bson.M{"user_id": userID}).Decode(&cart)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
+	cartCollection := h.getCartCollection()
+	var cart Models.Cart
+	err := cartCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&cart)
 	if err != nil {
-		c.JSON(404, gin.H{"error": "Cart not found"})
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy giỏ hàng"))
 		return
 	}
 
-	productCollection := getProductCollection()
-	for i, item := range cart.Items {
-		var product Models.Product
-		err := productCollection.FindOne(context.Background(), bson.M{"_id": item.ProductID}).Decode(&product)
-		if err != nil {
-			c.JSON(404, gin.H{"error": "Product not found"})
-			return
-		}
-
-		cart.Items[i].Name = product.Name
-		cart.Items[i].Price = product.Price
-		cart.Items[i].ImageURL = product.ImageURL
+	if err := h.priceCart(ctx, &cart); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
 	}
-
-	c.JSON(200, cart)
+	c.JSON(http.StatusOK, cart)
 }
 
-func UpdateCart(c *gin.Context) {
+func (h *Handler) UpdateCart(c *gin.Context) {
 	claims := c.MustGet("user").(*Middleware.UserClaims)
 	userID := claims.ID
 
-	var cartItem Models.CartItem
-	if err := c.BindJSON(&cartItem); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid input"})
+	var reqBody cartLineRequest
+	if !bindJSON(c, &reqBody) {
 		return
 	}
 
-	cartCollection := getCartCollection()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
 	var cart Models.Cart
-	err := cartCollection.FindOne(context.Background(), bson.M{"user_id": userID}).Decode(&cart)
+	err := h.getCartCollection().FindOne(ctx, bson.M{"user_id": userID}).Decode(&cart)
 	if err != nil {
-		c.JSON(404, gin.H{"error": "Cart not found"})
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy giỏ hàng"))
 		return
 	}
 
+	line := -1
 	for i, item := range cart.Items {
-		if item.ProductID == cartItem.ProductID {
-			cart.Items[i].Quantity = cartItem.Quantity
+		if item.ProductID == reqBody.ProductID {
+			line = i
 			break
 		}
 	}
+	if line < 0 {
+		Middleware.Fail(c, Middleware.NotFound("Sản phẩm không có trong giỏ hàng"))
+		return
+	}
 
-	cart.UpdatedAt = time.Now()
-	_, err = cartCollection.UpdateOne(context.Background(), bson.M{"user_id": userID}, bson.M{"$set": cart})
+	var product Models.Product
+	if err := h.getProductCollection().FindOne(ctx, bson.M{"_id": reqBody.ProductID}).Decode(&product); err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy sản phẩm"))
+		return
+	}
+	if apiErr := cartQuantityError(product, reqBody.Quantity); apiErr != nil {
+		Middleware.Fail(c, apiErr)
+		return
+	}
 
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to update cart"})
+	cart.Items[line].Quantity = reqBody.Quantity
+	cart.Items[line].AddedPrice = product.Price
+	if err := h.saveCart(ctx, &cart); err != nil {
+		Middleware.Fail(c, cartWriteError(err))
+		return
+	}
+
+	if err := h.priceCart(ctx, &cart); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	c.JSON(http.StatusOK, cart)
+}
+
+// RefreshCartPrices accepts the current price of every line, once the
+// customer has seen the changes GetCart flagged.
+func (h *Handler) RefreshCartPrices(c *gin.Context) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+	userID := claims.ID
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var cart Models.Cart
+	if err := h.getCartCollection().FindOne(ctx, bson.M{"user_id": userID}).Decode(&cart); err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy giỏ hàng"))
 		return
 	}
 
-	c.JSON(200, cart)
+	if err := h.priceCart(ctx, &cart); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	for i, item := range cart.Items {
+		if !item.Price.IsZero() {
+			cart.Items[i].AddedPrice = item.Price
+		}
+	}
+	if err := h.saveCart(ctx, &cart); err != nil {
+		Middleware.Fail(c, cartWriteError(err))
+		return
+	}
+
+	if err := h.priceCart(ctx, &cart); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	c.JSON(http.StatusOK, cart)
 }
 
-func RemoveFromCart(c *gin.Context) {
+func (h *Handler) RemoveFromCart(c *gin.Context) {
 	claims := c.MustGet("user").(*Middleware.UserClaims)
 	userID := claims.ID
 
-	var cartItem Models.CartItem
-	if err := c.BindJSON(&cartItem); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid input"})
+	var cartItem productRef
+	if !bindJSON(c, &cartItem) {
 		return
 	}
 
-	cartCollection := getCartCollection()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
 	var cart Models.Cart
-	err := cartCollection.FindOne(context.Background(), bson.M{"user_id": userID}).Decode(&cart)
-	if err == mongo.ErrNoDocuments {
-		c.JSON(404, gin.H{"error": "Cart not found"})
+	err := h.getCartCollection().FindOne(ctx, bson.M{"user_id": userID}).Decode(&cart)
+	if err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy giỏ hàng"))
 		return
 	}
 
@@ -160,25 +413,19 @@ func RemoveFromCart(c *gin.Context) {
 	}
 
 	if !productRemoved {
-		c.JSON(400, gin.H{"error": "Product not found in cart"})
+		Middleware.Fail(c, Middleware.NotFound("Sản phẩm không có trong giỏ hàng"))
 		return
 	}
 
-	if len(cart.Items) == 0 {
-		_, err = cartCollection.DeleteOne(context.Background(), bson.M{"user_id": userID})
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to delete cart"})
-			return
-		}
-	} else {
-		cart.UpdatedAt = time.Now()
-		_, err = cartCollection.UpdateOne(context.Background(), bson.M{"user_id": userID}, bson.M{"$set": cart})
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to update cart"})
-			return
-		}
+	if err := h.saveCart(ctx, &cart); err != nil {
+		Middleware.Fail(c, cartWriteError(err))
+		return
 	}
 
+	if err := h.priceCart(ctx, &cart); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
 	c.JSON(200, gin.H{
 		"message": "Product removed from cart",
 		"cart":    cart,
@@ -0,0 +1,105 @@
+package Controllers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"Server/Models"
+
+	"github.com/gin-gonic/gin"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestCartWritesKeepConcurrentChanges(t *testing.T) {
+	customer := &caller{ID: primitive.NewObjectID(), Role: Models.Customer}
+	product := Models.Product{ID: primitive.NewObjectID(), Name: "Sữa rửa mặt", Price: Models.Dong(150000), Stock: 10}
+	cart := Models.Cart{
+		ID:        primitive.NewObjectID(),
+		UserID:    customer.ID,
+		Items:     []Models.CartItem{{ProductID: product.ID, Quantity: 1, AddedPrice: product.Price}},
+		UpdatedAt: time.Now().Truncate(time.Millisecond),
+	}
+	upserted := bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "upserted", Value: bson.A{
+		bson.D{{Key: "index", Value: 0}, {Key: "_id", Value: primitive.NewObjectID()}},
+	}}}
+
+	tests := []struct {
+		name    string
+		handler func(h *Handler) gin.HandlerFunc
+		body    interface{}
+		replies func(mt *mtest.T) []bson.D
+		status  int
+	}{
+		{
+			name:    "add to a new cart",
+			handler: func(h *Handler) gin.HandlerFunc { return h.AddToCart },
+			body:    cartLineRequest{ProductID: product.ID, Quantity: 1},
+			replies: func(mt *mtest.T) []bson.D {
+				return []bson.D{found(mt, "products", product), found(mt, "carts"), upserted, found(mt, "products", product)}
+			},
+			status: http.StatusOK,
+		},
+		{
+			name:    "add while another request creates the cart",
+			handler: func(h *Handler) gin.HandlerFunc { return h.AddToCart },
+			body:    cartLineRequest{ProductID: product.ID, Quantity: 1},
+			replies: func(mt *mtest.T) []bson.D {
+				return []bson.D{found(mt, "products", product), found(mt, "carts"), updated(1)}
+			},
+			status: http.StatusConflict,
+		},
+		{
+			name:    "add to a cart that changed since it was read",
+			handler: func(h *Handler) gin.HandlerFunc { return h.AddToCart },
+			body:    cartLineRequest{ProductID: product.ID, Quantity: 1},
+			replies: func(mt *mtest.T) []bson.D {
+				return []bson.D{found(mt, "products", product), found(mt, "carts", cart), updated(0)}
+			},
+			status: http.StatusConflict,
+		},
+		{
+			name:    "update a cart that changed since it was read",
+			handler: func(h *Handler) gin.HandlerFunc { return h.UpdateCart },
+			body:    cartLineRequest{ProductID: product.ID, Quantity: 3},
+			replies: func(mt *mtest.T) []bson.D {
+				return []bson.D{found(mt, "carts", cart), found(mt, "products", product), updated(0)}
+			},
+			status: http.StatusConflict,
+		},
+		{
+			name:    "remove the last line of a cart that changed since it was read",
+			handler: func(h *Handler) gin.HandlerFunc { return h.RemoveFromCart },
+			body:    productRef{ProductID: product.ID},
+			replies: func(mt *mtest.T) []bson.D {
+				return []bson.D{found(mt, "carts", cart), bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 0}}}
+			},
+			status: http.StatusConflict,
+		},
+		{
+			name:    "remove the last line",
+			handler: func(h *Handler) gin.HandlerFunc { return h.RemoveFromCart },
+			body:    productRef{ProductID: product.ID},
+			replies: func(mt *mtest.T) []bson.D {
+				return []bson.D{found(mt, "carts", cart), bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}}}
+			},
+			status: http.StatusOK,
+		},
+	}
+
+	mt := newMockDB(t)
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			h := newTestHandler(mt)
+			mt.AddMockResponses(tt.replies(mt)...)
+
+			w := serve(tt.handler(h), customer, tt.body)
+			if w.Code != tt.status {
+				mt.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,527 @@
+package Controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"Server/Middleware"
+	"Server/Models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (h *Handler) getCouponCollection() *mongo.Collection {
+	return h.DB.Collection("coupons")
+}
+
+func (h *Handler) getCouponUsageCollection() *mongo.Collection {
+	return h.DB.Collection("coupon_usage")
+}
+
+func (h *Handler) getCouponRedemptionCollection() *mongo.Collection {
+	return h.DB.Collection("coupon_redemptions")
+}
+
+var couponIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+	{Keys: bson.D{{Key: "active", Value: 1}, {Key: "created_at", Value: -1}}},
+}
+
+var couponRedemptionIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "coupon_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	{Keys: bson.D{{Key: "order_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+	{Keys: bson.D{{Key: "booking_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+}
+
+type couponRequest struct {
+	Code              string               `json:"code" binding:"required,min=3,max=30,alphanum"`
+	Description       string               `json:"description" binding:"max=500"`
+	Type              Models.DiscountType  `json:"type" binding:"required,oneof=percent fixed"`
+	Percent           float64              `json:"percent" binding:"gte=0,lte=100"`
+	Amount            Models.Money         `json:"amount" binding:"gte=0"`
+	MaxDiscount       Models.Money         `json:"max_discount" binding:"gte=0"`
+	MinOrder          Models.Money         `json:"min_order" binding:"gte=0"`
+	AppliesTo         Models.CouponTarget  `json:"applies_to" binding:"omitempty,oneof=all products services"`
+	ProductCategories []primitive.ObjectID `json:"product_categories" binding:"max=50"`
+	ServiceCategories []primitive.ObjectID `json:"service_categories" binding:"max=50"`
+	UsageLimit        int                  `json:"usage_limit" binding:"gte=0"`
+	PerUserLimit      int                  `json:"per_user_limit" binding:"gte=0"`
+	StartsAt          *time.Time           `json:"starts_at"`
+	EndsAt            *time.Time           `json:"ends_at"`
+	// Active defaults to true.
+	Active *bool `json:"active"`
+}
+
+// validate checks the rules between fields and that the categories exist.
+// On failure it fails the request and returns false.
+func (h *Handler) validateCouponRequest(c *gin.Context, r *couponRequest) bool {
+	r.Code = Models.NormalizeCouponCode(r.Code)
+	if r.AppliesTo == "" {
+		r.AppliesTo = Models.CouponForAll
+	}
+
+	var fields []Middleware.FieldError
+	if r.Type == Models.DiscountPercent && r.Percent <= 0 {
+		fields = append(fields, Middleware.FieldError{Field: "percent", Code: "gt", Message: "Phải lớn hơn 0"})
+	}
+	if r.Type == Models.DiscountFixed && !r.Amount.IsPositive() {
+		fields = append(fields, Middleware.FieldError{Field: "amount", Code: "gt", Message: "Phải lớn hơn 0"})
+	}
+	if r.StartsAt != nil && r.EndsAt != nil && !r.EndsAt.After(*r.StartsAt) {
+		fields = append(fields, Middleware.FieldError{Field: "ends_at", Code: "after", Message: "Phải sau thời điểm bắt đầu"})
+	}
+	if len(r.ProductCategories) > 0 && r.AppliesTo == Models.CouponForServices {
+		fields = append(fields, Middleware.FieldError{Field: "product_categories", Code: "applies_to", Message: "Mã chỉ áp dụng cho dịch vụ"})
+	}
+	if len(r.ServiceCategories) > 0 && r.AppliesTo == Models.CouponForProducts {
+		fields = append(fields, Middleware.FieldError{Field: "service_categories", Code: "applies_to", Message: "Mã chỉ áp dụng cho sản phẩm"})
+	}
+
+	for field, check := range map[string]struct {
+		collection string
+		ids        []primitive.ObjectID
+	}{
+		"product_categories": {"product_categories", r.ProductCategories},
+		"service_categories": {"service_categories", r.ServiceCategories},
+	} {
+		for i, id := range check.ids {
+			count, err := h.getCollection(check.collection).CountDocuments(context.Background(), bson.M{"_id": id})
+			if err != nil {
+				Middleware.Fail(c, Middleware.Internal(err))
+				return false
+			}
+			if count == 0 {
+				fields = append(fields, Middleware.FieldError{
+					Field:   field + "[" + strconv.Itoa(i) + "]",
+					Code:    "exists",
+					Message: "Không tìm thấy danh mục",
+				})
+			}
+		}
+	}
+
+	if len(fields) > 0 {
+		Middleware.Fail(c, Middleware.ValidationFailed(fields...))
+		return false
+	}
+	return true
+}
+
+func (r couponRequest) fields() bson.M {
+	active := r.Active == nil || *r.Active
+	return bson.M{
+		"code":               r.Code,
+		"description":        r.Description,
+		"type":               r.Type,
+		"percent":            r.Percent,
+		"amount":             r.Amount,
+		"max_discount":       r.MaxDiscount,
+		"min_order":          r.MinOrder,
+		"applies_to":         r.AppliesTo,
+		"product_categories": r.ProductCategories,
+		"service_categories": r.ServiceCategories,
+		"usage_limit":        r.UsageLimit,
+		"per_user_limit":     r.PerUserLimit,
+		"starts_at":          r.StartsAt,
+		"ends_at":            r.EndsAt,
+		"active":             active,
+		"updated_at":         time.Now(),
+	}
+}
+
+func (h *Handler) CreateCoupon(c *gin.Context) {
+	var reqBody couponRequest
+	if !bindJSON(c, &reqBody) {
+		return
+	}
+	if !h.validateCouponRequest(c, &reqBody) {
+		return
+	}
+
+	fields := reqBody.fields()
+	fields["used"] = 0
+	fields["created_at"] = fields["updated_at"]
+
+	result, err := h.getCouponCollection().InsertOne(context.Background(), fields)
+	if mongo.IsDuplicateKeyError(err) {
+		Middleware.Fail(c, Middleware.Conflict("Mã giảm giá đã tồn tại"))
+		return
+	}
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	var coupon Models.Coupon
+	if err := h.getCouponCollection().FindOne(context.Background(), bson.M{"_id": result.InsertedID}).Decode(&coupon); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, coupon)
+}
+
+var couponListSpec = listSpec{
+	Filters: []listFilter{
+		couponActiveFilter,
+		equalFilter("applies_to", "applies_to"),
+	},
+	Sorts: map[string]string{
+		"code":       "code",
+		"created_at": "created_at",
+		"ends_at":    "ends_at",
+		"used":       "used",
+	},
+	DefaultSort: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}},
+}
+
+func couponActiveFilter(c *gin.Context, match bson.M) error {
+	value := c.Query("active")
+	if value == "" {
+		return nil
+	}
+	active, err := strconv.ParseBool(value)
+	if err != nil {
+		return &queryParamError{Param: "active", Code: "boolean", Message: "Phải là true hoặc false"}
+	}
+	match["active"] = active
+	return nil
+}
+
+func (h *Handler) GetCoupons(c *gin.Context) {
+	coupons := []Models.Coupon{}
+	page, ok := listPage(c, h.getCouponCollection(), couponListSpec, nil, nil, &coupons)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+func (h *Handler) GetCouponByID(c *gin.Context) {
+	objectID, ok := paramObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	var coupon Models.Coupon
+	if err := h.getCouponCollection().FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&coupon); err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy mã giảm giá"))
+		return
+	}
+
+	c.JSON(http.StatusOK, coupon)
+}
+
+// UpdateCoupon replaces the settings of a coupon. Its redemption count is
+// kept, so lowering the usage limit below it stops further use.
+func (h *Handler) UpdateCoupon(c *gin.Context) {
+	objectID, ok := paramObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	var reqBody couponRequest
+	if !bindJSON(c, &reqBody) {
+		return
+	}
+	if !h.validateCouponRequest(c, &reqBody) {
+		return
+	}
+
+	var coupon Models.Coupon
+	err := h.getCouponCollection().FindOneAndUpdate(context.Background(),
+		bson.M{"_id": objectID},
+		bson.M{"$set": reqBody.fields()},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&coupon)
+	if mongo.IsDuplicateKeyError(err) {
+		Middleware.Fail(c, Middleware.Conflict("Mã giảm giá đã tồn tại"))
+		return
+	}
+	if err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy mã giảm giá"))
+		return
+	}
+
+	c.JSON(http.StatusOK, coupon)
+}
+
+// DeleteCoupon removes a coupon nobody has used yet. Used coupons are
+// referenced by orders and bookings and can only be deactivated.
+func (h *Handler) DeleteCoupon(c *gin.Context) {
+	objectID, ok := paramObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	result, err := h.getCouponCollection().DeleteOne(context.Background(), bson.M{"_id": objectID, "used": 0})
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	if result.DeletedCount == 0 {
+		count, err := h.getCouponCollection().CountDocuments(context.Background(), bson.M{"_id": objectID})
+		if err != nil {
+			Middleware.Fail(c, Middleware.Internal(err))
+			return
+		}
+		if count == 0 {
+			Middleware.Fail(c, Middleware.NotFound("Không tìm thấy mã giảm giá"))
+			return
+		}
+		Middleware.Fail(c, Middleware.Conflict("Mã giảm giá đã được sử dụng, hãy tắt mã thay vì xóa"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Coupon deleted successfully"})
+}
+
+// couponQuote is the price of a purchase with a coupon applied.
+type couponQuote struct {
+	CouponCode string       `json:"coupon_code,omitempty"`
+	Subtotal   Models.Money `json:"subtotal"`
+	Discount   Models.Money `json:"discount"`
+	Total      Models.Money `json:"total"`
+}
+
+func newCouponQuote(code string, lines []Models.CouponLine, discount Models.Money) couponQuote {
+	quote := couponQuote{CouponCode: code, Discount: discount}
+	for _, line := range lines {
+		quote.Subtotal = quote.Subtotal.Add(line.Amount)
+	}
+	quote.Total = quote.Subtotal.Sub(discount)
+	return quote
+}
+
+// checkCoupon looks up code and works out its discount for userID on a
+// purchase of target made of lines. It returns a *Models.CouponError when
+// the coupon cannot be used.
+func (h *Handler) checkCoupon(ctx context.Context, code string, userID primitive.ObjectID, target Models.CouponTarget, lines []Models.CouponLine) (Models.Coupon, Models.Money, error) {
+	var coupon Models.Coupon
+	err := h.getCouponCollection().FindOne(ctx, bson.M{"code": Models.NormalizeCouponCode(code)}).Decode(&coupon)
+	if err == mongo.ErrNoDocuments {
+		return coupon, Models.Money{}, &Models.CouponError{Code: "not_found", Message: "Mã giảm giá không tồn tại"}
+	}
+	if err != nil {
+		return coupon, Models.Money{}, err
+	}
+
+	discount, err := coupon.Apply(target, lines, time.Now())
+	if err != nil {
+		return coupon, Models.Money{}, err
+	}
+
+	if coupon.PerUserLimit > 0 {
+		var usage Models.CouponUsage
+		err := h.getCouponUsageCollection().FindOne(ctx, bson.M{"_id": Models.CouponUsageID(coupon.ID, userID)}).Decode(&usage)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return coupon, Models.Money{}, err
+		}
+		if usage.Used >= coupon.PerUserLimit {
+			return coupon, Models.Money{}, errCouponUserLimit
+		}
+	}
+	return coupon, discount, nil
+}
+
+var (
+	errCouponUsedUp    = &Models.CouponError{Code: "usage_limit", Message: "Mã giảm giá đã hết lượt sử dụng"}
+	errCouponUserLimit = &Models.CouponError{Code: "user_limit", Message: "Bạn đã dùng hết lượt của mã giảm giá này"}
+)
+
+// redeemCoupon counts one use of coupon by the user of redemption and
+// records the redemption. Both limits are checked by the updates that count
+// the use, and an upsert of the per-user counter that collides with a full
+// one fails on the unique _id, so concurrent checkouts cannot exceed them.
+// It must run inside a transaction so that a failed step undoes the others.
+func (h *Handler) redeemCoupon(sc mongo.SessionContext, coupon Models.Coupon, redemption Models.CouponRedemption) error {
+	result, err := h.getCouponCollection().UpdateOne(sc,
+		bson.M{
+			"_id":    coupon.ID,
+			"active": true,
+			"$or": []bson.M{
+				{"usage_limit": 0},
+				{"$expr": bson.M{"$lt": []string{"$used", "$usage_limit"}}},
+			},
+		},
+		bson.M{"$inc": bson.M{"used": 1}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errCouponUsedUp
+	}
+
+	usageFilter := bson.M{"_id": Models.CouponUsageID(coupon.ID, redemption.UserID)}
+	if coupon.PerUserLimit > 0 {
+		usageFilter["used"] = bson.M{"$lt": coupon.PerUserLimit}
+	}
+	_, err = h.getCouponUsageCollection().UpdateOne(sc, usageFilter,
+		bson.M{
+			"$inc":         bson.M{"used": 1},
+			"$setOnInsert": bson.M{"coupon_id": coupon.ID, "user_id": redemption.UserID},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return errCouponUserLimit
+	}
+	if err != nil {
+		return err
+	}
+
+	redemption.CouponID = coupon.ID
+	redemption.Code = coupon.Code
+	redemption.CreatedAt = time.Now()
+	_, err = h.getCouponRedemptionCollection().InsertOne(sc, redemption)
+	return err
+}
+
+// releaseCoupon gives back the coupon use recorded for the order or
+// booking matching filter. Deleting the redemption guards the counters, so
+// releasing twice has no further effect. It must run inside a transaction
+// so that the redemption is never deleted without the counts going down.
+func (h *Handler) releaseCoupon(sc mongo.SessionContext, filter bson.M) error {
+	var redemption Models.CouponRedemption
+	err := h.getCouponRedemptionCollection().FindOneAndDelete(sc, filter).Decode(&redemption)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := h.getCouponCollection().UpdateOne(sc,
+		bson.M{"_id": redemption.CouponID, "used": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"used": -1}},
+	); err != nil {
+		return err
+	}
+	_, err = h.getCouponUsageCollection().UpdateOne(sc,
+		bson.M{"_id": Models.CouponUsageID(redemption.CouponID, redemption.UserID), "used": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"used": -1}},
+	)
+	return err
+}
+
+// couponFailure reports a coupon that cannot be used as an error on the
+// coupon_code field.
+func couponFailure(err error) *Middleware.APIError {
+	var couponErr *Models.CouponError
+	if errors.As(err, &couponErr) {
+		return Middleware.ValidationFailed(Middleware.FieldError{
+			Field:   "coupon_code",
+			Code:    couponErr.Code,
+			Message: couponErr.Message,
+		})
+	}
+	return Middleware.Internal(err)
+}
+
+type couponCodeRequest struct {
+	CouponCode string `json:"coupon_code" binding:"required,max=30"`
+}
+
+// ApplySelectedItemsCoupon checks a coupon against the selected items and
+// keeps it for checkout.
+func (h *Handler) ApplySelectedItemsCoupon(c *gin.Context) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+
+	var reqBody couponCodeRequest
+	if !bindJSON(c, &reqBody) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var selectedItems Models.SelectedItems
+	if err := h.getSelectedItemsCollection().FindOne(ctx, bson.M{"user_id": claims.ID}).Decode(&selectedItems); err != nil {
+		Middleware.Fail(c, lookupError(err, "Chưa chọn sản phẩm nào"))
+		return
+	}
+
+	lines, err := h.selectedItemLines(ctx, selectedItems.Items)
+	if err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy sản phẩm"))
+		return
+	}
+
+	coupon, discount, err := h.checkCoupon(ctx, reqBody.CouponCode, claims.ID, Models.CouponForProducts, lines.coupon)
+	if err != nil {
+		Middleware.Fail(c, couponFailure(err))
+		return
+	}
+
+	if _, err := h.getSelectedItemsCollection().UpdateOne(ctx, bson.M{"user_id": claims.ID}, bson.M{"$set": bson.M{
+		"coupon_code": coupon.Code,
+		"updated_at":  time.Now(),
+	}}); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, newCouponQuote(coupon.Code, lines.coupon, discount))
+}
+
+func (h *Handler) RemoveSelectedItemsCoupon(c *gin.Context) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+
+	if _, err := h.getSelectedItemsCollection().UpdateOne(context.Background(), bson.M{"user_id": claims.ID}, bson.M{
+		"$unset": bson.M{"coupon_code": ""},
+		"$set":   bson.M{"updated_at": time.Now()},
+	}); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Coupon removed"})
+}
+
+// QuoteBooking prices a booking, with a coupon when one is given, without
+// creating it.
+func (h *Handler) QuoteBooking(c *gin.Context) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+
+	var reqBody struct {
+		ServiceID  primitive.ObjectID `json:"service_id" binding:"required"`
+		Quantity   int                `json:"quantity" binding:"gt=0,lte=100"`
+		CouponCode string             `json:"coupon_code" binding:"max=30"`
+	}
+	if !bindJSON(c, &reqBody) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var service Models.Service
+	if err := h.getServiceCollection().FindOne(ctx, bson.M{"_id": reqBody.ServiceID}).Decode(&service); err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy dịch vụ"))
+		return
+	}
+
+	lines := []Models.CouponLine{{Category: service.ServiceCategory, Amount: service.Price.Mul(reqBody.Quantity)}}
+	if strings.TrimSpace(reqBody.CouponCode) == "" {
+		c.JSON(http.StatusOK, newCouponQuote("", lines, Models.Money{}))
+		return
+	}
+
+	coupon, discount, err := h.checkCoupon(ctx, reqBody.CouponCode, claims.ID, Models.CouponForServices, lines)
+	if err != nil {
+		Middleware.Fail(c, couponFailure(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, newCouponQuote(coupon.Code, lines, discount))
+}
@@ -0,0 +1,59 @@
+package Controllers
+
+import (
+	"errors"
+	"strings"
+
+	"Server/Middleware"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// lookupError turns a failed FindOne into a 404 with message when nothing
+// matched, and into an internal error otherwise.
+func lookupError(err error, message string) *Middleware.APIError {
+	if err == mongo.ErrNoDocuments {
+		return Middleware.NotFound(message)
+	}
+	return Middleware.Internal(err)
+}
+
+// isDuplicateID reports whether err is a write refused because another
+// document already has its _id, as opposed to a clash on another unique
+// index.
+func isDuplicateID(err error) bool {
+	var writeErr mongo.WriteException
+	if !errors.As(err, &writeErr) {
+		return false
+	}
+	for _, e := range writeErr.WriteErrors {
+		if e.Code == codeDuplicateKey && strings.Contains(e.Message, " index: _id_ ") {
+			return true
+		}
+	}
+	return false
+}
+
+// codeDuplicateKey is the server error code for a unique index violation.
+const codeDuplicateKey = 11000
+
+func errLoginExpired() *Middleware.APIError {
+	return Middleware.Unauthorized(Middleware.CodeChallengeExpired, "Phiên đăng nhập đã hết hạn, vui lòng đăng nhập lại")
+}
+
+func errAccountSuspended() *Middleware.APIError {
+	return Middleware.Forbidden(Middleware.CodeAccountSuspended, "Tài khoản đã bị khóa")
+}
+
+func errWrongTwoFactorCode() *Middleware.APIError {
+	return Middleware.Unauthorized(Middleware.CodeInvalidTwoFactor, "Mã xác thực không đúng")
+}
+
+// errMissingImage reports a multipart form sent without its "image" file.
+func errMissingImage() *Middleware.APIError {
+	return Middleware.ValidationFailed(Middleware.FieldError{
+		Field:   "image",
+		Code:    "required",
+		Message: "Vui lòng chọn ảnh",
+	})
+}
@@ -0,0 +1,121 @@
+package Controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"Server/Config"
+	"Server/Middleware"
+	"Server/Models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// Handler tests run against the mock deployment of the driver, which
+// answers each command with the next queued response. Tests queue exactly
+// the replies the handler's queries need, in order.
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func newMockDB(t *testing.T) *mtest.T {
+	return mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+}
+
+func newTestHandler(mt *mtest.T) *Handler {
+	cfg := &Config.Config{
+		JWT:       Config.JWTConfig{Secret: "test-secret"},
+		TwoFactor: Config.TwoFactorConfig{Issuer: "Test"},
+	}
+	return &Handler{
+		DB:     mt.DB,
+		Config: cfg,
+		Auth:   Middleware.NewAuth(cfg.JWT, mt.DB),
+		Limits: Middleware.NewMemoryRateLimitStore(),
+	}
+}
+
+// found is the reply to a find on collection that returns docs.
+func found(mt *mtest.T, collection string, docs ...interface{}) bson.D {
+	batch := make([]bson.D, len(docs))
+	for i, doc := range docs {
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if err := bson.Unmarshal(data, &batch[i]); err != nil {
+			mt.Fatal(err)
+		}
+	}
+	return mtest.CreateCursorResponse(0, mt.DB.Name()+"."+collection, mtest.FirstBatch, batch...)
+}
+
+// updated is the reply to an update that matched and changed n documents.
+func updated(n int) bson.D {
+	return bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: n}, {Key: "nModified", Value: n}}
+}
+
+// caller is who a test request is signed in as, with the permissions
+// Require would have granted them.
+type caller struct {
+	ID          primitive.ObjectID
+	Role        Models.Role
+	Permissions []Models.Permission
+}
+
+// serve runs handler on a request with body as JSON, signed in as user when
+// it is not nil, and returns the response.
+func serve(handler gin.HandlerFunc, user *caller, body interface{}) *httptest.ResponseRecorder {
+	return serveAt("/", "/", handler, user, body)
+}
+
+// serveAt is serve for a handler mounted at route, requested at target.
+func serveAt(route, target string, handler gin.HandlerFunc, user *caller, body interface{}) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Use(Middleware.ErrorHandler())
+	router.POST(route, func(c *gin.Context) {
+		if user != nil {
+			granted := map[Models.Permission]bool{}
+			for _, permission := range user.Permissions {
+				granted[permission] = true
+			}
+			c.Set("user", &Middleware.UserClaims{ID: user.ID, Role: Middleware.Role(user.Role)})
+			c.Set("permissions", granted)
+		}
+		handler(c)
+	})
+
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// errorCode returns the code of the error response w holds.
+func errorCode(t testing.TB, w *httptest.ResponseRecorder) string {
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	return body.Code
+}
+
+// findAndModified is the reply to a findAndModify that found doc, or
+// nothing when doc is nil.
+func findAndModified(doc interface{}) bson.D {
+	if doc == nil {
+		return bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: nil}}
+	}
+	return bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: doc}}
+}
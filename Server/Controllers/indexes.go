@@ -0,0 +1,62 @@
+package Controllers
+
+import (
+	"context"
+
+	"Server/Middleware"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes creates the indexes the list endpoints rely on. Creating
+// an index that already exists is a no-op, so it is safe to run on every
+// start.
+func (h *Handler) EnsureIndexes(ctx context.Context) error {
+	indexes := map[string][]mongo.IndexModel{
+		"products": {
+			{Keys: bson.D{{Key: "name", Value: "text"}}, Options: options.Index().SetName("name_text")},
+			{Keys: bson.D{{Key: "productcategory", Value: 1}, {Key: "price.amount", Value: 1}}},
+			{Keys: bson.D{{Key: "price.amount", Value: 1}}},
+			{Keys: bson.D{{Key: "name", Value: 1}}},
+		},
+		"services": {
+			{Keys: bson.D{{Key: "name", Value: "text"}, {Key: "description", Value: "text"}}, Options: options.Index().SetName("name_description_text")},
+			{Keys: bson.D{{Key: "servicecategory", Value: 1}, {Key: "price.amount", Value: 1}}},
+		},
+		"product_categories": {
+			{Keys: bson.D{{Key: "name", Value: "text"}, {Key: "description", Value: "text"}}, Options: options.Index().SetName("name_description_text")},
+		},
+		"service_categories": {
+			{Keys: bson.D{{Key: "name", Value: "text"}, {Key: "description", Value: "text"}}, Options: options.Index().SetName("name_description_text")},
+		},
+		"product_order": {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+		},
+		"refresh_tokens":     refreshTokenIndexes,
+		"sessions":           sessionIndexes,
+		"user_tokens":        userTokenIndexes,
+		"users":              userIndexes,
+		"user_audit":         userAuditIndexes,
+		"roles":              roleIndexes,
+		"user_identities":    identityIndexes,
+		"oauth_states":       oauthStateIndexes,
+		"payments":           paymentIndexes,
+		"coupons":            couponIndexes,
+		"coupon_redemptions": couponRedemptionIndexes,
+		"rate_limits":        Middleware.RateLimitIndexes,
+		"order_booking_service": {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "booking_date", Value: -1}}},
+			{Keys: bson.D{{Key: "assigned_to", Value: 1}, {Key: "booking_date", Value: 1}}},
+		},
+	}
+
+	for collection, models := range indexes {
+		if _, err := h.DB.Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package Controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"Server/Middleware"
+	"Server/Models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+	// maxPageOffset is how many results a page may skip. Deeper pages are
+	// refused rather than left to overflow or scan the whole collection.
+	maxPageOffset = 10000
+)
+
+// listSpec describes what a list endpoint lets clients query: which filters
+// apply, which fields can be sorted on and whether "q" runs a text search.
+// Every list endpoint answers with the same pageResponse envelope.
+type listSpec struct {
+	Filters []listFilter
+	// Sorts maps the public sort key to the stored field. A field prefixed
+	// with "-" is sorted in reverse, so "newest": "-_id" sorts newest first.
+	Sorts       map[string]string
+	DefaultSort bson.D
+	// TextSearch enables "q", which needs a text index on the collection.
+	TextSearch bool
+}
+
+// listFilter reads its query parameters from c and adds the matching
+// conditions to match. It returns a *queryParamError for malformed input.
+type listFilter func(c *gin.Context, match bson.M) error
+
+// queryParamError reports a malformed query parameter. Code names the
+// expected format, such as "number" or "date".
+type queryParamError struct {
+	Param   string
+	Code    string
+	Message string
+}
+
+func (e *queryParamError) Error() string {
+	return e.Param + ": " + e.Message
+}
+
+type pagination struct {
+	Page  int
+	Limit int
+}
+
+type pageResponse struct {
+	Items   interface{} `json:"items"`
+	Total   int64       `json:"total"`
+	Page    int         `json:"page"`
+	Limit   int         `json:"limit"`
+	HasNext bool        `json:"has_next"`
+}
+
+// listPage runs spec against collection on top of the base conditions and
+// decodes one page into out, which must point to a slice. Stages in
+// pageStages run on the returned page only. On failure it fails the request
+// and returns false.
+func listPage(c *gin.Context, collection *mongo.Collection, spec listSpec, base bson.M, pageStages []bson.M, out interface{}) (pageResponse, bool) {
+	match := bson.M{}
+	for key, value := range base {
+		match[key] = value
+	}
+
+	for _, filter := range spec.Filters {
+		if err := filter(c, match); err != nil {
+			respondListError(c, err)
+			return pageResponse{}, false
+		}
+	}
+
+	search := strings.TrimSpace(c.Query("q"))
+	if spec.TextSearch && search != "" {
+		match["$text"] = bson.M{"$search": search}
+	}
+
+	sort := spec.DefaultSort
+	if spec.TextSearch && search != "" {
+		sort = bson.D{{Key: "score", Value: bson.M{"$meta": "textScore"}}, {Key: "_id", Value: -1}}
+	}
+	sort = parseSort(c, spec.Sorts, sort)
+
+	page, err := parsePagination(c)
+	if err != nil {
+		respondListError(c, err)
+		return pageResponse{}, false
+	}
+	total, err := aggregatePage(collection, match, sort, page, pageStages, out)
+	if err != nil {
+		respondListError(c, err)
+		return pageResponse{}, false
+	}
+
+	return page.response(reflect.ValueOf(out).Elem().Interface(), total), true
+}
+
+func respondListError(c *gin.Context, err error) {
+	var paramErr *queryParamError
+	if errors.As(err, &paramErr) {
+		Middleware.Fail(c, Middleware.ValidationFailed(Middleware.FieldError{
+			Field:   paramErr.Param,
+			Code:    paramErr.Code,
+			Message: paramErr.Message,
+		}))
+		return
+	}
+	Middleware.Fail(c, Middleware.Internal(err))
+}
+
+func parsePagination(c *gin.Context) (pagination, error) {
+	page, err := strconv.Atoi(c.Query("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit < 1 {
+		limit = defaultPageLimit
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	if page > maxPageOffset/limit+1 {
+		return pagination{}, &queryParamError{
+			Param:   "page",
+			Code:    "max",
+			Message: "Trang vượt quá giới hạn " + strconv.Itoa(maxPageOffset/limit+1),
+		}
+	}
+	return pagination{Page: page, Limit: limit}, nil
+}
+
+func (p pagination) skip() int64 {
+	return int64(p.Page-1) * int64(p.Limit)
+}
+
+func (p pagination) response(items interface{}, total int64) pageResponse {
+	return pageResponse{
+		Items:   items,
+		Total:   total,
+		Page:    p.Page,
+		Limit:   p.Limit,
+		HasNext: p.skip()+int64(p.Limit) < total,
+	}
+}
+
+// parseSort reads the "sort" query parameter, a key of allowed optionally
+// prefixed with "-" for descending order.
+func parseSort(c *gin.Context, allowed map[string]string, fallback bson.D) bson.D {
+	value := c.Query("sort")
+	direction := 1
+	if strings.HasPrefix(value, "-") {
+		direction = -1
+		value = value[1:]
+	}
+	field, ok := allowed[value]
+	if !ok {
+		return fallback
+	}
+	if strings.HasPrefix(field, "-") {
+		direction = -direction
+		field = field[1:]
+	}
+	if field == "_id" {
+		return bson.D{{Key: field, Value: direction}}
+	}
+	return bson.D{{Key: field, Value: direction}, {Key: "_id", Value: direction}}
+}
+
+// aggregatePage runs match and sort on collection and decodes one page of
+// results into out, returning the total number of matches.
+func aggregatePage(collection *mongo.Collection, match bson.M, sort bson.D, p pagination, pageStages []bson.M, out interface{}) (int64, error) {
+	items := []bson.M{
+		{"$skip": p.skip()},
+		{"$limit": p.Limit},
+	}
+	items = append(items, pageStages...)
+
+	pipeline := []bson.M{
+		{"$match": match},
+		{"$sort": sort},
+		{"$facet": bson.M{
+			"items": items,
+			"total": []bson.M{{"$count": "count"}},
+		}},
+	}
+
+	cursor, err := collection.Aggregate(context.Background(), pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(context.Background())
+
+	var result []struct {
+		Items bson.RawValue `bson:"items"`
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+	}
+	if err := cursor.All(context.Background(), &result); err != nil {
+		return 0, err
+	}
+	if len(result) == 0 {
+		return 0, nil
+	}
+
+	if err := result[0].Items.Unmarshal(out); err != nil {
+		return 0, err
+	}
+	if len(result[0].Total) == 0 {
+		return 0, nil
+	}
+	return result[0].Total[0].Count, nil
+}
+
+func objectIDFilter(param, field string) listFilter {
+	return func(c *gin.Context, match bson.M) error {
+		value := c.Query(param)
+		if value == "" {
+			return nil
+		}
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return &queryParamError{Param: param, Code: "object_id", Message: "Mã không hợp lệ"}
+		}
+		match[field] = id
+		return nil
+	}
+}
+
+func equalFilter(param, field string) listFilter {
+	return func(c *gin.Context, match bson.M) error {
+		if value := c.Query(param); value != "" {
+			match[field] = value
+		}
+		return nil
+	}
+}
+
+// moneyRangeFilter matches the Money stored in field against the inclusive
+// bounds given in minParam and maxParam, whole amounts in the shop
+// currency.
+func moneyRangeFilter(minParam, maxParam, field string) listFilter {
+	return func(c *gin.Context, match bson.M) error {
+		bounds := bson.M{}
+		for param, operator := range map[string]string{minParam: "$gte", maxParam: "$lte"} {
+			value := c.Query(param)
+			if value == "" {
+				continue
+			}
+			amount, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return &queryParamError{Param: param, Code: "integer", Message: "Phải là số nguyên"}
+			}
+			bounds[operator] = amount
+		}
+		if len(bounds) > 0 {
+			match[field+".amount"] = bounds
+		}
+		return nil
+	}
+}
+
+// dateRangeFilter matches field against the YYYY-MM-DD dates given in
+// fromParam and toParam, both inclusive.
+func dateRangeFilter(fromParam, toParam, field string) listFilter {
+	return func(c *gin.Context, match bson.M) error {
+		bounds := bson.M{}
+		if from := c.Query(fromParam); from != "" {
+			start, err := time.ParseInLocation("2006-01-02", from, Models.ScheduleLocation)
+			if err != nil {
+				return &queryParamError{Param: fromParam, Code: "date", Message: "Phải là ngày theo định dạng YYYY-MM-DD"}
+			}
+			bounds["$gte"] = primitive.NewDateTimeFromTime(start)
+		}
+		if to := c.Query(toParam); to != "" {
+			end, err := time.ParseInLocation("2006-01-02", to, Models.ScheduleLocation)
+			if err != nil {
+				return &queryParamError{Param: toParam, Code: "date", Message: "Phải là ngày theo định dạng YYYY-MM-DD"}
+			}
+			bounds["$lt"] = primitive.NewDateTimeFromTime(end.AddDate(0, 0, 1))
+		}
+		if len(bounds) > 0 {
+			match[field] = bounds
+		}
+		return nil
+	}
+}
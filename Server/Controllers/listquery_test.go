@@ -0,0 +1,43 @@
+package Controllers
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParsePagination(t *testing.T) {
+	tests := []struct {
+		query    string
+		want     pagination
+		wantSkip int64
+		wantErr  bool
+	}{
+		{query: "", want: pagination{Page: 1, Limit: defaultPageLimit}},
+		{query: "page=3&limit=10", want: pagination{Page: 3, Limit: 10}, wantSkip: 20},
+		{query: "page=0&limit=-5", want: pagination{Page: 1, Limit: defaultPageLimit}},
+		{query: "page=x&limit=500", want: pagination{Page: 1, Limit: maxPageLimit}},
+		{query: "page=101&limit=100", want: pagination{Page: 101, Limit: 100}, wantSkip: maxPageOffset},
+		{query: "page=102&limit=100", wantErr: true},
+		{query: "page=9223372036854775807&limit=100", wantErr: true},
+		{query: "page=99999999999999999999", want: pagination{Page: 1, Limit: defaultPageLimit}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/?"+tt.query, nil)
+
+			got, err := parsePagination(c)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want || got.skip() != tt.wantSkip {
+				t.Errorf("got %+v (skip %d), %v; want %+v (skip %d)", got, got.skip(), err, tt.want, tt.wantSkip)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package Controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"Server/Models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migration changes stored documents to match the models. A migration that
+// stops halfway runs again on the next start, so it must be safe to repeat.
+type migration struct {
+	ID  string
+	Run func(h *Handler, ctx context.Context) error
+}
+
+// migrations run in order, each once per database.
+var migrations = []migration{
+	{ID: "money", Run: (*Handler).migrateMoney},
+	{ID: "unique_user_email", Run: (*Handler).migrateUniqueUserEmail},
+	{ID: "verify_existing_emails", Run: (*Handler).migrateVerifiedEmails},
+	{ID: "normalize_user_emails", Run: (*Handler).migrateNormalizedEmails},
+}
+
+// Migrate runs the migrations the database has not had yet and records
+// each one when it finishes.
+func (h *Handler) Migrate(ctx context.Context) error {
+	collection := h.DB.Collection("migrations")
+	for _, m := range migrations {
+		count, err := collection.CountDocuments(ctx, bson.M{"_id": m.ID})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		if err := m.Run(h, ctx); err != nil {
+			return err
+		}
+		if _, err := collection.InsertOne(ctx, bson.M{"_id": m.ID, "applied_at": time.Now()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moneyFields are the amounts of one collection that used to be stored as
+// numbers. Items are fields of the documents in its items array.
+type moneyFields struct {
+	Collection string
+	Fields     []string
+	Items      []string
+}
+
+var legacyMoneyFields = []moneyFields{
+	{Collection: "products", Fields: []string{"price"}},
+	{Collection: "services", Fields: []string{"price"}},
+	{Collection: "carts", Items: []string{"price"}},
+	{Collection: "selected_items", Items: []string{"price"}},
+	{Collection: "product_order", Fields: []string{"subtotal", "discount", "shipping_fee", "vat", "total_price"}, Items: []string{"price"}},
+	{Collection: "order_booking_service", Fields: []string{"total_price", "discount"}},
+	{Collection: "coupons", Fields: []string{"max_discount", "min_order"}},
+	{Collection: "coupon_redemptions", Fields: []string{"discount"}},
+	{Collection: "payments", Fields: []string{"amount"}},
+}
+
+// migrateMoney rewrites amounts stored as numbers as Money documents, and
+// splits the value of coupons into the percent or amount of their type.
+// Only documents that still hold numbers are touched.
+func (h *Handler) migrateMoney(ctx context.Context) error {
+	for _, spec := range legacyMoneyFields {
+		var legacy bson.A
+		set := bson.M{}
+		for _, field := range spec.Fields {
+			legacy = append(legacy, bson.M{field: bson.M{"$type": "number"}})
+			set[field] = moneyExpression("$" + field)
+		}
+		if len(spec.Items) > 0 {
+			item := bson.M{}
+			for _, field := range spec.Items {
+				legacy = append(legacy, bson.M{"items." + field: bson.M{"$type": "number"}})
+				item[field] = moneyExpression("$$item." + field)
+			}
+			set["items"] = bson.M{"$cond": bson.A{
+				bson.M{"$isArray": "$items"},
+				bson.M{"$map": bson.M{
+					"input": "$items",
+					"as":    "item",
+					"in":    bson.M{"$mergeObjects": bson.A{"$$item", item}},
+				}},
+				"$items",
+			}}
+		}
+
+		if _, err := h.DB.Collection(spec.Collection).UpdateMany(ctx,
+			bson.M{"$or": legacy},
+			mongo.Pipeline{{{Key: "$set", Value: set}}},
+		); err != nil {
+			return err
+		}
+	}
+
+	_, err := h.getCouponCollection().UpdateMany(ctx,
+		bson.M{"value": bson.M{"$exists": true}},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"percent": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$type", Models.DiscountPercent}}, "$value", "$$REMOVE"}},
+				"amount":  bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$type", Models.DiscountFixed}}, moneyExpression("$value"), "$$REMOVE"}},
+			}}},
+			{{Key: "$unset", Value: "value"}},
+		},
+	)
+	return err
+}
+
+// moneyExpression turns the number at path into a Money document in the
+// shop currency and leaves anything else as it is.
+func moneyExpression(path string) bson.M {
+	return bson.M{"$cond": bson.A{
+		bson.M{"$isNumber": path},
+		bson.M{
+			"amount":   bson.M{"$toLong": bson.M{"$round": bson.A{path, 0}}},
+			"currency": Models.DefaultCurrency,
+		},
+		path,
+	}}
+}
+
+// migrateUniqueUserEmail drops the plain index on the email of users, so
+// that EnsureIndexes can create the unique one in its place.
+func (h *Handler) migrateUniqueUserEmail(ctx context.Context) error {
+	_, err := h.DB.Collection("users").Indexes().DropOne(ctx, "email_1")
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && (cmdErr.Code == codeNamespaceNotFound || cmdErr.Code == codeIndexNotFound) {
+		return nil
+	}
+	return err
+}
+
+// migrateVerifiedEmails marks the users that registered before email
+// verification existed as verified, so that they can still place orders
+// and bookings. Users that were sent a verification link keep their state.
+func (h *Handler) migrateVerifiedEmails(ctx context.Context) error {
+	pending, err := h.getUserTokenCollection().Distinct(ctx, "user_id",
+		bson.M{"purpose": Models.VerifyEmailToken})
+	if err != nil {
+		return err
+	}
+	_, err = h.DB.Collection("users").UpdateMany(ctx,
+		bson.M{"email_verified": bson.M{"$exists": false}, "_id": bson.M{"$nin": pending}},
+		bson.M{"$set": bson.M{"email_verified": true}},
+	)
+	return err
+}
+
+// migrateNormalizedEmails stores the emails of users and their linked
+// identities in the form normalizeEmail gives, which is how they are now
+// looked up. A user whose email only differs in case from another user's
+// is left as it is and logged, since the two accounts need merging by hand.
+func (h *Handler) migrateNormalizedEmails(ctx context.Context) error {
+	normalized := bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$email"}}}
+	notNormalized := bson.M{
+		"email": bson.M{"$type": "string"},
+		"$expr": bson.M{"$ne": bson.A{"$email", normalized}},
+	}
+
+	users := h.DB.Collection("users")
+	cursor, err := users.Find(ctx, notNormalized, options.Find().SetProjection(bson.M{"email": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var user struct {
+			ID    primitive.ObjectID `bson:"_id"`
+			Email string             `bson:"email"`
+		}
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+		_, err := users.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"email": normalizeEmail(user.Email)}})
+		if mongo.IsDuplicateKeyError(err) {
+			log.Printf("normalizing email of user %s: %s is taken by another user", user.ID.Hex(), normalizeEmail(user.Email))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	_, err = h.getIdentityCollection().UpdateMany(ctx, notNormalized,
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"email": normalized}}}})
+	return err
+}
+
+// Server error codes for dropping an index that is not there.
+const (
+	codeNamespaceNotFound = 26
+	codeIndexNotFound     = 27
+)
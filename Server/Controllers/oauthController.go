@@ -0,0 +1,298 @@
+package Controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"Server/Middleware"
+	"Server/Models"
+	"Server/OIDC"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	oauthStateTTL      = 10 * time.Minute
+	oauthLoginTokenTTL = 2 * time.Minute
+	oauthStateCookie   = "oauth_state"
+)
+
+// Reasons a social login failed, passed to the client page so it can show
+// a message.
+var (
+	errOAuthEmailUnverified   = errors.New("email_unverified")
+	errOAuthAccountUnverified = errors.New("account_unverified")
+)
+
+func (h *Handler) getIdentityCollection() *mongo.Collection {
+	return h.DB.Collection("user_identities")
+}
+
+func (h *Handler) getOAuthStateCollection() *mongo.Collection {
+	return h.DB.Collection("oauth_states")
+}
+
+func (h *Handler) oauthRedirectURI(provider string) string {
+	return strings.TrimSuffix(h.Config.OAuth.CallbackBaseURL, "/") + "/api/oauth/" + provider + "/callback"
+}
+
+// finishOAuthRedirect sends the browser back to the client with either a
+// login token or an error code in the URL fragment, which is not sent to
+// servers or written to access logs.
+func (h *Handler) finishOAuthRedirect(c *gin.Context, key, value string) {
+	c.Redirect(http.StatusFound, h.Config.OAuth.ClientRedirectURL+"#"+url.Values{key: {value}}.Encode())
+}
+
+// GetOAuthProviders lists the configured social login providers.
+func (h *Handler) GetOAuthProviders(c *gin.Context) {
+	names := make([]string, 0, len(h.Providers))
+	for name := range h.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	c.JSON(http.StatusOK, gin.H{"providers": names})
+}
+
+// StartOAuthLogin redirects the browser to the provider. The state is
+// stored server-side with the PKCE verifier and nonce, and also set in a
+// cookie so the callback only completes in the browser that started it.
+func (h *Handler) StartOAuthLogin(c *gin.Context) {
+	name := c.Param("provider")
+	provider, ok := h.Providers[name]
+	if !ok {
+		Middleware.Fail(c, Middleware.NotFound("Không hỗ trợ đăng nhập bằng "+name))
+		return
+	}
+
+	var values [3]string
+	for i := range values {
+		value, err := OIDC.RandomString()
+		if err != nil {
+			Middleware.Fail(c, Middleware.Internal(err))
+			return
+		}
+		values[i] = value
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := h.getOAuthStateCollection().InsertOne(ctx, Models.OAuthState{
+		StateHash: hashToken(state),
+		Provider:  name,
+		Nonce:     nonce,
+		Verifier:  verifier,
+		ExpiresAt: time.Now().Add(oauthStateTTL),
+	}); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, h.oauthRedirectURI(name), state, nonce, OIDC.CodeChallenge(verifier))
+	if err != nil {
+		Middleware.Fail(c, Middleware.NewError(http.StatusBadGateway, Middleware.CodeUnavailable, "Không kết nối được với "+name+", vui lòng thử lại sau").WithCause(err))
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthStateCookie, state, int(oauthStateTTL.Seconds()), "/api/oauth", "", strings.HasPrefix(h.Config.OAuth.CallbackBaseURL, "https://"), true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OAuthCallback receives the authorization code from the provider, checks
+// the ID token and finds or creates the user. It hands the client a
+// short-lived login token rather than session tokens, since the redirect
+// URL can end up in browser history.
+func (h *Handler) OAuthCallback(c *gin.Context) {
+	name := c.Param("provider")
+	provider, ok := h.Providers[name]
+	if !ok {
+		Middleware.Fail(c, Middleware.NotFound("Không hỗ trợ đăng nhập bằng "+name))
+		return
+	}
+
+	state := c.Query("state")
+	cookie, _ := c.Cookie(oauthStateCookie)
+	c.SetCookie(oauthStateCookie, "", -1, "/api/oauth", "", strings.HasPrefix(h.Config.OAuth.CallbackBaseURL, "https://"), true)
+
+	if c.Query("error") != "" {
+		h.finishOAuthRedirect(c, "error", "cancelled")
+		return
+	}
+	if state == "" || cookie != state || c.Query("code") == "" {
+		h.finishOAuthRedirect(c, "error", "invalid_state")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	var saved Models.OAuthState
+	err := h.getOAuthStateCollection().FindOneAndDelete(ctx, bson.M{
+		"state_hash": hashToken(state),
+		"provider":   name,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&saved)
+	if err != nil {
+		h.finishOAuthRedirect(c, "error", "invalid_state")
+		return
+	}
+
+	identity, err := provider.Exchange(ctx, h.oauthRedirectURI(name), c.Query("code"), saved.Verifier, saved.Nonce)
+	if err != nil {
+		log.Printf("oauth %s: %v", name, err)
+		h.finishOAuthRedirect(c, "error", "provider_error")
+		return
+	}
+
+	user, err := h.userForIdentity(ctx, name, identity)
+	if errors.Is(err, errOAuthEmailUnverified) || errors.Is(err, errOAuthAccountUnverified) {
+		h.finishOAuthRedirect(c, "error", err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("oauth %s: %v", name, err)
+		h.finishOAuthRedirect(c, "error", "server_error")
+		return
+	}
+
+	token, err := h.issueUserToken(ctx, user.ID, Models.OAuthLoginToken, oauthLoginTokenTTL)
+	if err != nil {
+		h.finishOAuthRedirect(c, "error", "server_error")
+		return
+	}
+
+	h.finishOAuthRedirect(c, "token", token)
+}
+
+// userForIdentity returns the user linked to identity. An identity seen
+// for the first time is linked to the user with the same email, or to a
+// new user, but only when the provider has verified the email. Accounts
+// whose own email is still unverified are not linked, so someone who
+// registered another person's address cannot take over their social
+// login.
+func (h *Handler) userForIdentity(ctx context.Context, provider string, identity *OIDC.Identity) (Models.User, error) {
+	var user Models.User
+	users := h.DB.Collection("users")
+	now := time.Now()
+
+	var linked Models.UserIdentity
+	err := h.getIdentityCollection().FindOneAndUpdate(ctx,
+		bson.M{"provider": provider, "subject": identity.Subject},
+		bson.M{"$set": bson.M{"last_login_at": now}},
+	).Decode(&linked)
+	if err == nil {
+		err = users.FindOne(ctx, bson.M{"_id": linked.UserID}).Decode(&user)
+		return user, err
+	}
+	if err != mongo.ErrNoDocuments {
+		return user, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return user, errOAuthEmailUnverified
+	}
+
+	email := normalizeEmail(identity.Email)
+	err = users.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	switch {
+	case err == nil && !user.EmailVerified:
+		return user, errOAuthAccountUnverified
+	case err == mongo.ErrNoDocuments:
+		user = Models.User{
+			FirstName:     identity.GivenName,
+			LastName:      identity.FamilyName,
+			Email:         email,
+			Role:          Models.Customer,
+			Avatar:        identity.Picture,
+			EmailVerified: true,
+		}
+		result, err := users.InsertOne(ctx, user)
+		if err != nil {
+			return user, err
+		}
+		user.ID = result.InsertedID.(primitive.ObjectID)
+	case err != nil:
+		return user, err
+	}
+
+	if _, err := h.getIdentityCollection().InsertOne(ctx, Models.UserIdentity{
+		UserID:      user.ID,
+		Provider:    provider,
+		Subject:     identity.Subject,
+		Email:       email,
+		CreatedAt:   now,
+		LastLoginAt: now,
+	}); err != nil && !mongo.IsDuplicateKeyError(err) {
+		return user, err
+	}
+	return user, nil
+}
+
+// CompleteOAuthLogin trades the login token from OAuthCallback for the
+// same session tokens a password login returns, after two-factor
+// authentication if the user needs it.
+func (h *Handler) CompleteOAuthLogin(c *gin.Context) {
+	var reqBody struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if !bindJSON(c, &reqBody) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	record, err := h.consumeUserToken(ctx, reqBody.Token, Models.OAuthLoginToken)
+	if errors.Is(err, errInvalidUserToken) {
+		Middleware.Fail(c, errLoginExpired())
+		return
+	}
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	var user Models.User
+	if err := h.DB.Collection("users").FindOne(ctx, bson.M{"_id": record.UserID}).Decode(&user); err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy người dùng"))
+		return
+	}
+	if user.SuspendedAt != nil {
+		Middleware.Fail(c, errAccountSuspended())
+		return
+	}
+
+	challenged, err := h.challengeTwoFactor(ctx, c, user)
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	if challenged {
+		return
+	}
+
+	h.completeLogin(c, user, nil)
+}
+
+var identityIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "provider", Value: 1}, {Key: "subject", Value: 1}}, Options: options.Index().SetUnique(true)},
+	{Keys: bson.D{{Key: "user_id", Value: 1}}},
+}
+
+var oauthStateIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "state_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+	{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+}
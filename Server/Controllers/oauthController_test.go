@@ -0,0 +1,206 @@
+package Controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"Server/Config"
+	"Server/Models"
+	"Server/OIDC"
+	"Server/OIDC/oidctest"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+const testClientRedirectURL = "http://app.test/login/oauth"
+
+// withMockProvider sets h up with the mock OpenID Connect provider under
+// the name "mock".
+func withMockProvider(t testing.TB, h *Handler) {
+	mock, err := oidctest.New("", "test-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(mock.Handler())
+	t.Cleanup(server.Close)
+	mock.Issuer = server.URL
+
+	h.Config.OAuth = Config.OAuthConfig{CallbackBaseURL: "http://api.test", ClientRedirectURL: testClientRedirectURL}
+	h.Providers = map[string]*OIDC.Provider{
+		"mock": OIDC.NewProvider("mock", Config.OIDCProviderConfig{Issuer: server.URL, ClientID: "test-client"}),
+	}
+}
+
+// signInWithMock signs email in at the mock provider for a login started
+// with state, nonce and verifier, and returns the authorization code.
+func signInWithMock(t testing.TB, h *Handler, email, state, nonce, verifier string) string {
+	authURL, err := h.Providers["mock"].AuthCodeURL(context.Background(), h.oauthRedirectURI("mock"), state, nonce, OIDC.CodeChallenge(verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.PostForm(authURL, url.Values{"email": {email}, "email_verified": {"on"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location.Query().Get("code")
+}
+
+// callback requests the OAuth callback with query, sending cookie as the
+// state cookie when it is not empty, and returns the client redirect's
+// fragment.
+func callback(t testing.TB, h *Handler, query url.Values, cookie string) url.Values {
+	router := gin.New()
+	router.GET("/api/oauth/:provider/callback", h.OAuthCallback)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/oauth/mock/callback?"+query.Encode(), nil)
+	if cookie != "" {
+		req.AddCookie(&http.Cookie{Name: oauthStateCookie, Value: cookie})
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	location := w.Header().Get("Location")
+	if w.Code != http.StatusFound || !strings.HasPrefix(location, testClientRedirectURL+"#") {
+		t.Fatalf("status %d, location %q", w.Code, location)
+	}
+	fragment, err := url.ParseQuery(strings.TrimPrefix(location, testClientRedirectURL+"#"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fragment
+}
+
+func TestOAuthCallback(t *testing.T) {
+	userID := primitive.NewObjectID()
+	saved := func(nonce, verifier string) Models.OAuthState {
+		return Models.OAuthState{
+			StateHash: hashToken("state-1"),
+			Provider:  "mock",
+			Nonce:     nonce,
+			Verifier:  verifier,
+			ExpiresAt: time.Now().Add(oauthStateTTL),
+		}
+	}
+
+	tests := []struct {
+		name    string
+		cookie  string
+		replies func(mt *mtest.T) []bson.D
+		want    url.Values
+	}{
+		{
+			name:   "no state cookie",
+			cookie: "",
+			want:   url.Values{"error": {"invalid_state"}},
+		},
+		{
+			name:   "state cookie from another login",
+			cookie: "state-2",
+			want:   url.Values{"error": {"invalid_state"}},
+		},
+		{
+			name:   "state already used",
+			cookie: "state-1",
+			replies: func(mt *mtest.T) []bson.D {
+				return []bson.D{findAndModified(nil)}
+			},
+			want: url.Values{"error": {"invalid_state"}},
+		},
+		{
+			name:   "wrong PKCE verifier",
+			cookie: "state-1",
+			replies: func(mt *mtest.T) []bson.D {
+				return []bson.D{findAndModified(saved("nonce-1", "other-verifier"))}
+			},
+			want: url.Values{"error": {"provider_error"}},
+		},
+		{
+			name:   "nonce mismatch",
+			cookie: "state-1",
+			replies: func(mt *mtest.T) []bson.D {
+				return []bson.D{findAndModified(saved("other-nonce", "verifier-1"))}
+			},
+			want: url.Values{"error": {"provider_error"}},
+		},
+		{
+			name:   "linked identity",
+			cookie: "state-1",
+			replies: func(mt *mtest.T) []bson.D {
+				return []bson.D{
+					findAndModified(saved("nonce-1", "verifier-1")),
+					findAndModified(Models.UserIdentity{UserID: userID, Provider: "mock", Subject: oidctest.Subject("user@example.com")}),
+					found(mt, "users", Models.User{ID: userID, Email: "user@example.com", EmailVerified: true}),
+					updated(0),
+					mtest.CreateSuccessResponse(),
+				}
+			},
+		},
+	}
+
+	mt := newMockDB(t)
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			h := newTestHandler(mt)
+			withMockProvider(mt, h)
+			if tt.replies != nil {
+				mt.AddMockResponses(tt.replies(mt)...)
+			}
+
+			code := signInWithMock(mt, h, "user@example.com", "state-1", "nonce-1", "verifier-1")
+			got := callback(mt, h, url.Values{"state": {"state-1"}, "code": {code}}, tt.cookie)
+			if tt.want == nil {
+				if got.Get("token") == "" {
+					mt.Errorf("redirected with %v, want a login token", got)
+				}
+				return
+			}
+			if got.Encode() != tt.want.Encode() {
+				mt.Errorf("redirected with %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOAuthCallbackLinksByNormalizedEmail(t *testing.T) {
+	mt := newMockDB(t)
+	mt.Run("new identity", func(mt *mtest.T) {
+		h := newTestHandler(mt)
+		withMockProvider(mt, h)
+		userID := primitive.NewObjectID()
+		mt.AddMockResponses(
+			findAndModified(Models.OAuthState{StateHash: hashToken("state-1"), Provider: "mock", Nonce: "nonce-1", Verifier: "verifier-1", ExpiresAt: time.Now().Add(oauthStateTTL)}),
+			findAndModified(nil),
+			found(mt, "users", Models.User{ID: userID, Email: "user@example.com", EmailVerified: true}),
+			mtest.CreateSuccessResponse(),
+			updated(0),
+			mtest.CreateSuccessResponse(),
+		)
+
+		code := signInWithMock(mt, h, "User@Example.com", "state-1", "nonce-1", "verifier-1")
+		mt.ClearEvents()
+		got := callback(mt, h, url.Values{"state": {"state-1"}, "code": {code}}, "state-1")
+		if got.Get("token") == "" {
+			mt.Fatalf("redirected with %v, want a login token", got)
+		}
+		if email := lookedUpEmail(mt); email != "user@example.com" {
+			mt.Errorf("looked up %q, want %q", email, "user@example.com")
+		}
+	})
+}
@@ -2,6 +2,7 @@ package Controllers
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"Server/Middleware"
@@ -13,148 +14,520 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func getOrderCollection() *mongo.Collection {
-	return Database.Collection("product_order")
+func (h *Handler) getOrderCollection() *mongo.Collection {
+	return h.DB.Collection("product_order")
 }
 
-func CreateOrder(c *gin.Context) {
+// stockShortage describes an order line that cannot be fulfilled with the
+// stock currently on hand.
+type stockShortage struct {
+	ProductID primitive.ObjectID `json:"product_id"`
+	Name      string             `json:"name"`
+	Requested int                `json:"requested"`
+	Available int                `json:"available"`
+}
+
+type insufficientStockError struct {
+	Items []stockShortage
+}
+
+func (e *insufficientStockError) Error() string {
+	return "insufficient stock"
+}
+
+// orderRequest is the optional body of CreateOrder and QuoteOrder. Blank
+// shipping fields are filled in from the customer's profile.
+type orderRequest struct {
+	Shipping Models.ShippingAddress `json:"shipping"`
+}
+
+func (h *Handler) CreateOrder(c *gin.Context) {
 	claims := c.MustGet("user").(*Middleware.UserClaims)
 	userID := claims.ID
 
-	selectedItemsCollection := getSelectedItemsCollection()
-	var selectedItems Models.SelectedItems
-	err := selectedItemsCollection.FindOne(context.Background(), bson.M{"user_id": userID}).Decode(&selectedItems)
-	if err == mongo.ErrNoDocuments {
-		c.JSON(404, gin.H{"error": "No selected items found"})
+	if !h.requireVerifiedEmail(c, userID) {
+		return
+	}
+
+	var reqBody orderRequest
+	if !bindOptionalJSON(c, &reqBody) {
+		return
+	}
+	shipping, err := h.shippingAddress(context.Background(), userID, reqBody.Shipping)
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	session, err := h.DB.Client().StartSession()
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	defer session.EndSession(context.Background())
+
+	result, err := session.WithTransaction(context.Background(), func(sc mongo.SessionContext) (interface{}, error) {
+		return h.placeOrder(sc, userID, shipping)
+	})
+
+	var shortage *insufficientStockError
+	var couponErr *Models.CouponError
+	if errors.Is(err, errNothingSelected) {
+		Middleware.Fail(c, Middleware.NotFound("Chưa chọn sản phẩm nào để đặt hàng"))
+		return
+	}
+	if errors.Is(err, errSelectionChanged) {
+		Middleware.Fail(c, Middleware.Conflict("Danh sách sản phẩm đã chọn vừa thay đổi, vui lòng thử lại"))
+		return
+	}
+	if errors.As(err, &shortage) {
+		Middleware.Fail(c, Middleware.Conflict("Không đủ hàng trong kho").WithDetail("items", shortage.Items))
+		return
+	}
+	if errors.As(err, &couponErr) {
+		Middleware.Fail(c, couponFailure(couponErr))
+		return
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		Middleware.Fail(c, Middleware.NotFound("Không tìm thấy sản phẩm"))
 		return
 	}
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	c.JSON(200, result.(Models.Order))
+}
+
+var (
+	errNothingSelected  = errors.New("no items selected for the order")
+	errSelectionChanged = errors.New("selected items changed concurrently")
+)
 
-	productCollection := getProductCollection()
+// placeOrder reads the selected items, checks and decrements stock for
+// each, prices the order and redeems its coupon if there is one, inserts
+// the order and prunes the cart and selected items. It must run inside a
+// transaction so that a failure on any step leaves nothing changed. The
+// selection is read and removed within the transaction, on condition that
+// it is the one read, so a double submit or a retried transaction cannot
+// order the same selection twice.
+func (h *Handler) placeOrder(sc mongo.SessionContext, userID primitive.ObjectID, shipping Models.ShippingAddress) (Models.Order, error) {
+	selectedItemsCollection := h.getSelectedItemsCollection()
+	var selectedItems Models.SelectedItems
+	err := selectedItemsCollection.FindOne(sc, bson.M{"user_id": userID}).Decode(&selectedItems)
+	if err == mongo.ErrNoDocuments || (err == nil && len(selectedItems.Items) == 0) {
+		return Models.Order{}, errNothingSelected
+	}
+	if err != nil {
+		return Models.Order{}, err
+	}
+
+	productCollection := h.getProductCollection()
 	var orderItems []Models.OrderItem
-	totalPrice := 0.0
+	var lines orderLines
+	var shortages []stockShortage
 
 	for _, selectedItem := range selectedItems.Items {
 		var product Models.Product
-		err := productCollection.FindOne(context.Background(), bson.M{"_id": selectedItem.ProductID}).Decode(&product)
-		if err != nil {
-			c.JSON(404, gin.H{"error": "Product not found"})
-			return
+		if err := productCollection.FindOne(sc, bson.M{"_id": selectedItem.ProductID}).Decode(&product); err != nil {
+			return Models.Order{}, err
 		}
 
-		orderItem := Models.OrderItem{
+		if selectedItem.Quantity <= 0 || product.Stock < selectedItem.Quantity {
+			shortages = append(shortages, stockShortage{
+				ProductID: product.ID,
+				Name:      product.Name,
+				Requested: selectedItem.Quantity,
+				Available: product.Stock,
+			})
+			continue
+		}
+
+		orderItems = append(orderItems, Models.OrderItem{
 			ProductID: selectedItem.ProductID,
 			Quantity:  selectedItem.Quantity,
 			Price:     product.Price,
 			Name:      product.Name,
 			ImageURL:  product.ImageURL,
+		})
+		lines.add(product, selectedItem.Quantity)
+	}
+
+	if len(shortages) > 0 {
+		return Models.Order{}, &insufficientStockError{Items: shortages}
+	}
+
+	for _, item := range orderItems {
+		// The stock guard in the filter protects against a concurrent order
+		// taking the last units between the read above and this write.
+		result, err := productCollection.UpdateOne(sc,
+			bson.M{"_id": item.ProductID, "stock": bson.M{"$gte": item.Quantity}},
+			bson.M{"$inc": bson.M{"stock": -item.Quantity}},
+		)
+		if err != nil {
+			return Models.Order{}, err
 		}
+		if result.MatchedCount == 0 {
+			var product Models.Product
+			if err := productCollection.FindOne(sc, bson.M{"_id": item.ProductID}).Decode(&product); err != nil {
+				return Models.Order{}, err
+			}
+			return Models.Order{}, &insufficientStockError{Items: []stockShortage{{
+				ProductID: product.ID,
+				Name:      product.Name,
+				Requested: item.Quantity,
+				Available: product.Stock,
+			}}}
+		}
+	}
 
-		orderItems = append(orderItems, orderItem)
-		totalPrice += product.Price * float64(selectedItem.Quantity)
+	coupon, breakdown, err := h.priceOrder(sc, userID, lines, selectedItems.CouponCode, shipping.Province)
+	if err != nil {
+		return Models.Order{}, err
 	}
 
+	now := time.Now()
 	order := Models.Order{
-		UserID:     userID,
-		Items:      orderItems,
-		TotalPrice: totalPrice,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
+		ID:             primitive.NewObjectID(),
+		UserID:         userID,
+		Items:          orderItems,
+		PriceBreakdown: breakdown,
+		CouponCode:     coupon.Code,
+		Shipping:       shipping,
+		Status:         Models.OrderPending,
+		History: []Models.OrderStatusChange{{
+			To:        Models.OrderPending,
+			ChangedBy: userID,
+			ChangedAt: now,
+		}},
+		CreatedAt: now,
+		UpdatedAt: now,
 	}
 
-	orderCollection := getOrderCollection()
-	_, err = orderCollection.InsertOne(context.Background(), order)
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to create order"})
-		return
+	if order.CouponCode != "" {
+		redemption := Models.CouponRedemption{UserID: userID, OrderID: order.ID, Discount: order.Discount}
+		if err := h.redeemCoupon(sc, coupon, redemption); err != nil {
+			return Models.Order{}, err
+		}
 	}
 
-	cartCollection := getCartCollection()
+	if _, err := h.getOrderCollection().InsertOne(sc, order); err != nil {
+		return Models.Order{}, err
+	}
+
+	cartCollection := h.getCartCollection()
 	var cart Models.Cart
-	err = cartCollection.FindOne(context.Background(), bson.M{"user_id": userID}).Decode(&cart)
-	if err == mongo.ErrNoDocuments {
-		c.JSON(404, gin.H{"error": "Cart not found"})
-		return
+	err = cartCollection.FindOne(sc, bson.M{"user_id": userID}).Decode(&cart)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return Models.Order{}, err
 	}
 
-	for _, selectedItem := range selectedItems.Items {
-		for i, cartItem := range cart.Items {
-			if selectedItem.ProductID == cartItem.ProductID {
-				cart.Items = append(cart.Items[:i], cart.Items[i+1:]...)
-				break
+	if err == nil {
+		ordered := make(map[primitive.ObjectID]bool, len(orderItems))
+		for _, item := range orderItems {
+			ordered[item.ProductID] = true
+		}
+
+		var remaining []Models.CartItem
+		for _, cartItem := range cart.Items {
+			if !ordered[cartItem.ProductID] {
+				remaining = append(remaining, cartItem)
 			}
 		}
-	}
 
-	if len(cart.Items) == 0 {
-		_, err = cartCollection.DeleteOne(context.Background(), bson.M{"user_id": userID})
-	} else {
-		cart.UpdatedAt = time.Now()
-		_, err = cartCollection.UpdateOne(context.Background(), bson.M{"user_id": userID}, bson.M{"$set": cart})
+		if len(remaining) == 0 {
+			_, err = cartCollection.DeleteOne(sc, bson.M{"user_id": userID})
+		} else {
+			_, err = cartCollection.UpdateOne(sc, bson.M{"user_id": userID}, bson.M{"$set": bson.M{
+				"items":      remaining,
+				"updated_at": time.Now(),
+			}})
+		}
+		if err != nil {
+			return Models.Order{}, err
+		}
 	}
 
+	selection := bson.M{"_id": selectedItems.ID, "updated_at": selectedItems.UpdatedAt}
+	if selectedItems.UpdatedAt.IsZero() {
+		selection["updated_at"] = bson.M{"$exists": false}
+	}
+	deleted, err := selectedItemsCollection.DeleteOne(sc, selection)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to update cart after order"})
-		return
+		return Models.Order{}, err
+	}
+	if deleted.DeletedCount == 0 {
+		return Models.Order{}, errSelectionChanged
 	}
 
-	_, err = selectedItemsCollection.DeleteOne(context.Background(), bson.M{"user_id": userID})
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to clear selected items"})
+	return order, nil
+}
+
+var orderListSpec = listSpec{
+	Filters: []listFilter{
+		orderStatusFilter,
+		dateRangeFilter("from", "to", "created_at"),
+		moneyRangeFilter("min_total", "max_total", "total_price"),
+		objectIDFilter("product", "items.product_id"),
+	},
+	Sorts: map[string]string{
+		"created_at":  "created_at",
+		"total_price": "total_price.amount",
+		"status":      "status",
+	},
+	DefaultSort: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}},
+}
+
+func orderStatusFilter(c *gin.Context, match bson.M) error {
+	status := Models.OrderStatus(c.Query("status"))
+	if status == "" {
+		return nil
+	}
+	if !status.IsValid() {
+		return &queryParamError{Param: "status", Code: "oneof", Message: "Trạng thái đơn hàng không hợp lệ"}
+	}
+	if status == Models.OrderPending {
+		match["status"] = bson.M{"$in": []interface{}{status, nil, ""}}
+	} else {
+		match["status"] = status
+	}
+	return nil
+}
+
+func (h *Handler) GetOrders(c *gin.Context) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+	userID := claims.ID
+
+	orders := []Models.Order{}
+	page, ok := listPage(c, h.getOrderCollection(), orderListSpec, bson.M{"user_id": userID}, nil, &orders)
+	if !ok {
 		return
 	}
 
-	c.JSON(200, order)
+	c.JSON(200, page)
 }
 
-func GetOrders(c *gin.Context) {
+var errOrderStatusConflict = errors.New("order status changed concurrently")
+
+func (h *Handler) CancelOrder(c *gin.Context) {
 	claims := c.MustGet("user").(*Middleware.UserClaims)
-	userID := claims.ID
 
-	orderCollection := getOrderCollection()
-	var orders []Models.Order
-	cursor, err := orderCollection.Find(context.Background(), bson.M{"user_id": userID})
+	objectID, ok := paramObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Note string `json:"note" binding:"max=500"`
+	}
+	if !bindOptionalJSON(c, &body) {
+		return
+	}
+
+	orderCollection := h.getOrderCollection()
+	var order Models.Order
+	err := orderCollection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&order)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to get orders"})
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy đơn hàng"))
 		return
 	}
-	defer cursor.Close(context.Background())
 
-	if err = cursor.All(context.Background(), &orders); err != nil {
-		c.JSON(500, gin.H{"error": "Failed to decode orders"})
+	if !Middleware.HasPermission(c, Models.PermOrdersManage) {
+		if order.UserID != claims.ID {
+			Middleware.Fail(c, Middleware.Forbidden(Middleware.CodeForbidden, "Bạn không có quyền hủy đơn hàng này"))
+			return
+		}
+		// Once the order is being packed only staff can pull it back.
+		if order.Status != "" && order.Status != Models.OrderPending && order.Status != Models.OrderPaid {
+			Middleware.Fail(c, Middleware.Conflict("Đơn hàng không thể hủy được nữa"))
+			return
+		}
+	}
+
+	if !order.Status.CanTransitionTo(Models.OrderCancelled) {
+		respondOrderTransitionError(c, &orderTransitionError{From: order.Status, To: Models.OrderCancelled})
+		return
+	}
+
+	updated, apiErr := h.transitionOrderRefunding(c, order, Models.OrderCancelled, claims.ID, body.Note)
+	if apiErr != nil {
+		Middleware.Fail(c, apiErr)
 		return
 	}
 
-	c.JSON(200, orders)
+	c.JSON(200, gin.H{"message": "Order cancelled successfully", "order": updated})
 }
 
-func CancelOrder(c *gin.Context) {
+func (h *Handler) UpdateOrderStatus(c *gin.Context) {
 	claims := c.MustGet("user").(*Middleware.UserClaims)
-	orderID := c.Param("id")
 
-	objectID, err := primitive.ObjectIDFromHex(orderID)
-	if err != nil {
-		c.JSON(400, gin.H{"error": "Invalid order ID format"})
+	objectID, ok := paramObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	var statusUpdate struct {
+		Status Models.OrderStatus `json:"status" binding:"required,oneof=pending paid packed shipped delivered cancelled refunded"`
+		Note   string             `json:"note" binding:"max=500"`
+	}
+	if !bindJSON(c, &statusUpdate) {
 		return
 	}
 
-	orderCollection := getOrderCollection()
 	var order Models.Order
-	err = orderCollection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&order)
-	if err != nil {
-		c.JSON(404, gin.H{"error": "Order not found"})
+	if err := h.getOrderCollection().FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&order); err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy đơn hàng"))
 		return
 	}
 
-	if claims.Role == Middleware.Customer && order.UserID != claims.ID {
-		c.JSON(403, gin.H{"error": "You are not authorized to cancel this order"})
+	next := statusUpdate.Status
+	if next == Models.OrderPaid {
+		Middleware.Fail(c, Middleware.ValidationFailed(Middleware.FieldError{
+			Field:   "status",
+			Code:    "oneof",
+			Message: "Đơn hàng chỉ chuyển sang đã thanh toán khi cổng thanh toán xác nhận",
+		}))
+		return
+	}
+	if !order.Status.CanTransitionTo(next) {
+		respondOrderTransitionError(c, &orderTransitionError{From: order.Status, To: next})
+		return
+	}
+	if next == Models.OrderCancelled || next == Models.OrderRefunded {
+		updated, apiErr := h.transitionOrderRefunding(c, order, next, claims.ID, statusUpdate.Note)
+		if apiErr != nil {
+			Middleware.Fail(c, apiErr)
+			return
+		}
+		c.JSON(200, updated)
 		return
 	}
 
-	_, err = orderCollection.DeleteOne(context.Background(), bson.M{"_id": objectID})
+	updated, err := h.transitionOrder(order, next, claims.ID, statusUpdate.Note)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to cancel order"})
+		respondOrderTransitionError(c, err)
 		return
 	}
 
-	c.JSON(200, gin.H{"message": "Order cancelled successfully"})
+	c.JSON(200, updated)
+}
+
+// transitionOrder moves order to next, appending to its history and putting
+// stock back when the transition calls for it. The update is conditional on
+// the status read by the caller so two concurrent transitions cannot both
+// succeed.
+func (h *Handler) transitionOrder(order Models.Order, next Models.OrderStatus, actor primitive.ObjectID, note string) (Models.Order, error) {
+	return h.transitionOrderSetting(order, next, actor, note, nil, nil)
+}
+
+// transitionOrderSetting is transitionOrder that also sets fields on the
+// order in the same update and, when within is not nil, runs it in the same
+// transaction.
+func (h *Handler) transitionOrderSetting(order Models.Order, next Models.OrderStatus, actor primitive.ObjectID, note string, fields bson.M, within func(sc mongo.SessionContext) error) (Models.Order, error) {
+	current := order.Status
+	if !current.CanTransitionTo(next) {
+		return Models.Order{}, &orderTransitionError{From: current, To: next}
+	}
+
+	session, err := h.DB.Client().StartSession()
+	if err != nil {
+		return Models.Order{}, err
+	}
+	defer session.EndSession(context.Background())
+
+	now := time.Now()
+	change := Models.OrderStatusChange{
+		From:      current,
+		To:        next,
+		ChangedBy: actor,
+		Note:      note,
+		ChangedAt: now,
+	}
+
+	_, err = session.WithTransaction(context.Background(), func(sc mongo.SessionContext) (interface{}, error) {
+		filter := bson.M{"_id": order.ID, "status": current}
+		if current == "" {
+			filter["status"] = bson.M{"$in": []interface{}{nil, ""}}
+		}
+
+		set := bson.M{"status": next, "updated_at": now}
+		for key, value := range fields {
+			set[key] = value
+		}
+
+		result, err := h.getOrderCollection().UpdateOne(sc, filter, bson.M{
+			"$set":  set,
+			"$push": bson.M{"history": change},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, errOrderStatusConflict
+		}
+
+		if within != nil {
+			if err := within(sc); err != nil {
+				return nil, err
+			}
+		}
+
+		if next == Models.OrderCancelled && order.CouponCode != "" {
+			if err := h.releaseCoupon(sc, bson.M{"order_id": order.ID}); err != nil {
+				return nil, err
+			}
+		}
+
+		if current.RestoresStock(next) {
+			productCollection := h.getProductCollection()
+			for _, item := range order.Items {
+				if _, err := productCollection.UpdateOne(sc,
+					bson.M{"_id": item.ProductID},
+					bson.M{"$inc": bson.M{"stock": item.Quantity}},
+				); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return Models.Order{}, err
+	}
+
+	order.Status = next
+	order.History = append(order.History, change)
+	order.UpdatedAt = now
+	return order, nil
+}
+
+type orderTransitionError struct {
+	From Models.OrderStatus
+	To   Models.OrderStatus
+}
+
+func (e *orderTransitionError) Error() string {
+	return "cannot move order from " + string(e.From) + " to " + string(e.To)
+}
+
+func respondOrderTransitionError(c *gin.Context, err error) {
+	Middleware.Fail(c, orderTransitionFailure(err))
+}
+
+// orderTransitionFailure turns a failed transitionOrder into the response
+// for it.
+func orderTransitionFailure(err error) *Middleware.APIError {
+	var transitionErr *orderTransitionError
+	switch {
+	case errors.As(err, &transitionErr):
+		return Middleware.Conflict("Không thể chuyển trạng thái đơn hàng").
+			WithDetail("from", transitionErr.From).
+			WithDetail("to", transitionErr.To)
+	case errors.Is(err, errOrderStatusConflict):
+		return Middleware.Conflict("Đơn hàng vừa được người khác cập nhật, vui lòng thử lại")
+	case errors.Is(err, errRefundConflict):
+		return Middleware.Conflict("Giao dịch đang được hoàn tiền, vui lòng thử lại sau")
+	}
+	return Middleware.Internal(err)
 }
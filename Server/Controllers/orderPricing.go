@@ -0,0 +1,150 @@
+package Controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"Server/Middleware"
+	"Server/Models"
+	"Server/Pricing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// orderLines are the lines of an order as coupons and the pricing pipeline
+// see them.
+type orderLines struct {
+	coupon  []Models.CouponLine
+	pricing []Pricing.Line
+}
+
+func (l *orderLines) add(product Models.Product, quantity int) {
+	amount := product.Price.Mul(quantity)
+	l.coupon = append(l.coupon, Models.CouponLine{Category: product.ProductCategory, Amount: amount})
+	l.pricing = append(l.pricing, Pricing.Line{Amount: amount, Weight: product.Weight * quantity})
+}
+
+// selectedItemLines prices the selected items at the current product
+// prices.
+func (h *Handler) selectedItemLines(ctx context.Context, items []Models.SelectedItem) (orderLines, error) {
+	var lines orderLines
+	for _, item := range items {
+		var product Models.Product
+		if err := h.getProductCollection().FindOne(ctx, bson.M{"_id": item.ProductID}).Decode(&product); err != nil {
+			return lines, err
+		}
+		lines.add(product, item.Quantity)
+	}
+	return lines, nil
+}
+
+// priceOrder applies couponCode, when there is one, and runs the pricing
+// pipeline for delivery to province. The returned coupon is the zero value
+// without a code.
+func (h *Handler) priceOrder(ctx context.Context, userID primitive.ObjectID, lines orderLines, couponCode, province string) (Models.Coupon, Models.PriceBreakdown, error) {
+	var coupon Models.Coupon
+	var discount Models.Money
+	if couponCode != "" {
+		var err error
+		if coupon, discount, err = h.checkCoupon(ctx, couponCode, userID, Models.CouponForProducts, lines.coupon); err != nil {
+			return coupon, Models.PriceBreakdown{}, err
+		}
+	}
+
+	breakdown := h.Pricing.Price(Pricing.Input{
+		Lines:    lines.pricing,
+		Discount: discount,
+		Province: province,
+	})
+	return coupon, breakdown, nil
+}
+
+// shippingAddress fills the blank fields of requested from the profile of
+// userID.
+func (h *Handler) shippingAddress(ctx context.Context, userID primitive.ObjectID, requested Models.ShippingAddress) (Models.ShippingAddress, error) {
+	address := Models.ShippingAddress{
+		Recipient: strings.TrimSpace(requested.Recipient),
+		Phone:     strings.TrimSpace(requested.Phone),
+		Address:   strings.TrimSpace(requested.Address),
+		Province:  strings.TrimSpace(requested.Province),
+	}
+	if address.Recipient != "" && address.Phone != "" && address.Address != "" {
+		return address, nil
+	}
+
+	var user Models.User
+	err := h.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID},
+		options.FindOne().SetProjection(bson.M{"firstname": 1, "lastname": 1, "phone": 1, "address": 1})).Decode(&user)
+	if err != nil {
+		return address, err
+	}
+	if address.Recipient == "" {
+		address.Recipient = strings.TrimSpace(user.FirstName + " " + user.LastName)
+	}
+	if address.Phone == "" {
+		address.Phone = user.Phone
+	}
+	if address.Address == "" {
+		address.Address = user.Address
+	}
+	return address, nil
+}
+
+// orderQuote is the price an order of the selected items would have now.
+type orderQuote struct {
+	Models.PriceBreakdown
+	CouponCode string                 `json:"coupon_code,omitempty"`
+	Shipping   Models.ShippingAddress `json:"shipping"`
+}
+
+// QuoteOrder prices the selected items, with their coupon and the shipping
+// address in the body, the same way CreateOrder will, without placing the
+// order.
+func (h *Handler) QuoteOrder(c *gin.Context) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+
+	var reqBody orderRequest
+	if !bindOptionalJSON(c, &reqBody) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var selectedItems Models.SelectedItems
+	err := h.getSelectedItemsCollection().FindOne(ctx, bson.M{"user_id": claims.ID}).Decode(&selectedItems)
+	if err == mongo.ErrNoDocuments || len(selectedItems.Items) == 0 {
+		Middleware.Fail(c, Middleware.NotFound("Chưa chọn sản phẩm nào để đặt hàng"))
+		return
+	}
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	shipping, err := h.shippingAddress(ctx, claims.ID, reqBody.Shipping)
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	lines, err := h.selectedItemLines(ctx, selectedItems.Items)
+	if err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy sản phẩm"))
+		return
+	}
+
+	coupon, breakdown, err := h.priceOrder(ctx, claims.ID, lines, selectedItems.CouponCode, shipping.Province)
+	if err != nil {
+		Middleware.Fail(c, couponFailure(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, orderQuote{PriceBreakdown: breakdown, CouponCode: coupon.Code, Shipping: shipping})
+}
@@ -2,6 +2,7 @@ package Controllers
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"Server/Middleware"
@@ -13,104 +14,310 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func getOrderBookingServiceCollection() *mongo.Collection {
-	return Database.Collection("order_booking_service")
+func (h *Handler) getOrderBookingServiceCollection() *mongo.Collection {
+	return h.DB.Collection("order_booking_service")
 }
 
-func getServiceCollection() *mongo.Collection {
-	return Database.Collection("services")
+func (h *Handler) getServiceCollection() *mongo.Collection {
+	return h.DB.Collection("services")
 }
 
-func CreateOrderBookingService(c *gin.Context) {
+func (h *Handler) CreateOrderBookingService(c *gin.Context) {
 	claims := c.MustGet("user").(*Middleware.UserClaims)
 	userID := claims.ID
 
+	if !h.requireVerifiedEmail(c, userID) {
+		return
+	}
+
 	var orderBookingService Models.OrderBookingService
-	if err := c.ShouldBindJSON(&orderBookingService); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid input"})
+	if !bindJSON(c, &orderBookingService) {
 		return
 	}
 
-	serviceCollection := getServiceCollection()
+	serviceCollection := h.getServiceCollection()
 	var service Models.Service
 	if err := serviceCollection.FindOne(context.Background(), bson.M{"_id": orderBookingService.ServiceID}).Decode(&service); err != nil {
-		c.JSON(404, gin.H{"error": "Service not found"})
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy dịch vụ"))
+		return
+	}
+
+	slotStart := orderBookingService.BookingDate.Time()
+	schedule := service.EffectiveSchedule()
+	if !slotStart.After(time.Now()) {
+		Middleware.Fail(c, Middleware.ValidationFailed(Middleware.FieldError{
+			Field:   "booking_date",
+			Code:    "future",
+			Message: "Phải là thời điểm trong tương lai",
+		}))
+		return
+	}
+	if !schedule.IsSlotStart(slotStart) {
+		Middleware.Fail(c, Middleware.ValidationFailed(Middleware.FieldError{
+			Field:   "booking_date",
+			Code:    "slot",
+			Message: "Không phải khung giờ nhận đặt của dịch vụ này",
+		}))
 		return
 	}
 
+	totalPrice := service.Price.Mul(orderBookingService.Quantity)
+	var coupon Models.Coupon
+	var discount Models.Money
+	if orderBookingService.CouponCode != "" {
+		lines := []Models.CouponLine{{Category: service.ServiceCategory, Amount: totalPrice}}
+		var err error
+		if coupon, discount, err = h.checkCoupon(context.Background(), orderBookingService.CouponCode, userID, Models.CouponForServices, lines); err != nil {
+			Middleware.Fail(c, couponFailure(err))
+			return
+		}
+	}
+
+	orderBookingService.ID = primitive.NewObjectID()
 	orderBookingService.UserID = userID
-	orderBookingService.TotalPrice = float64(orderBookingService.Quantity) * service.Price
-	orderBookingService.Status = "Chờ xác nhận"
+	orderBookingService.CouponCode = coupon.Code
+	orderBookingService.Discount = discount
+	orderBookingService.TotalPrice = totalPrice.Sub(discount)
+	orderBookingService.Status = Models.BookingPending
+	orderBookingService.History = nil
+	orderBookingService.AssignedTo = nil
+	orderBookingService.FinishAt = 0
 	orderBookingService.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
 	orderBookingService.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
-	orderBookingService.BookingDate = primitive.NewDateTimeFromTime(time.Now())
 
-	orderBookingServiceCollection := getOrderBookingServiceCollection()
-	if _, err := orderBookingServiceCollection.InsertOne(context.Background(), orderBookingService); err != nil {
-		c.JSON(500, gin.H{"error": "Failed to create order booking service"})
+	err := h.insertBooking(orderBookingService, coupon, schedule.Capacity)
+	var couponErr *Models.CouponError
+	if errors.Is(err, errSlotFull) {
+		Middleware.Fail(c, Middleware.Conflict("Khung giờ này không còn đủ chỗ"))
+		return
+	}
+	if errors.As(err, &couponErr) {
+		Middleware.Fail(c, couponFailure(couponErr))
+		return
+	}
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
 		return
 	}
 
+	orderBookingService.StatusLabel = orderBookingService.Status.Label()
 	c.JSON(200, orderBookingService)
 }
 
-func GetOrderBookingServices(c *gin.Context) {
+// insertBooking stores a new booking together with its place in the slot
+// and the redemption of coupon when it uses one. They share a transaction,
+// so a booking that cannot be stored takes no place and no coupon use.
+func (h *Handler) insertBooking(booking Models.OrderBookingService, coupon Models.Coupon, capacity int) error {
+	session, err := h.DB.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(context.Background())
+
+	_, err = session.WithTransaction(context.Background(), func(sc mongo.SessionContext) (interface{}, error) {
+		if err := h.reserveServiceSlot(sc, booking.ServiceID, capacity, booking.BookingDate.Time(), booking.Quantity); err != nil {
+			return nil, err
+		}
+		if booking.CouponCode != "" {
+			redemption := Models.CouponRedemption{UserID: booking.UserID, BookingID: booking.ID, Discount: booking.Discount}
+			if err := h.redeemCoupon(sc, coupon, redemption); err != nil {
+				return nil, err
+			}
+		}
+		return h.getOrderBookingServiceCollection().InsertOne(sc, booking)
+	})
+	return err
+}
+
+var bookingListSpec = listSpec{
+	Filters: []listFilter{
+		bookingStatusFilter,
+		dateRangeFilter("from", "to", "booking_date"),
+		moneyRangeFilter("min_total", "max_total", "total_price"),
+		objectIDFilter("service", "service_id"),
+	},
+	Sorts: map[string]string{
+		"booking_date": "booking_date",
+		"created_at":   "created_at",
+		"total_price":  "total_price.amount",
+		"status":       "status",
+	},
+	DefaultSort: bson.D{{Key: "booking_date", Value: -1}, {Key: "_id", Value: -1}},
+}
+
+func bookingStatusFilter(c *gin.Context, match bson.M) error {
+	value := c.Query("status")
+	if value == "" {
+		return nil
+	}
+	status, ok := Models.ParseBookingStatus(value)
+	if !ok {
+		return &queryParamError{Param: "status", Code: "oneof", Message: "Trạng thái lịch hẹn không hợp lệ"}
+	}
+	match["status"] = bson.M{"$in": status.StoredValues()}
+	return nil
+}
+
+func (h *Handler) GetOrderBookingServices(c *gin.Context) {
 	claims := c.MustGet("user").(*Middleware.UserClaims)
 	userID := claims.ID
 
-	orderBookingCollection := getOrderBookingServiceCollection()
-	var orderBookings []Models.OrderBookingService
-	cursor, err := orderBookingCollection.Find(context.Background(), bson.M{"user_id": userID})
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to get order bookings"})
+	orderBookings := []Models.OrderBookingService{}
+	page, ok := listPage(c, h.getOrderBookingServiceCollection(), bookingListSpec, bson.M{"user_id": userID}, nil, &orderBookings)
+	if !ok {
 		return
 	}
-	defer cursor.Close(context.Background())
 
-	if err := cursor.All(context.Background(), &orderBookings); err != nil {
-		c.JSON(500, gin.H{"error": "Failed to decode order bookings"})
-		return
+	for i := range orderBookings {
+		orderBookings[i].StatusLabel = orderBookings[i].Status.Label()
 	}
 
-	c.JSON(200, orderBookings)
+	c.JSON(200, page)
 }
 
-func UpdateOrderBookingServiceStatus(c *gin.Context) {
-	orderID := c.Param("id")
+func (h *Handler) UpdateOrderBookingServiceStatus(c *gin.Context) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+
+	orderIDObj, ok := paramObjectID(c, "id")
+	if !ok {
+		return
+	}
 
 	var statusUpdate struct {
-		Status string `json:"status"`
+		Status string `json:"status" binding:"required"`
+		Reason string `json:"reason" binding:"max=500"`
 	}
-	if err := c.ShouldBindJSON(&statusUpdate); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid input"})
+	if !bindJSON(c, &statusUpdate) {
 		return
 	}
 
-	if statusUpdate.Status != "Chờ xác nhận" && statusUpdate.Status != "Đã xác nhận" &&
-		statusUpdate.Status != "Đang tiến hành" && statusUpdate.Status != "Hoàn thành" &&
-		statusUpdate.Status != "Đã hủy" {
-		c.JSON(400, gin.H{"error": "Invalid status value"})
+	// Display labels are accepted too, so the status is not checked with a
+	// oneof tag.
+	next, ok := Models.ParseBookingStatus(statusUpdate.Status)
+	if !ok {
+		Middleware.Fail(c, Middleware.ValidationFailed(Middleware.FieldError{
+			Field:   "status",
+			Code:    "oneof",
+			Message: "Trạng thái không hợp lệ",
+		}))
 		return
 	}
 
-	orderBookingServiceCollection := getOrderBookingServiceCollection()
-	orderIDObj, err := primitive.ObjectIDFromHex(orderID)
+	updated, err := h.transitionBooking(bson.M{"_id": orderIDObj}, next, claims.ID, statusUpdate.Reason)
 	if err != nil {
-		c.JSON(400, gin.H{"error": "Invalid order ID"})
+		respondBookingTransitionError(c, err)
 		return
 	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"status":     statusUpdate.Status,
-			"updated_at": primitive.NewDateTimeFromTime(time.Now()),
-		},
+	c.JSON(200, gin.H{"message": "Order status updated", "booking": updated})
+}
+
+var errBookingStatusConflict = errors.New("booking status changed concurrently")
+
+type bookingTransitionError struct {
+	From Models.BookingStatus
+	To   Models.BookingStatus
+}
+
+func (e *bookingTransitionError) Error() string {
+	return "cannot move booking from " + string(e.From) + " to " + string(e.To)
+}
+
+// transitionBooking moves the booking that match selects to next and
+// records the change in its history. The update only applies if the booking
+// still matches and is still in the status that was read, so concurrent
+// changes cannot skip the transition table or slip past conditions in match
+// such as who the booking is assigned to.
+func (h *Handler) transitionBooking(match bson.M, next Models.BookingStatus, actor primitive.ObjectID, reason string) (Models.OrderBookingService, error) {
+	collection := h.getOrderBookingServiceCollection()
+
+	var booking Models.OrderBookingService
+	if err := collection.FindOne(context.Background(), match).Decode(&booking); err != nil {
+		return booking, err
 	}
 
-	if _, err := orderBookingServiceCollection.UpdateOne(context.Background(), bson.M{"_id": orderIDObj}, update); err != nil {
-		c.JSON(500, gin.H{"error": "Failed to update order status"})
-		return
+	current := booking.Status
+	if !current.CanTransitionTo(next) {
+		return booking, &bookingTransitionError{From: current, To: next}
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	change := Models.BookingStatusChange{
+		ChangedBy: actor,
+		From:      current,
+		To:        next,
+		Reason:    reason,
+		ChangedAt: now,
+	}
+
+	set := bson.M{
+		"status":     next,
+		"updated_at": now,
+	}
+	if next == Models.BookingCompleted {
+		set["finish_at"] = now
+		booking.FinishAt = now
+	}
+
+	session, err := h.DB.Client().StartSession()
+	if err != nil {
+		return booking, err
 	}
+	defer session.EndSession(context.Background())
 
-	c.JSON(200, gin.H{"message": "Order status updated"})
+	// Cancelling gives back the slot and the coupon use in the same
+	// transaction, so a failure leaves the booking as it was and the
+	// cancellation can be retried.
+	_, err = session.WithTransaction(context.Background(), func(sc mongo.SessionContext) (interface{}, error) {
+		unchanged := bson.M{"status": bson.M{"$in": current.StoredValues()}}
+		for key, value := range match {
+			unchanged[key] = value
+		}
+		result, err := collection.UpdateOne(sc, unchanged,
+			bson.M{"$set": set, "$push": bson.M{"history": change}},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, errBookingStatusConflict
+		}
+
+		if next == Models.BookingCancelled {
+			if err := h.releaseServiceSlot(sc, booking.ServiceID, booking.BookingDate.Time(), booking.Quantity); err != nil {
+				return nil, err
+			}
+			if booking.CouponCode != "" {
+				if err := h.releaseCoupon(sc, bson.M{"booking_id": booking.ID}); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return booking, err
+	}
+
+	booking.Status = next
+	booking.StatusLabel = next.Label()
+	booking.History = append(booking.History, change)
+	booking.UpdatedAt = now
+	return booking, nil
+}
+
+func respondBookingTransitionError(c *gin.Context, err error) {
+	var transitionErr *bookingTransitionError
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		Middleware.Fail(c, Middleware.NotFound("Không tìm thấy lịch hẹn"))
+	case errors.As(err, &transitionErr):
+		Middleware.Fail(c, Middleware.Conflict("Không thể chuyển trạng thái lịch hẹn").
+			WithDetail("from", transitionErr.From).
+			WithDetail("to", transitionErr.To))
+	case errors.Is(err, errBookingStatusConflict):
+		Middleware.Fail(c, Middleware.Conflict("Lịch hẹn vừa được người khác cập nhật, vui lòng thử lại"))
+	default:
+		Middleware.Fail(c, Middleware.Internal(err))
+	}
 }
@@ -0,0 +1,573 @@
+package Controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"Server/Middleware"
+	"Server/Models"
+	"Server/Payment"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (h *Handler) getPaymentCollection() *mongo.Collection {
+	return h.DB.Collection("payments")
+}
+
+var paymentIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "provider", Value: 1}, {Key: "txn_ref", Value: 1}}, Options: options.Index().SetUnique(true)},
+	{Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "created_at", Value: -1}}},
+}
+
+// paymentGateway returns the configured gateway when it is the one named
+// in the :provider parameter of a callback route.
+func (h *Handler) paymentGateway(c *gin.Context) (Payment.Gateway, bool) {
+	if h.Payments == nil || h.Payments.Name() != c.Param("provider") {
+		return nil, false
+	}
+	return h.Payments, true
+}
+
+func errPaymentsDisabled() *Middleware.APIError {
+	return Middleware.NewError(http.StatusServiceUnavailable, Middleware.CodeUnavailable, "Chưa hỗ trợ thanh toán trực tuyến")
+}
+
+// gatewayError reports a gateway call that failed or was declined.
+func gatewayError(err error) *Middleware.APIError {
+	var declined *Payment.GatewayError
+	if errors.As(err, &declined) {
+		return Middleware.NewError(http.StatusBadGateway, Middleware.CodeUnavailable, "Cổng thanh toán từ chối yêu cầu").
+			WithDetail("gateway_code", declined.Code).
+			WithCause(err)
+	}
+	return Middleware.NewError(http.StatusBadGateway, Middleware.CodeUnavailable, "Không kết nối được với cổng thanh toán, vui lòng thử lại sau").WithCause(err)
+}
+
+func (h *Handler) StartOrderPayment(c *gin.Context) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+
+	if h.Payments == nil {
+		Middleware.Fail(c, errPaymentsDisabled())
+		return
+	}
+
+	objectID, ok := paramObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var order Models.Order
+	if err := h.getOrderCollection().FindOne(ctx, bson.M{"_id": objectID}).Decode(&order); err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy đơn hàng"))
+		return
+	}
+	if order.UserID != claims.ID {
+		Middleware.Fail(c, Middleware.Forbidden(Middleware.CodeForbidden, "Bạn không có quyền thanh toán đơn hàng này"))
+		return
+	}
+	if order.Status != "" && order.Status != Models.OrderPending {
+		Middleware.Fail(c, Middleware.Conflict("Đơn hàng không còn chờ thanh toán").WithDetail("status", order.Status))
+		return
+	}
+
+	now := time.Now()
+	payment := Models.Payment{
+		ID:        primitive.NewObjectID(),
+		OrderID:   order.ID,
+		UserID:    claims.ID,
+		Provider:  h.Payments.Name(),
+		Amount:    order.TotalPrice,
+		Status:    Models.PaymentPending,
+		ClientIP:  c.ClientIP(),
+		CreatedAt: now,
+		ExpiresAt: now.Add(h.Config.Payment.Expiry.Duration()),
+		UpdatedAt: now,
+	}
+	payment.TxnRef = payment.ID.Hex()
+
+	if _, err := h.getPaymentCollection().InsertOne(ctx, payment); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	paymentURL, err := h.Payments.CreatePayment(ctx, Payment.PaymentRequest{
+		TxnRef:    payment.TxnRef,
+		Amount:    payment.Amount.Amount,
+		OrderInfo: "Thanh toan don hang " + order.ID.Hex(),
+		ReturnURL: h.Config.Payment.CallbackBaseURL + "/api/payments/" + payment.Provider + "/return",
+		ClientIP:  payment.ClientIP,
+		CreatedAt: payment.CreatedAt,
+		ExpiresAt: payment.ExpiresAt,
+	})
+	if err != nil {
+		Middleware.Fail(c, gatewayError(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"payment_url": paymentURL, "payment": payment})
+}
+
+// PaymentIPN receives the gateway's server-to-server notification. The
+// gateway keeps resending it until it is acknowledged, so the reply is in
+// the gateway's own format and repeats are acknowledged as already
+// confirmed.
+func (h *Handler) PaymentIPN(c *gin.Context) {
+	gateway, ok := h.paymentGateway(c)
+	if !ok {
+		Middleware.Fail(c, Middleware.NotFound("Không tìm thấy cổng thanh toán"))
+		return
+	}
+
+	result, err := gateway.VerifyCallback(c.Request.URL.Query())
+	if errors.Is(err, Payment.ErrInvalidSignature) {
+		c.JSON(gateway.Acknowledge(Payment.AckInvalidSignature))
+		return
+	}
+	if err != nil {
+		log.Printf("payment IPN from %s: %v", gateway.Name(), err)
+		c.JSON(gateway.Acknowledge(Payment.AckError))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, ack, err := h.applyPaymentResult(ctx, gateway.Name(), result)
+	if err != nil {
+		log.Printf("payment IPN for %s: %v", result.TxnRef, err)
+	}
+	c.JSON(gateway.Acknowledge(ack))
+}
+
+// PaymentReturn is where the gateway sends the customer back to. The
+// signed parameters are as trustworthy as an IPN, so a payment the IPN has
+// not confirmed yet is confirmed here. The customer then lands on the
+// client with the outcome in the URL fragment.
+func (h *Handler) PaymentReturn(c *gin.Context) {
+	gateway, ok := h.paymentGateway(c)
+	if !ok {
+		Middleware.Fail(c, Middleware.NotFound("Không tìm thấy cổng thanh toán"))
+		return
+	}
+
+	outcome := url.Values{}
+	result, err := gateway.VerifyCallback(c.Request.URL.Query())
+	if err != nil {
+		outcome.Set("status", "invalid")
+		c.Redirect(http.StatusFound, h.Config.Payment.ClientReturnURL+"#"+outcome.Encode())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	payment, ack, err := h.applyPaymentResult(ctx, gateway.Name(), result)
+	switch {
+	case err != nil:
+		log.Printf("payment return for %s: %v", result.TxnRef, err)
+		outcome.Set("status", "error")
+	case ack == Payment.AckNotFound || ack == Payment.AckInvalidAmount:
+		outcome.Set("status", "invalid")
+	default:
+		outcome.Set("status", string(payment.Status))
+	}
+	if !payment.OrderID.IsZero() {
+		outcome.Set("order", payment.OrderID.Hex())
+	}
+	c.Redirect(http.StatusFound, h.Config.Payment.ClientReturnURL+"#"+outcome.Encode())
+}
+
+// applyPaymentResult records a verified gateway result against its payment
+// and moves the order to paid when the payment succeeded. Only a pending
+// payment is updated, and the update is conditional on it still being
+// pending, so repeated or concurrent callbacks for the same transaction
+// take effect once.
+func (h *Handler) applyPaymentResult(ctx context.Context, provider string, result Payment.Result) (Models.Payment, Payment.Ack, error) {
+	collection := h.getPaymentCollection()
+
+	var payment Models.Payment
+	err := collection.FindOne(ctx, bson.M{"provider": provider, "txn_ref": result.TxnRef}).Decode(&payment)
+	if err == mongo.ErrNoDocuments {
+		return payment, Payment.AckNotFound, nil
+	}
+	if err != nil {
+		return payment, Payment.AckError, err
+	}
+	if result.Amount != payment.Amount.Amount {
+		return payment, Payment.AckInvalidAmount, nil
+	}
+
+	if payment.Status != Models.PaymentPending || result.Status == Payment.StatusPending {
+		if payment.Status == Models.PaymentSucceeded {
+			// An earlier callback may have recorded the payment but failed
+			// to update the order.
+			if err := h.markOrderPaid(payment); err != nil {
+				return payment, Payment.AckError, err
+			}
+		}
+		return payment, Payment.AckAlreadyConfirmed, nil
+	}
+
+	now := time.Now()
+	set := bson.M{
+		"status":         Models.PaymentFailed,
+		"transaction_no": result.TransactionNo,
+		"response_code":  result.ResponseCode,
+		"updated_at":     now,
+	}
+	if result.Status == Payment.StatusSucceeded {
+		paidAt := result.PaidAt
+		if paidAt.IsZero() {
+			paidAt = now
+		}
+		set["status"] = Models.PaymentSucceeded
+		set["paid_at"] = paidAt
+	}
+
+	updated, err := collection.UpdateOne(ctx, bson.M{"_id": payment.ID, "status": Models.PaymentPending}, bson.M{"$set": set})
+	if err != nil {
+		return payment, Payment.AckError, err
+	}
+	if updated.MatchedCount == 0 {
+		// Another callback for the same transaction got there first.
+		err := collection.FindOne(ctx, bson.M{"_id": payment.ID}).Decode(&payment)
+		if err != nil {
+			return payment, Payment.AckError, err
+		}
+		return payment, Payment.AckAlreadyConfirmed, nil
+	}
+
+	if err := collection.FindOne(ctx, bson.M{"_id": payment.ID}).Decode(&payment); err != nil {
+		return payment, Payment.AckError, err
+	}
+	if payment.Status == Models.PaymentSucceeded {
+		if err := h.markOrderPaid(payment); err != nil {
+			return payment, Payment.AckError, err
+		}
+	}
+	return payment, Payment.AckConfirmed, nil
+}
+
+// markOrderPaid moves the order of a successful payment to paid and
+// records the payment on it. An order that is no longer pending, because it
+// was cancelled or paid through another attempt in the meantime, is left
+// alone and the payment has to be refunded by staff.
+func (h *Handler) markOrderPaid(payment Models.Payment) error {
+	var order Models.Order
+	if err := h.getOrderCollection().FindOne(context.Background(), bson.M{"_id": payment.OrderID}).Decode(&order); err != nil {
+		return err
+	}
+	if order.Status != "" && order.Status != Models.OrderPending {
+		if order.PaymentID != payment.ID {
+			log.Printf("payment %s succeeded for order %s which is %s, it needs a refund", payment.ID.Hex(), order.ID.Hex(), order.Status)
+		}
+		return nil
+	}
+
+	_, err := h.transitionOrderSetting(order, Models.OrderPaid, primitive.NilObjectID,
+		"Thanh toán qua "+payment.Provider+", mã giao dịch "+payment.TransactionNo,
+		bson.M{"payment_id": payment.ID}, nil)
+	return err
+}
+
+// loadPayment finds the payment in the :id parameter. Only its owner and
+// order managers may see it.
+func (h *Handler) loadPayment(c *gin.Context) (Models.Payment, bool) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+
+	objectID, ok := paramObjectID(c, "id")
+	if !ok {
+		return Models.Payment{}, false
+	}
+
+	var payment Models.Payment
+	if err := h.getPaymentCollection().FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&payment); err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy giao dịch"))
+		return Models.Payment{}, false
+	}
+	if payment.UserID != claims.ID && !Middleware.HasPermission(c, Models.PermOrdersManage) {
+		Middleware.Fail(c, Middleware.NotFound("Không tìm thấy giao dịch"))
+		return Models.Payment{}, false
+	}
+	return payment, true
+}
+
+func (h *Handler) GetOrderPayments(c *gin.Context) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+
+	objectID, ok := paramObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	var order Models.Order
+	if err := h.getOrderCollection().FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&order); err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy đơn hàng"))
+		return
+	}
+	if order.UserID != claims.ID && !Middleware.HasPermission(c, Models.PermOrdersManage) {
+		Middleware.Fail(c, Middleware.NotFound("Không tìm thấy đơn hàng"))
+		return
+	}
+
+	cursor, err := h.getPaymentCollection().Find(context.Background(), bson.M{"order_id": order.ID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	payments := []Models.Payment{}
+	if err := cursor.All(context.Background(), &payments); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, payments)
+}
+
+// RefreshPayment asks the gateway about a payment that is still pending,
+// for when its callbacks never arrived.
+func (h *Handler) RefreshPayment(c *gin.Context) {
+	payment, ok := h.loadPayment(c)
+	if !ok {
+		return
+	}
+	if payment.Status != Models.PaymentPending {
+		c.JSON(http.StatusOK, payment)
+		return
+	}
+	if h.Payments == nil || h.Payments.Name() != payment.Provider {
+		Middleware.Fail(c, errPaymentsDisabled())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	result, err := h.Payments.QueryStatus(ctx, Payment.StatusRequest{
+		TxnRef:    payment.TxnRef,
+		OrderInfo: "Kiem tra giao dich " + payment.TxnRef,
+		ClientIP:  c.ClientIP(),
+		CreatedAt: payment.CreatedAt,
+	})
+	var declined *Payment.GatewayError
+	if errors.As(err, &declined) && time.Now().After(payment.ExpiresAt) {
+		// The gateway has no record of a payment the customer never
+		// finished, and after it expires nothing can come of it.
+		result = Payment.Result{TxnRef: payment.TxnRef, Amount: payment.Amount.Amount, Status: Payment.StatusFailed, ResponseCode: declined.Code}
+		err = nil
+	}
+	if err != nil {
+		Middleware.Fail(c, gatewayError(err))
+		return
+	}
+
+	updated, _, err := h.applyPaymentResult(ctx, payment.Provider, result)
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// RefundPayment refunds a payment, including one whose earlier refund the
+// gateway did not take.
+func (h *Handler) RefundPayment(c *gin.Context) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+
+	var body struct {
+		Note string `json:"note" binding:"max=500"`
+	}
+	if !bindOptionalJSON(c, &body) {
+		return
+	}
+
+	payment, ok := h.loadPayment(c)
+	if !ok {
+		return
+	}
+	if apiErr := h.checkRefundable(payment); apiErr != nil {
+		Middleware.Fail(c, apiErr)
+		return
+	}
+
+	var order Models.Order
+	if err := h.getOrderCollection().FindOne(context.Background(), bson.M{"_id": payment.OrderID}).Decode(&order); err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy đơn hàng"))
+		return
+	}
+	// The order follows the refund when this payment paid for it. A
+	// cancelled order, one already refunded by an earlier attempt, or one
+	// paid by another attempt keeps its status and only the money goes back.
+	movesOrder := order.PaymentID == payment.ID && order.Status != Models.OrderCancelled && order.Status != Models.OrderRefunded
+	if movesOrder {
+		if !order.Status.CanTransitionTo(Models.OrderRefunded) {
+			respondOrderTransitionError(c, &orderTransitionError{From: order.Status, To: Models.OrderRefunded})
+			return
+		}
+		updated, err := h.transitionOrderSetting(order, Models.OrderRefunded, claims.ID, body.Note, nil, func(sc mongo.SessionContext) error {
+			return h.claimRefund(sc, payment)
+		})
+		if err != nil {
+			respondOrderTransitionError(c, err)
+			return
+		}
+		order = updated
+	} else if err := h.claimRefund(context.Background(), payment); err != nil {
+		respondOrderTransitionError(c, err)
+		return
+	}
+
+	if apiErr := h.completeRefund(c, &payment, claims.ID, order); apiErr != nil {
+		Middleware.Fail(c, apiErr)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"payment": payment, "order": order})
+}
+
+var errRefundConflict = errors.New("payment refund claimed concurrently")
+
+// errRefundFailed reports a refund the gateway did not take after order
+// had already changed. The payment is left for staff to retry.
+func errRefundFailed(err error, order Models.Order) *Middleware.APIError {
+	apiErr := Middleware.NewError(http.StatusBadGateway, Middleware.CodeUnavailable, "Đơn hàng đã được cập nhật nhưng chưa hoàn tiền được, cửa hàng sẽ hoàn tiền sau").
+		WithDetail("order", order).
+		WithCause(err)
+	var declined *Payment.GatewayError
+	if errors.As(err, &declined) {
+		apiErr = apiErr.WithDetail("gateway_code", declined.Code)
+	}
+	return apiErr
+}
+
+// transitionOrderRefunding moves order to next and refunds the online
+// payment that paid for it, if there is one. The payment is claimed in the
+// same transaction as the status change, so a concurrent change that wins
+// the status race leaves the money alone, and only one caller ever asks
+// the gateway. The order keeps its new status when the gateway then fails.
+func (h *Handler) transitionOrderRefunding(c *gin.Context, order Models.Order, next Models.OrderStatus, actor primitive.ObjectID, note string) (Models.Order, *Middleware.APIError) {
+	var payment Models.Payment
+	var claim func(sc mongo.SessionContext) error
+	if !order.PaymentID.IsZero() {
+		err := h.getPaymentCollection().FindOne(context.Background(), bson.M{"_id": order.PaymentID}).Decode(&payment)
+		if err != nil {
+			return Models.Order{}, Middleware.Internal(err)
+		}
+		if payment.Status.IsRefundable() {
+			if apiErr := h.checkRefundable(payment); apiErr != nil {
+				return Models.Order{}, apiErr
+			}
+			claim = func(sc mongo.SessionContext) error {
+				return h.claimRefund(sc, payment)
+			}
+		}
+	}
+
+	updated, err := h.transitionOrderSetting(order, next, actor, note, nil, claim)
+	if err != nil {
+		return Models.Order{}, orderTransitionFailure(err)
+	}
+
+	if claim != nil {
+		if apiErr := h.completeRefund(c, &payment, actor, updated); apiErr != nil {
+			return Models.Order{}, apiErr
+		}
+	}
+	return updated, nil
+}
+
+// checkRefundable reports why payment cannot be refunded through the
+// configured gateway, or returns nil.
+func (h *Handler) checkRefundable(payment Models.Payment) *Middleware.APIError {
+	if !payment.Status.IsRefundable() {
+		return Middleware.Conflict("Chỉ hoàn tiền được giao dịch đã thanh toán").WithDetail("status", payment.Status)
+	}
+	if h.Payments == nil || h.Payments.Name() != payment.Provider {
+		return errPaymentsDisabled()
+	}
+	return nil
+}
+
+// claimRefund moves payment to refunding, on condition that it is still
+// refundable, so that only one caller goes on to ask the gateway. It
+// returns errRefundConflict when another caller claimed it first. It runs
+// in the transaction of the order change that calls for the refund, when
+// there is one.
+func (h *Handler) claimRefund(ctx context.Context, payment Models.Payment) error {
+	result, err := h.getPaymentCollection().UpdateOne(ctx,
+		bson.M{"_id": payment.ID, "status": bson.M{"$in": []Models.PaymentStatus{Models.PaymentSucceeded, Models.PaymentRefundFailed}}},
+		bson.M{
+			"$set":   bson.M{"status": Models.PaymentRefunding, "updated_at": time.Now()},
+			"$unset": bson.M{"refund_error": ""},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errRefundConflict
+	}
+	return nil
+}
+
+// completeRefund asks the gateway to return the money of a payment claimed
+// by claimRefund and records the outcome. A refund the gateway does not
+// take is marked refund_failed for staff to retry and reported with order,
+// which has already changed by then.
+func (h *Handler) completeRefund(c *gin.Context, payment *Models.Payment, actor primitive.ObjectID, order Models.Order) *Middleware.APIError {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	_, refundErr := h.Payments.Refund(ctx, Payment.RefundRequest{
+		TxnRef:        payment.TxnRef,
+		TransactionNo: payment.TransactionNo,
+		Amount:        payment.Amount.Amount,
+		OrderInfo:     "Hoan tien don hang " + payment.OrderID.Hex(),
+		ClientIP:      c.ClientIP(),
+		CreatedAt:     payment.CreatedAt,
+		CreatedBy:     actor.Hex(),
+	})
+
+	now := time.Now()
+	set := bson.M{"status": Models.PaymentRefunded, "refunded_at": now, "updated_at": now}
+	if refundErr != nil {
+		log.Printf("refund of payment %s for order %s failed, it needs a retry: %v", payment.ID.Hex(), payment.OrderID.Hex(), refundErr)
+		set = bson.M{"status": Models.PaymentRefundFailed, "refund_error": refundErr.Error(), "updated_at": now}
+	}
+	// The gateway call may have used up ctx, so the outcome is recorded
+	// with a fresh one. Should this fail, the payment stays refunding for
+	// staff to check.
+	if _, err := h.getPaymentCollection().UpdateOne(context.Background(),
+		bson.M{"_id": payment.ID, "status": Models.PaymentRefunding},
+		bson.M{"$set": set},
+	); err != nil {
+		if refundErr != nil {
+			return errRefundFailed(refundErr, order)
+		}
+		return Middleware.Internal(err)
+	}
+
+	payment.UpdatedAt = now
+	if refundErr != nil {
+		payment.Status = Models.PaymentRefundFailed
+		payment.RefundError = refundErr.Error()
+		return errRefundFailed(refundErr, order)
+	}
+	payment.Status = Models.PaymentRefunded
+	payment.RefundedAt = &now
+	return nil
+}
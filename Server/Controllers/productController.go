@@ -2,238 +2,200 @@ package Controllers
 
 import (
 	"context"
-	"mime/multipart"
 	"net/http"
-	"strconv"
 
 	"Server/Middleware"
 	"Server/Models"
 
-	"github.com/cloudinary/cloudinary-go"
-	"github.com/cloudinary/cloudinary-go/api/uploader"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func uploadToCloudinary(file multipart.File, fileName string) (string, error) {
-	cld, err := cloudinary.NewFromParams("dflhancsp", "437865386617669", "uLJSc-9ItdeXSbWyEndQ3x-F1FY")
-	if err != nil {
-		return "", err
-	}
-	ctx := context.Background()
-	uploadResult, err := cld.Upload.Upload(ctx, file, uploader.UploadParams{PublicID: fileName})
-	if err != nil {
-		return "", err
-	}
-	return uploadResult.SecureURL, nil
-}
-
-func CreateProduct(c *gin.Context) {
-	claims := c.MustGet("user").(*Middleware.UserClaims)
-
-	if claims.Role != Middleware.Admin && claims.Role != Middleware.Staff {
-		c.JSON(403, gin.H{"error": "You are not authorized to create products"})
-		return
-	}
-
+func (h *Handler) CreateProduct(c *gin.Context) {
 	var product Models.Product
-
-	err := c.Request.ParseMultipartForm(10 << 20)
-	if err != nil {
-		c.JSON(400, gin.H{"error": "Could not parse multipart form"})
-		return
-	}
-
-	file, err := c.FormFile("image")
-	if err != nil {
-		c.JSON(400, gin.H{"error": "Could not get file from form"})
+	if !bindForm(c, &product) {
 		return
 	}
 
-	fileContent, err := file.Open()
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Could not open file"})
+	category, ok := parseObjectID(c, "productcategory", c.PostForm("productcategory"))
+	if !ok {
 		return
 	}
-	defer fileContent.Close()
+	product.ProductCategory = category
 
-	url, err := uploadToCloudinary(fileContent, file.Filename)
+	image, err := h.uploadFormImage(c)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Could not upload image to Cloudinary"})
-		return
-	}
-
-	product.ImageURL = url
-	product.Name = c.PostForm("name")
-	product.Price, _ = strconv.ParseFloat(c.PostForm("price"), 64)
-	product.Stock, _ = strconv.Atoi(c.PostForm("stock"))
-	product.ProductCategory, _ = primitive.ObjectIDFromHex(c.PostForm("productcategory"))
-
-	if product.Name == "" || product.Price <= 0 || product.Stock <= 0 {
-		c.JSON(400, gin.H{"error": "Invalid input"})
+		Middleware.Fail(c, imageError(err))
 		return
 	}
 
+	product.ImageURL = image.URL
+	product.ImageKey = image.Key
 	product.ID = primitive.NewObjectID()
 
-	collection := getCollection("products")
+	collection := h.getCollection("products")
 	if _, err := collection.InsertOne(context.Background(), product); err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+		h.deleteStoredImage(image.Key)
+		Middleware.Fail(c, Middleware.Internal(err))
 		return
 	}
 
 	c.JSON(200, product)
 }
 
-func GetAllProducts(c *gin.Context) {
-	var products []Models.Product
-	collection := getCollection("products")
-	cursor, err := collection.Find(context.Background(), bson.M{})
-	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
-		return
-	}
-	defer cursor.Close(context.Background())
+var productListSpec = listSpec{
+	Filters: []listFilter{
+		objectIDFilter("productcategory", "productcategory"),
+		moneyRangeFilter("min_price", "max_price", "price"),
+		func(c *gin.Context, match bson.M) error {
+			if c.Query("in_stock") == "true" {
+				match["stock"] = bson.M{"$gt": 0}
+			}
+			return nil
+		},
+	},
+	Sorts: map[string]string{
+		"price":  "price.amount",
+		"name":   "name",
+		"newest": "-_id",
+	},
+	DefaultSort: bson.D{{Key: "_id", Value: -1}},
+	TextSearch:  true,
+}
 
-	for cursor.Next(context.Background()) {
-		var product Models.Product
-		cursor.Decode(&product)
-		products = append(products, product)
+// GetAllProducts lists products one page at a time. It accepts "q" for a
+// text search on the name, "productcategory", "min_price", "max_price",
+// "in_stock=true", and "sort" as price, name or newest ("-" for descending).
+func (h *Handler) GetAllProducts(c *gin.Context) {
+	products := []Models.Product{}
+	page, ok := listPage(c, h.getProductCollection(), productListSpec, nil, nil, &products)
+	if !ok {
+		return
 	}
 
-	c.JSON(200, products)
+	c.JSON(http.StatusOK, page)
 }
 
-func GetProductByID(c *gin.Context) {
-	id := c.Param("id")
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		c.JSON(400, gin.H{"error": "Invalid ID format"})
+func (h *Handler) GetProductByID(c *gin.Context) {
+	objectID, ok := paramObjectID(c, "id")
+	if !ok {
 		return
 	}
 
 	var product Models.Product
-	collection := getCollection("products")
-	if err = collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&product); err != nil {
-		c.JSON(404, gin.H{"error": "Product not found"})
+	collection := h.getCollection("products")
+	if err := collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&product); err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy sản phẩm"))
 		return
 	}
 
 	c.JSON(200, product)
 }
 
-func UpdateProduct(c *gin.Context) {
-	claims := c.MustGet("user").(*Middleware.UserClaims)
-
-	if claims.Role != Middleware.Admin && claims.Role != Middleware.Staff {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to update products"})
+// UpdateProduct changes the fields present in the multipart form and keeps
+// the others.
+func (h *Handler) UpdateProduct(c *gin.Context) {
+	objectID, ok := paramObjectID(c, "id")
+	if !ok {
 		return
 	}
 
-	id := c.Param("id")
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
-		return
+	var form struct {
+		Name            *string       `form:"name"`
+		Price           *Models.Money `form:"price"`
+		Stock           *int          `form:"stock"`
+		Weight          *int          `form:"weight"`
+		ProductCategory string        `form:"productcategory"`
 	}
-
-	var existingProduct Models.Product
-	collection := getCollection("products")
-	err = collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&existingProduct)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+	if !bindForm(c, &form) {
 		return
 	}
 
-	err = c.Request.ParseMultipartForm(10 << 20)
+	var existingProduct Models.Product
+	collection := h.getCollection("products")
+	err := collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&existingProduct)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not parse multipart form"})
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy sản phẩm"))
 		return
 	}
 
-	file, err := c.FormFile("image")
-	if err == nil {
-		fileContent, err := file.Open()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not open file"})
-			return
-		}
-		defer fileContent.Close()
-
-		url, err := uploadToCloudinary(fileContent, file.Filename)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not upload image to Cloudinary"})
-			return
-		}
-
-		existingProduct.ImageURL = url
+	if form.Name != nil {
+		existingProduct.Name = *form.Name
 	}
-
-	if name := c.PostForm("name"); name != "" {
-		existingProduct.Name = name
+	if form.Price != nil {
+		existingProduct.Price = *form.Price
 	}
-	if price, err := strconv.ParseFloat(c.PostForm("price"), 64); err == nil && price > 0 {
-		existingProduct.Price = price
+	if form.Stock != nil {
+		existingProduct.Stock = *form.Stock
 	}
-	if stock, err := strconv.Atoi(c.PostForm("stock")); err == nil && stock >= 0 {
-		existingProduct.Stock = stock
+	if form.Weight != nil {
+		existingProduct.Weight = *form.Weight
 	}
-	if category := c.PostForm("productcategory"); category != "" {
-		if productCategory, err := primitive.ObjectIDFromHex(category); err == nil {
-			existingProduct.ProductCategory = productCategory
+	if form.ProductCategory != "" {
+		if existingProduct.ProductCategory, ok = parseObjectID(c, "productcategory", form.ProductCategory); !ok {
+			return
 		}
 	}
+	if !validateStruct(c, &existingProduct) {
+		return
+	}
 
-	if existingProduct.Name == "" || existingProduct.Price <= 0 || existingProduct.Stock < 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	previousImageKey := existingProduct.ImageKey
+	image, err := h.uploadFormImage(c)
+	if err != nil && err != http.ErrMissingFile {
+		Middleware.Fail(c, imageError(err))
 		return
 	}
+	if err == nil {
+		existingProduct.ImageURL = image.URL
+		existingProduct.ImageKey = image.Key
+	}
 
 	update := bson.M{
 		"$set": bson.M{
 			"name":            existingProduct.Name,
 			"price":           existingProduct.Price,
 			"stock":           existingProduct.Stock,
+			"weight":          existingProduct.Weight,
 			"productcategory": existingProduct.ProductCategory,
 			"imageurl":        existingProduct.ImageURL,
+			"imagekey":        existingProduct.ImageKey,
 		},
 	}
 
 	result, err := collection.UpdateOne(context.Background(), bson.M{"_id": objectID}, update)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		Middleware.Fail(c, Middleware.Internal(err))
 		return
 	}
 
 	if result.MatchedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		h.deleteStoredImage(image.Key)
+		Middleware.Fail(c, Middleware.NotFound("Không tìm thấy sản phẩm"))
 		return
 	}
 
+	if existingProduct.ImageKey != previousImageKey {
+		h.deleteStoredImage(previousImageKey)
+	}
+
 	c.JSON(http.StatusOK, existingProduct)
 }
 
-func DeleteProduct(c *gin.Context) {
-	claims := c.MustGet("user").(*Middleware.UserClaims)
-
-	if claims.Role != Middleware.Admin {
-		c.JSON(403, gin.H{"error": "You are not authorized to delete products"})
+func (h *Handler) DeleteProduct(c *gin.Context) {
+	objectID, ok := paramObjectID(c, "id")
+	if !ok {
 		return
 	}
 
-	id := c.Param("id")
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		c.JSON(400, gin.H{"error": "Invalid ID format"})
-		return
-	}
-
-	collection := getCollection("products")
-	if result, err := collection.DeleteOne(context.Background(), bson.M{"_id": objectID}); err != nil || result.DeletedCount == 0 {
-		c.JSON(404, gin.H{"error": "Product not found"})
+	collection := h.getCollection("products")
+	var product Models.Product
+	if err := collection.FindOneAndDelete(context.Background(), bson.M{"_id": objectID}).Decode(&product); err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy sản phẩm"))
 		return
 	}
+	h.deleteStoredImage(product.ImageKey)
 
 	c.Status(204)
 }
@@ -13,135 +13,109 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func getCollection(name string) *mongo.Collection {
-	return Database.Collection(name)
+func (h *Handler) getCollection(name string) *mongo.Collection {
+	return h.DB.Collection(name)
 }
 
-func CreateProductCategory(c *gin.Context) {
-	claims := c.MustGet("user").(*Middleware.UserClaims)
-	if claims.Role > Middleware.Staff {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to create a product category"})
-		return
-	}
-
+func (h *Handler) CreateProductCategory(c *gin.Context) {
 	var productCategory Models.ProductCategory
-	if err := c.ShouldBindJSON(&productCategory); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	if !bindJSON(c, &productCategory) {
 		return
 	}
 
 	productCategory.ID = primitive.NewObjectID()
 
-	collection := getCollection("product_categories")
+	collection := h.getCollection("product_categories")
 	_, err := collection.InsertOne(context.Background(), productCategory)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		Middleware.Fail(c, Middleware.Internal(err))
 		return
 	}
 
 	c.JSON(http.StatusOK, productCategory)
 }
 
-func GetAllProductCategories(c *gin.Context) {
-	var productCategories []Models.ProductCategory
-	collection := getCollection("product_categories")
-	cursor, err := collection.Find(context.Background(), bson.M{})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	defer cursor.Close(context.Background())
+var productCategoryListSpec = listSpec{
+	Sorts: map[string]string{
+		"name":   "name",
+		"newest": "-_id",
+	},
+	DefaultSort: bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}},
+	TextSearch:  true,
+}
 
-	for cursor.Next(context.Background()) {
-		var productCategory Models.ProductCategory
-		cursor.Decode(&productCategory)
-		productCategories = append(productCategories, productCategory)
+func (h *Handler) GetAllProductCategories(c *gin.Context) {
+	productCategories := []Models.ProductCategory{}
+	page, ok := listPage(c, h.getCollection("product_categories"), productCategoryListSpec, nil, nil, &productCategories)
+	if !ok {
+		return
 	}
 
-	c.JSON(http.StatusOK, productCategories)
+	c.JSON(http.StatusOK, page)
 }
 
-func GetProductCategoryByID(c *gin.Context) {
-	id := c.Param("id")
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+func (h *Handler) GetProductCategoryByID(c *gin.Context) {
+	objectID, ok := paramObjectID(c, "id")
+	if !ok {
 		return
 	}
 
 	var productCategory Models.ProductCategory
-	collection := getCollection("product_categories")
-	err = collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&productCategory)
+	collection := h.getCollection("product_categories")
+	err := collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&productCategory)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Product category not found"})
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy danh mục sản phẩm"))
 		return
 	}
 
 	c.JSON(http.StatusOK, productCategory)
 }
 
-func UpdateProductCategory(c *gin.Context) {
-	claims := c.MustGet("user").(*Middleware.UserClaims)
-	if claims.Role > Middleware.Staff {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to update a product category"})
-		return
-	}
-
-	id := c.Param("id")
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+func (h *Handler) UpdateProductCategory(c *gin.Context) {
+	objectID, ok := paramObjectID(c, "id")
+	if !ok {
 		return
 	}
 
 	var productCategory Models.ProductCategory
-	if err := c.ShouldBindJSON(&productCategory); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+	if !bindJSON(c, &productCategory) {
 		return
 	}
 
-	collection := getCollection("product_categories")
+	collection := h.getCollection("product_categories")
 	update := bson.M{"$set": bson.M{
 		"name":        productCategory.Name,
 		"description": productCategory.Description,
 	}}
 	result, err := collection.UpdateOne(context.Background(), bson.M{"_id": objectID}, update)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		Middleware.Fail(c, Middleware.Internal(err))
 		return
 	}
 
 	if result.MatchedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Product category not found"})
+		Middleware.Fail(c, Middleware.NotFound("Không tìm thấy danh mục sản phẩm"))
 		return
 	}
 
 	c.JSON(http.StatusOK, productCategory)
 }
 
-func DeleteProductCategory(c *gin.Context) {
-	claims := c.MustGet("user").(*Middleware.UserClaims)
-	if claims.Role != Middleware.Admin {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to delete a product category"})
-		return
-	}
-
-	id := c.Param("id")
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+func (h *Handler) DeleteProductCategory(c *gin.Context) {
+	objectID, ok := paramObjectID(c, "id")
+	if !ok {
 		return
 	}
 
-	collection := getCollection("product_categories")
+	collection := h.getCollection("product_categories")
 	result, err := collection.DeleteOne(context.Background(), bson.M{"_id": objectID})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		Middleware.Fail(c, Middleware.Internal(err))
 		return
 	}
 
 	if result.DeletedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Product category not found"})
+		Middleware.Fail(c, Middleware.NotFound("Không tìm thấy danh mục sản phẩm"))
 		return
 	}
 
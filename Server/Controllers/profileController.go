@@ -0,0 +1,231 @@
+package Controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"Server/Middleware"
+	"Server/Models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// profileProjection keeps credentials and the cart out of profile responses.
+var profileProjection = bson.M{"password": 0, "cart": 0, "two_factor": 0}
+
+// profileUpdate lists the fields that can be changed on a profile.
+// Anything else in the request body, such as role or password, is rejected.
+type profileUpdate struct {
+	FirstName *string `json:"firstname" binding:"omitnil,min=1,max=50"`
+	LastName  *string `json:"lastname" binding:"omitnil,max=50"`
+	Phone     *string `json:"phone" binding:"omitnil,numeric,min=9,max=15"`
+	Address   *string `json:"address" binding:"omitnil,max=200"`
+}
+
+func (u *profileUpdate) values() map[string]*string {
+	return map[string]*string{
+		"firstname": u.FirstName,
+		"lastname":  u.LastName,
+		"phone":     u.Phone,
+		"address":   u.Address,
+	}
+}
+
+// bindProfileUpdate decodes a profileUpdate from the request body and
+// returns the fields to set. On failure it fails the request and returns
+// false.
+func bindProfileUpdate(c *gin.Context) (bson.M, bool) {
+	var update profileUpdate
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&update); err != nil {
+		if strings.HasPrefix(err.Error(), "json: unknown field") {
+			Middleware.Fail(c, Middleware.BadRequest("Chỉ được thay đổi họ, tên, số điện thoại và địa chỉ").WithCause(err))
+		} else {
+			Middleware.Fail(c, bindError(err))
+		}
+		return nil, false
+	}
+
+	fields := bson.M{}
+	for key, value := range update.values() {
+		if value != nil {
+			*value = strings.TrimSpace(*value)
+			fields[key] = *value
+		}
+	}
+	if len(fields) == 0 {
+		Middleware.Fail(c, Middleware.BadRequest("Không có thông tin nào để cập nhật"))
+		return nil, false
+	}
+	if !validateStruct(c, &update) {
+		return nil, false
+	}
+	return fields, true
+}
+
+// phoneAvailable fails the request with a conflict and returns false when
+// fields change the phone of userID to one another user has.
+func (h *Handler) phoneAvailable(ctx context.Context, c *gin.Context, fields bson.M, userID primitive.ObjectID) bool {
+	phone, ok := fields["phone"]
+	if !ok {
+		return true
+	}
+	count, err := h.DB.Collection("users").CountDocuments(ctx, bson.M{"phone": phone, "_id": bson.M{"$ne": userID}})
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return false
+	}
+	if count > 0 {
+		Middleware.Fail(c, Middleware.Conflict("Số điện thoại đã tồn tại"))
+		return false
+	}
+	return true
+}
+
+func (h *Handler) findProfile(ctx context.Context, c *gin.Context) (Models.User, bool) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+
+	var user Models.User
+	err := h.DB.Collection("users").FindOne(ctx, bson.M{"_id": claims.ID},
+		options.FindOne().SetProjection(profileProjection)).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		Middleware.Fail(c, Middleware.NotFound("Không tìm thấy người dùng"))
+		return user, false
+	}
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return user, false
+	}
+	return user, true
+}
+
+func (h *Handler) GetMe(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := h.findProfile(ctx, c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func (h *Handler) UpdateMe(c *gin.Context) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+
+	fields, ok := bindProfileUpdate(c)
+	if !ok {
+		return
+	}
+
+	collection := h.DB.Collection("users")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !h.phoneAvailable(ctx, c, fields, claims.ID) {
+		return
+	}
+
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": claims.ID}, bson.M{"$set": fields}); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	user, ok := h.findProfile(ctx, c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// ChangePassword replaces the caller's password after checking the current
+// one, then signs out every other device.
+func (h *Handler) ChangePassword(c *gin.Context) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+
+	var reqBody struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required,min=8,max=72"`
+	}
+	if !bindJSON(c, &reqBody) {
+		return
+	}
+
+	collection := h.DB.Collection("users")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user Models.User
+	if err := collection.FindOne(ctx, bson.M{"_id": claims.ID}).Decode(&user); err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy người dùng"))
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(reqBody.CurrentPassword)); err != nil {
+		Middleware.Fail(c, Middleware.ValidationFailed(Middleware.FieldError{
+			Field:   "current_password",
+			Code:    "mismatch",
+			Message: "Mật khẩu hiện tại không đúng",
+		}))
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(reqBody.NewPassword), 10)
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": claims.ID}, bson.M{"$set": bson.M{"password": string(hash)}}); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	if err := h.revokeUserSessions(ctx, claims.ID, claims.SessionID()); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed"})
+}
+
+// UploadAvatar stores the "image" form file as the caller's avatar and
+// removes the one it replaces.
+func (h *Handler) UploadAvatar(c *gin.Context) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+
+	image, err := h.uploadFormImage(c)
+	if err != nil {
+		Middleware.Fail(c, imageError(err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var previous Models.User
+	err = h.DB.Collection("users").FindOneAndUpdate(ctx,
+		bson.M{"_id": claims.ID},
+		bson.M{"$set": bson.M{"avatar": image.URL, "avatar_key": image.Key}},
+		options.FindOneAndUpdate().SetProjection(bson.M{"avatar_key": 1}),
+	).Decode(&previous)
+	if err != nil {
+		h.deleteStoredImage(image.Key)
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	h.deleteStoredImage(previous.AvatarKey)
+
+	c.JSON(http.StatusOK, gin.H{"avatar": image.URL})
+}
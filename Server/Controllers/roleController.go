@@ -0,0 +1,332 @@
+package Controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"Server/Middleware"
+	"Server/Models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (h *Handler) getRoleCollection() *mongo.Collection {
+	return h.DB.Collection("roles")
+}
+
+// EnsureRoles creates the built-in roles that are missing. The Admin role
+// is reset to every known permission, so admins can never lock themselves
+// out of role management. Whether a role requires two-factor
+// authentication is only seeded, so changes made through the API stick.
+func (h *Handler) EnsureRoles(ctx context.Context) error {
+	collection := h.getRoleCollection()
+	for _, role := range Models.DefaultRoleDefinitions() {
+		fields := bson.M{
+			"name":        role.Name,
+			"permissions": role.Permissions,
+			"built_in":    role.BuiltIn,
+			"updated_at":  time.Now(),
+		}
+
+		update := bson.M{"$setOnInsert": fields}
+		if role.ID == Models.Admin {
+			update = bson.M{"$set": fields, "$setOnInsert": bson.M{"require_two_factor": role.RequireTwoFactor}}
+		} else {
+			fields["require_two_factor"] = role.RequireTwoFactor
+		}
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": role.ID}, update, options.Update().SetUpsert(true)); err != nil {
+			return err
+		}
+
+		// Roles created before two-factor authentication existed get the
+		// default once.
+		if _, err := collection.UpdateOne(ctx,
+			bson.M{"_id": role.ID, "require_two_factor": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"require_two_factor": role.RequireTwoFactor}},
+		); err != nil {
+			return err
+		}
+	}
+	h.Auth.InvalidatePermissions()
+	return nil
+}
+
+// rolesWith returns the roles that grant permission.
+func (h *Handler) rolesWith(ctx context.Context, permission Models.Permission) ([]Models.Role, error) {
+	cursor, err := h.getRoleCollection().Find(ctx, bson.M{"permissions": permission})
+	if err != nil {
+		return nil, err
+	}
+	var definitions []Models.RoleDefinition
+	if err := cursor.All(ctx, &definitions); err != nil {
+		return nil, err
+	}
+
+	roles := make([]Models.Role, len(definitions))
+	for i, definition := range definitions {
+		roles[i] = definition.ID
+	}
+	return roles, nil
+}
+
+type roleRequest struct {
+	Name        string              `json:"name" binding:"required,max=50"`
+	Permissions []Models.Permission `json:"permissions" binding:"dive,permission"`
+}
+
+// normalize trims the name and drops repeated permissions. A name of only
+// spaces fails the request, and normalize returns false.
+func (r *roleRequest) normalize(c *gin.Context) bool {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		Middleware.Fail(c, Middleware.ValidationFailed(Middleware.FieldError{
+			Field:   "name",
+			Code:    "required",
+			Message: "Không được để trống",
+		}))
+		return false
+	}
+
+	seen := map[Models.Permission]bool{}
+	permissions := make([]Models.Permission, 0, len(r.Permissions))
+	for _, permission := range r.Permissions {
+		if !seen[permission] {
+			seen[permission] = true
+			permissions = append(permissions, permission)
+		}
+	}
+	r.Permissions = permissions
+	return true
+}
+
+func parseRoleID(c *gin.Context) (Models.Role, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id < 0 {
+		Middleware.Fail(c, Middleware.ValidationFailed(Middleware.FieldError{
+			Field:   "id",
+			Code:    "number",
+			Message: "Phải là mã vai trò",
+		}))
+		return 0, false
+	}
+	return Models.Role(id), true
+}
+
+func (h *Handler) GetRoles(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := h.getRoleCollection().Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	roles := []Models.RoleDefinition{}
+	if err := cursor.All(ctx, &roles); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"roles": roles, "permissions": Models.AllPermissions})
+}
+
+func (h *Handler) CreateRole(c *gin.Context) {
+	var reqBody roleRequest
+	if !bindJSON(c, &reqBody) {
+		return
+	}
+	if !reqBody.normalize(c) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	role, err := h.insertRole(ctx, reqBody.Name, reqBody.Permissions)
+	if errors.Is(err, errRoleNameTaken) {
+		Middleware.Fail(c, Middleware.Conflict("Đã có vai trò với tên này"))
+		return
+	}
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	h.Auth.InvalidatePermissions()
+
+	c.JSON(http.StatusCreated, role)
+}
+
+// createRoleAttempts bounds how often insertRole picks a new ID after
+// another role took the one it chose.
+const createRoleAttempts = 5
+
+var errRoleNameTaken = errors.New("role name taken")
+
+// insertRole stores a new role under the ID after the highest one. When a
+// concurrent insert takes that ID first, it reads the highest ID again and
+// retries; errRoleNameTaken is only returned when the name index refused
+// the role.
+func (h *Handler) insertRole(ctx context.Context, name string, permissions []Models.Permission) (Models.RoleDefinition, error) {
+	collection := h.getRoleCollection()
+	for attempt := 1; ; attempt++ {
+		var last Models.RoleDefinition
+		err := collection.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}})).Decode(&last)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return Models.RoleDefinition{}, err
+		}
+
+		role := Models.RoleDefinition{
+			ID:          last.ID + 1,
+			Name:        name,
+			Permissions: permissions,
+			UpdatedAt:   time.Now(),
+		}
+		_, err = collection.InsertOne(ctx, role)
+		switch {
+		case err == nil:
+			return role, nil
+		case isDuplicateID(err):
+			if attempt == createRoleAttempts {
+				return Models.RoleDefinition{}, err
+			}
+		case mongo.IsDuplicateKeyError(err):
+			return Models.RoleDefinition{}, errRoleNameTaken
+		default:
+			return Models.RoleDefinition{}, err
+		}
+	}
+}
+
+// UpdateRole renames a role and replaces its permissions. The Admin role
+// always holds every permission and cannot be edited.
+func (h *Handler) UpdateRole(c *gin.Context) {
+	roleID, ok := parseRoleID(c)
+	if !ok {
+		return
+	}
+	if roleID == Models.Admin {
+		Middleware.Fail(c, Middleware.Forbidden(Middleware.CodeForbidden, "Không thể sửa vai trò Admin"))
+		return
+	}
+
+	var reqBody roleRequest
+	if !bindJSON(c, &reqBody) {
+		return
+	}
+	if !reqBody.normalize(c) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var role Models.RoleDefinition
+	err := h.getRoleCollection().FindOneAndUpdate(ctx,
+		bson.M{"_id": roleID},
+		bson.M{"$set": bson.M{"name": reqBody.Name, "permissions": reqBody.Permissions, "updated_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&role)
+	if err == mongo.ErrNoDocuments {
+		Middleware.Fail(c, Middleware.NotFound("Không tìm thấy vai trò"))
+		return
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		Middleware.Fail(c, Middleware.Conflict("Đã có vai trò với tên này"))
+		return
+	}
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	h.Auth.InvalidatePermissions()
+
+	c.JSON(http.StatusOK, role)
+}
+
+// SetRoleTwoFactor turns the two-factor requirement for a role on or off.
+// Unlike other settings it can be changed on the Admin role too. Holders
+// without two-factor authentication enroll on their next login.
+func (h *Handler) SetRoleTwoFactor(c *gin.Context) {
+	roleID, ok := parseRoleID(c)
+	if !ok {
+		return
+	}
+
+	var reqBody struct {
+		Required *bool `json:"required" binding:"required"`
+	}
+	if !bindJSON(c, &reqBody) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var role Models.RoleDefinition
+	err := h.getRoleCollection().FindOneAndUpdate(ctx,
+		bson.M{"_id": roleID},
+		bson.M{"$set": bson.M{"require_two_factor": *reqBody.Required, "updated_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&role)
+	if err == mongo.ErrNoDocuments {
+		Middleware.Fail(c, Middleware.NotFound("Không tìm thấy vai trò"))
+		return
+	}
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, role)
+}
+
+// DeleteRole removes a custom role that no user holds any more.
+func (h *Handler) DeleteRole(c *gin.Context) {
+	roleID, ok := parseRoleID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var role Models.RoleDefinition
+	if err := h.getRoleCollection().FindOne(ctx, bson.M{"_id": roleID}).Decode(&role); err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy vai trò"))
+		return
+	}
+	if role.BuiltIn {
+		Middleware.Fail(c, Middleware.Forbidden(Middleware.CodeForbidden, "Không thể xóa vai trò có sẵn"))
+		return
+	}
+
+	holders, err := h.DB.Collection("users").CountDocuments(ctx, bson.M{"role": roleID})
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	if holders > 0 {
+		Middleware.Fail(c, Middleware.Conflict("Vai trò vẫn đang được gán cho người dùng").WithDetail("users", holders))
+		return
+	}
+
+	if _, err := h.getRoleCollection().DeleteOne(ctx, bson.M{"_id": roleID, "built_in": false}); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	h.Auth.InvalidatePermissions()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted"})
+}
+
+var roleIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+}
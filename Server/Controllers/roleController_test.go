@@ -0,0 +1,89 @@
+package Controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"Server/Models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// duplicateKey is the reply to an insert that index refused.
+func duplicateKey(mt *mtest.T, collection, index string) bson.D {
+	return mtest.CreateWriteErrorsResponse(mtest.WriteError{
+		Code:    codeDuplicateKey,
+		Message: "E11000 duplicate key error collection: " + mt.DB.Name() + "." + collection + " index: " + index + " dup key: { }",
+	})
+}
+
+func TestCreateRole(t *testing.T) {
+	admin := &caller{ID: primitive.NewObjectID(), Role: Models.Admin, Permissions: []Models.Permission{Models.PermRolesManage}}
+
+	tests := []struct {
+		name     string
+		replies  func(mt *mtest.T) []bson.D
+		status   int
+		wantCode string
+		wantID   Models.Role
+	}{
+		{
+			name: "next ID",
+			replies: func(mt *mtest.T) []bson.D {
+				return []bson.D{found(mt, "roles", Models.RoleDefinition{ID: 4}), mtest.CreateSuccessResponse()}
+			},
+			status: http.StatusCreated,
+			wantID: 5,
+		},
+		{
+			name: "ID taken by a concurrent create",
+			replies: func(mt *mtest.T) []bson.D {
+				return []bson.D{
+					found(mt, "roles", Models.RoleDefinition{ID: 4}),
+					duplicateKey(mt, "roles", "_id_"),
+					found(mt, "roles", Models.RoleDefinition{ID: 5}),
+					mtest.CreateSuccessResponse(),
+				}
+			},
+			status: http.StatusCreated,
+			wantID: 6,
+		},
+		{
+			name: "name taken",
+			replies: func(mt *mtest.T) []bson.D {
+				return []bson.D{found(mt, "roles", Models.RoleDefinition{ID: 4}), duplicateKey(mt, "roles", "name_1")}
+			},
+			status:   http.StatusConflict,
+			wantCode: "conflict",
+		},
+	}
+
+	mt := newMockDB(t)
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			h := newTestHandler(mt)
+			mt.AddMockResponses(tt.replies(mt)...)
+
+			w := serve(h.CreateRole, admin, map[string]interface{}{"name": "Kế toán"})
+			if w.Code != tt.status {
+				mt.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.wantCode != "" {
+				if code := errorCode(mt, w); code != tt.wantCode {
+					mt.Errorf("error %s, want %s", w.Body, tt.wantCode)
+				}
+				return
+			}
+			var role Models.RoleDefinition
+			if err := json.Unmarshal(w.Body.Bytes(), &role); err != nil {
+				mt.Fatal(err)
+			}
+			if role.ID != tt.wantID {
+				mt.Errorf("created role %d, want %d", role.ID, tt.wantID)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package Controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"Server/Middleware"
+	"Server/Models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxSlotRangeDays = 31
+
+var errSlotFull = errors.New("slot is fully booked")
+
+func (h *Handler) getServiceSlotCollection() *mongo.Collection {
+	return h.DB.Collection("service_slots")
+}
+
+func (h *Handler) UpdateServiceSchedule(c *gin.Context) {
+	id, ok := paramObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	var schedule Models.ServiceSchedule
+	if !bindJSON(c, &schedule) {
+		return
+	}
+	var scheduleErr *Models.ScheduleError
+	if err := schedule.Validate(); errors.As(err, &scheduleErr) {
+		Middleware.Fail(c, Middleware.ValidationFailed(Middleware.FieldError{
+			Field:   scheduleErr.Field,
+			Code:    "schedule",
+			Message: scheduleErr.Message,
+		}))
+		return
+	}
+
+	result, err := h.getServiceCollection().UpdateOne(context.Background(), bson.M{"_id": id}, bson.M{"$set": bson.M{"schedule": schedule}})
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	if result.MatchedCount == 0 {
+		Middleware.Fail(c, Middleware.NotFound("Không tìm thấy dịch vụ"))
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+// GetServiceSlots lists the slots of a service between the "from" and "to"
+// dates (YYYY-MM-DD, inclusive) together with how many places are left.
+func (h *Handler) GetServiceSlots(c *gin.Context) {
+	id, ok := paramObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	from, err := time.ParseInLocation("2006-01-02", c.Query("from"), Models.ScheduleLocation)
+	if err != nil {
+		respondListError(c, &queryParamError{Param: "from", Code: "date", Message: "Phải là ngày có dạng YYYY-MM-DD"})
+		return
+	}
+	to := from
+	if c.Query("to") != "" {
+		to, err = time.ParseInLocation("2006-01-02", c.Query("to"), Models.ScheduleLocation)
+		if err != nil || to.Before(from) {
+			respondListError(c, &queryParamError{Param: "to", Code: "date", Message: "Phải là ngày có dạng YYYY-MM-DD, không trước from"})
+			return
+		}
+	}
+	to = to.AddDate(0, 0, 1)
+	if to.Sub(from) > maxSlotRangeDays*24*time.Hour {
+		respondListError(c, &queryParamError{Param: "to", Code: "max", Message: fmt.Sprintf("Chỉ xem được tối đa %d ngày", maxSlotRangeDays)})
+		return
+	}
+
+	var service Models.Service
+	if err := h.getServiceCollection().FindOne(context.Background(), bson.M{"_id": id}).Decode(&service); err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy dịch vụ"))
+		return
+	}
+
+	now := time.Now()
+	if from.Before(now) {
+		from = now
+	}
+	schedule := service.EffectiveSchedule()
+	starts := schedule.SlotsBetween(from, to)
+
+	booked := map[int64]int{}
+	if len(starts) > 0 {
+		cursor, err := h.getServiceSlotCollection().Find(context.Background(), bson.M{
+			"service_id": id,
+			"start": bson.M{
+				"$gte": primitive.NewDateTimeFromTime(starts[0]),
+				"$lte": primitive.NewDateTimeFromTime(starts[len(starts)-1]),
+			},
+		})
+		if err != nil {
+			Middleware.Fail(c, Middleware.Internal(err))
+			return
+		}
+		defer cursor.Close(context.Background())
+
+		var slots []Models.ServiceSlot
+		if err := cursor.All(context.Background(), &slots); err != nil {
+			Middleware.Fail(c, Middleware.Internal(err))
+			return
+		}
+		for _, slot := range slots {
+			booked[slot.Start.Time().Unix()] = slot.Booked
+		}
+	}
+
+	slotLength := time.Duration(schedule.SlotMinutes) * time.Minute
+	availability := make([]Models.SlotAvailability, 0, len(starts))
+	for _, start := range starts {
+		taken := booked[start.Unix()]
+		available := schedule.Capacity - taken
+		if available < 0 {
+			available = 0
+		}
+		availability = append(availability, Models.SlotAvailability{
+			Start:     start,
+			End:       start.Add(slotLength),
+			Capacity:  schedule.Capacity,
+			Booked:    taken,
+			Available: available,
+		})
+	}
+
+	c.JSON(http.StatusOK, availability)
+}
+
+// reserveServiceSlot takes places in the slot starting at start, one for
+// every unit of the booking. The capacity check and the increment happen in
+// a single update, and an upsert that collides with an existing full slot
+// fails on the unique _id, so the slot can never be overbooked. It runs in
+// the transaction that stores the booking.
+func (h *Handler) reserveServiceSlot(sc mongo.SessionContext, serviceID primitive.ObjectID, capacity int, start time.Time, places int) error {
+	if places > capacity {
+		return errSlotFull
+	}
+	_, err := h.getServiceSlotCollection().UpdateOne(sc,
+		bson.M{"_id": Models.ServiceSlotID(serviceID, start), "booked": bson.M{"$lte": capacity - places}},
+		bson.M{
+			"$inc":         bson.M{"booked": places},
+			"$setOnInsert": bson.M{"service_id": serviceID, "start": primitive.NewDateTimeFromTime(start)},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return errSlotFull
+	}
+	return err
+}
+
+// releaseServiceSlot gives back places in the slot starting at start. The
+// count never drops below zero, as bookings made before they took a place
+// per unit only held one.
+func (h *Handler) releaseServiceSlot(ctx context.Context, serviceID primitive.ObjectID, start time.Time, places int) error {
+	_, err := h.getServiceSlotCollection().UpdateOne(ctx,
+		bson.M{"_id": Models.ServiceSlotID(serviceID, start)},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"booked": bson.M{"$max": bson.A{0, bson.M{"$subtract": bson.A{"$booked", places}}}},
+		}}}},
+	)
+	return err
+}
@@ -10,128 +10,190 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func getSelectedItemsCollection() *mongo.Collection {
-	return Database.Collection("selected_items")
+func (h *Handler) getSelectedItemsCollection() *mongo.Collection {
+	return h.DB.Collection("selected_items")
 }
 
-func AddToSelectedItems(c *gin.Context) {
+// priceSelectedItems fills in and flags the selected items the way
+// priceCart does for the cart.
+func (h *Handler) priceSelectedItems(ctx context.Context, selectedItems *Models.SelectedItems) error {
+	var err error
+	selectedItems.Subtotal, selectedItems.HasIssues, err = h.priceCartItems(ctx, selectedItems.Items)
+	return err
+}
+
+// AddToSelectedItems picks a product for checkout. As with the cart, the
+// price comes from the product and the quantity is checked against stock.
+func (h *Handler) AddToSelectedItems(c *gin.Context) {
 	claims := c.MustGet("user").(*Middleware.UserClaims)
 	userID := claims.ID
 
-	var selectedItem Models.SelectedItem
-	if err := c.ShouldBindJSON(&selectedItem); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	var reqBody cartLineRequest
+	if !bindJSON(c, &reqBody) {
 		return
 	}
 
-	productCollection := getProductCollection()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
 	var product Models.Product
-	if err := productCollection.FindOne(context.Background(), bson.M{"_id": selectedItem.ProductID}).Decode(&product); err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+	if err := h.getProductCollection().FindOne(ctx, bson.M{"_id": reqBody.ProductID}).Decode(&product); err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy sản phẩm"))
 		return
 	}
 
-	selectedItem.Name = product.Name
-	selectedItem.ImageURL = product.ImageURL
-
-	collection := getSelectedItemsCollection()
+	collection := h.getSelectedItemsCollection()
 	var selectedItems Models.SelectedItems
-	if err := collection.FindOne(context.Background(), bson.M{"user_id": userID}).Decode(&selectedItems); err == mongo.ErrNoDocuments {
-		selectedItems = Models.SelectedItems{
-			UserID:    userID,
-			Items:     []Models.SelectedItem{selectedItem},
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}
-		if _, err := collection.InsertOne(context.Background(), selectedItems); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add to selected items"})
-			return
+	err := collection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&selectedItems)
+	if err != nil && err != mongo.ErrNoDocuments {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	found := err == nil
+
+	line := -1
+	for i, item := range selectedItems.Items {
+		if item.ProductID == reqBody.ProductID {
+			line = i
+			break
 		}
+	}
+	quantity := reqBody.Quantity
+	if line >= 0 {
+		quantity += selectedItems.Items[line].Quantity
+	}
+	if apiErr := cartQuantityError(product, quantity); apiErr != nil {
+		Middleware.Fail(c, apiErr)
+		return
+	}
+
+	if line >= 0 {
+		selectedItems.Items[line].Quantity = quantity
+		selectedItems.Items[line].AddedPrice = product.Price
 	} else {
-		exists := false
-		for i, item := range selectedItems.Items {
-			if item.ProductID == selectedItem.ProductID {
-				selectedItems.Items[i].Quantity += selectedItem.Quantity
-				exists = true
-				break
-			}
-		}
-		if !exists {
-			selectedItems.Items = append(selectedItems.Items, selectedItem)
-		}
-		selectedItems.UpdatedAt = time.Now()
-		if _, err := collection.UpdateOne(context.Background(), bson.M{"user_id": userID}, bson.M{"$set": selectedItems}); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update selected items"})
-			return
-		}
+		selectedItems.Items = append(selectedItems.Items, Models.SelectedItem{
+			ProductID:  product.ID,
+			Quantity:   quantity,
+			AddedPrice: product.Price,
+		})
+	}
+
+	selectedItems.UpdatedAt = time.Now()
+	if !found {
+		selectedItems.UserID = userID
+		selectedItems.CreatedAt = selectedItems.UpdatedAt
+		_, err = collection.InsertOne(ctx, selectedItems)
+	} else {
+		_, err = collection.UpdateOne(ctx, bson.M{"user_id": userID}, bson.M{"$set": selectedItems})
+	}
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	if err := h.priceSelectedItems(ctx, &selectedItems); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
 	}
 	c.JSON(http.StatusOK, selectedItems)
 }
 
-func GetSelectedItems(c *gin.Context) {
+// GetSelectedItems returns the selected items at current prices, with the
+// lines that changed since they were picked flagged.
+func (h *Handler) GetSelectedItems(c *gin.Context) {
 	claims := c.MustGet("user").(*Middleware.UserClaims)
 	userID := claims.ID
 
-	collection := getSelectedItemsCollection()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := h.getSelectedItemsCollection()
 	var selectedItems Models.SelectedItems
-	if err := collection.FindOne(context.Background(), bson.M{"user_id": userID}).Decode(&selectedItems); err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Selected items not found"})
+	if err := collection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&selectedItems); err != nil {
+		Middleware.Fail(c, lookupError(err, "Chưa chọn sản phẩm nào"))
 		return
 	}
 
+	if err := h.priceSelectedItems(ctx, &selectedItems); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
 	c.JSON(http.StatusOK, selectedItems)
 }
 
-func UpdateSelectedItems(c *gin.Context) {
+func (h *Handler) UpdateSelectedItems(c *gin.Context) {
 	claims := c.MustGet("user").(*Middleware.UserClaims)
 	userID := claims.ID
 
-	var selectedItem Models.SelectedItem
-	if err := c.ShouldBindJSON(&selectedItem); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	var reqBody cartLineRequest
+	if !bindJSON(c, &reqBody) {
 		return
 	}
 
-	collection := getSelectedItemsCollection()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := h.getSelectedItemsCollection()
 	var selectedItems Models.SelectedItems
-	if err := collection.FindOne(context.Background(), bson.M{"user_id": userID}).Decode(&selectedItems); err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Selected items not found"})
+	if err := collection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&selectedItems); err != nil {
+		Middleware.Fail(c, lookupError(err, "Chưa chọn sản phẩm nào"))
 		return
 	}
 
+	line := -1
 	for i, item := range selectedItems.Items {
-		if item.ProductID == selectedItem.ProductID {
-			selectedItems.Items[i].Quantity = selectedItem.Quantity
+		if item.ProductID == reqBody.ProductID {
+			line = i
 			break
 		}
 	}
-	selectedItems.UpdatedAt = time.Now()
+	if line < 0 {
+		Middleware.Fail(c, Middleware.NotFound("Sản phẩm chưa được chọn"))
+		return
+	}
+
+	var product Models.Product
+	if err := h.getProductCollection().FindOne(ctx, bson.M{"_id": reqBody.ProductID}).Decode(&product); err != nil {
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy sản phẩm"))
+		return
+	}
+	if apiErr := cartQuantityError(product, reqBody.Quantity); apiErr != nil {
+		Middleware.Fail(c, apiErr)
+		return
+	}
 
-	if _, err := collection.UpdateOne(context.Background(), bson.M{"user_id": userID}, bson.M{"$set": selectedItems}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update selected items"})
+	selectedItems.Items[line].Quantity = reqBody.Quantity
+	selectedItems.Items[line].AddedPrice = product.Price
+	selectedItems.UpdatedAt = time.Now()
+	if _, err := collection.UpdateOne(ctx, bson.M{"user_id": userID}, bson.M{"$set": selectedItems}); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
 		return
 	}
 
+	if err := h.priceSelectedItems(ctx, &selectedItems); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
 	c.JSON(http.StatusOK, selectedItems)
 }
 
-func RemoveFromSelectedItems(c *gin.Context) {
+func (h *Handler) RemoveFromSelectedItems(c *gin.Context) {
 	claims := c.MustGet("user").(*Middleware.UserClaims)
 	userID := claims.ID
 
-	var selectedItem Models.SelectedItem
-	if err := c.ShouldBindJSON(&selectedItem); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	var selectedItem productRef
+	if !bindJSON(c, &selectedItem) {
 		return
 	}
 
-	collection := getSelectedItemsCollection()
+	collection := h.getSelectedItemsCollection()
 	var selectedItems Models.SelectedItems
 	if err := collection.FindOne(context.Background(), bson.M{"user_id": userID}).Decode(&selectedItems); err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Selected items not found"})
+		Middleware.Fail(c, lookupError(err, "Chưa chọn sản phẩm nào"))
 		return
 	}
 
@@ -144,84 +206,127 @@ func RemoveFromSelectedItems(c *gin.Context) {
 
 	if len(selectedItems.Items) == 0 {
 		if _, err := collection.DeleteOne(context.Background(), bson.M{"user_id": userID}); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete selected items"})
+			Middleware.Fail(c, Middleware.Internal(err))
 			return
 		}
 	} else {
 		selectedItems.UpdatedAt = time.Now()
 		if _, err := collection.UpdateOne(context.Background(), bson.M{"user_id": userID}, bson.M{"$set": selectedItems}); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update selected items"})
+			Middleware.Fail(c, Middleware.Internal(err))
 			return
 		}
 	}
 
+	if err := h.priceSelectedItems(context.Background(), &selectedItems); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Product removed from selected items",
 		"items":   selectedItems,
 	})
 }
 
-func ClearSelectedItems(c *gin.Context) {
+func (h *Handler) ClearSelectedItems(c *gin.Context) {
 	claims := c.MustGet("user").(*Middleware.UserClaims)
 	userID := claims.ID
 
-	collection := getSelectedItemsCollection()
+	collection := h.getSelectedItemsCollection()
 	if _, err := collection.DeleteOne(context.Background(), bson.M{"user_id": userID}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear selected items"})
+		Middleware.Fail(c, Middleware.Internal(err))
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Selected items cleared"})
 }
 
-func AddMultipleToSelectedItems(c *gin.Context) {
+// AddMultipleToSelectedItems picks several products at once, checking
+// each like AddToSelectedItems. Nothing is saved unless every line passes.
+func (h *Handler) AddMultipleToSelectedItems(c *gin.Context) {
 	claims := c.MustGet("user").(*Middleware.UserClaims)
 	userID := claims.ID
 
-	var selectedItems []Models.SelectedItem
-	if err := c.ShouldBindJSON(&selectedItems); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	var lines []cartLineRequest
+	if !bindJSON(c, &lines) {
 		return
 	}
 
-	collection := getSelectedItemsCollection()
-	var existingSelectedItems Models.SelectedItems
-	if err := collection.FindOne(context.Background(), bson.M{"user_id": userID}).Decode(&existingSelectedItems); err == mongo.ErrNoDocuments {
-		newSelectedItems := Models.SelectedItems{
-			UserID:    userID,
-			Items:     selectedItems,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}
-		if _, err := collection.InsertOne(context.Background(), newSelectedItems); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add selected items"})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := h.getSelectedItemsCollection()
+	var selectedItems Models.SelectedItems
+	err := collection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&selectedItems)
+	if err != nil && err != mongo.ErrNoDocuments {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	found := err == nil
+
+	ids := make([]primitive.ObjectID, 0, len(lines))
+	for _, line := range lines {
+		ids = append(ids, line.ProductID)
+	}
+	products, err := h.productsByID(ctx, ids)
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	for _, line := range lines {
+		product, ok := products[line.ProductID]
+		if !ok {
+			Middleware.Fail(c, Middleware.NotFound("Không tìm thấy sản phẩm").WithDetail("product_id", line.ProductID))
 			return
 		}
-	} else {
-		updatedItems := existingSelectedItems.Items
-		for _, newItem := range selectedItems {
-			exists := false
-			for i, item := range updatedItems {
-				if item.ProductID == newItem.ProductID {
-					updatedItems[i].Quantity += newItem.Quantity
-					exists = true
-					break
-				}
-			}
-			if !exists {
-				updatedItems = append(updatedItems, newItem)
+
+		existing := -1
+		for i, item := range selectedItems.Items {
+			if item.ProductID == line.ProductID {
+				existing = i
+				break
 			}
 		}
-		existingSelectedItems.Items = updatedItems
-		existingSelectedItems.UpdatedAt = time.Now()
-		if _, err := collection.UpdateOne(context.Background(), bson.M{"user_id": userID}, bson.M{"$set": existingSelectedItems}); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update selected items"})
+		quantity := line.Quantity
+		if existing >= 0 {
+			quantity += selectedItems.Items[existing].Quantity
+		}
+		if apiErr := cartQuantityError(product, quantity); apiErr != nil {
+			Middleware.Fail(c, apiErr)
 			return
 		}
+
+		if existing >= 0 {
+			selectedItems.Items[existing].Quantity = quantity
+			selectedItems.Items[existing].AddedPrice = product.Price
+		} else {
+			selectedItems.Items = append(selectedItems.Items, Models.SelectedItem{
+				ProductID:  product.ID,
+				Quantity:   quantity,
+				AddedPrice: product.Price,
+			})
+		}
+	}
+
+	selectedItems.UpdatedAt = time.Now()
+	if !found {
+		selectedItems.UserID = userID
+		selectedItems.CreatedAt = selectedItems.UpdatedAt
+		_, err = collection.InsertOne(ctx, selectedItems)
+	} else {
+		_, err = collection.UpdateOne(ctx, bson.M{"user_id": userID}, bson.M{"$set": selectedItems})
+	}
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
 	}
 
+	if err := h.priceSelectedItems(ctx, &selectedItems); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Items added successfully",
-		"items":   existingSelectedItems.Items,
+		"items":   selectedItems.Items,
 	})
 }
@@ -3,7 +3,6 @@ package Controllers
 import (
 	"context"
 	"net/http"
-	"strconv"
 
 	"Server/Middleware"
 	"Server/Models"
@@ -13,164 +12,136 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func CreateService(c *gin.Context) {
-	claims := c.MustGet("user").(*Middleware.UserClaims)
-
-	if claims.Role != Middleware.Admin && claims.Role != Middleware.Staff {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to create services"})
-		return
-	}
-
+func (h *Handler) CreateService(c *gin.Context) {
 	var service Models.Service
-	err := c.Request.ParseMultipartForm(10 << 20)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not parse multipart form"})
+	if !bindForm(c, &service) {
 		return
 	}
 
-	file, err := c.FormFile("image")
-	if err == nil {
-		fileContent, err := file.Open()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not open file"})
-			return
-		}
-		defer fileContent.Close()
-
-		// Upload to Cloudinary
-		url, err := uploadToCloudinary(fileContent, file.Filename)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not upload image to Cloudinary"})
+	if category := c.PostForm("servicecategory"); category != "" {
+		var ok bool
+		if service.ServiceCategory, ok = parseObjectID(c, "servicecategory", category); !ok {
 			return
 		}
-		service.ImageURL = url
 	}
 
-	service.Name = c.PostForm("name")
-	service.Price, _ = strconv.ParseFloat(c.PostForm("price"), 64)
-	service.Description = c.PostForm("description")
-	service.ServiceCategory, _ = primitive.ObjectIDFromHex(c.PostForm("servicecategory"))
-
-	if service.Name == "" || service.Price <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	image, err := h.uploadFormImage(c)
+	if err != nil && err != http.ErrMissingFile {
+		Middleware.Fail(c, imageError(err))
 		return
 	}
+	service.ImageURL = image.URL
+	service.ImageKey = image.Key
 
 	service.ID = primitive.NewObjectID()
 
-	collection := getCollection("services")
+	collection := h.getCollection("services")
 	_, err = collection.InsertOne(context.Background(), service)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		h.deleteStoredImage(image.Key)
+		Middleware.Fail(c, Middleware.Internal(err))
 		return
 	}
 
 	c.JSON(http.StatusOK, service)
 }
 
-func GetAllServices(c *gin.Context) {
-	var services []Models.Service
-	collection := getCollection("services")
-	cursor, err := collection.Find(context.Background(), bson.M{})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	defer cursor.Close(context.Background())
+var serviceListSpec = listSpec{
+	Filters: []listFilter{
+		objectIDFilter("servicecategory", "servicecategory"),
+		moneyRangeFilter("min_price", "max_price", "price"),
+	},
+	Sorts: map[string]string{
+		"price":  "price.amount",
+		"name":   "name",
+		"newest": "-_id",
+	},
+	DefaultSort: bson.D{{Key: "_id", Value: -1}},
+	TextSearch:  true,
+}
 
-	for cursor.Next(context.Background()) {
-		var service Models.Service
-		cursor.Decode(&service)
-		services = append(services, service)
+func (h *Handler) GetAllServices(c *gin.Context) {
+	services := []Models.Service{}
+	page, ok := listPage(c, h.getServiceCollection(), serviceListSpec, nil, nil, &services)
+	if !ok {
+		return
 	}
 
-	c.JSON(http.StatusOK, services)
+	c.JSON(http.StatusOK, page)
 }
 
-func GetServiceByID(c *gin.Context) {
-	id, err := primitive.ObjectIDFromHex(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+func (h *Handler) GetServiceByID(c *gin.Context) {
+	id, ok := paramObjectID(c, "id")
+	if !ok {
 		return
 	}
 
 	var service Models.Service
-	collection := getCollection("services")
-	err = collection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&service)
+	collection := h.getCollection("services")
+	err := collection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&service)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy dịch vụ"))
 		return
 	}
 
 	c.JSON(http.StatusOK, service)
 }
 
-func UpdateService(c *gin.Context) {
-	claims := c.MustGet("user").(*Middleware.UserClaims)
-
-	if claims.Role != Middleware.Admin && claims.Role != Middleware.Staff {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to update services"})
+// UpdateService changes the fields present in the multipart form and keeps
+// the others.
+func (h *Handler) UpdateService(c *gin.Context) {
+	id, ok := paramObjectID(c, "id")
+	if !ok {
 		return
 	}
 
-	id, err := primitive.ObjectIDFromHex(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+	var form struct {
+		Name            *string       `form:"name"`
+		Price           *Models.Money `form:"price"`
+		Description     *string       `form:"description"`
+		ServiceCategory string        `form:"servicecategory"`
+	}
+	if !bindForm(c, &form) {
 		return
 	}
 
 	var existingService Models.Service
-	collection := getCollection("services")
+	collection := h.getCollection("services")
 
-	err = collection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&existingService)
+	err := collection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&existingService)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy dịch vụ"))
 		return
 	}
 
-	err = c.Request.ParseMultipartForm(10 << 20)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not parse multipart form"})
-		return
+	if form.Name != nil {
+		existingService.Name = *form.Name
 	}
-
-	file, err := c.FormFile("image")
-	if err == nil {
-		fileContent, err := file.Open()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not open file"})
-			return
-		}
-		defer fileContent.Close()
-
-		// Upload to Cloudinary
-		url, err := uploadToCloudinary(fileContent, file.Filename)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not upload image to Cloudinary"})
-			return
-		}
-		existingService.ImageURL = url
-	}
-
-	if name := c.PostForm("name"); name != "" {
-		existingService.Name = name
+	if form.Price != nil {
+		existingService.Price = *form.Price
 	}
-	if price, err := strconv.ParseFloat(c.PostForm("price"), 64); err == nil && price > 0 {
-		existingService.Price = price
+	if form.Description != nil {
+		existingService.Description = *form.Description
 	}
-	if description := c.PostForm("description"); description != "" {
-		existingService.Description = description
-	}
-	if category := c.PostForm("servicecategory"); category != "" {
-		if serviceCategory, err := primitive.ObjectIDFromHex(category); err == nil {
-			existingService.ServiceCategory = serviceCategory
+	if form.ServiceCategory != "" {
+		if existingService.ServiceCategory, ok = parseObjectID(c, "servicecategory", form.ServiceCategory); !ok {
+			return
 		}
 	}
+	if !validateStruct(c, &existingService) {
+		return
+	}
 
-	if existingService.Name == "" || existingService.Price <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	previousImageKey := existingService.ImageKey
+	image, err := h.uploadFormImage(c)
+	if err != nil && err != http.ErrMissingFile {
+		Middleware.Fail(c, imageError(err))
 		return
 	}
+	if err == nil {
+		existingService.ImageURL = image.URL
+		existingService.ImageKey = image.Key
+	}
 
 	update := bson.M{
 		"$set": bson.M{
@@ -179,48 +150,43 @@ func UpdateService(c *gin.Context) {
 			"description":     existingService.Description,
 			"servicecategory": existingService.ServiceCategory,
 			"imageurl":        existingService.ImageURL,
+			"imagekey":        existingService.ImageKey,
 		},
 	}
 
 	result, err := collection.UpdateOne(context.Background(), bson.M{"_id": id}, update)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		Middleware.Fail(c, Middleware.Internal(err))
 		return
 	}
 
 	if result.MatchedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		h.deleteStoredImage(image.Key)
+		Middleware.Fail(c, Middleware.NotFound("Không tìm thấy dịch vụ"))
 		return
 	}
 
+	if existingService.ImageKey != previousImageKey {
+		h.deleteStoredImage(previousImageKey)
+	}
+
 	c.JSON(http.StatusOK, existingService)
 }
 
-func DeleteService(c *gin.Context) {
-	claims := c.MustGet("user").(*Middleware.UserClaims)
-
-	if claims.Role != Middleware.Admin {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to delete services"})
-		return
-	}
-
-	id, err := primitive.ObjectIDFromHex(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+func (h *Handler) DeleteService(c *gin.Context) {
+	id, ok := paramObjectID(c, "id")
+	if !ok {
 		return
 	}
 
-	collection := getCollection("services")
-	result, err := collection.DeleteOne(context.Background(), bson.M{"_id": id})
+	collection := h.getCollection("services")
+	var service Models.Service
+	err := collection.FindOneAndDelete(context.Background(), bson.M{"_id": id}).Decode(&service)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	if result.DeletedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy dịch vụ"))
 		return
 	}
+	h.deleteStoredImage(service.ImageKey)
 
 	c.Status(http.StatusNoContent)
 }
@@ -1,9 +1,10 @@
 package Controllers
 
 import (
+	"context"
+
 	"Server/Middleware"
 	"Server/Models"
-	"context"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
@@ -11,91 +12,73 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func getServiceCategoryCollection() *mongo.Collection {
-	return Database.Collection("service_categories")
+func (h *Handler) getServiceCategoryCollection() *mongo.Collection {
+	return h.DB.Collection("service_categories")
 }
 
-func CreateServiceCategory(c *gin.Context) {
-	claims := c.MustGet("user").(*Middleware.UserClaims)
-	if claims.Role != Middleware.Admin && claims.Role != Middleware.Staff {
-		c.JSON(403, gin.H{"error": "Permission denied"})
-		return
-	}
-
+func (h *Handler) CreateServiceCategory(c *gin.Context) {
 	var serviceCategory Models.ServiceCategory
-	if err := c.ShouldBindJSON(&serviceCategory); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid input"})
+	if !bindJSON(c, &serviceCategory) {
 		return
 	}
 
 	serviceCategory.ID = primitive.NewObjectID()
-	collection := getServiceCategoryCollection()
+	collection := h.getServiceCategoryCollection()
 	if _, err := collection.InsertOne(context.Background(), serviceCategory); err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+		Middleware.Fail(c, Middleware.Internal(err))
 		return
 	}
 
 	c.JSON(200, serviceCategory)
 }
 
-func GetAllServiceCategories(c *gin.Context) {
-	var serviceCategories []Models.ServiceCategory
-	collection := getServiceCategoryCollection()
-	cursor, err := collection.Find(context.Background(), bson.M{})
-	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
-		return
-	}
-	defer cursor.Close(context.Background())
+var serviceCategoryListSpec = listSpec{
+	Sorts: map[string]string{
+		"name":   "name",
+		"newest": "-_id",
+	},
+	DefaultSort: bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}},
+	TextSearch:  true,
+}
 
-	for cursor.Next(context.Background()) {
-		var serviceCategory Models.ServiceCategory
-		cursor.Decode(&serviceCategory)
-		serviceCategories = append(serviceCategories, serviceCategory)
+func (h *Handler) GetAllServiceCategories(c *gin.Context) {
+	serviceCategories := []Models.ServiceCategory{}
+	page, ok := listPage(c, h.getServiceCategoryCollection(), serviceCategoryListSpec, nil, nil, &serviceCategories)
+	if !ok {
+		return
 	}
 
-	c.JSON(200, serviceCategories)
+	c.JSON(200, page)
 }
 
-func GetServiceCategoryByID(c *gin.Context) {
-	id := c.Param("id")
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		c.JSON(400, gin.H{"error": "Invalid ID format"})
+func (h *Handler) GetServiceCategoryByID(c *gin.Context) {
+	objectID, ok := paramObjectID(c, "id")
+	if !ok {
 		return
 	}
 
 	var serviceCategory Models.ServiceCategory
-	collection := getServiceCategoryCollection()
+	collection := h.getServiceCategoryCollection()
 	if err := collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&serviceCategory); err != nil {
-		c.JSON(404, gin.H{"error": "Service category not found"})
+		Middleware.Fail(c, lookupError(err, "Không tìm thấy danh mục dịch vụ"))
 		return
 	}
 
 	c.JSON(200, serviceCategory)
 }
 
-func UpdateServiceCategory(c *gin.Context) {
-	claims := c.MustGet("user").(*Middleware.UserClaims)
-	if claims.Role != Middleware.Admin && claims.Role != Middleware.Staff {
-		c.JSON(403, gin.H{"error": "Permission denied"})
-		return
-	}
-
-	id := c.Param("id")
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		c.JSON(400, gin.H{"error": "Invalid ID format"})
+func (h *Handler) UpdateServiceCategory(c *gin.Context) {
+	objectID, ok := paramObjectID(c, "id")
+	if !ok {
 		return
 	}
 
 	var serviceCategory Models.ServiceCategory
-	if err := c.ShouldBindJSON(&serviceCategory); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid input"})
+	if !bindJSON(c, &serviceCategory) {
 		return
 	}
 
-	collection := getServiceCategoryCollection()
+	collection := h.getServiceCategoryCollection()
 	update := bson.M{
 		"$set": bson.M{
 			"name":        serviceCategory.Name,
@@ -104,41 +87,33 @@ func UpdateServiceCategory(c *gin.Context) {
 	}
 	result, err := collection.UpdateOne(context.Background(), bson.M{"_id": objectID}, update)
 	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+		Middleware.Fail(c, Middleware.Internal(err))
 		return
 	}
 
 	if result.MatchedCount == 0 {
-		c.JSON(404, gin.H{"error": "Service category not found"})
+		Middleware.Fail(c, Middleware.NotFound("Không tìm thấy danh mục dịch vụ"))
 		return
 	}
 
 	c.JSON(200, serviceCategory)
 }
 
-func DeleteServiceCategory(c *gin.Context) {
-	claims := c.MustGet("user").(*Middleware.UserClaims)
-	if claims.Role != Middleware.Admin && claims.Role != Middleware.Staff {
-		c.JSON(403, gin.H{"error": "Permission denied"})
-		return
-	}
-
-	id := c.Param("id")
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		c.JSON(400, gin.H{"error": "Invalid ID format"})
+func (h *Handler) DeleteServiceCategory(c *gin.Context) {
+	objectID, ok := paramObjectID(c, "id")
+	if !ok {
 		return
 	}
 
-	collection := getServiceCategoryCollection()
+	collection := h.getServiceCategoryCollection()
 	result, err := collection.DeleteOne(context.Background(), bson.M{"_id": objectID})
 	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+		Middleware.Fail(c, Middleware.Internal(err))
 		return
 	}
 
 	if result.DeletedCount == 0 {
-		c.JSON(404, gin.H{"error": "Service category not found"})
+		Middleware.Fail(c, Middleware.NotFound("Không tìm thấy danh mục dịch vụ"))
 		return
 	}
 
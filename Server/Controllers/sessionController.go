@@ -0,0 +1,146 @@
+package Controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"Server/Middleware"
+	"Server/Models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (h *Handler) getSessionCollection() *mongo.Collection {
+	return h.DB.Collection("sessions")
+}
+
+// revokeSessions revokes every active session matching filter together with
+// its refresh tokens. Access tokens of those sessions are rejected by the
+// auth middleware from then on.
+func (h *Handler) revokeSessions(ctx context.Context, filter bson.M) error {
+	filter["revoked_at"] = nil
+
+	cursor, err := h.getSessionCollection().Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	var sessions []Models.Session
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	ids := make([]primitive.ObjectID, len(sessions))
+	for i, session := range sessions {
+		ids[i] = session.ID
+	}
+
+	now := time.Now()
+	if _, err := h.getSessionCollection().UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": ids}},
+		bson.M{"$set": bson.M{"revoked_at": now}},
+	); err != nil {
+		return err
+	}
+	_, err = h.getRefreshTokenCollection().UpdateMany(ctx,
+		bson.M{"family_id": bson.M{"$in": ids}, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": now}},
+	)
+	return err
+}
+
+// revokeUserSessions signs userID out everywhere except, when it is not
+// zero, the session keep.
+func (h *Handler) revokeUserSessions(ctx context.Context, userID, keep primitive.ObjectID) error {
+	filter := bson.M{"user_id": userID}
+	if !keep.IsZero() {
+		filter["_id"] = bson.M{"$ne": keep}
+	}
+	return h.revokeSessions(ctx, filter)
+}
+
+func (h *Handler) GetMySessions(c *gin.Context) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := h.getSessionCollection().Find(ctx,
+		bson.M{"user_id": claims.ID, "revoked_at": nil, "expires_at": bson.M{"$gt": time.Now()}},
+		options.Find().SetSort(bson.D{{Key: "last_used_at", Value: -1}}),
+	)
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	sessions := []Models.Session{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == claims.SessionID()
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+func (h *Handler) RevokeMySession(c *gin.Context) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+
+	sessionID, ok := paramObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.revokeSessions(ctx, bson.M{"_id": sessionID, "user_id": claims.ID}); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// RevokeOtherSessions signs the caller out of every device but this one.
+func (h *Handler) RevokeOtherSessions(c *gin.Context) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.revokeUserSessions(ctx, claims.ID, claims.SessionID()); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Other sessions revoked"})
+}
+
+// ForceLogoutUser lets an admin sign a user out of every device.
+func (h *Handler) ForceLogoutUser(c *gin.Context) {
+	userID, ok := paramObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.revokeUserSessions(ctx, userID, primitive.NilObjectID); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User logged out of all sessions"})
+}
@@ -0,0 +1,330 @@
+package Controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"Server/Middleware"
+	"Server/Models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// getStaffLockCollection holds one document per staff member that
+// assignStaff writes to serialize assignments of that person.
+func (h *Handler) getStaffLockCollection() *mongo.Collection {
+	return h.DB.Collection("staff_assignment_locks")
+}
+
+type assignmentConflict struct {
+	StaffID   primitive.ObjectID `json:"staff_id"`
+	BookingID primitive.ObjectID `json:"booking_id"`
+	Start     time.Time          `json:"start"`
+	End       time.Time          `json:"end"`
+}
+
+// openBookingFilter matches bookings that are neither completed nor cancelled,
+// including ones stored with legacy status labels.
+func openBookingFilter() bson.M {
+	closed := append(Models.BookingCompleted.StoredValues(), Models.BookingCancelled.StoredValues()...)
+	return bson.M{"$nin": closed}
+}
+
+// bookingDuration looks up how long a booking of serviceID lasts, caching
+// results in cache for the lifetime of a single request. Bookings of a
+// service that was deleted last as long as the default schedule's slots.
+func (h *Handler) bookingDuration(ctx context.Context, cache map[primitive.ObjectID]time.Duration, serviceID primitive.ObjectID) (time.Duration, error) {
+	if duration, ok := cache[serviceID]; ok {
+		return duration, nil
+	}
+
+	var service Models.Service
+	err := h.getServiceCollection().FindOne(ctx, bson.M{"_id": serviceID}).Decode(&service)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return 0, err
+	}
+	duration := time.Duration(service.EffectiveSchedule().SlotMinutes) * time.Minute
+	cache[serviceID] = duration
+	return duration, nil
+}
+
+func (h *Handler) AssignBookingStaff(c *gin.Context) {
+	bookingID, ok := paramObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	var body struct {
+		StaffIDs []primitive.ObjectID `json:"staff_ids" binding:"max=20"`
+	}
+	if !bindJSON(c, &body) {
+		return
+	}
+
+	staffIDs := make([]primitive.ObjectID, 0, len(body.StaffIDs))
+	seen := map[primitive.ObjectID]bool{}
+	for _, id := range body.StaffIDs {
+		if !seen[id] {
+			seen[id] = true
+			staffIDs = append(staffIDs, id)
+		}
+	}
+
+	if len(staffIDs) > 0 {
+		staffRoles, err := h.rolesWith(context.Background(), Models.PermBookingsWork)
+		if err != nil {
+			Middleware.Fail(c, Middleware.Internal(err))
+			return
+		}
+		count, err := h.DB.Collection("users").CountDocuments(context.Background(), bson.M{
+			"_id":  bson.M{"$in": staffIDs},
+			"role": bson.M{"$in": staffRoles},
+		})
+		if err != nil {
+			Middleware.Fail(c, Middleware.Internal(err))
+			return
+		}
+		if int(count) != len(staffIDs) {
+			Middleware.Fail(c, Middleware.ValidationFailed(Middleware.FieldError{
+				Field:   "staff_ids",
+				Code:    "staff",
+				Message: "Người được giao phải là nhân viên",
+			}))
+			return
+		}
+	}
+
+	session, err := h.DB.Client().StartSession()
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	defer session.EndSession(context.Background())
+
+	result, err := session.WithTransaction(context.Background(), func(sc mongo.SessionContext) (interface{}, error) {
+		return h.assignStaff(sc, bookingID, staffIDs)
+	})
+
+	var conflictErr *assignmentConflictError
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		Middleware.Fail(c, Middleware.NotFound("Không tìm thấy lịch hẹn"))
+		return
+	case errors.Is(err, errBookingClosed):
+		Middleware.Fail(c, Middleware.Conflict("Không thể giao việc cho đơn đã đóng"))
+		return
+	case errors.As(err, &conflictErr):
+		Middleware.Fail(c, Middleware.Conflict("Nhân viên đã có lịch vào thời gian này").WithDetail("conflicts", conflictErr.Conflicts))
+		return
+	case err != nil:
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	booking := result.(Models.OrderBookingService)
+	booking.StatusLabel = booking.Status.Label()
+	c.JSON(http.StatusOK, booking)
+}
+
+var errBookingClosed = errors.New("booking is closed")
+
+type assignmentConflictError struct {
+	Conflicts []assignmentConflict
+}
+
+func (e *assignmentConflictError) Error() string {
+	return "staff already booked at that time"
+}
+
+// assignStaff checks that staffIDs are free for the booking and assigns
+// them. It must run inside a transaction: it first bumps a lock document
+// for every staff member, so two assignments involving the same person
+// write-conflict and the later one is retried against the earlier one's
+// result instead of both passing the overlap check. Bookings of different
+// lengths overlap across slots, so the lock is per person rather than per
+// slot. The booking update is conditional on it still being open, and a
+// concurrent cancellation writes the same document, so staff are never
+// assigned to a booking that was just closed.
+func (h *Handler) assignStaff(sc mongo.SessionContext, bookingID primitive.ObjectID, staffIDs []primitive.ObjectID) (Models.OrderBookingService, error) {
+	for _, staffID := range staffIDs {
+		if _, err := h.getStaffLockCollection().UpdateOne(sc,
+			bson.M{"_id": staffID},
+			bson.M{"$inc": bson.M{"assignments": 1}},
+			options.Update().SetUpsert(true),
+		); err != nil {
+			return Models.OrderBookingService{}, err
+		}
+	}
+
+	collection := h.getOrderBookingServiceCollection()
+	var booking Models.OrderBookingService
+	if err := collection.FindOne(sc, bson.M{"_id": bookingID}).Decode(&booking); err != nil {
+		return booking, err
+	}
+	if !booking.Status.IsOpen() {
+		return booking, errBookingClosed
+	}
+
+	if len(staffIDs) > 0 {
+		conflicts, err := h.findAssignmentConflicts(sc, booking, staffIDs)
+		if err != nil {
+			return booking, err
+		}
+		if len(conflicts) > 0 {
+			return booking, &assignmentConflictError{Conflicts: conflicts}
+		}
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	result, err := collection.UpdateOne(sc,
+		bson.M{"_id": bookingID, "status": openBookingFilter()},
+		bson.M{"$set": bson.M{"assigned_to": staffIDs, "updated_at": now}},
+	)
+	if err != nil {
+		return booking, err
+	}
+	if result.MatchedCount == 0 {
+		return booking, errBookingClosed
+	}
+
+	booking.AssignedTo = staffIDs
+	booking.UpdatedAt = now
+	return booking, nil
+}
+
+// findAssignmentConflicts returns the open bookings of any of staffIDs whose
+// time window overlaps the one of booking.
+func (h *Handler) findAssignmentConflicts(ctx context.Context, booking Models.OrderBookingService, staffIDs []primitive.ObjectID) ([]assignmentConflict, error) {
+	durations := map[primitive.ObjectID]time.Duration{}
+	start := booking.BookingDate.Time()
+	duration, err := h.bookingDuration(ctx, durations, booking.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+	end := start.Add(duration)
+
+	// No booking lasts longer than a working day, so anything starting more
+	// than a day before this one cannot overlap it.
+	cursor, err := h.getOrderBookingServiceCollection().Find(ctx, bson.M{
+		"_id":         bson.M{"$ne": booking.ID},
+		"assigned_to": bson.M{"$in": staffIDs},
+		"status":      openBookingFilter(),
+		"booking_date": bson.M{
+			"$gt": primitive.NewDateTimeFromTime(start.Add(-24 * time.Hour)),
+			"$lt": primitive.NewDateTimeFromTime(end),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var others []Models.OrderBookingService
+	if err := cursor.All(ctx, &others); err != nil {
+		return nil, err
+	}
+
+	var conflicts []assignmentConflict
+	for _, other := range others {
+		otherStart := other.BookingDate.Time()
+		otherDuration, err := h.bookingDuration(ctx, durations, other.ServiceID)
+		if err != nil {
+			return nil, err
+		}
+		otherEnd := otherStart.Add(otherDuration)
+		if !otherStart.Before(end) || !start.Before(otherEnd) {
+			continue
+		}
+		for _, staffID := range other.AssignedTo {
+			for _, wanted := range staffIDs {
+				if staffID == wanted {
+					conflicts = append(conflicts, assignmentConflict{
+						StaffID:   staffID,
+						BookingID: other.ID,
+						Start:     otherStart,
+						End:       otherEnd,
+					})
+				}
+			}
+		}
+	}
+	return conflicts, nil
+}
+
+func (h *Handler) GetMyAssignments(c *gin.Context) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+
+	filter := bson.M{
+		"assigned_to": claims.ID,
+		"status":      openBookingFilter(),
+	}
+	if c.Query("all") != "true" {
+		filter["booking_date"] = bson.M{"$gte": primitive.NewDateTimeFromTime(time.Now().Add(-24 * time.Hour))}
+	}
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "booking_date", Value: 1}})
+	cursor, err := h.getOrderBookingServiceCollection().Find(context.Background(), filter, findOptions)
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var bookings []Models.OrderBookingService
+	if err := cursor.All(context.Background(), &bookings); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	for i := range bookings {
+		bookings[i].StatusLabel = bookings[i].Status.Label()
+	}
+
+	c.JSON(http.StatusOK, bookings)
+}
+
+// UpdateMyAssignmentStatus lets a technician start or finish a job they are
+// assigned to without needing admin rights.
+func (h *Handler) UpdateMyAssignmentStatus(c *gin.Context) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+
+	bookingID, ok := paramObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	var statusUpdate struct {
+		Status string `json:"status" binding:"required"`
+		Reason string `json:"reason" binding:"max=500"`
+	}
+	if !bindJSON(c, &statusUpdate) {
+		return
+	}
+
+	next, ok := Models.ParseBookingStatus(statusUpdate.Status)
+	if !ok || (next != Models.BookingInProgress && next != Models.BookingCompleted) {
+		Middleware.Fail(c, Middleware.ValidationFailed(Middleware.FieldError{
+			Field:   "status",
+			Code:    "oneof",
+			Message: "Phải là một trong: " + string(Models.BookingInProgress) + ", " + string(Models.BookingCompleted),
+		}))
+		return
+	}
+
+	updated, err := h.transitionBooking(bson.M{"_id": bookingID, "assigned_to": claims.ID}, next, claims.ID, statusUpdate.Reason)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		Middleware.Fail(c, Middleware.NotFound("Không tìm thấy công việc được giao"))
+		return
+	}
+	if err != nil {
+		respondBookingTransitionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
@@ -0,0 +1,86 @@
+package Controllers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"Server/Models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestUpdateMyAssignmentStatusOnlyMatchesOwnJobs(t *testing.T) {
+	staff := &caller{ID: primitive.NewObjectID(), Role: Models.Staff}
+	bookingID := primitive.NewObjectID()
+
+	mt := newMockDB(t)
+	mt.Run("not assigned", func(mt *mtest.T) {
+		h := newTestHandler(mt)
+		mt.AddMockResponses(found(mt, "order_booking_service"))
+
+		w := serveAt("/assignments/:id/status", "/assignments/"+bookingID.Hex()+"/status", h.UpdateMyAssignmentStatus, staff,
+			bson.M{"status": Models.BookingInProgress})
+		if w.Code != http.StatusNotFound {
+			mt.Fatalf("status %d, want %d: %s", w.Code, http.StatusNotFound, w.Body)
+		}
+
+		event := mt.GetStartedEvent()
+		if event == nil || event.CommandName != "find" {
+			mt.Fatalf("first command %v, want a find", event)
+		}
+		if assignee, ok := event.Command.Lookup("filter", "assigned_to").ObjectIDOK(); !ok || assignee != staff.ID {
+			mt.Errorf("booking looked up with %s, want it limited to jobs of %s", event.Command.Lookup("filter"), staff.ID.Hex())
+		}
+	})
+}
+
+func TestUpdateMyAssignmentStatusUnassignedMeanwhile(t *testing.T) {
+	staff := &caller{ID: primitive.NewObjectID(), Role: Models.Staff}
+	booking := Models.OrderBookingService{ID: primitive.NewObjectID(), Status: Models.BookingConfirmed, AssignedTo: []primitive.ObjectID{staff.ID}}
+
+	mt := newMockDB(t)
+	mt.Run("unassigned meanwhile", func(mt *mtest.T) {
+		h := newTestHandler(mt)
+		mt.AddMockResponses(found(mt, "order_booking_service", booking), updated(0), mtest.CreateSuccessResponse())
+
+		w := serveAt("/assignments/:id/status", "/assignments/"+booking.ID.Hex()+"/status", h.UpdateMyAssignmentStatus, staff,
+			bson.M{"status": Models.BookingInProgress})
+		if w.Code != http.StatusConflict {
+			mt.Fatalf("status %d, want %d: %s", w.Code, http.StatusConflict, w.Body)
+		}
+
+		for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+			if event.CommandName != "update" {
+				continue
+			}
+			filter := event.Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("q")
+			if assignee, ok := filter.Document().Lookup("assigned_to").ObjectIDOK(); !ok || assignee != staff.ID {
+				mt.Errorf("booking updated with %s, want it limited to jobs of %s", filter, staff.ID.Hex())
+			}
+			return
+		}
+		mt.Error("booking was not updated")
+	})
+}
+
+func TestFindAssignmentConflictsFailsWhenServiceLookupFails(t *testing.T) {
+	booking := Models.OrderBookingService{
+		ID:          primitive.NewObjectID(),
+		ServiceID:   primitive.NewObjectID(),
+		BookingDate: primitive.NewDateTimeFromTime(time.Now().Add(24 * time.Hour)),
+	}
+
+	mt := newMockDB(t)
+	mt.Run("service lookup fails", func(mt *mtest.T) {
+		h := newTestHandler(mt)
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 1, Message: "lookup failed"}))
+
+		if _, err := h.findAssignmentConflicts(context.Background(), booking, []primitive.ObjectID{primitive.NewObjectID()}); err == nil {
+			mt.Error("conflicts were checked without the booking's duration")
+		}
+	})
+}
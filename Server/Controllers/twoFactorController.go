@@ -0,0 +1,552 @@
+package Controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"Server/Middleware"
+	"Server/Models"
+	"Server/TOTP"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	recoveryCodeCount = 10
+	// challengeMaxAttempts caps the codes tried against one challenge.
+	// Wrong codes also count as failed logins of the account, so a stolen
+	// password does not allow guessing the code with fresh challenges.
+	challengeMaxAttempts = 5
+)
+
+var errInvalidTwoFactorCode = errors.New("invalid two-factor code")
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func (h *Handler) roleRequiresTwoFactor(ctx context.Context, role Models.Role) (bool, error) {
+	var definition Models.RoleDefinition
+	if err := h.getRoleCollection().FindOne(ctx, bson.M{"_id": role}).Decode(&definition); err != nil {
+		return false, err
+	}
+	return definition.RequireTwoFactor, nil
+}
+
+// challengeTwoFactor answers a login whose password was accepted with a
+// challenge token when the user has two-factor authentication or their role
+// requires it. It returns false when the login can complete right away.
+func (h *Handler) challengeTwoFactor(ctx context.Context, c *gin.Context, user Models.User) (bool, error) {
+	enabled := user.TwoFactor != nil && user.TwoFactor.Enabled
+	if !enabled {
+		required, err := h.roleRequiresTwoFactor(ctx, user.Role)
+		if err != nil || !required {
+			return false, err
+		}
+	}
+
+	challenge, err := h.Auth.GenerateChallenge(user.ID, !enabled)
+	if err != nil {
+		return false, err
+	}
+
+	response := gin.H{
+		"challenge_token": challenge,
+		"expires_in":      int64(Middleware.ChallengeTTL.Seconds()),
+	}
+	if enabled {
+		response["two_factor_required"] = true
+	} else {
+		response["two_factor_setup_required"] = true
+	}
+	c.JSON(http.StatusOK, response)
+	return true, nil
+}
+
+// readChallenge checks a challenge token from the second login step and
+// loads its user. On failure it fails the request.
+func (h *Handler) readChallenge(ctx context.Context, c *gin.Context, token string, setup bool) (*Middleware.ChallengeClaims, Models.User, bool) {
+	var user Models.User
+
+	claims, err := h.Auth.ParseChallenge(token)
+	if err != nil || claims.Setup != setup {
+		Middleware.Fail(c, errLoginExpired())
+		return nil, user, false
+	}
+
+	used, err := h.Limits.BlockedUntil(ctx, "2fa:used:"+claims.Id)
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return nil, user, false
+	}
+	if !used.IsZero() {
+		Middleware.Fail(c, errLoginExpired())
+		return nil, user, false
+	}
+
+	attempts, resetAt, err := h.Limits.Hit(ctx, "2fa:attempts:"+claims.Id, Middleware.ChallengeTTL)
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return nil, user, false
+	}
+	if attempts > challengeMaxAttempts {
+		Middleware.AbortTooManyRequests(c, resetAt)
+		return nil, user, false
+	}
+
+	if err := h.DB.Collection("users").FindOne(ctx, bson.M{"_id": claims.UserID}).Decode(&user); err != nil || user.SuspendedAt != nil {
+		Middleware.Fail(c, errLoginExpired())
+		return nil, user, false
+	}
+
+	// Wrong codes count as failed logins, so the lockout of the account
+	// also stops guessing with challenges fetched beforehand.
+	blockedUntil, err := h.loginBlockedUntil(ctx, user.Email, c.ClientIP())
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return nil, user, false
+	}
+	if !blockedUntil.IsZero() {
+		Middleware.AbortTooManyRequests(c, blockedUntil)
+		return nil, user, false
+	}
+	return claims, user, true
+}
+
+// failTwoFactorCode records a wrong code as a failed login of user and
+// fails the request.
+func (h *Handler) failTwoFactorCode(ctx context.Context, c *gin.Context, user Models.User) {
+	if err := h.recordLoginFailure(ctx, user.Email, c.ClientIP()); err != nil {
+		log.Printf("recording failed login: %v", err)
+	}
+	Middleware.Fail(c, errWrongTwoFactorCode())
+}
+
+// finishChallenge makes a challenge token unusable once it completed a
+// login.
+func (h *Handler) finishChallenge(ctx context.Context, claims *Middleware.ChallengeClaims) error {
+	return h.Limits.Block(ctx, "2fa:used:"+claims.Id, time.Unix(claims.ExpiresAt, 0))
+}
+
+// useTOTPCode checks code against secret and records its time step, so the
+// same code cannot be used twice.
+func (h *Handler) useTOTPCode(ctx context.Context, userID primitive.ObjectID, secret, code string) (bool, error) {
+	step, ok := TOTP.Validate(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	result, err := h.DB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": userID, "$or": []bson.M{
+			{"two_factor.last_used_step": bson.M{"$lt": step}},
+			{"two_factor.last_used_step": bson.M{"$exists": false}},
+		}},
+		bson.M{"$set": bson.M{"two_factor.last_used_step": step}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+}
+
+// useRecoveryCode removes code from the user's recovery codes if it is one
+// of them. Each recovery code works once.
+func (h *Handler) useRecoveryCode(ctx context.Context, userID primitive.ObjectID, code string) (bool, error) {
+	hash := hashToken(normalizeRecoveryCode(code))
+	result, err := h.DB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": userID, "two_factor.enabled": true, "two_factor.recovery_codes": hash},
+		bson.M{"$pull": bson.M{"two_factor.recovery_codes": hash}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// generateRecoveryCodes returns new recovery codes to show the user once
+// and the hashes to store.
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := recoveryCodeEncoding.EncodeToString(raw)
+		codes[i] = strings.ToLower(code[:4] + "-" + code[4:])
+		hashes[i] = hashToken(code)
+	}
+	return codes, hashes, nil
+}
+
+// beginTwoFactorSetup stores a new pending secret for user and returns what
+// the authenticator app needs to add it.
+func (h *Handler) beginTwoFactorSetup(ctx context.Context, user Models.User) (gin.H, error) {
+	secret, err := TOTP.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := h.DB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"two_factor.pending_secret": secret, "two_factor.enabled": user.TwoFactor != nil && user.TwoFactor.Enabled}},
+	); err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"secret":      secret,
+		"otpauth_uri": TOTP.ProvisioningURI(secret, h.Config.TwoFactor.Issuer, user.Email),
+	}, nil
+}
+
+// enableTwoFactor confirms the pending secret with a code from the app,
+// switches two-factor authentication on and returns fresh recovery codes.
+func (h *Handler) enableTwoFactor(ctx context.Context, user Models.User, code string) ([]string, error) {
+	if user.TwoFactor == nil || user.TwoFactor.PendingSecret == "" {
+		return nil, errInvalidTwoFactorCode
+	}
+	secret := user.TwoFactor.PendingSecret
+
+	ok, err := h.useTOTPCode(ctx, user.ID, secret, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errInvalidTwoFactorCode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if _, err := h.DB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": user.ID, "two_factor.pending_secret": secret},
+		bson.M{
+			"$set": bson.M{
+				"two_factor.enabled":        true,
+				"two_factor.secret":         secret,
+				"two_factor.recovery_codes": hashes,
+				"two_factor.enabled_at":     now,
+			},
+			"$unset": bson.M{"two_factor.pending_secret": ""},
+		},
+	); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifyLoginTwoFactor completes a login with a code from the
+// authenticator app or one of the recovery codes.
+func (h *Handler) VerifyLoginTwoFactor(c *gin.Context) {
+	var reqBody struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+		Code           string `json:"code" binding:"required_without=RecoveryCode"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+	if !bindJSON(c, &reqBody) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	claims, user, ok := h.readChallenge(ctx, c, reqBody.ChallengeToken, false)
+	if !ok {
+		return
+	}
+	if user.TwoFactor == nil || !user.TwoFactor.Enabled {
+		Middleware.Fail(c, errLoginExpired())
+		return
+	}
+
+	var err error
+	if reqBody.RecoveryCode != "" {
+		ok, err = h.useRecoveryCode(ctx, user.ID, reqBody.RecoveryCode)
+	} else {
+		ok, err = h.useTOTPCode(ctx, user.ID, user.TwoFactor.Secret, reqBody.Code)
+	}
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	if !ok {
+		h.failTwoFactorCode(ctx, c, user)
+		return
+	}
+
+	if err := h.finishChallenge(ctx, claims); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	h.completeLogin(c, user, nil)
+}
+
+// SetupLoginTwoFactor starts enrollment for a user whose role requires
+// two-factor authentication, during their login.
+func (h *Handler) SetupLoginTwoFactor(c *gin.Context) {
+	var reqBody struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+	}
+	if !bindJSON(c, &reqBody) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, user, ok := h.readChallenge(ctx, c, reqBody.ChallengeToken, true)
+	if !ok {
+		return
+	}
+
+	setup, err := h.beginTwoFactorSetup(ctx, user)
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, setup)
+}
+
+// EnableLoginTwoFactor finishes enrollment started by SetupLoginTwoFactor
+// and completes the login. The recovery codes are only shown here.
+func (h *Handler) EnableLoginTwoFactor(c *gin.Context) {
+	var reqBody struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+		Code           string `json:"code" binding:"required"`
+	}
+	if !bindJSON(c, &reqBody) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	claims, user, ok := h.readChallenge(ctx, c, reqBody.ChallengeToken, true)
+	if !ok {
+		return
+	}
+
+	codes, err := h.enableTwoFactor(ctx, user, reqBody.Code)
+	if errors.Is(err, errInvalidTwoFactorCode) {
+		h.failTwoFactorCode(ctx, c, user)
+		return
+	}
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	if err := h.finishChallenge(ctx, claims); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	h.completeLogin(c, user, gin.H{"recovery_codes": codes})
+}
+
+func (h *Handler) findTwoFactorUser(ctx context.Context, c *gin.Context) (Models.User, bool) {
+	claims := c.MustGet("user").(*Middleware.UserClaims)
+
+	var user Models.User
+	if err := h.DB.Collection("users").FindOne(ctx, bson.M{"_id": claims.ID}).Decode(&user); err != nil {
+		Middleware.Fail(c, Middleware.NotFound("Không tìm thấy người dùng"))
+		return user, false
+	}
+	return user, true
+}
+
+func (h *Handler) GetMyTwoFactor(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := h.findTwoFactorUser(ctx, c)
+	if !ok {
+		return
+	}
+
+	required, err := h.roleRequiresTwoFactor(ctx, user.Role)
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	status := gin.H{"enabled": false, "required": required, "recovery_codes_left": 0}
+	if user.TwoFactor != nil && user.TwoFactor.Enabled {
+		status["enabled"] = true
+		status["enabled_at"] = user.TwoFactor.EnabledAt
+		status["recovery_codes_left"] = len(user.TwoFactor.RecoveryCodes)
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func (h *Handler) SetupMyTwoFactor(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := h.findTwoFactorUser(ctx, c)
+	if !ok {
+		return
+	}
+	if user.TwoFactor != nil && user.TwoFactor.Enabled {
+		Middleware.Fail(c, Middleware.Conflict("Xác thực hai bước đã được bật"))
+		return
+	}
+
+	setup, err := h.beginTwoFactorSetup(ctx, user)
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, setup)
+}
+
+func (h *Handler) EnableMyTwoFactor(c *gin.Context) {
+	var reqBody struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if !bindJSON(c, &reqBody) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := h.findTwoFactorUser(ctx, c)
+	if !ok {
+		return
+	}
+
+	codes, err := h.enableTwoFactor(ctx, user, reqBody.Code)
+	if errors.Is(err, errInvalidTwoFactorCode) {
+		Middleware.Fail(c, errWrongTwoFactorCode())
+		return
+	}
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication enabled", "recovery_codes": codes})
+}
+
+// DisableMyTwoFactor turns two-factor authentication off after checking
+// both the password and a current code. Users whose role requires it
+// cannot turn it off.
+func (h *Handler) DisableMyTwoFactor(c *gin.Context) {
+	var reqBody struct {
+		Password string `json:"password" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+	if !bindJSON(c, &reqBody) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := h.findTwoFactorUser(ctx, c)
+	if !ok {
+		return
+	}
+	if user.TwoFactor == nil || !user.TwoFactor.Enabled {
+		Middleware.Fail(c, Middleware.Conflict("Xác thực hai bước chưa được bật"))
+		return
+	}
+
+	required, err := h.roleRequiresTwoFactor(ctx, user.Role)
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	if required {
+		Middleware.Fail(c, Middleware.Forbidden(Middleware.CodeForbidden, "Vai trò của bạn bắt buộc dùng xác thực hai bước"))
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(reqBody.Password)); err != nil {
+		Middleware.Fail(c, Middleware.Unauthorized(Middleware.CodeInvalidCredentials, "Mật khẩu hiện tại không đúng"))
+		return
+	}
+	ok, err = h.useTOTPCode(ctx, user.ID, user.TwoFactor.Secret, reqBody.Code)
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	if !ok {
+		Middleware.Fail(c, errWrongTwoFactorCode())
+		return
+	}
+
+	if _, err := h.DB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$unset": bson.M{"two_factor": ""}}); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes, for users who used
+// or lost them.
+func (h *Handler) RegenerateRecoveryCodes(c *gin.Context) {
+	var reqBody struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if !bindJSON(c, &reqBody) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := h.findTwoFactorUser(ctx, c)
+	if !ok {
+		return
+	}
+	if user.TwoFactor == nil || !user.TwoFactor.Enabled {
+		Middleware.Fail(c, Middleware.Conflict("Xác thực hai bước chưa được bật"))
+		return
+	}
+
+	ok, err := h.useTOTPCode(ctx, user.ID, user.TwoFactor.Secret, reqBody.Code)
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	if !ok {
+		Middleware.Fail(c, errWrongTwoFactorCode())
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	if _, err := h.DB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"two_factor.recovery_codes": hashes}},
+	); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
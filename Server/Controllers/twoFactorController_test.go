@@ -0,0 +1,107 @@
+package Controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"Server/Models"
+	"Server/TOTP"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// wrongCode returns a well formed code that secret does not accept now.
+func wrongCode(t *testing.T, secret string) string {
+	now := time.Now()
+	for step := TOTP.Step(now) + 10; ; step++ {
+		code, err := TOTP.Code(secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := TOTP.Validate(secret, code, now); !ok {
+			return code
+		}
+	}
+}
+
+func TestPasswordLoginKeepsTwoFactorFailures(t *testing.T) {
+	mt := newMockDB(t)
+	mt.Run("login", func(mt *mtest.T) {
+		h := newTestHandler(mt)
+		ctx := context.Background()
+
+		secret, err := TOTP.GenerateSecret()
+		if err != nil {
+			mt.Fatal(err)
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		user := Models.User{
+			ID:        primitive.NewObjectID(),
+			Email:     "admin@example.com",
+			Password:  string(hash),
+			Role:      Models.Admin,
+			TwoFactor: &Models.TwoFactor{Enabled: true, Secret: secret},
+		}
+		code := wrongCode(mt.T, secret)
+
+		guess := func(challenge string) int {
+			mt.AddMockResponses(found(mt, "users", user))
+			return serve(h.VerifyLoginTwoFactor, nil, map[string]string{
+				"challenge_token": challenge,
+				"code":            code,
+			}).Code
+		}
+		login := func() string {
+			mt.AddMockResponses(found(mt, "users", user))
+			w := serve(h.LoginUser, nil, map[string]string{"email": user.Email, "password": "password"})
+			if w.Code != http.StatusOK {
+				mt.Fatalf("login: status %d: %s", w.Code, w.Body)
+			}
+			var body struct {
+				ChallengeToken string `json:"challenge_token"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.ChallengeToken == "" {
+				mt.Fatalf("login: no challenge in %s", w.Body)
+			}
+			return body.ChallengeToken
+		}
+
+		challenge := login()
+		for i := 1; i < loginFreeAttempts; i++ {
+			if status := guess(challenge); status != http.StatusUnauthorized {
+				mt.Fatalf("guess %d: status %d, want %d", i, status, http.StatusUnauthorized)
+			}
+		}
+
+		// Logging in with the password again must not forgive the wrong
+		// codes, so the next one locks the account.
+		challenge = login()
+		if status := guess(challenge); status != http.StatusUnauthorized {
+			mt.Fatalf("last guess: status %d, want %d", status, http.StatusUnauthorized)
+		}
+		until, err := h.Limits.BlockedUntil(ctx, loginAccountKey(user.Email))
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if until.IsZero() {
+			mt.Fatalf("account not locked after %d wrong codes", loginFreeAttempts)
+		}
+
+		// A challenge fetched before the lockout cannot keep guessing.
+		challenge, err = h.Auth.GenerateChallenge(user.ID, false)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if status := guess(challenge); status != http.StatusTooManyRequests {
+			mt.Errorf("guess while locked: status %d, want %d", status, http.StatusTooManyRequests)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package Controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"Server/Middleware"
+	"Server/Storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uploadFormImage stores the "image" file of the request's multipart form.
+// It returns http.ErrMissingFile when the form has no image, and
+// Storage.ErrNotImage or Storage.ErrImageTooLarge when the file is not one
+// that may be uploaded.
+func (h *Handler) uploadFormImage(c *gin.Context) (Storage.StoredImage, error) {
+	file, err := c.FormFile("image")
+	if err != nil {
+		return Storage.StoredImage{}, err
+	}
+	if file.Size > Storage.MaxImageSize {
+		return Storage.StoredImage{}, Storage.ErrImageTooLarge
+	}
+
+	fileContent, err := file.Open()
+	if err != nil {
+		return Storage.StoredImage{}, err
+	}
+	defer fileContent.Close()
+
+	content, extension, err := Storage.DetectImage(fileContent)
+	if err != nil {
+		return Storage.StoredImage{}, err
+	}
+	return h.Images.Upload(c, content, "image"+extension)
+}
+
+// imageError turns a failed uploadFormImage into the response for it.
+func imageError(err error) *Middleware.APIError {
+	switch err {
+	case http.ErrMissingFile:
+		return errMissingImage()
+	case Storage.ErrNotImage:
+		return Middleware.ValidationFailed(Middleware.FieldError{
+			Field:   "image",
+			Code:    "image",
+			Message: "Chỉ chấp nhận ảnh JPEG, PNG, GIF hoặc WebP",
+		})
+	case Storage.ErrImageTooLarge:
+		return Middleware.ValidationFailed(Middleware.FieldError{
+			Field:   "image",
+			Code:    "max",
+			Message: fmt.Sprintf("Ảnh không được lớn hơn %d MB", Storage.MaxImageSize>>20),
+		})
+	}
+	return Middleware.Internal(err)
+}
+
+// deleteStoredImage removes an image that is no longer referenced. Failures
+// only leave an orphaned file behind, so they are logged rather than
+// returned.
+func (h *Handler) deleteStoredImage(key string) {
+	if key == "" {
+		return
+	}
+	if err := h.Images.Delete(context.Background(), key); err != nil && err != Storage.ErrNotFound {
+		log.Printf("failed to delete image %s: %v", key, err)
+	}
+}
+
+func (h *Handler) UploadImage(c *gin.Context) {
+	image, err := h.uploadFormImage(c)
+	if err != nil {
+		Middleware.Fail(c, imageError(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"url": image.URL, "key": image.Key})
+}
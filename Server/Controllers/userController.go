@@ -2,178 +2,270 @@ package Controllers
 
 import (
 	"context"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
+	"Server/Config"
+	"Server/Mail"
 	"Server/Middleware"
 	"Server/Models"
+	"Server/OIDC"
+	"Server/Payment"
+	"Server/Pricing"
+	"Server/Storage"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var Database *mongo.Database
+// Handler serves the API. Its dependencies are set up once in main and
+// shared by every request.
+type Handler struct {
+	DB     *mongo.Database
+	Config *Config.Config
+	Auth   *Middleware.Auth
+	Images Storage.ImageStorage
+	Mailer Mail.Mailer
+	Limits Middleware.RateLimitStore
+	// Providers are the OpenID Connect providers for social login, by
+	// name.
+	Providers map[string]*OIDC.Provider
+	// Payments is the online payment gateway, or nil when online payment
+	// is turned off.
+	Payments Payment.Gateway
+	// Pricing prices orders and their quotes.
+	Pricing *Pricing.Pipeline
+}
+
+func NewHandler(db *mongo.Database, cfg *Config.Config, auth *Middleware.Auth, images Storage.ImageStorage, mailer Mail.Mailer, limits Middleware.RateLimitStore, providers map[string]*OIDC.Provider, payments Payment.Gateway, pricing *Pricing.Pipeline) *Handler {
+	return &Handler{DB: db, Config: cfg, Auth: auth, Images: images, Mailer: mailer, Limits: limits, Providers: providers, Payments: payments, Pricing: pricing}
+}
 
-func RegisterUser(c *gin.Context) {
+// normalizeEmail is the form emails are stored and looked up in, so that
+// the same address in another case finds the same account.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func (h *Handler) RegisterUser(c *gin.Context) {
 	var user Models.User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+	if !bindJSON(c, &user) {
 		return
 	}
+	user.Email = normalizeEmail(user.Email)
 
-	if len(user.Password) < 8 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Mật khẩu phải có độ dài ít nhất 8 ký tự"})
+	hash, err := bcrypt.GenerateFromPassword([]byte(user.Password), 10)
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
 		return
 	}
-
-	hash, _ := bcrypt.GenerateFromPassword([]byte(user.Password), 10)
 	user.Password = string(hash)
 
-	collection := Database.Collection("users")
+	collection := h.DB.Collection("users")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	count, _ := collection.CountDocuments(ctx, bson.M{"email": user.Email})
+	count, err := collection.CountDocuments(ctx, bson.M{"email": user.Email})
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
 	if count > 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Email đã tồn tại"})
+		Middleware.Fail(c, Middleware.Conflict("Email đã tồn tại"))
 		return
 	}
 
-	count, _ = collection.CountDocuments(ctx, bson.M{"phone": user.Phone})
+	count, err = collection.CountDocuments(ctx, bson.M{"phone": user.Phone})
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
 	if count > 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Số điện thoại đã tồn tại"})
+		Middleware.Fail(c, Middleware.Conflict("Số điện thoại đã tồn tại"))
 		return
 	}
 
 	user.Role = Models.Customer
+	user.EmailVerified = false
+
+	result, err := collection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		// Another registration with the same email won the race.
+		Middleware.Fail(c, Middleware.Conflict("Email đã tồn tại"))
+		return
+	}
+	if err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
 
-	result, _ := collection.InsertOne(ctx, user)
+	user.ID = result.InsertedID.(primitive.ObjectID)
+	if err := h.sendVerificationEmail(ctx, user); err != nil {
+		log.Printf("verification email for %s: %v", user.ID.Hex(), err)
+	}
 
-	c.JSON(http.StatusOK, gin.H{"success": result.InsertedID != nil})
+	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
-func LoginUser(c *gin.Context) {
-	var user Models.User
+type loginRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+func (h *Handler) LoginUser(c *gin.Context) {
+	var user loginRequest
 	var dbUser Models.User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+	if !bindJSON(c, &user) {
 		return
 	}
+	user.Email = normalizeEmail(user.Email)
 
-	collection := Database.Collection("users")
+	collection := h.DB.Collection("users")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	err := collection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&dbUser)
+	blockedUntil, err := h.loginBlockedUntil(ctx, user.Email, c.ClientIP())
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email"})
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	if !blockedUntil.IsZero() {
+		Middleware.AbortTooManyRequests(c, blockedUntil)
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(dbUser.Password), []byte(user.Password))
+	// Unknown emails and wrong passwords get the same answer, so the
+	// response does not reveal which emails are registered.
+	err = collection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&dbUser)
+	if err == nil {
+		err = bcrypt.CompareHashAndPassword([]byte(dbUser.Password), []byte(user.Password))
+	} else if err == mongo.ErrNoDocuments {
+		compareDummyPassword(user.Password)
+	}
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+		if err := h.recordLoginFailure(ctx, user.Email, c.ClientIP()); err != nil {
+			log.Printf("recording failed login: %v", err)
+		}
+		Middleware.Fail(c, Middleware.Unauthorized(Middleware.CodeInvalidCredentials, loginFailedMessage))
 		return
 	}
 
-	middlewareRole := Middleware.Role(dbUser.Role)
+	if dbUser.SuspendedAt != nil {
+		Middleware.Fail(c, errAccountSuspended())
+		return
+	}
 
-	token, err := Middleware.GenerateJWT(dbUser.ID, middlewareRole)
+	challenged, err := h.challengeTwoFactor(ctx, c, dbUser)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	if challenged {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"token":     token,
-		"firstname": dbUser.FirstName,
-		"lastname":  dbUser.LastName,
-		"role":      dbUser.Role,
-	})
+	h.completeLogin(c, dbUser, nil)
 }
 
-func GetAllUsers(c *gin.Context) {
-	var users []Models.User
-	collection := Database.Collection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// completeLogin starts a session for user and answers with its tokens and
+// the profile fields the client shows, plus any extra fields. Failed logins
+// of the account are only forgotten here, once every factor has passed, so
+// a known password cannot be used to reset the count of wrong codes.
+func (h *Handler) completeLogin(c *gin.Context, user Models.User, extra gin.H) {
+	if err := h.clearLoginFailures(c.Request.Context(), user.Email); err != nil {
+		log.Printf("clearing failed logins: %v", err)
+	}
 
-	cursor, err := collection.Find(ctx, bson.M{})
+	tokens, err := h.startSession(c, user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching users"})
+		Middleware.Fail(c, Middleware.Internal(err))
 		return
 	}
-	defer cursor.Close(ctx)
 
-	for cursor.Next(ctx) {
-		var user Models.User
-		if err := cursor.Decode(&user); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		users = append(users, user)
+	response := gin.H{
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+		"firstname":     user.FirstName,
+		"lastname":      user.LastName,
+		"role":          user.Role,
+	}
+	for key, value := range extra {
+		response[key] = value
 	}
+	c.JSON(http.StatusOK, response)
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"count": len(users),
-		"users": users,
-	})
+// GetAllUsers lists users for admins. It supports q (name, email or phone),
+// role and suspended filters on top of the shared paging and sorting.
+func (h *Handler) GetAllUsers(c *gin.Context) {
+	users := []Models.User{}
+	page, ok := listPage(c, h.DB.Collection("users"), userListSpec, nil, userListStages, &users)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
 }
 
-func GetUserByID(c *gin.Context) {
+func (h *Handler) GetUserByID(c *gin.Context) {
 	id := c.Param("id")
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		Middleware.Fail(c, Middleware.BadRequest("Mã người dùng không hợp lệ"))
 		return
 	}
 
 	var user Models.User
-	collection := Database.Collection("users")
+	collection := h.DB.Collection("users")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	err = collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&user)
+	err = collection.FindOne(ctx, bson.M{"_id": objectID}, options.FindOne().SetProjection(profileProjection)).Decode(&user)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		Middleware.Fail(c, Middleware.NotFound("Không tìm thấy người dùng"))
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"success": true, "user": user})
 }
 
-func UpdateUser(c *gin.Context) {
-	claims := c.MustGet("user").(*Middleware.UserClaims)
-	userID := claims.ID
-
+// UpdateUser lets an admin correct a user's profile. It accepts the same
+// fields as UpdateMe; roles and suspension have their own audited endpoints.
+func (h *Handler) UpdateUser(c *gin.Context) {
 	id := c.Param("id")
-	requestedID, err := primitive.ObjectIDFromHex(id)
+	userID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		Middleware.Fail(c, Middleware.BadRequest("Mã người dùng không hợp lệ"))
 		return
 	}
 
-	if userID != requestedID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to update this user"})
+	fields, ok := bindProfileUpdate(c)
+	if !ok {
 		return
 	}
 
-	var user Models.User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
-		return
-	}
-
-	collection := Database.Collection("users")
+	collection := h.DB.Collection("users")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	result, err := collection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": user})
+	if !h.phoneAvailable(ctx, c, fields, userID) {
+		return
+	}
+
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": fields})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+	if result.MatchedCount == 0 {
+		Middleware.Fail(c, Middleware.NotFound("Không tìm thấy người dùng"))
 		return
 	}
 
@@ -184,21 +276,26 @@ func UpdateUser(c *gin.Context) {
 	})
 }
 
-func DeleteUser(c *gin.Context) {
+func (h *Handler) DeleteUser(c *gin.Context) {
 	id := c.Param("id")
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		Middleware.Fail(c, Middleware.BadRequest("Mã người dùng không hợp lệ"))
 		return
 	}
 
-	collection := Database.Collection("users")
+	collection := h.DB.Collection("users")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	_, err = collection.DeleteOne(ctx, bson.M{"_id": objectID})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		Middleware.Fail(c, Middleware.Internal(err))
+		return
+	}
+
+	if err := h.revokeUserSessions(ctx, objectID, primitive.NilObjectID); err != nil {
+		Middleware.Fail(c, Middleware.Internal(err))
 		return
 	}
 
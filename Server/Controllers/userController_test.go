@@ -0,0 +1,92 @@
+package Controllers
+
+import (
+	"net/http"
+	"testing"
+
+	"Server/Models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// lookedUpEmail returns the email the next find command filtered on.
+func lookedUpEmail(mt *mtest.T) string {
+	for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+		if event.CommandName != "find" {
+			continue
+		}
+		email, _ := event.Command.Lookup("filter", "email").StringValueOK()
+		return email
+	}
+	mt.Fatal("no find command")
+	return ""
+}
+
+func TestLoginUserNormalizesEmail(t *testing.T) {
+	mt := newMockDB(t)
+	mt.Run("mixed case", func(mt *mtest.T) {
+		h := newTestHandler(mt)
+		mt.AddMockResponses(found(mt, "users"))
+
+		w := serve(h.LoginUser, nil, bson.M{"email": "  User@Example.COM ", "password": "secret"})
+		if w.Code != http.StatusUnauthorized {
+			mt.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+		if got := lookedUpEmail(mt); got != "user@example.com" {
+			mt.Errorf("looked up %q, want %q", got, "user@example.com")
+		}
+	})
+}
+
+func TestUpdateUserPhone(t *testing.T) {
+	admin := &caller{ID: primitive.NewObjectID(), Role: Models.Admin, Permissions: []Models.Permission{Models.PermUsersManage}}
+	target := primitive.NewObjectID()
+
+	tests := []struct {
+		name    string
+		replies func(mt *mtest.T) []bson.D
+		status  int
+	}{
+		{
+			name: "phone of another user",
+			replies: func(mt *mtest.T) []bson.D {
+				return []bson.D{mtest.CreateCursorResponse(0, mt.DB.Name()+".users", mtest.FirstBatch, bson.D{{Key: "n", Value: 1}})}
+			},
+			status: http.StatusConflict,
+		},
+		{
+			name: "phone nobody else has",
+			replies: func(mt *mtest.T) []bson.D {
+				return []bson.D{found(mt, "users"), updated(1)}
+			},
+			status: http.StatusOK,
+		},
+	}
+
+	mt := newMockDB(t)
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			h := newTestHandler(mt)
+			mt.AddMockResponses(tt.replies(mt)...)
+
+			w := serveAt("/users/:id", "/users/"+target.Hex(), h.UpdateUser, admin, bson.M{"phone": "0901234567"})
+			if w.Code != tt.status {
+				mt.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+				if event.CommandName != "aggregate" {
+					continue
+				}
+				match := event.Command.Lookup("pipeline").Array().Index(0).Value().Document().Lookup("$match")
+				if id, ok := match.Document().Lookup("_id", "$ne").ObjectIDOK(); !ok || id != target {
+					mt.Errorf("phone checked against %s, want every user but %s", match, target.Hex())
+				}
+				return
+			}
+			mt.Error("phone was not checked")
+		})
+	}
+}
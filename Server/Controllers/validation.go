@@ -0,0 +1,213 @@
+package Controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"Server/Middleware"
+	"Server/Models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func init() {
+	// Report fields by their JSON name, which is what clients send.
+	if engine, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		engine.RegisterTagNameFunc(func(field reflect.StructField) string {
+			for _, tag := range []string{"json", "form"} {
+				name, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+				if name == "-" {
+					return ""
+				}
+				if name != "" {
+					return name
+				}
+			}
+			return field.Name
+		})
+		engine.RegisterValidation("permission", func(fl validator.FieldLevel) bool {
+			return Models.Permission(fl.Field().String()).Valid()
+		})
+		// Rules such as gt=0 on a Money field apply to its amount.
+		engine.RegisterCustomTypeFunc(func(field reflect.Value) interface{} {
+			return field.Interface().(Models.Money).Amount
+		}, Models.Money{})
+	}
+}
+
+// bindJSON decodes the request body into obj and checks its binding tags.
+// On failure it fails the request with per-field errors and returns false.
+func bindJSON(c *gin.Context, obj interface{}) bool {
+	if err := c.ShouldBindJSON(obj); err != nil {
+		Middleware.Fail(c, bindError(err))
+		return false
+	}
+	return true
+}
+
+// bindOptionalJSON is bindJSON for endpoints whose body may be left out.
+func bindOptionalJSON(c *gin.Context, obj interface{}) bool {
+	if c.Request.ContentLength == 0 {
+		return true
+	}
+	return bindJSON(c, obj)
+}
+
+// bindForm is bindJSON for multipart and urlencoded forms.
+func bindForm(c *gin.Context, obj interface{}) bool {
+	if err := c.ShouldBind(obj); err != nil {
+		Middleware.Fail(c, bindError(err))
+		return false
+	}
+	return true
+}
+
+func bindError(err error) *Middleware.APIError {
+	if fields := validationFields(err); len(fields) > 0 {
+		return Middleware.ValidationFailed(fields...)
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return Middleware.ValidationFailed(Middleware.FieldError{
+			Field:   typeErr.Field,
+			Code:    "type",
+			Message: "Sai kiểu dữ liệu",
+		})
+	}
+
+	if errors.Is(err, io.EOF) {
+		return Middleware.BadRequest("Thiếu dữ liệu gửi lên")
+	}
+	return Middleware.BadRequest("Dữ liệu gửi lên không hợp lệ").WithCause(err)
+}
+
+// validationFields lists the fields that failed their binding tags, for a
+// struct or, when a JSON array was bound, for each of its elements.
+func validationFields(err error) []Middleware.FieldError {
+	var sliceErrors binding.SliceValidationError
+	if errors.As(err, &sliceErrors) {
+		var fields []Middleware.FieldError
+		for _, elemErr := range sliceErrors {
+			fields = append(fields, validationFields(elemErr)...)
+		}
+		return fields
+	}
+
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return nil
+	}
+	fields := make([]Middleware.FieldError, len(validationErrors))
+	for i, fieldErr := range validationErrors {
+		fields[i] = Middleware.FieldError{
+			Field:   fieldPath(fieldErr),
+			Code:    fieldErr.Tag(),
+			Message: fieldMessage(fieldErr),
+		}
+	}
+	return fields
+}
+
+// fieldPath drops the top-level struct name from the validator's
+// namespace, so "Product.price" becomes "price" and nested fields keep
+// their path, such as "items[0].quantity".
+func fieldPath(fieldErr validator.FieldError) string {
+	if _, path, ok := strings.Cut(fieldErr.Namespace(), "."); ok {
+		return path
+	}
+	return fieldErr.Field()
+}
+
+func fieldMessage(fieldErr validator.FieldError) string {
+	param := fieldErr.Param()
+	isString := fieldErr.Kind() == reflect.String
+	isList := fieldErr.Kind() == reflect.Slice || fieldErr.Kind() == reflect.Map
+
+	switch fieldErr.Tag() {
+	case "required":
+		return "Không được để trống"
+	case "email":
+		return "Email không hợp lệ"
+	case "numeric":
+		return "Chỉ được chứa chữ số"
+	case "alphanum":
+		return "Chỉ được chứa chữ cái và chữ số"
+	case "oneof":
+		return "Phải là một trong: " + strings.Join(strings.Fields(param), ", ")
+	case "len":
+		if isString {
+			return fmt.Sprintf("Phải có đúng %s ký tự", param)
+		}
+		return fmt.Sprintf("Phải có đúng %s phần tử", param)
+	case "min":
+		switch {
+		case isString:
+			return fmt.Sprintf("Phải có ít nhất %s ký tự", param)
+		case isList:
+			return fmt.Sprintf("Phải có ít nhất %s phần tử", param)
+		}
+		return "Phải lớn hơn hoặc bằng " + param
+	case "max":
+		switch {
+		case isString:
+			return fmt.Sprintf("Không được dài quá %s ký tự", param)
+		case isList:
+			return fmt.Sprintf("Không được quá %s phần tử", param)
+		}
+		return "Phải nhỏ hơn hoặc bằng " + param
+	case "gt":
+		return "Phải lớn hơn " + param
+	case "gte":
+		return "Phải lớn hơn hoặc bằng " + param
+	case "lt":
+		return "Phải nhỏ hơn " + param
+	case "lte":
+		return "Phải nhỏ hơn hoặc bằng " + param
+	case "url":
+		return "Đường dẫn không hợp lệ"
+	case "permission":
+		return "Quyền không tồn tại"
+	case "datetime":
+		return "Phải có dạng " + strings.NewReplacer("2006", "YYYY", "01", "MM", "02", "DD", "15", "HH", "04", "MM").Replace(param)
+	}
+	return "Giá trị không hợp lệ"
+}
+
+// validateStruct checks the binding tags of a model that was not bound
+// from a single request, such as one merged from a partial update.
+func validateStruct(c *gin.Context, obj interface{}) bool {
+	if err := binding.Validator.ValidateStruct(obj); err != nil {
+		Middleware.Fail(c, bindError(err))
+		return false
+	}
+	return true
+}
+
+// paramObjectID reads the path parameter name as an ObjectID. On failure
+// it fails the request and returns false.
+func paramObjectID(c *gin.Context, name string) (primitive.ObjectID, bool) {
+	return parseObjectID(c, name, c.Param(name))
+}
+
+// parseObjectID reads value, sent as field, as an ObjectID. On failure it
+// fails the request and returns false.
+func parseObjectID(c *gin.Context, field, value string) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(value)
+	if err != nil {
+		Middleware.Fail(c, Middleware.ValidationFailed(Middleware.FieldError{
+			Field:   field,
+			Code:    "object_id",
+			Message: "Mã không hợp lệ",
+		}))
+		return id, false
+	}
+	return id, true
+}
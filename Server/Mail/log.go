@@ -0,0 +1,37 @@
+package Mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LogMailer writes every message to the server log and, when dir is set, to
+// a file in dir instead of sending it. It is meant for local development,
+// where links can be copied out of the log or the file.
+type LogMailer struct {
+	dir string
+}
+
+func NewLogMailer(dir string) (*LogMailer, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return &LogMailer{dir: dir}, nil
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	if m.dir == "" {
+		return nil
+	}
+
+	name := fmt.Sprintf("%d-%s.txt", time.Now().UnixNano(), filepath.Base(msg.To))
+	content := fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n", msg.To, msg.Subject, msg.Body)
+	return os.WriteFile(filepath.Join(m.dir, name), []byte(content), 0o644)
+}
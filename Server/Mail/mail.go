@@ -0,0 +1,32 @@
+package Mail
+
+import (
+	"context"
+	"errors"
+
+	"Server/Config"
+)
+
+// Mailer delivers transactional email such as verification and password
+// reset links.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// New builds the mailer selected by cfg.Driver.
+func New(cfg Config.MailConfig) (Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return NewSMTPMailer(cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.Username, cfg.SMTP.Password, cfg.From), nil
+	case "log":
+		return NewLogMailer(cfg.Log.Dir)
+	default:
+		return nil, errors.New("unknown mail driver " + cfg.Driver)
+	}
+}
@@ -0,0 +1,62 @@
+package Middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ChallengeTTL is how long a user has to enter their two-factor code after
+// their password was accepted.
+const ChallengeTTL = 5 * time.Minute
+
+const challengeAudience = "two-factor-challenge"
+
+var ErrInvalidChallenge = errors.New("invalid or expired challenge")
+
+// ChallengeClaims identify a user who passed the password step of a login
+// but still has to complete two-factor authentication. Setup marks users
+// who must enroll first because their role requires it.
+type ChallengeClaims struct {
+	UserID primitive.ObjectID `json:"uid"`
+	Setup  bool               `json:"setup,omitempty"`
+	jwt.StandardClaims
+}
+
+func (a *Auth) GenerateChallenge(userID primitive.ObjectID, setup bool) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := &ChallengeClaims{
+		UserID: userID,
+		Setup:  setup,
+		StandardClaims: jwt.StandardClaims{
+			Id:        hex.EncodeToString(id),
+			Audience:  challengeAudience,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ChallengeTTL).Unix(),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(a.secret)
+}
+
+func (a *Auth) ParseChallenge(tokenString string) (*ChallengeClaims, error) {
+	claims := &ChallengeClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return a.secret, nil
+	})
+	if err != nil || !token.Valid || !claims.VerifyAudience(challengeAudience, true) {
+		return nil, ErrInvalidChallenge
+	}
+	return claims, nil
+}
@@ -0,0 +1,178 @@
+package Middleware
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorCode is a stable, machine-readable reason for a failed request.
+// Clients should branch on the code; the message is for people and may
+// change.
+type ErrorCode string
+
+const (
+	CodeInvalidRequest  ErrorCode = "invalid_request"
+	CodeValidation      ErrorCode = "validation_failed"
+	CodeUnauthorized    ErrorCode = "unauthorized"
+	CodeForbidden       ErrorCode = "forbidden"
+	CodeNotFound        ErrorCode = "not_found"
+	CodeConflict        ErrorCode = "conflict"
+	CodeTooManyRequests ErrorCode = "too_many_requests"
+	CodeInternal        ErrorCode = "internal_error"
+	CodeUnavailable     ErrorCode = "service_unavailable"
+
+	CodeInvalidToken       ErrorCode = "invalid_token"
+	CodeSessionRevoked     ErrorCode = "session_revoked"
+	CodeAccountSuspended   ErrorCode = "account_suspended"
+	CodeMissingPermission  ErrorCode = "missing_permission"
+	CodeInvalidCredentials ErrorCode = "invalid_credentials"
+	CodeEmailNotVerified   ErrorCode = "email_not_verified"
+	CodeInvalidTwoFactor   ErrorCode = "invalid_two_factor_code"
+	CodeChallengeExpired   ErrorCode = "challenge_expired"
+)
+
+// FieldError describes one invalid field of a request. Code is the rule
+// that failed, such as "required" or "min".
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// APIError is the error handlers report instead of writing a response.
+// ErrorHandler renders it as
+//
+//	{"error": message, "code": code, "fields": [...], "request_id": id}
+//
+// plus any Details.
+type APIError struct {
+	Status  int
+	Code    ErrorCode
+	Message string
+	Fields  []FieldError
+	// Details are extra response fields, such as the values that would
+	// have been accepted.
+	Details gin.H
+	// Err is the underlying cause. It is logged but never sent to clients.
+	Err error
+}
+
+func NewError(status int, code ErrorCode, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+func (e *APIError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// WithDetail adds an extra field to the response.
+func (e *APIError) WithDetail(key string, value interface{}) *APIError {
+	if e.Details == nil {
+		e.Details = gin.H{}
+	}
+	e.Details[key] = value
+	return e
+}
+
+// WithCause records err as the reason for e, for the log.
+func (e *APIError) WithCause(err error) *APIError {
+	e.Err = err
+	return e
+}
+
+func BadRequest(message string) *APIError {
+	return NewError(http.StatusBadRequest, CodeInvalidRequest, message)
+}
+
+func Unauthorized(code ErrorCode, message string) *APIError {
+	return NewError(http.StatusUnauthorized, code, message)
+}
+
+func Forbidden(code ErrorCode, message string) *APIError {
+	return NewError(http.StatusForbidden, code, message)
+}
+
+func NotFound(message string) *APIError {
+	return NewError(http.StatusNotFound, CodeNotFound, message)
+}
+
+func Conflict(message string) *APIError {
+	return NewError(http.StatusConflict, CodeConflict, message)
+}
+
+// Internal wraps an unexpected failure, such as a database error. Clients
+// only get a generic message and the request ID to report.
+func Internal(err error) *APIError {
+	return NewError(http.StatusInternalServerError, CodeInternal, "Đã xảy ra lỗi, vui lòng thử lại sau").WithCause(err)
+}
+
+// ValidationFailed reports invalid fields of a request.
+func ValidationFailed(fields ...FieldError) *APIError {
+	e := NewError(http.StatusBadRequest, CodeValidation, "Dữ liệu không hợp lệ")
+	e.Fields = fields
+	return e
+}
+
+// Fail records err for ErrorHandler to render and stops the handler
+// chain. Handlers return right after calling it.
+func Fail(c *gin.Context, err error) {
+	c.Error(err)
+	c.Abort()
+}
+
+// ErrorHandler renders the last error recorded with Fail once the
+// handlers have run. Errors that are not an APIError become a 500 whose
+// cause is only logged.
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		renderError(c, c.Errors.Last().Err)
+	}
+}
+
+// RecoverPanic renders a panic in a handler as an internal error, for use
+// with gin.CustomRecovery.
+func RecoverPanic(c *gin.Context, recovered interface{}) {
+	renderError(c, Internal(fmt.Errorf("panic: %v", recovered)))
+	c.Abort()
+}
+
+func renderError(c *gin.Context, err error) {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		apiErr = Internal(err)
+	}
+
+	requestID := GetRequestID(c)
+	if apiErr.Status >= http.StatusInternalServerError {
+		log.Printf("%s %s [%s]: %v", c.Request.Method, c.FullPath(), requestID, apiErr)
+	}
+
+	body := gin.H{
+		"error":      apiErr.Message,
+		"code":       apiErr.Code,
+		"request_id": requestID,
+	}
+	if len(apiErr.Fields) > 0 {
+		body["fields"] = apiErr.Fields
+	}
+	for key, value := range apiErr.Details {
+		body[key] = value
+	}
+	c.JSON(apiErr.Status, body)
+}
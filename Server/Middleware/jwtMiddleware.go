@@ -1,79 +1,136 @@
 package Middleware
 
 import (
-	"net/http"
-	"os"
+	"context"
 	"strings"
+	"sync"
 	"time"
 
+	"Server/Config"
+	"Server/Models"
+
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
-var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
-
+// Role is the user's role ID carried in the access token. What a role may
+// do is looked up from its definition, see Require.
 type Role int
 
-const (
-	Admin Role = iota
-	Staff
-	Customer
-)
-
 type UserClaims struct {
 	ID   primitive.ObjectID `json:"id"`
 	Role Role               `json:"role"`
 	jwt.StandardClaims
 }
 
-func AuthMiddleware(requiredRole Role) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header missing"})
-			c.Abort()
-			return
-		}
+// Auth verifies and issues the JWTs used to authenticate API requests.
+// Every token belongs to a session, and a token is only accepted while its
+// session has not been revoked and its user is not suspended.
+type Auth struct {
+	secret   []byte
+	ttl      time.Duration
+	sessions *mongo.Collection
+	users    *mongo.Collection
+	roles    *mongo.Collection
+
+	grantsMu     sync.RWMutex
+	grants       map[Role]map[Models.Permission]bool
+	grantsLoaded time.Time
+}
 
-		tokenString := strings.TrimSpace(strings.Replace(authHeader, "Bearer", "", 1))
+func NewAuth(cfg Config.JWTConfig, db *mongo.Database) *Auth {
+	return &Auth{
+		secret:   []byte(cfg.Secret),
+		ttl:      cfg.AccessTTL.Duration(),
+		sessions: db.Collection("sessions"),
+		users:    db.Collection("users"),
+		roles:    db.Collection("roles"),
+	}
+}
 
-		claims := &UserClaims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		})
+// SessionID returns the session the token behind claims was issued for.
+func (claims *UserClaims) SessionID() primitive.ObjectID {
+	id, _ := primitive.ObjectIDFromHex(claims.Id)
+	return id
+}
 
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
-			return
-		}
+// authenticate checks the bearer token of the request, its session and its
+// user. On failure it fails the request and returns false.
+func (a *Auth) authenticate(c *gin.Context) (*UserClaims, bool) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		Fail(c, Unauthorized(CodeUnauthorized, "Vui lòng đăng nhập"))
+		return nil, false
+	}
+
+	tokenString := strings.TrimSpace(strings.Replace(authHeader, "Bearer", "", 1))
 
-		if claims.Role > requiredRole {
-			c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to access this resource"})
-			c.Abort()
-			return
+	claims := &UserClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, jwt.ErrSignatureInvalid
 		}
+		return a.secret, nil
+	})
+
+	// Challenge tokens are signed with the same secret but only prove the
+	// password step of a login, so they are never accepted here.
+	if err != nil || !token.Valid || claims.Audience != "" {
+		Fail(c, Unauthorized(CodeInvalidToken, "Phiên đăng nhập không hợp lệ hoặc đã hết hạn"))
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+	active, err := a.sessions.CountDocuments(ctx, bson.M{
+		"_id":        claims.SessionID(),
+		"user_id":    claims.ID,
+		"revoked_at": nil,
+	})
+	if err != nil {
+		Fail(c, Internal(err))
+		return nil, false
+	}
+	if active == 0 {
+		Fail(c, Unauthorized(CodeSessionRevoked, "Phiên đăng nhập đã kết thúc, vui lòng đăng nhập lại"))
+		return nil, false
+	}
 
-		c.Set("user", claims)
-		c.Next()
+	suspended, err := a.users.CountDocuments(ctx, bson.M{
+		"_id":          claims.ID,
+		"suspended_at": bson.M{"$ne": nil},
+	})
+	if err != nil {
+		Fail(c, Internal(err))
+		return nil, false
 	}
+	if suspended > 0 {
+		Fail(c, Forbidden(CodeAccountSuspended, "Tài khoản đã bị khóa"))
+		return nil, false
+	}
+
+	return claims, true
 }
 
-func GenerateJWT(userID primitive.ObjectID, role Role) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+func (a *Auth) GenerateJWT(userID primitive.ObjectID, role Role, sessionID primitive.ObjectID) (string, error) {
+	expirationTime := time.Now().Add(a.ttl)
 
 	claims := &UserClaims{
 		ID:   userID,
 		Role: role,
 		StandardClaims: jwt.StandardClaims{
+			Id:        sessionID.Hex(),
+			IssuedAt:  time.Now().Unix(),
 			ExpiresAt: expirationTime.Unix(),
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
-	tokenString, err := token.SignedString(jwtSecret)
+	tokenString, err := token.SignedString(a.secret)
 	if err != nil {
 		return "", err
 	}
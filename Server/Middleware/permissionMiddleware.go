@@ -0,0 +1,99 @@
+package Middleware
+
+import (
+	"context"
+	"time"
+
+	"Server/Models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// permissionCacheTTL bounds how long a role edit made by another server
+// instance takes to apply. Edits made through this instance apply at once.
+const permissionCacheTTL = 30 * time.Second
+
+// Require authenticates the request and checks that the caller's role
+// grants every one of permissions. With no permissions it only requires a
+// signed-in user.
+func (a *Auth) Require(permissions ...Models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := a.authenticate(c)
+		if !ok {
+			return
+		}
+
+		granted, err := a.rolePermissions(c.Request.Context(), claims.Role)
+		if err != nil {
+			Fail(c, Internal(err))
+			return
+		}
+
+		for _, permission := range permissions {
+			if !granted[permission] {
+				Fail(c, Forbidden(CodeMissingPermission, "Bạn không có quyền thực hiện thao tác này").WithDetail("permission", permission))
+				return
+			}
+		}
+
+		c.Set("user", claims)
+		c.Set("permissions", granted)
+		c.Next()
+	}
+}
+
+// HasPermission reports whether the caller of a request that passed
+// Require has permission, for handlers whose behaviour depends on it.
+func HasPermission(c *gin.Context, permission Models.Permission) bool {
+	granted, _ := c.Get("permissions")
+	grants, _ := granted.(map[Models.Permission]bool)
+	return grants[permission]
+}
+
+// InvalidatePermissions drops the cached role definitions so the next
+// request reads them again.
+func (a *Auth) InvalidatePermissions() {
+	a.grantsMu.Lock()
+	a.grants = nil
+	a.grantsMu.Unlock()
+}
+
+func (a *Auth) rolePermissions(ctx context.Context, role Role) (map[Models.Permission]bool, error) {
+	a.grantsMu.RLock()
+	grants, loaded := a.grants, a.grantsLoaded
+	a.grantsMu.RUnlock()
+
+	if grants == nil || time.Since(loaded) > permissionCacheTTL {
+		var err error
+		if grants, err = a.loadGrants(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return grants[role], nil
+}
+
+func (a *Auth) loadGrants(ctx context.Context) (map[Role]map[Models.Permission]bool, error) {
+	cursor, err := a.roles.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var definitions []Models.RoleDefinition
+	if err := cursor.All(ctx, &definitions); err != nil {
+		return nil, err
+	}
+
+	grants := make(map[Role]map[Models.Permission]bool, len(definitions))
+	for _, definition := range definitions {
+		granted := make(map[Models.Permission]bool, len(definition.Permissions))
+		for _, permission := range definition.Permissions {
+			granted[permission] = true
+		}
+		grants[Role(definition.ID)] = granted
+	}
+
+	a.grantsMu.Lock()
+	a.grants, a.grantsLoaded = grants, time.Now()
+	a.grantsMu.Unlock()
+	return grants, nil
+}
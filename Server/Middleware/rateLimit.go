@@ -0,0 +1,84 @@
+package Middleware
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitStore counts events per key in fixed windows and keeps
+// temporary blocks. MemoryRateLimitStore suits a single instance;
+// MongoRateLimitStore shares the counts between instances.
+type RateLimitStore interface {
+	// Hit counts one event for key and returns how many events the current
+	// window holds and when it ends.
+	Hit(ctx context.Context, key string, window time.Duration) (int, time.Time, error)
+	// Block makes BlockedUntil report until for key.
+	Block(ctx context.Context, key string, until time.Time) error
+	// BlockedUntil returns when the block on key ends, or the zero time
+	// when key is not blocked.
+	BlockedUntil(ctx context.Context, key string) (time.Time, error)
+	// Reset forgets the counts and block of key.
+	Reset(ctx context.Context, key string) error
+}
+
+// RateLimitKey picks what a limit is counted against.
+type RateLimitKey func(c *gin.Context) string
+
+// ByIP counts requests per client address.
+func ByIP(c *gin.Context) string {
+	return c.ClientIP()
+}
+
+// ByUser counts requests per signed-in user, falling back to the client
+// address. It must run after Require to see the user.
+func ByUser(c *gin.Context) string {
+	if claims, ok := c.Get("user"); ok {
+		return "user:" + claims.(*UserClaims).ID.Hex()
+	}
+	return c.ClientIP()
+}
+
+// RateLimit allows limit requests per window for each key and answers 429
+// beyond that. name separates the counters of different limits.
+func RateLimit(store RateLimitStore, name string, limit int, window time.Duration, key RateLimitKey) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		count, resetAt, err := store.Hit(c.Request.Context(), "rl:"+name+":"+key(c), window)
+		if err != nil {
+			// Failing open keeps the API usable when the store is down.
+			log.Printf("rate limit %s: %v", name, err)
+			c.Next()
+			return
+		}
+
+		remaining := limit - count
+		if remaining < 0 {
+			remaining = 0
+		}
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Header("X-RateLimit-Reset", strconv.FormatInt(resetAt.Unix(), 10))
+
+		if count > limit {
+			AbortTooManyRequests(c, resetAt)
+			return
+		}
+		c.Next()
+	}
+}
+
+// AbortTooManyRequests fails the request with 429 with a Retry-After header pointing at
+// until.
+func AbortTooManyRequests(c *gin.Context, until time.Time) {
+	retryAfter := int(math.Ceil(time.Until(until).Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	Fail(c, NewError(http.StatusTooManyRequests, CodeTooManyRequests, "Bạn đã thử quá nhiều lần, vui lòng thử lại sau").WithDetail("retry_after", retryAfter))
+}
@@ -0,0 +1,184 @@
+package Middleware
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"Server/Config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NewRateLimitStore builds the store selected by cfg.Store.
+func NewRateLimitStore(cfg Config.RateLimitConfig, db *mongo.Database) (RateLimitStore, error) {
+	switch cfg.Store {
+	case "memory":
+		return NewMemoryRateLimitStore(), nil
+	case "mongo":
+		return NewMongoRateLimitStore(db.Collection("rate_limits")), nil
+	default:
+		return nil, errors.New("unknown rate limit store " + cfg.Store)
+	}
+}
+
+// windowStart returns the start of the fixed window of length window that
+// now falls in.
+func windowStart(now time.Time, window time.Duration) time.Time {
+	return now.Truncate(window)
+}
+
+type memoryCounter struct {
+	count   int
+	resetAt time.Time
+}
+
+// MemoryRateLimitStore keeps counters in process memory. Expired entries
+// are swept at most once a minute.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	counters  map[string]*memoryCounter
+	blocks    map[string]time.Time
+	lastSweep time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		counters:  map[string]*memoryCounter{},
+		blocks:    map[string]time.Time{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *MemoryRateLimitStore) Hit(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	counter, ok := s.counters[key]
+	if !ok || !now.Before(counter.resetAt) {
+		counter = &memoryCounter{resetAt: windowStart(now, window).Add(window)}
+		s.counters[key] = counter
+	}
+	counter.count++
+	return counter.count, counter.resetAt, nil
+}
+
+func (s *MemoryRateLimitStore) Block(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.blocks[key] = until
+	return nil
+}
+
+func (s *MemoryRateLimitStore) BlockedUntil(ctx context.Context, key string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	until, ok := s.blocks[key]
+	if !ok || !time.Now().Before(until) {
+		return time.Time{}, nil
+	}
+	return until, nil
+}
+
+func (s *MemoryRateLimitStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.counters, key)
+	delete(s.blocks, key)
+	return nil
+}
+
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, counter := range s.counters {
+		if !now.Before(counter.resetAt) {
+			delete(s.counters, key)
+		}
+	}
+	for key, until := range s.blocks {
+		if !now.Before(until) {
+			delete(s.blocks, key)
+		}
+	}
+}
+
+// MongoRateLimitStore keeps one document per key and window, so every
+// server instance sees the same counts. A TTL index on expires_at removes
+// old windows and blocks.
+type MongoRateLimitStore struct {
+	collection *mongo.Collection
+}
+
+func NewMongoRateLimitStore(collection *mongo.Collection) *MongoRateLimitStore {
+	return &MongoRateLimitStore{collection: collection}
+}
+
+var RateLimitIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "key", Value: 1}}},
+	{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+}
+
+func (s *MongoRateLimitStore) Hit(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	start := windowStart(time.Now(), window)
+	resetAt := start.Add(window)
+
+	var counter struct {
+		Count int `bson:"count"`
+	}
+	hit := func() error {
+		return s.collection.FindOneAndUpdate(ctx,
+			bson.M{"_id": key + "@" + strconv.FormatInt(start.Unix(), 10)},
+			bson.M{
+				"$inc":         bson.M{"count": 1},
+				"$setOnInsert": bson.M{"key": key, "expires_at": resetAt},
+			},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		).Decode(&counter)
+	}
+
+	err := hit()
+	// Two instances upserting the same new window race on the _id; the
+	// loser's retry finds the document the winner created.
+	if mongo.IsDuplicateKeyError(err) {
+		err = hit()
+	}
+	return counter.Count, resetAt, err
+}
+
+func (s *MongoRateLimitStore) Block(ctx context.Context, key string, until time.Time) error {
+	_, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": "block:" + key},
+		bson.M{"$set": bson.M{"key": key, "expires_at": until}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (s *MongoRateLimitStore) BlockedUntil(ctx context.Context, key string) (time.Time, error) {
+	var block struct {
+		ExpiresAt time.Time `bson:"expires_at"`
+	}
+	err := s.collection.FindOne(ctx, bson.M{"_id": "block:" + key, "expires_at": bson.M{"$gt": time.Now()}}).Decode(&block)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	}
+	return block.ExpiresAt, err
+}
+
+func (s *MongoRateLimitStore) Reset(ctx context.Context, key string) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"key": key})
+	return err
+}
@@ -0,0 +1,28 @@
+package Models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type UserAuditAction string
+
+const (
+	AuditRoleChanged UserAuditAction = "role_changed"
+	AuditSuspended   UserAuditAction = "suspended"
+	AuditReactivated UserAuditAction = "reactivated"
+)
+
+// UserAuditEntry records an administrative change to a user account, who
+// made it and why.
+type UserAuditEntry struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	ActorID   primitive.ObjectID `bson:"actor_id" json:"actor_id"`
+	Action    UserAuditAction    `bson:"action" json:"action"`
+	FromRole  *Role              `bson:"from_role,omitempty" json:"from_role,omitempty"`
+	ToRole    *Role              `bson:"to_role,omitempty" json:"to_role,omitempty"`
+	Reason    string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,92 @@
+package Models
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type BookingStatus string
+
+const (
+	BookingPending    BookingStatus = "pending"
+	BookingConfirmed  BookingStatus = "confirmed"
+	BookingInProgress BookingStatus = "in_progress"
+	BookingCompleted  BookingStatus = "completed"
+	BookingCancelled  BookingStatus = "cancelled"
+)
+
+var bookingStatusLabels = map[BookingStatus]string{
+	BookingPending:    "Chờ xác nhận",
+	BookingConfirmed:  "Đã xác nhận",
+	BookingInProgress: "Đang tiến hành",
+	BookingCompleted:  "Hoàn thành",
+	BookingCancelled:  "Đã hủy",
+}
+
+var bookingTransitions = map[BookingStatus][]BookingStatus{
+	BookingPending:    {BookingConfirmed, BookingCancelled},
+	BookingConfirmed:  {BookingInProgress, BookingCancelled},
+	BookingInProgress: {BookingCompleted},
+}
+
+type BookingStatusChange struct {
+	ChangedBy primitive.ObjectID `bson:"changed_by" json:"changed_by"`
+	From      BookingStatus      `bson:"from" json:"from"`
+	To        BookingStatus      `bson:"to" json:"to"`
+	Reason    string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	ChangedAt primitive.DateTime `bson:"changed_at" json:"changed_at"`
+}
+
+// ParseBookingStatus accepts either a machine code or the Vietnamese display
+// label that older clients and documents still use.
+func ParseBookingStatus(value string) (BookingStatus, bool) {
+	if _, ok := bookingStatusLabels[BookingStatus(value)]; ok {
+		return BookingStatus(value), true
+	}
+	for status, label := range bookingStatusLabels {
+		if label == value {
+			return status, true
+		}
+	}
+	return "", false
+}
+
+func (s BookingStatus) Label() string {
+	return bookingStatusLabels[s]
+}
+
+func (s BookingStatus) CanTransitionTo(next BookingStatus) bool {
+	for _, allowed := range bookingTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// StoredValues lists every value a document in this status may hold, so
+// queries also match bookings saved with the legacy label.
+func (s BookingStatus) StoredValues() []string {
+	return []string{string(s), s.Label()}
+}
+
+// UnmarshalBSONValue maps legacy label values onto their machine code.
+func (s *BookingStatus) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	var raw string
+	if err := (bson.RawValue{Type: t, Value: data}).Unmarshal(&raw); err != nil {
+		return err
+	}
+	if status, ok := ParseBookingStatus(raw); ok {
+		*s = status
+		return nil
+	}
+	*s = BookingStatus(raw)
+	return nil
+}
+
+// IsOpen reports whether a booking in status s still occupies its slot and
+// its assigned staff.
+func (s BookingStatus) IsOpen() bool {
+	return s != BookingCompleted && s != BookingCancelled
+}
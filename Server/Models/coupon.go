@@ -0,0 +1,168 @@
+package Models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type DiscountType string
+
+const (
+	DiscountPercent DiscountType = "percent"
+	DiscountFixed   DiscountType = "fixed"
+)
+
+// CouponTarget is what a coupon can be used on.
+type CouponTarget string
+
+const (
+	CouponForAll      CouponTarget = "all"
+	CouponForProducts CouponTarget = "products"
+	CouponForServices CouponTarget = "services"
+)
+
+// Coupon is a discount code managed by staff. A percentage coupon takes
+// Percent off the lines it applies to, capped at MaxDiscount when that is
+// set; a fixed coupon takes Amount off. Zero limits mean no limit.
+type Coupon struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Code        string             `bson:"code" json:"code"`
+	Description string             `bson:"description,omitempty" json:"description,omitempty"`
+	Type        DiscountType       `bson:"type" json:"type"`
+	Percent     float64            `bson:"percent,omitempty" json:"percent,omitempty"`
+	Amount      Money              `bson:"amount,omitempty" json:"amount"`
+	MaxDiscount Money              `bson:"max_discount,omitempty" json:"max_discount"`
+	// MinOrder is the smallest subtotal, before the discount, the coupon
+	// can be used on.
+	MinOrder  Money        `bson:"min_order,omitempty" json:"min_order"`
+	AppliesTo CouponTarget `bson:"applies_to" json:"applies_to"`
+	// ProductCategories and ServiceCategories restrict the discount to
+	// lines in those categories. Empty means every category.
+	ProductCategories []primitive.ObjectID `bson:"product_categories,omitempty" json:"product_categories,omitempty"`
+	ServiceCategories []primitive.ObjectID `bson:"service_categories,omitempty" json:"service_categories,omitempty"`
+	UsageLimit        int                  `bson:"usage_limit" json:"usage_limit"`
+	PerUserLimit      int                  `bson:"per_user_limit" json:"per_user_limit"`
+	// Used counts redemptions. It only changes through the conditional
+	// updates that redeem and release the coupon.
+	Used      int        `bson:"used" json:"used"`
+	StartsAt  *time.Time `bson:"starts_at,omitempty" json:"starts_at,omitempty"`
+	EndsAt    *time.Time `bson:"ends_at,omitempty" json:"ends_at,omitempty"`
+	Active    bool       `bson:"active" json:"active"`
+	CreatedAt time.Time  `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time  `bson:"updated_at" json:"updated_at"`
+}
+
+// CouponUsage counts how often one user has redeemed a coupon. The
+// document ID is derived from both so that concurrent redemptions contend
+// on a single document.
+type CouponUsage struct {
+	ID       string             `bson:"_id"`
+	CouponID primitive.ObjectID `bson:"coupon_id"`
+	UserID   primitive.ObjectID `bson:"user_id"`
+	Used     int                `bson:"used"`
+}
+
+func CouponUsageID(couponID, userID primitive.ObjectID) string {
+	return couponID.Hex() + ":" + userID.Hex()
+}
+
+// CouponRedemption records a coupon used on an order or a booking, so the
+// use can be given back when that is cancelled.
+type CouponRedemption struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	CouponID  primitive.ObjectID `bson:"coupon_id" json:"coupon_id"`
+	Code      string             `bson:"code" json:"code"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	OrderID   primitive.ObjectID `bson:"order_id,omitempty" json:"order_id,omitempty"`
+	BookingID primitive.ObjectID `bson:"booking_id,omitempty" json:"booking_id,omitempty"`
+	Discount  Money              `bson:"discount" json:"discount"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// CouponLine is one line of a purchase a coupon may apply to.
+type CouponLine struct {
+	Category primitive.ObjectID
+	Amount   Money
+}
+
+// CouponError explains why a coupon cannot be used. Code is a stable
+// identifier for clients.
+type CouponError struct {
+	Code    string
+	Message string
+}
+
+func (e *CouponError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (c Coupon) appliesTo(target CouponTarget) bool {
+	return c.AppliesTo == "" || c.AppliesTo == CouponForAll || c.AppliesTo == target
+}
+
+func (c Coupon) categoriesFor(target CouponTarget) []primitive.ObjectID {
+	if target == CouponForServices {
+		return c.ServiceCategories
+	}
+	return c.ProductCategories
+}
+
+// Apply works out the discount the coupon gives on a purchase of target
+// made of lines, at time now. It returns a *CouponError when the coupon
+// cannot be used. Usage limits are only checked here as a courtesy; they
+// are enforced when the coupon is redeemed.
+func (c Coupon) Apply(target CouponTarget, lines []CouponLine, now time.Time) (Money, error) {
+	switch {
+	case !c.Active:
+		return Money{}, &CouponError{Code: "inactive", Message: "Mã giảm giá không còn hiệu lực"}
+	case c.StartsAt != nil && now.Before(*c.StartsAt):
+		return Money{}, &CouponError{Code: "not_started", Message: "Mã giảm giá chưa đến thời gian sử dụng"}
+	case c.EndsAt != nil && !now.Before(*c.EndsAt):
+		return Money{}, &CouponError{Code: "expired", Message: "Mã giảm giá đã hết hạn"}
+	case c.UsageLimit > 0 && c.Used >= c.UsageLimit:
+		return Money{}, &CouponError{Code: "usage_limit", Message: "Mã giảm giá đã hết lượt sử dụng"}
+	case !c.appliesTo(target):
+		return Money{}, &CouponError{Code: "not_applicable", Message: "Mã giảm giá không áp dụng cho đơn này"}
+	}
+
+	categories := c.categoriesFor(target)
+	var subtotal, eligible Money
+	for _, line := range lines {
+		subtotal = subtotal.Add(line.Amount)
+		if len(categories) == 0 || containsObjectID(categories, line.Category) {
+			eligible = eligible.Add(line.Amount)
+		}
+	}
+
+	if subtotal.Cmp(c.MinOrder) < 0 {
+		return Money{}, &CouponError{Code: "min_order", Message: fmt.Sprintf("Đơn hàng phải từ %s để dùng mã này", c.MinOrder)}
+	}
+	if !eligible.IsPositive() {
+		return Money{}, &CouponError{Code: "not_applicable", Message: "Mã giảm giá không áp dụng cho sản phẩm nào trong đơn"}
+	}
+
+	discount := c.Amount
+	if c.Type == DiscountPercent {
+		discount = eligible.MulRate(c.Percent / 100)
+		if c.MaxDiscount.IsPositive() {
+			discount = discount.Min(c.MaxDiscount)
+		}
+	}
+	return discount.Min(eligible), nil
+}
+
+func containsObjectID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
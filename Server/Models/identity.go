@@ -0,0 +1,30 @@
+package Models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UserIdentity links an account at an OpenID Connect provider to a user,
+// so later social logins find the user without relying on the email.
+type UserIdentity struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID      primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Provider    string             `bson:"provider" json:"provider"`
+	Subject     string             `bson:"subject" json:"-"`
+	Email       string             `bson:"email" json:"email"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	LastLoginAt time.Time          `bson:"last_login_at" json:"last_login_at"`
+}
+
+// OAuthState remembers a social login between the redirect to the provider
+// and its callback. Only a hash of the state parameter is stored.
+type OAuthState struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	StateHash string             `bson:"state_hash"`
+	Provider  string             `bson:"provider"`
+	Nonce     string             `bson:"nonce"`
+	Verifier  string             `bson:"verifier"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
@@ -0,0 +1,223 @@
+package Models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// Currency is an ISO 4217 currency code.
+type Currency string
+
+const VND Currency = "VND"
+
+// DefaultCurrency is the currency of the shop. Amounts stored or sent as
+// bare numbers are in it.
+const DefaultCurrency = VND
+
+// Money is an amount in the minor unit of Currency. The dong has no minor
+// unit, so for VND Amount is whole dong. Arithmetic stays in integers;
+// only MulRate rounds.
+//
+// It is stored and sent as {"amount": 150000, "currency": "VND"}. Bare
+// numbers, as written before Money existed, are read as amounts in
+// DefaultCurrency.
+type Money struct {
+	Amount   int64
+	Currency Currency
+}
+
+// ErrCurrencyMismatch is the panic value of arithmetic on amounts in
+// different currencies, which is a programming error.
+var ErrCurrencyMismatch = errors.New("money: currency mismatch")
+
+func NewMoney(amount int64, currency Currency) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Dong returns amount whole dong.
+func Dong(amount int64) Money {
+	return Money{Amount: amount, Currency: VND}
+}
+
+func (m Money) currency() Currency {
+	if m.Currency == "" {
+		return DefaultCurrency
+	}
+	return m.Currency
+}
+
+// with returns the currency of an operation on m and other. The zero Money
+// takes the currency of the other side.
+func (m Money) with(other Money) Currency {
+	switch {
+	case m.Amount == 0 && m.Currency == "":
+		return other.currency()
+	case other.Amount == 0 && other.Currency == "":
+		return m.currency()
+	case m.currency() != other.currency():
+		panic(ErrCurrencyMismatch)
+	}
+	return m.currency()
+}
+
+func (m Money) Add(other Money) Money {
+	return Money{Amount: m.Amount + other.Amount, Currency: m.with(other)}
+}
+
+func (m Money) Sub(other Money) Money {
+	return Money{Amount: m.Amount - other.Amount, Currency: m.with(other)}
+}
+
+// Mul returns m times quantity.
+func (m Money) Mul(quantity int) Money {
+	return Money{Amount: m.Amount * int64(quantity), Currency: m.currency()}
+}
+
+// MulRate returns m times rate, rounded half away from zero to the minor
+// unit.
+func (m Money) MulRate(rate float64) Money {
+	return Money{Amount: int64(math.Round(float64(m.Amount) * rate)), Currency: m.currency()}
+}
+
+// Cmp returns -1, 0 or +1 as m is less than, equal to or greater than
+// other.
+func (m Money) Cmp(other Money) int {
+	m.with(other)
+	switch {
+	case m.Amount < other.Amount:
+		return -1
+	case m.Amount > other.Amount:
+		return 1
+	}
+	return 0
+}
+
+func (m Money) Min(other Money) Money {
+	if m.Cmp(other) <= 0 {
+		return m
+	}
+	return other
+}
+
+func (m Money) Max(other Money) Money {
+	if m.Cmp(other) >= 0 {
+		return m
+	}
+	return other
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+func (m Money) String() string {
+	return strconv.FormatInt(m.Amount, 10) + " " + string(m.currency())
+}
+
+// SumMoney adds up amounts.
+func SumMoney(amounts ...Money) Money {
+	var total Money
+	for _, amount := range amounts {
+		total = total.Add(amount)
+	}
+	return total
+}
+
+type moneyDocument struct {
+	Amount   int64    `bson:"amount" json:"amount"`
+	Currency Currency `bson:"currency" json:"currency"`
+}
+
+func (d moneyDocument) money() (Money, error) {
+	if d.Currency == "" {
+		d.Currency = DefaultCurrency
+	}
+	if d.Currency != DefaultCurrency {
+		return Money{}, fmt.Errorf("money: unsupported currency %q", d.Currency)
+	}
+	return Money{Amount: d.Amount, Currency: d.Currency}, nil
+}
+
+func (m Money) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bson.MarshalValue(moneyDocument{Amount: m.Amount, Currency: m.currency()})
+}
+
+func (m *Money) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	value := bson.RawValue{Type: t, Value: data}
+	switch t {
+	case bsontype.EmbeddedDocument:
+		var doc moneyDocument
+		if err := value.Unmarshal(&doc); err != nil {
+			return err
+		}
+		money, err := doc.money()
+		if err != nil {
+			return err
+		}
+		*m = money
+	case bsontype.Double:
+		*m = Money{Amount: int64(math.Round(value.Double())), Currency: DefaultCurrency}
+	case bsontype.Int32:
+		*m = Money{Amount: int64(value.Int32()), Currency: DefaultCurrency}
+	case bsontype.Int64:
+		*m = Money{Amount: value.Int64(), Currency: DefaultCurrency}
+	case bsontype.Null, bsontype.Undefined:
+		*m = Money{}
+	default:
+		return fmt.Errorf("money: cannot decode BSON %s", t)
+	}
+	return nil
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyDocument{Amount: m.Amount, Currency: m.currency()})
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	text := strings.TrimSpace(string(data))
+	if text == "null" {
+		return nil
+	}
+	if strings.HasPrefix(text, "{") {
+		var doc moneyDocument
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		money, err := doc.money()
+		if err != nil {
+			return &json.UnmarshalTypeError{Value: "currency " + string(doc.Currency), Type: reflect.TypeOf(m).Elem()}
+		}
+		*m = money
+		return nil
+	}
+
+	amount, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return &json.UnmarshalTypeError{Value: "number " + text, Type: reflect.TypeOf(m).Elem()}
+	}
+	*m = Money{Amount: amount, Currency: DefaultCurrency}
+	return nil
+}
+
+// UnmarshalParam reads a form value, a whole number of minor units in
+// DefaultCurrency.
+func (m *Money) UnmarshalParam(param string) error {
+	amount, err := strconv.ParseInt(strings.TrimSpace(param), 10, 64)
+	if err != nil {
+		return fmt.Errorf("money: %q is not a whole amount", param)
+	}
+	*m = Money{Amount: amount, Currency: DefaultCurrency}
+	return nil
+}
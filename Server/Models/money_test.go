@@ -0,0 +1,203 @@
+package Models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMoneyArithmetic(t *testing.T) {
+	tests := []struct {
+		name string
+		got  Money
+		want Money
+	}{
+		{"add", Dong(150000).Add(Dong(25000)), Dong(175000)},
+		{"add to zero value", Money{}.Add(Dong(10)), Dong(10)},
+		{"add zero value", Dong(10).Add(Money{}), Dong(10)},
+		{"sub", Dong(100).Sub(Dong(250)), Dong(-150)},
+		{"mul", Dong(35000).Mul(3), Dong(105000)},
+		{"mul zero", Dong(35000).Mul(0), Dong(0)},
+		{"mul rate", Dong(150000).MulRate(0.1), Dong(15000)},
+		{"mul rate rounds half up", Dong(5).MulRate(0.5), Dong(3)},
+		{"mul rate rounds to nearest", Dong(1234).MulRate(0.08), Dong(99)},
+		{"mul rate rounds half away from zero", Dong(-5).MulRate(0.5), Dong(-3)},
+		{"sum", SumMoney(Dong(1), Dong(2), Dong(3)), Dong(6)},
+		{"sum of nothing", SumMoney(), Money{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyCompare(t *testing.T) {
+	tests := []struct {
+		a, b     Money
+		cmp      int
+		min, max Money
+	}{
+		{Dong(1), Dong(2), -1, Dong(1), Dong(2)},
+		{Dong(2), Dong(1), 1, Dong(1), Dong(2)},
+		{Dong(5), Dong(5), 0, Dong(5), Dong(5)},
+		{Money{}, Dong(-1), 1, Dong(-1), Money{}},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Cmp(tt.b); got != tt.cmp {
+			t.Errorf("%v.Cmp(%v) = %d, want %d", tt.a, tt.b, got, tt.cmp)
+		}
+		if got := tt.a.Min(tt.b); got != tt.min {
+			t.Errorf("%v.Min(%v) = %v, want %v", tt.a, tt.b, got, tt.min)
+		}
+		if got := tt.a.Max(tt.b); got != tt.max {
+			t.Errorf("%v.Max(%v) = %v, want %v", tt.a, tt.b, got, tt.max)
+		}
+	}
+}
+
+func TestMoneyCurrencyMismatch(t *testing.T) {
+	usd := NewMoney(100, "USD")
+	tests := []struct {
+		name string
+		op   func()
+	}{
+		{"add", func() { Dong(1).Add(usd) }},
+		{"sub", func() { usd.Sub(Dong(1)) }},
+		{"cmp", func() { Dong(1).Cmp(usd) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != ErrCurrencyMismatch {
+					t.Errorf("recovered %v, want %v", r, ErrCurrencyMismatch)
+				}
+			}()
+			tt.op()
+		})
+	}
+}
+
+func TestMoneyBSON(t *testing.T) {
+	type document struct {
+		Price Money `bson:"price"`
+	}
+
+	data, err := bson.Marshal(document{Price: Dong(150000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw struct {
+		Price bson.M `bson:"price"`
+	}
+	if err := bson.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw.Price["amount"] != int64(150000) || raw.Price["currency"] != string(VND) {
+		t.Errorf("stored %v, want amount 150000 and currency VND", raw.Price)
+	}
+
+	tests := []struct {
+		name  string
+		price interface{}
+		want  Money
+	}{
+		{"document", bson.M{"amount": int64(150000), "currency": "VND"}, Dong(150000)},
+		{"document without currency", bson.M{"amount": int64(42)}, Dong(42)},
+		{"double", 149999.6, Dong(150000)},
+		{"int32", int32(25000), Dong(25000)},
+		{"int64", int64(3000000000), Dong(3000000000)},
+		{"null", nil, Money{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := bson.Marshal(bson.M{"price": tt.price})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got document
+			if err := bson.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Price != tt.want {
+				t.Errorf("got %v, want %v", got.Price, tt.want)
+			}
+		})
+	}
+
+	for _, price := range []interface{}{"150000", bson.M{"amount": int64(1), "currency": "USD"}} {
+		data, err := bson.Marshal(bson.M{"price": price})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got document
+		if err := bson.Unmarshal(data, &got); err == nil {
+			t.Errorf("decoding %v: got %v, want an error", price, got.Price)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	data, err := json.Marshal(Dong(150000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"amount":150000,"currency":"VND"}` {
+		t.Errorf("marshalled %s", data)
+	}
+
+	tests := []struct {
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{in: `{"amount":150000,"currency":"VND"}`, want: Dong(150000)},
+		{in: `{"amount":7}`, want: Dong(7)},
+		{in: `150000`, want: Dong(150000)},
+		{in: ` -20 `, want: Dong(-20)},
+		{in: `null`, want: Money{}},
+		{in: `1.5`, wantErr: true},
+		{in: `"150000"`, wantErr: true},
+		{in: `{"amount":1,"currency":"USD"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var got Money
+			err := json.Unmarshal([]byte(tt.in), &got)
+			if tt.wantErr {
+				var typeErr *json.UnmarshalTypeError
+				if !errors.As(err, &typeErr) {
+					t.Errorf("got %v, %v; want a type error", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("got %v, %v; want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyUnmarshalParam(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{in: "150000", want: Dong(150000)},
+		{in: " 42 ", want: Dong(42)},
+		{in: "1.5", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		var got Money
+		err := got.UnmarshalParam(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("UnmarshalParam(%q) = %v, %v; want %v, error %t", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package Models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type OrderStatus string
+
+const (
+	OrderPending   OrderStatus = "pending"
+	OrderPaid      OrderStatus = "paid"
+	OrderPacked    OrderStatus = "packed"
+	OrderShipped   OrderStatus = "shipped"
+	OrderDelivered OrderStatus = "delivered"
+	OrderCancelled OrderStatus = "cancelled"
+	OrderRefunded  OrderStatus = "refunded"
+)
+
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderPending:   {OrderPaid, OrderCancelled},
+	OrderPaid:      {OrderPacked, OrderCancelled, OrderRefunded},
+	OrderPacked:    {OrderShipped, OrderCancelled},
+	OrderShipped:   {OrderDelivered},
+	OrderDelivered: {OrderRefunded},
+}
+
+type OrderStatusChange struct {
+	From      OrderStatus        `bson:"from,omitempty" json:"from,omitempty"`
+	To        OrderStatus        `bson:"to" json:"to"`
+	ChangedBy primitive.ObjectID `bson:"changed_by,omitempty" json:"changed_by,omitempty"`
+	Note      string             `bson:"note,omitempty" json:"note,omitempty"`
+	ChangedAt time.Time          `bson:"changed_at" json:"changed_at"`
+}
+
+func (s OrderStatus) IsValid() bool {
+	switch s {
+	case OrderPending, OrderPaid, OrderPacked, OrderShipped, OrderDelivered, OrderCancelled, OrderRefunded:
+		return true
+	}
+	return false
+}
+
+// CanTransitionTo reports whether an order in status s may move to next.
+// Orders stored before statuses existed have an empty status and are treated
+// as pending.
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	if s == "" {
+		s = OrderPending
+	}
+	for _, allowed := range orderTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// RestoresStock reports whether moving from s to next puts the ordered units
+// back on the shelf. Cancelled orders always do; refunds only do when the
+// goods never left the warehouse.
+func (s OrderStatus) RestoresStock(next OrderStatus) bool {
+	if next == OrderCancelled {
+		return true
+	}
+	return next == OrderRefunded && s == OrderPaid
+}
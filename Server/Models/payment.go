@@ -0,0 +1,52 @@
+package Models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type PaymentStatus string
+
+const (
+	PaymentPending   PaymentStatus = "pending"
+	PaymentSucceeded PaymentStatus = "succeeded"
+	PaymentFailed    PaymentStatus = "failed"
+	// PaymentRefunding is a refund that has been claimed but that the
+	// gateway has not confirmed yet. A payment left in it after a crash
+	// needs checking with the gateway by staff.
+	PaymentRefunding PaymentStatus = "refunding"
+	PaymentRefunded  PaymentStatus = "refunded"
+	// PaymentRefundFailed is a refund the gateway did not take. Staff retry
+	// it through the refund endpoint.
+	PaymentRefundFailed PaymentStatus = "refund_failed"
+)
+
+// IsRefundable reports whether a refund of a payment in status s may be
+// started.
+func (s PaymentStatus) IsRefundable() bool {
+	return s == PaymentSucceeded || s == PaymentRefundFailed
+}
+
+// Payment is one attempt to pay for an order through a gateway. An order
+// can have several, for example after the customer abandons the gateway
+// page and tries again. TxnRef is the reference the gateway knows the
+// attempt by.
+type Payment struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrderID       primitive.ObjectID `bson:"order_id" json:"order_id"`
+	UserID        primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Provider      string             `bson:"provider" json:"provider"`
+	TxnRef        string             `bson:"txn_ref" json:"txn_ref"`
+	Amount        Money              `bson:"amount" json:"amount"`
+	Status        PaymentStatus      `bson:"status" json:"status"`
+	TransactionNo string             `bson:"transaction_no,omitempty" json:"transaction_no,omitempty"`
+	ResponseCode  string             `bson:"response_code,omitempty" json:"response_code,omitempty"`
+	ClientIP      string             `bson:"client_ip" json:"-"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt     time.Time          `bson:"expires_at" json:"expires_at"`
+	PaidAt        *time.Time         `bson:"paid_at,omitempty" json:"paid_at,omitempty"`
+	RefundedAt    *time.Time         `bson:"refunded_at,omitempty" json:"refunded_at,omitempty"`
+	RefundError   string             `bson:"refund_error,omitempty" json:"refund_error,omitempty"`
+	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,106 @@
+package Models
+
+import "time"
+
+// Permission names one action a role can be granted. Routes declare the
+// permissions they need instead of a minimum role.
+type Permission string
+
+const (
+	PermCategoriesWrite Permission = "categories:write"
+	PermProductsWrite   Permission = "products:write"
+	PermProductsDelete  Permission = "products:delete"
+	PermServicesWrite   Permission = "services:write"
+	PermServicesDelete  Permission = "services:delete"
+	PermOrdersManage    Permission = "orders:manage"
+	PermCouponsManage   Permission = "coupons:manage"
+	PermBookingsRead    Permission = "bookings:read"
+	PermBookingsManage  Permission = "bookings:manage"
+	PermBookingsAssign  Permission = "bookings:assign"
+	// PermBookingsWork marks staff who can be assigned to bookings and
+	// update the ones assigned to them.
+	PermBookingsWork Permission = "bookings:work"
+	PermUsersManage  Permission = "users:manage"
+	PermRolesManage  Permission = "roles:manage"
+)
+
+var AllPermissions = []Permission{
+	PermCategoriesWrite,
+	PermProductsWrite,
+	PermProductsDelete,
+	PermServicesWrite,
+	PermServicesDelete,
+	PermOrdersManage,
+	PermCouponsManage,
+	PermBookingsRead,
+	PermBookingsManage,
+	PermBookingsAssign,
+	PermBookingsWork,
+	PermUsersManage,
+	PermRolesManage,
+}
+
+func (p Permission) Valid() bool {
+	for _, known := range AllPermissions {
+		if p == known {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleDefinition is the set of permissions granted to every user with the
+// role. Its ID is the value stored in User.Role.
+type RoleDefinition struct {
+	ID          Role         `bson:"_id" json:"id"`
+	Name        string       `bson:"name" json:"name"`
+	Permissions []Permission `bson:"permissions" json:"permissions"`
+	// RequireTwoFactor makes holders of the role confirm every login with
+	// an authenticator code, enrolling on their next login if needed.
+	RequireTwoFactor bool `bson:"require_two_factor" json:"require_two_factor"`
+	// BuiltIn roles are created on startup and cannot be deleted.
+	BuiltIn   bool      `bson:"built_in" json:"built_in"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+func (r RoleDefinition) Has(permission Permission) bool {
+	for _, granted := range r.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultRoleDefinitions grants the built-in roles what the fixed
+// Admin > Staff > Customer ladder used to allow.
+func DefaultRoleDefinitions() []RoleDefinition {
+	return []RoleDefinition{
+		{
+			ID:               Admin,
+			Name:             "Admin",
+			Permissions:      append([]Permission(nil), AllPermissions...),
+			RequireTwoFactor: true,
+			BuiltIn:          true,
+		},
+		{
+			ID:   Staff,
+			Name: "Staff",
+			Permissions: []Permission{
+				PermProductsWrite,
+				PermServicesWrite,
+				PermOrdersManage,
+				PermBookingsRead,
+				PermBookingsWork,
+			},
+			RequireTwoFactor: true,
+			BuiltIn:          true,
+		},
+		{
+			ID:          Customer,
+			Name:        "Customer",
+			Permissions: []Permission{},
+			BuiltIn:     true,
+		},
+	}
+}
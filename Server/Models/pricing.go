@@ -0,0 +1,22 @@
+package Models
+
+// PriceBreakdown is how the price of an order is made up. TotalPrice is
+// Subtotal less Discount plus ShippingFee, plus VAT unless VATIncluded says
+// prices already contain it.
+type PriceBreakdown struct {
+	Subtotal    Money `bson:"subtotal,omitempty" json:"subtotal"`
+	Discount    Money `bson:"discount,omitempty" json:"discount"`
+	ShippingFee Money `bson:"shipping_fee,omitempty" json:"shipping_fee"`
+	VAT         Money `bson:"vat,omitempty" json:"vat"`
+	VATIncluded bool  `bson:"vat_included,omitempty" json:"vat_included"`
+	TotalPrice  Money `bson:"total_price,omitempty" json:"total_price"`
+}
+
+// ShippingAddress is where an order is delivered. Province picks the
+// shipping rate.
+type ShippingAddress struct {
+	Recipient string `bson:"recipient,omitempty" json:"recipient,omitempty" binding:"max=100"`
+	Phone     string `bson:"phone,omitempty" json:"phone,omitempty" binding:"omitempty,numeric,min=9,max=15"`
+	Address   string `bson:"address,omitempty" json:"address,omitempty" binding:"max=200"`
+	Province  string `bson:"province,omitempty" json:"province,omitempty" binding:"max=100"`
+}
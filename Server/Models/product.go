@@ -4,11 +4,15 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Product is an item for sale. Weight is the shipping weight of one unit in
+// grams.
 type Product struct {
-	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Name            string             `bson:"name" json:"name"`
-	Price           float64            `bson:"price" json:"price"`
-	Stock           int                `bson:"stock" json:"stock"`
-	ProductCategory primitive.ObjectID `bson:"productcategory" json:"productcategory"`
-	ImageURL        string             `bson:"imageurl" json:"imageurl"`
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id" form:"-"`
+	Name            string             `bson:"name" json:"name" form:"name" binding:"required,max=200"`
+	Price           Money              `bson:"price" json:"price" form:"price" binding:"gt=0"`
+	Stock           int                `bson:"stock" json:"stock" form:"stock" binding:"gte=0"`
+	Weight          int                `bson:"weight,omitempty" json:"weight,omitempty" form:"weight" binding:"gte=0,lte=1000000"`
+	ProductCategory primitive.ObjectID `bson:"productcategory" json:"productcategory" form:"-"`
+	ImageURL        string             `bson:"imageurl" json:"imageurl" form:"-"`
+	ImageKey        string             `bson:"imagekey,omitempty" json:"imagekey,omitempty" form:"-"`
 }
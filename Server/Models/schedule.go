@@ -0,0 +1,148 @@
+package Models
+
+import (
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ScheduleLocation is the time zone working hours are expressed in.
+var ScheduleLocation = loadScheduleLocation()
+
+func loadScheduleLocation() *time.Location {
+	if loc, err := time.LoadLocation("Asia/Ho_Chi_Minh"); err == nil {
+		return loc
+	}
+	return time.FixedZone("ICT", 7*60*60)
+}
+
+type ServiceSchedule struct {
+	WorkingDays []time.Weekday `bson:"working_days" json:"working_days" binding:"required,min=1,dive,gte=0,lte=6"`
+	OpenTime    string         `bson:"open_time" json:"open_time" binding:"required,datetime=15:04"`
+	CloseTime   string         `bson:"close_time" json:"close_time" binding:"required,datetime=15:04"`
+	SlotMinutes int            `bson:"slot_minutes" json:"slot_minutes" binding:"gt=0"`
+	Capacity    int            `bson:"capacity" json:"capacity" binding:"gt=0"`
+}
+
+// ScheduleError reports a schedule whose fields are each valid but do not
+// fit together.
+type ScheduleError struct {
+	Field   string
+	Message string
+}
+
+func (e *ScheduleError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// DefaultServiceSchedule applies to services that were never given their own
+// schedule: Monday to Saturday, 08:00-17:00, hourly slots, one job at a time.
+var DefaultServiceSchedule = ServiceSchedule{
+	WorkingDays: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday},
+	OpenTime:    "08:00",
+	CloseTime:   "17:00",
+	SlotMinutes: 60,
+	Capacity:    1,
+}
+
+// ServiceSlot counts the places booked in one slot of a service, one per
+// unit of each booking. The document ID is derived from the service and
+// slot start so that concurrent reservations contend on a single document.
+type ServiceSlot struct {
+	ID        string             `bson:"_id" json:"id"`
+	ServiceID primitive.ObjectID `bson:"service_id" json:"service_id"`
+	Start     primitive.DateTime `bson:"start" json:"start"`
+	Booked    int                `bson:"booked" json:"booked"`
+}
+
+type SlotAvailability struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Capacity  int       `json:"capacity"`
+	Booked    int       `json:"booked"`
+	Available int       `json:"available"`
+}
+
+func ServiceSlotID(serviceID primitive.ObjectID, start time.Time) string {
+	return fmt.Sprintf("%s:%d", serviceID.Hex(), start.Unix())
+}
+
+func (s Service) EffectiveSchedule() ServiceSchedule {
+	if s.Schedule == nil {
+		return DefaultServiceSchedule
+	}
+	return *s.Schedule
+}
+
+// Validate checks the rules between fields that binding tags cannot
+// express. It returns a *ScheduleError.
+func (s ServiceSchedule) Validate() error {
+	open, err := parseClock(s.OpenTime)
+	if err != nil {
+		return &ScheduleError{Field: "open_time", Message: "Phải có dạng HH:MM"}
+	}
+	closing, err := parseClock(s.CloseTime)
+	if err != nil {
+		return &ScheduleError{Field: "close_time", Message: "Phải có dạng HH:MM"}
+	}
+	if closing <= open {
+		return &ScheduleError{Field: "close_time", Message: "Phải sau giờ mở cửa"}
+	}
+	if s.SlotMinutes <= 0 || time.Duration(s.SlotMinutes)*time.Minute > closing-open {
+		return &ScheduleError{Field: "slot_minutes", Message: "Phải nằm trong giờ làm việc"}
+	}
+	return nil
+}
+
+// SlotsBetween returns the start of every slot that begins within [from, to).
+func (s ServiceSchedule) SlotsBetween(from, to time.Time) []time.Time {
+	open, errOpen := parseClock(s.OpenTime)
+	closing, errClose := parseClock(s.CloseTime)
+	if errOpen != nil || errClose != nil || s.SlotMinutes <= 0 {
+		return nil
+	}
+	slotLength := time.Duration(s.SlotMinutes) * time.Minute
+
+	var slots []time.Time
+	from, to = from.In(ScheduleLocation), to.In(ScheduleLocation)
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, ScheduleLocation)
+	for ; day.Before(to); day = day.AddDate(0, 0, 1) {
+		if !s.worksOn(day.Weekday()) {
+			continue
+		}
+		for start := day.Add(open); !start.Add(slotLength).After(day.Add(closing)); start = start.Add(slotLength) {
+			if !start.Before(from) && start.Before(to) {
+				slots = append(slots, start)
+			}
+		}
+	}
+	return slots
+}
+
+// IsSlotStart reports whether t is exactly the start of a bookable slot.
+func (s ServiceSchedule) IsSlotStart(t time.Time) bool {
+	for _, start := range s.SlotsBetween(t, t.Add(time.Minute)) {
+		if start.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s ServiceSchedule) worksOn(day time.Weekday) bool {
+	for _, working := range s.WorkingDays {
+		if working == day {
+			return true
+		}
+	}
+	return false
+}
+
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
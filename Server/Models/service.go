@@ -5,10 +5,12 @@ import (
 )
 
 type Service struct {
-	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Name            string             `bson:"name" json:"name"`
-	Price           float64            `bson:"price" json:"price"`
-	Description     string             `bson:"description" json:"description"`
-	ServiceCategory primitive.ObjectID `bson:"servicecategory" json:"servicecategory"`
-	ImageURL        string             `bson:"imageurl" json:"imageurl"`
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id" form:"-"`
+	Name            string             `bson:"name" json:"name" form:"name" binding:"required,max=200"`
+	Price           Money              `bson:"price" json:"price" form:"price" binding:"gt=0"`
+	Description     string             `bson:"description" json:"description" form:"description" binding:"max=2000"`
+	ServiceCategory primitive.ObjectID `bson:"servicecategory" json:"servicecategory" form:"-"`
+	ImageURL        string             `bson:"imageurl" json:"imageurl" form:"-"`
+	ImageKey        string             `bson:"imagekey,omitempty" json:"imagekey,omitempty" form:"-"`
+	Schedule        *ServiceSchedule   `bson:"schedule,omitempty" json:"schedule,omitempty" form:"-"`
 }
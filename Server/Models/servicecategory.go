@@ -6,6 +6,6 @@ import (
 
 type ServiceCategory struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Name        string             `bson:"name" json:"name"`
-	Description string             `bson:"description" json:"description"`
+	Name        string             `bson:"name" json:"name" binding:"required,max=100"`
+	Description string             `bson:"description" json:"description" binding:"max=1000"`
 }
@@ -15,80 +15,134 @@ const (
 )
 
 type User struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	FirstName string             `json:"firstname,omitempty"`
-	LastName  string             `json:"lastname,omitempty"`
-	Email     string             `json:"email,omitempty"`
-	Password  string             `json:"password,omitempty"`
-	Phone     string             `json:"phone,omitempty"`
-	Address   string             `json:"address,omitempty"`
-	Role      Role               `json:"role,omitempty"`
-	Avatar    string             `json:"avatar,omitempty"`
-	Cart      Cart               `json:"cart,omitempty"`
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	FirstName     string             `json:"firstname,omitempty" binding:"required,max=50"`
+	LastName      string             `json:"lastname,omitempty" binding:"required,max=50"`
+	Email         string             `json:"email,omitempty" binding:"required,email"`
+	Password      string             `json:"password,omitempty" binding:"required,min=8,max=72"`
+	Phone         string             `json:"phone,omitempty" binding:"required,numeric,min=9,max=15"`
+	Address       string             `json:"address,omitempty" binding:"max=200"`
+	Role          Role               `json:"role,omitempty"`
+	Avatar        string             `json:"avatar,omitempty"`
+	AvatarKey     string             `bson:"avatar_key,omitempty" json:"-"`
+	EmailVerified bool               `bson:"email_verified" json:"email_verified"`
+	SuspendedAt   *time.Time         `bson:"suspended_at,omitempty" json:"suspended_at,omitempty"`
+	TwoFactor     *TwoFactor         `bson:"two_factor,omitempty" json:"-"`
+	Cart          Cart               `json:"cart,omitempty"`
+}
+
+// TwoFactor holds a user's authenticator app enrollment. PendingSecret is
+// set between setup and the first verified code; Secret only once enabled.
+type TwoFactor struct {
+	Enabled       bool       `bson:"enabled"`
+	Secret        string     `bson:"secret,omitempty"`
+	PendingSecret string     `bson:"pending_secret,omitempty"`
+	LastUsedStep  int64      `bson:"last_used_step,omitempty"`
+	RecoveryCodes []string   `bson:"recovery_codes,omitempty"`
+	EnabledAt     *time.Time `bson:"enabled_at,omitempty"`
 }
 
+// Cart is what a customer has put aside to buy. Subtotal and HasIssues are
+// worked out from the current products whenever the cart is read.
 type Cart struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
 	UserID    primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
 	Items     []CartItem         `bson:"items,omitempty" json:"items,omitempty"`
+	Subtotal  Money              `bson:"-" json:"subtotal"`
+	HasIssues bool               `bson:"-" json:"has_issues"`
 	CreatedAt time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty"`
 	UpdatedAt time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
 }
 
+// CartItem is one product in a cart. Only the product, the quantity and
+// AddedPrice, the unit price the customer last saw, are stored; the rest is
+// filled in from the product when the cart is read.
 type CartItem struct {
-	ProductID primitive.ObjectID `bson:"product_id,omitempty" json:"product_id,omitempty"`
-	Quantity  int                `bson:"quantity,omitempty" json:"quantity,omitempty"`
-	Price     float64            `bson:"price,omitempty" json:"price,omitempty"`
-	Name      string             `bson:"-" json:"name,omitempty"`
-	ImageURL  string             `bson:"-" json:"imageurl,omitempty"`
+	ProductID  primitive.ObjectID `bson:"product_id,omitempty" json:"product_id,omitempty"`
+	Quantity   int                `bson:"quantity,omitempty" json:"quantity,omitempty"`
+	AddedPrice Money              `bson:"price,omitempty" json:"added_price"`
+	Price      Money              `bson:"-" json:"price"`
+	LineTotal  Money              `bson:"-" json:"line_total"`
+	Available  int                `bson:"-" json:"available"`
+	Issues     []CartIssue        `bson:"-" json:"issues,omitempty"`
+	Name       string             `bson:"-" json:"name,omitempty"`
+	ImageURL   string             `bson:"-" json:"imageurl,omitempty"`
 }
 
-type SelectedItems struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	UserID    primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
-	Items     []SelectedItem     `bson:"items,omitempty" json:"items,omitempty"`
-	CreatedAt time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty"`
-	UpdatedAt time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
-}
+// CartIssue is something about a cart line the customer should look at
+// before checking out.
+type CartIssue string
 
-type SelectedItem struct {
-	ProductID primitive.ObjectID `bson:"product_id,omitempty" json:"product_id,omitempty"`
-	Quantity  int                `bson:"quantity,omitempty" json:"quantity,omitempty"`
-	Price     float64            `bson:"price,omitempty" json:"price,omitempty"`
-	Name      string             `bson:"name,omitempty" json:"name,omitempty"`
-	ImageURL  string             `bson:"imageurl,omitempty" json:"imageurl,omitempty"`
-}
+const (
+	CartPriceChanged      CartIssue = "price_changed"
+	CartOutOfStock        CartIssue = "out_of_stock"
+	CartInsufficientStock CartIssue = "insufficient_stock"
+	CartUnavailable       CartIssue = "unavailable"
+)
 
-type Order struct {
+// SelectedItems are the cart lines picked for checkout. Subtotal and
+// HasIssues are worked out from the current products whenever they are
+// read, as for the cart.
+type SelectedItems struct {
 	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
 	UserID     primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
-	Items      []OrderItem        `bson:"items,omitempty" json:"items,omitempty"`
-	TotalPrice float64            `bson:"total_price,omitempty" json:"total_price,omitempty"`
+	Items      []SelectedItem     `bson:"items,omitempty" json:"items,omitempty"`
+	CouponCode string             `bson:"coupon_code,omitempty" json:"coupon_code,omitempty"`
+	Subtotal   Money              `bson:"-" json:"subtotal"`
+	HasIssues  bool               `bson:"-" json:"has_issues"`
 	CreatedAt  time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty"`
 	UpdatedAt  time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
 }
 
+// SelectedItem is a cart line picked for checkout. It is stored, priced
+// and flagged exactly like one.
+type SelectedItem = CartItem
+
+// Order is a purchase of products. TotalPrice, from the embedded
+// PriceBreakdown, is what the customer pays.
+type Order struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID         primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Items          []OrderItem        `bson:"items,omitempty" json:"items,omitempty"`
+	PriceBreakdown `bson:",inline"`
+	CouponCode     string              `bson:"coupon_code,omitempty" json:"coupon_code,omitempty"`
+	Shipping       ShippingAddress     `bson:"shipping" json:"shipping"`
+	Status         OrderStatus         `bson:"status,omitempty" json:"status,omitempty"`
+	History        []OrderStatusChange `bson:"history,omitempty" json:"history,omitempty"`
+	// PaymentID is the online payment that paid for the order.
+	PaymentID primitive.ObjectID `bson:"payment_id,omitempty" json:"payment_id,omitempty"`
+	CreatedAt time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty"`
+	UpdatedAt time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
 type OrderItem struct {
 	ProductID primitive.ObjectID `bson:"product_id,omitempty" json:"product_id,omitempty"`
 	Quantity  int                `bson:"quantity,omitempty" json:"quantity,omitempty"`
-	Price     float64            `bson:"price,omitempty" json:"price,omitempty"`
+	Price     Money              `bson:"price,omitempty" json:"price"`
 	Name      string             `bson:"name,omitempty" json:"name,omitempty"`
 	ImageURL  string             `bson:"imageurl,omitempty" json:"imageurl,omitempty"`
 }
 
+// OrderBookingService is a booking of a service. TotalPrice is what the
+// customer pays, after Discount.
 type OrderBookingService struct {
-	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	UserID       primitive.ObjectID `bson:"user_id" json:"user_id"`
-	ServiceID    primitive.ObjectID `bson:"service_id" json:"service_id"`
-	Quantity     int                `bson:"quantity" json:"quantity"`
-	TotalPrice   float64            `bson:"total_price" json:"total_price"`
-	BookingDate  primitive.DateTime `bson:"booking_date" json:"booking_date"`
-	ContactName  string             `bson:"contact_name" json:"contact_name"`
-	ContactPhone string             `bson:"contact_phone" json:"contact_phone"`
-	Address      string             `bson:"address" json:"address"`
-	Status       string             `bson:"status" json:"status"`
-	CreatedAt    primitive.DateTime `bson:"created_at" json:"created_at"`
-	UpdatedAt    primitive.DateTime `bson:"updated_at" json:"updated_at"`
-	FinishAt     primitive.DateTime `bson:"finish_at" json:"finish_at"`
-	Note         string             `bson:"note" json:"note"`
+	ID           primitive.ObjectID    `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID       primitive.ObjectID    `bson:"user_id" json:"user_id"`
+	ServiceID    primitive.ObjectID    `bson:"service_id" json:"service_id" binding:"required"`
+	Quantity     int                   `bson:"quantity" json:"quantity" binding:"gt=0,lte=100"`
+	TotalPrice   Money                 `bson:"total_price" json:"total_price"`
+	CouponCode   string                `bson:"coupon_code,omitempty" json:"coupon_code,omitempty" binding:"max=30"`
+	Discount     Money                 `bson:"discount,omitempty" json:"discount"`
+	BookingDate  primitive.DateTime    `bson:"booking_date" json:"booking_date" binding:"required"`
+	ContactName  string                `bson:"contact_name" json:"contact_name" binding:"required,max=100"`
+	ContactPhone string                `bson:"contact_phone" json:"contact_phone" binding:"required,numeric,min=9,max=15"`
+	Address      string                `bson:"address" json:"address" binding:"required,max=300"`
+	Status       BookingStatus         `bson:"status" json:"status"`
+	AssignedTo   []primitive.ObjectID  `bson:"assigned_to,omitempty" json:"assigned_to,omitempty"`
+	StatusLabel  string                `bson:"-" json:"status_label,omitempty"`
+	History      []BookingStatusChange `bson:"history,omitempty" json:"history,omitempty"`
+	CreatedAt    primitive.DateTime    `bson:"created_at" json:"created_at"`
+	UpdatedAt    primitive.DateTime    `bson:"updated_at" json:"updated_at"`
+	FinishAt     primitive.DateTime    `bson:"finish_at" json:"finish_at"`
+	Note         string                `bson:"note" json:"note" binding:"max=1000"`
 }
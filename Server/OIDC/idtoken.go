@@ -0,0 +1,134 @@
+package OIDC
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// keyRefreshInterval limits how often an unknown key ID makes the key set
+// be fetched again, so forged tokens cannot flood the provider.
+const keyRefreshInterval = time.Minute
+
+// flexBool accepts both true and "true", since some providers send
+// email_verified as a string.
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case bool:
+		*b = flexBool(v)
+	case string:
+		*b = flexBool(v == "true")
+	}
+	return nil
+}
+
+type userClaims struct {
+	Email         string   `json:"email"`
+	EmailVerified flexBool `json:"email_verified"`
+	GivenName     string   `json:"given_name"`
+	FamilyName    string   `json:"family_name"`
+	Picture       string   `json:"picture"`
+}
+
+type idTokenClaims struct {
+	Nonce string `json:"nonce"`
+	userClaims
+	jwt.RegisteredClaims
+}
+
+type keySet struct {
+	uri   string
+	fetch func(ctx context.Context, endpoint, accessToken string, out interface{}) error
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+func newKeySet(uri string, fetch func(context.Context, string, string, interface{}) error) *keySet {
+	return &keySet{uri: uri, fetch: fetch}
+}
+
+// key returns the RSA key with the given ID, fetching the key set again
+// when the provider has rotated its keys.
+func (s *keySet) key(ctx context.Context, id string) (*rsa.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.keys[id]; ok {
+		return key, nil
+	}
+	if time.Since(s.fetchedAt) < keyRefreshInterval {
+		return nil, ErrInvalidIDToken
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := s.fetch(ctx, s.uri, "", &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			continue
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	s.keys = keys
+	s.fetchedAt = time.Now()
+
+	if key, ok := s.keys[id]; ok {
+		return key, nil
+	}
+	return nil, ErrInvalidIDToken
+}
+
+// verifyIDToken checks the signature, issuer, audience, expiry and nonce
+// of an ID token.
+func (p *Provider) verifyIDToken(ctx context.Context, doc *discovery, raw, nonce string) (*idTokenClaims, error) {
+	claims := &idTokenClaims{}
+	token, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, ErrInvalidIDToken
+		}
+		id, _ := token.Header["kid"].(string)
+		return p.keys.key(ctx, id)
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidIDToken
+	}
+
+	if !claims.VerifyIssuer(doc.Issuer, true) ||
+		!claims.VerifyAudience(p.cfg.ClientID, true) ||
+		!claims.VerifyExpiresAt(time.Now(), true) ||
+		claims.Subject == "" ||
+		claims.Nonce != nonce {
+		return nil, ErrInvalidIDToken
+	}
+	return claims, nil
+}
@@ -0,0 +1,219 @@
+package OIDC
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"Server/Config"
+)
+
+// ErrInvalidIDToken is returned when the ID token from the provider fails
+// any check, such as its signature, issuer, audience or nonce.
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+// Identity is what a provider asserts about the user who signed in.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+	Picture       string
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider runs the authorization code flow with PKCE against one OpenID
+// Connect provider. Its discovery document and signing keys are fetched on
+// first use and cached.
+type Provider struct {
+	Name   string
+	cfg    Config.OIDCProviderConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      *keySet
+}
+
+func NewProvider(name string, cfg Config.OIDCProviderConfig) *Provider {
+	return &Provider{Name: name, cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// New builds a provider for every entry in cfg.Providers.
+func New(cfg Config.OAuthConfig) map[string]*Provider {
+	providers := make(map[string]*Provider, len(cfg.Providers))
+	for name, providerCfg := range cfg.Providers {
+		providers[name] = NewProvider(name, providerCfg)
+	}
+	return providers
+}
+
+func (p *Provider) getJSON(ctx context.Context, endpoint, accessToken string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var doc discovery
+	endpoint := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, endpoint, "", &doc); err != nil {
+		return nil, err
+	}
+	if doc.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("provider %s: discovery issuer %q does not match %q", p.Name, doc.Issuer, p.cfg.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, fmt.Errorf("provider %s: discovery document is incomplete", p.Name)
+	}
+
+	p.discovery = &doc
+	p.keys = newKeySet(doc.JWKSURI, p.getJSON)
+	return p.discovery, nil
+}
+
+func (p *Provider) scopes() string {
+	scopes := p.cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	return strings.Join(scopes, " ")
+}
+
+// AuthCodeURL returns the provider page the user is sent to. state and
+// nonce tie the answer to this login attempt and challenge is the PKCE
+// code challenge of the verifier later passed to Exchange.
+func (p *Provider) AuthCodeURL(ctx context.Context, redirectURI, state, nonce, challenge string) (string, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {p.scopes()},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return doc.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange trades an authorization code for tokens, verifies the ID token
+// and returns the identity it asserts. Claims missing from the ID token
+// are read from the userinfo endpoint.
+func (p *Provider) Exchange(ctx context.Context, redirectURI, code, verifier, nonce string) (*Identity, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {verifier},
+	}
+	if p.cfg.ClientSecret != "" {
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("provider %s: token response: %w", p.Name, err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return nil, fmt.Errorf("provider %s: token exchange failed: %s %s", p.Name, resp.Status, tokens.Error)
+	}
+
+	claims, err := p.verifyIDToken(ctx, doc, tokens.IDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Email == "" && doc.UserinfoEndpoint != "" && tokens.AccessToken != "" {
+		var info struct {
+			Subject string `json:"sub"`
+			userClaims
+		}
+		if err := p.getJSON(ctx, doc.UserinfoEndpoint, tokens.AccessToken, &info); err != nil {
+			return nil, err
+		}
+		if info.Subject != claims.Subject {
+			return nil, ErrInvalidIDToken
+		}
+		claims.userClaims = info.userClaims
+	}
+
+	return &Identity{
+		Subject:       claims.Subject,
+		Email:         strings.TrimSpace(claims.Email),
+		EmailVerified: bool(claims.EmailVerified) || p.cfg.TrustEmail,
+		GivenName:     claims.GivenName,
+		FamilyName:    claims.FamilyName,
+		Picture:       claims.Picture,
+	}, nil
+}
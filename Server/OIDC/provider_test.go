@@ -0,0 +1,240 @@
+package OIDC
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"Server/Config"
+	"Server/OIDC/oidctest"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	testClientID    = "test-client"
+	testRedirectURI = "http://api.test/api/oauth/mock/callback"
+)
+
+// newTestProvider runs the mock provider and returns a Provider for it.
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Provider) {
+	t.Helper()
+	mock, err := oidctest.New("", testClientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(mock.Handler())
+	t.Cleanup(server.Close)
+	mock.Issuer = server.URL
+
+	return NewProvider("mock", Config.OIDCProviderConfig{Issuer: server.URL, ClientID: testClientID}), mock
+}
+
+// signIn takes email through the login page of the mock provider and
+// returns the authorization code it redirects back with.
+func signIn(t *testing.T, p *Provider, email, state, nonce, verifier string) string {
+	t.Helper()
+	authURL, err := p.AuthCodeURL(context.Background(), testRedirectURI, state, nonce, CodeChallenge(verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.PostForm(authURL, url.Values{"email": {email}, "email_verified": {"on"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("sign in: %s, location %q", resp.Status, resp.Header.Get("Location"))
+	}
+	if got := location.Query().Get("state"); got != state {
+		t.Fatalf("sign in: state %q, want %q", got, state)
+	}
+	return location.Query().Get("code")
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	p, mock := newTestProvider(t)
+	ctx := context.Background()
+	doc, err := p.discover(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second provider signs with its own key under the same key ID.
+	forger, err := oidctest.New(mock.Issuer, testClientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := func(change func(jwt.MapClaims)) jwt.MapClaims {
+		c := mock.Claims("user@example.com", "nonce-1", true)
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+	sign := func(c jwt.MapClaims) string {
+		raw, err := mock.SignIDToken(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+
+	tests := []struct {
+		name    string
+		raw     string
+		nonce   string
+		wantErr bool
+	}{
+		{name: "valid", raw: sign(claims(nil)), nonce: "nonce-1"},
+		{
+			name: "forged signature",
+			raw: func() string {
+				raw, err := forger.SignIDToken(claims(nil))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return raw
+			}(),
+			nonce:   "nonce-1",
+			wantErr: true,
+		},
+		{
+			name: "signed with a shared secret",
+			raw: func() string {
+				raw, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims(nil)).SignedString([]byte("secret"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return raw
+			}(),
+			nonce:   "nonce-1",
+			wantErr: true,
+		},
+		{name: "wrong audience", raw: sign(claims(func(c jwt.MapClaims) { c["aud"] = "other-client" })), nonce: "nonce-1", wantErr: true},
+		{name: "wrong issuer", raw: sign(claims(func(c jwt.MapClaims) { c["iss"] = "https://evil.test" })), nonce: "nonce-1", wantErr: true},
+		{name: "expired", raw: sign(claims(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() })), nonce: "nonce-1", wantErr: true},
+		{name: "no expiry", raw: sign(claims(func(c jwt.MapClaims) { delete(c, "exp") })), nonce: "nonce-1", wantErr: true},
+		{name: "no subject", raw: sign(claims(func(c jwt.MapClaims) { delete(c, "sub") })), nonce: "nonce-1", wantErr: true},
+		{name: "nonce mismatch", raw: sign(claims(nil)), nonce: "nonce-2", wantErr: true},
+		{
+			name:    "tampered payload",
+			raw:     splice(sign(claims(nil)), sign(claims(func(c jwt.MapClaims) { c["email"] = "admin@example.com" }))),
+			nonce:   "nonce-1",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.verifyIDToken(ctx, doc, tt.raw, tt.nonce)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidIDToken) {
+					t.Errorf("got %+v, %v; want %v", got, err, ErrInvalidIDToken)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Email != "user@example.com" || got.Subject != oidctest.Subject("user@example.com") {
+				t.Errorf("claims %+v", got)
+			}
+		})
+	}
+}
+
+// splice returns the token signed with the payload of other in its place.
+func splice(signed, other string) string {
+	parts := strings.Split(signed, ".")
+	parts[1] = strings.Split(other, ".")[1]
+	return strings.Join(parts, ".")
+}
+
+func TestVerifyIDTokenKeyRotation(t *testing.T) {
+	p, mock := newTestProvider(t)
+	ctx := context.Background()
+	doc, err := p.discover(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := mock.SignIDToken(mock.Claims("user@example.com", "n", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.verifyIDToken(ctx, doc, raw, "n"); err != nil {
+		t.Fatalf("before rotation: %v", err)
+	}
+
+	if err := mock.RotateKey(); err != nil {
+		t.Fatal(err)
+	}
+	raw, err = mock.SignIDToken(mock.Claims("user@example.com", "n", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unknown key IDs only refetch the key set once per interval.
+	if _, err := p.verifyIDToken(ctx, doc, raw, "n"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("right after rotation: %v, want %v", err, ErrInvalidIDToken)
+	}
+	p.keys.fetchedAt = time.Now().Add(-keyRefreshInterval)
+	if _, err := p.verifyIDToken(ctx, doc, raw, "n"); err != nil {
+		t.Errorf("after refresh interval: %v", err)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("valid", func(t *testing.T) {
+		p, _ := newTestProvider(t)
+		code := signIn(t, p, "User@Example.com", "state", "nonce", "verifier")
+		identity, err := p.Exchange(ctx, testRedirectURI, code, "verifier", "nonce")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if identity.Email != "User@Example.com" || !identity.EmailVerified || identity.Subject != oidctest.Subject("user@example.com") {
+			t.Errorf("identity %+v", identity)
+		}
+	})
+
+	t.Run("code used twice", func(t *testing.T) {
+		p, _ := newTestProvider(t)
+		code := signIn(t, p, "user@example.com", "state", "nonce", "verifier")
+		if _, err := p.Exchange(ctx, testRedirectURI, code, "verifier", "nonce"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Exchange(ctx, testRedirectURI, code, "verifier", "nonce"); err == nil {
+			t.Error("second exchange succeeded")
+		}
+	})
+
+	t.Run("wrong PKCE verifier", func(t *testing.T) {
+		p, _ := newTestProvider(t)
+		code := signIn(t, p, "user@example.com", "state", "nonce", "verifier")
+		if _, err := p.Exchange(ctx, testRedirectURI, code, "other-verifier", "nonce"); err == nil {
+			t.Error("exchange with the wrong verifier succeeded")
+		}
+	})
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		p, _ := newTestProvider(t)
+		code := signIn(t, p, "user@example.com", "state", "nonce", "verifier")
+		if _, err := p.Exchange(ctx, testRedirectURI, code, "verifier", "other-nonce"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("got %v, want %v", err, ErrInvalidIDToken)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package Payment
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"Server/Config"
+)
+
+// ErrInvalidSignature is returned when a callback or gateway response is
+// not signed with the merchant secret.
+var ErrInvalidSignature = errors.New("payment: invalid signature")
+
+// GatewayError is a request the gateway received but declined, such as a
+// refund of a transaction it cannot find.
+type GatewayError struct {
+	Code    string
+	Message string
+}
+
+func (e *GatewayError) Error() string {
+	return "payment gateway declined with " + e.Code + ": " + e.Message
+}
+
+// Gateway is an online payment provider. The customer pays on the page
+// returned by CreatePayment and the provider reports the outcome with a
+// signed callback, both as a browser redirect and as a server-to-server
+// notification (IPN).
+type Gateway interface {
+	Name() string
+	// CreatePayment returns the URL to send the customer to.
+	CreatePayment(ctx context.Context, req PaymentRequest) (string, error)
+	// VerifyCallback checks the signature of a return or IPN callback and
+	// returns the outcome it reports.
+	VerifyCallback(query url.Values) (Result, error)
+	// Acknowledge builds the reply the provider expects to an IPN.
+	Acknowledge(ack Ack) (int, interface{})
+	QueryStatus(ctx context.Context, req StatusRequest) (Result, error)
+	Refund(ctx context.Context, req RefundRequest) (Result, error)
+}
+
+// Status is the outcome of a transaction as reported by the gateway.
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusRefunded  Status = "refunded"
+)
+
+// Ack is how the shop handled an IPN.
+type Ack int
+
+const (
+	AckConfirmed Ack = iota
+	AckAlreadyConfirmed
+	AckNotFound
+	AckInvalidAmount
+	AckInvalidSignature
+	AckError
+)
+
+// PaymentRequest starts a payment. Amounts are whole dong.
+type PaymentRequest struct {
+	TxnRef    string
+	Amount    int64
+	OrderInfo string
+	ReturnURL string
+	ClientIP  string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+type StatusRequest struct {
+	TxnRef    string
+	OrderInfo string
+	ClientIP  string
+	// CreatedAt is when the payment was created, which the gateway uses to
+	// find the transaction.
+	CreatedAt time.Time
+}
+
+type RefundRequest struct {
+	TxnRef        string
+	TransactionNo string
+	Amount        int64
+	OrderInfo     string
+	ClientIP      string
+	CreatedAt     time.Time
+	// CreatedBy names who asked for the refund, for the gateway's records.
+	CreatedBy string
+}
+
+// Result is what the gateway reports about a transaction.
+type Result struct {
+	TxnRef        string
+	Amount        int64
+	Status        Status
+	TransactionNo string
+	ResponseCode  string
+	PaidAt        time.Time
+}
+
+// New builds the gateway selected by cfg.Driver. It returns nil when
+// online payment is turned off.
+func New(cfg Config.PaymentConfig) (Gateway, error) {
+	switch cfg.Driver {
+	case "vnpay":
+		return NewVNPay(cfg.VNPay), nil
+	case "none":
+		return nil, nil
+	default:
+		return nil, errors.New("unknown payment driver " + cfg.Driver)
+	}
+}
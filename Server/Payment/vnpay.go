@@ -0,0 +1,295 @@
+package Payment
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"Server/Config"
+)
+
+const (
+	vnpayVersion    = "2.1.0"
+	vnpayTimeFormat = "20060102150405"
+)
+
+// vnpayLocation is the zone VNPay timestamps are written in.
+var vnpayLocation = time.FixedZone("GMT+7", 7*60*60)
+
+// VNPay implements version 2.1.0 of the VNPay API. Redirects and callbacks
+// carry vnp_* query parameters signed with HMAC-SHA512 over the sorted,
+// URL-encoded parameters; the query and refund API takes JSON signed over
+// its fields joined with "|".
+type VNPay struct {
+	cfg    Config.VNPayConfig
+	client *http.Client
+}
+
+func NewVNPay(cfg Config.VNPayConfig) *VNPay {
+	return &VNPay{cfg: cfg, client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+func (v *VNPay) Name() string {
+	return "vnpay"
+}
+
+func (v *VNPay) sign(data string) string {
+	mac := hmac.New(sha512.New, []byte(v.cfg.HashSecret))
+	mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (v *VNPay) verify(data, signature string) bool {
+	expected, _ := hex.DecodeString(v.sign(data))
+	given, err := hex.DecodeString(signature)
+	return err == nil && hmac.Equal(expected, given)
+}
+
+func (v *VNPay) CreatePayment(ctx context.Context, req PaymentRequest) (string, error) {
+	params := url.Values{
+		"vnp_Version":    {vnpayVersion},
+		"vnp_Command":    {"pay"},
+		"vnp_TmnCode":    {v.cfg.TmnCode},
+		"vnp_Amount":     {strconv.FormatInt(req.Amount*100, 10)},
+		"vnp_CurrCode":   {"VND"},
+		"vnp_TxnRef":     {req.TxnRef},
+		"vnp_OrderInfo":  {req.OrderInfo},
+		"vnp_OrderType":  {"other"},
+		"vnp_Locale":     {"vn"},
+		"vnp_ReturnUrl":  {req.ReturnURL},
+		"vnp_IpAddr":     {req.ClientIP},
+		"vnp_CreateDate": {req.CreatedAt.In(vnpayLocation).Format(vnpayTimeFormat)},
+		"vnp_ExpireDate": {req.ExpiresAt.In(vnpayLocation).Format(vnpayTimeFormat)},
+	}
+
+	// Encode sorts by key, which is the order VNPay signs in.
+	query := params.Encode()
+	return v.cfg.PayURL + "?" + query + "&vnp_SecureHash=" + v.sign(query), nil
+}
+
+func (v *VNPay) VerifyCallback(query url.Values) (Result, error) {
+	signed := url.Values{}
+	for key, values := range query {
+		if strings.HasPrefix(key, "vnp_") && key != "vnp_SecureHash" && key != "vnp_SecureHashType" {
+			signed[key] = values
+		}
+	}
+	if !v.verify(signed.Encode(), query.Get("vnp_SecureHash")) || query.Get("vnp_TmnCode") != v.cfg.TmnCode {
+		return Result{}, ErrInvalidSignature
+	}
+
+	amount, err := strconv.ParseInt(query.Get("vnp_Amount"), 10, 64)
+	if err != nil {
+		return Result{}, fmt.Errorf("vnpay: invalid amount %q", query.Get("vnp_Amount"))
+	}
+
+	result := Result{
+		TxnRef:        query.Get("vnp_TxnRef"),
+		Amount:        amount / 100,
+		Status:        StatusFailed,
+		TransactionNo: query.Get("vnp_TransactionNo"),
+		ResponseCode:  query.Get("vnp_ResponseCode"),
+		PaidAt:        parseVNPayTime(query.Get("vnp_PayDate")),
+	}
+	if result.ResponseCode == "00" && query.Get("vnp_TransactionStatus") == "00" {
+		result.Status = StatusSucceeded
+	}
+	return result, nil
+}
+
+func (v *VNPay) Acknowledge(ack Ack) (int, interface{}) {
+	code, message := "99", "Unknown error"
+	switch ack {
+	case AckConfirmed:
+		code, message = "00", "Confirm Success"
+	case AckAlreadyConfirmed:
+		code, message = "02", "Order already confirmed"
+	case AckNotFound:
+		code, message = "01", "Order not found"
+	case AckInvalidAmount:
+		code, message = "04", "Invalid amount"
+	case AckInvalidSignature:
+		code, message = "97", "Invalid signature"
+	}
+	return http.StatusOK, map[string]string{"RspCode": code, "Message": message}
+}
+
+// vnpayResponse is the reply to both querydr and refund. Refund replies
+// leave out the promotion fields.
+type vnpayResponse struct {
+	ResponseID        string `json:"vnp_ResponseId"`
+	Command           string `json:"vnp_Command"`
+	ResponseCode      string `json:"vnp_ResponseCode"`
+	Message           string `json:"vnp_Message"`
+	TmnCode           string `json:"vnp_TmnCode"`
+	TxnRef            string `json:"vnp_TxnRef"`
+	Amount            string `json:"vnp_Amount"`
+	BankCode          string `json:"vnp_BankCode"`
+	PayDate           string `json:"vnp_PayDate"`
+	TransactionNo     string `json:"vnp_TransactionNo"`
+	TransactionType   string `json:"vnp_TransactionType"`
+	TransactionStatus string `json:"vnp_TransactionStatus"`
+	OrderInfo         string `json:"vnp_OrderInfo"`
+	PromotionCode     string `json:"vnp_PromotionCode"`
+	PromotionAmount   string `json:"vnp_PromotionAmount"`
+	SecureHash        string `json:"vnp_SecureHash"`
+}
+
+func (v *VNPay) QueryStatus(ctx context.Context, req StatusRequest) (Result, error) {
+	now := time.Now().In(vnpayLocation).Format(vnpayTimeFormat)
+	body := map[string]string{
+		"vnp_RequestId":       requestID(),
+		"vnp_Version":         vnpayVersion,
+		"vnp_Command":         "querydr",
+		"vnp_TmnCode":         v.cfg.TmnCode,
+		"vnp_TxnRef":          req.TxnRef,
+		"vnp_OrderInfo":       req.OrderInfo,
+		"vnp_TransactionDate": req.CreatedAt.In(vnpayLocation).Format(vnpayTimeFormat),
+		"vnp_CreateDate":      now,
+		"vnp_IpAddr":          req.ClientIP,
+	}
+	body["vnp_SecureHash"] = v.sign(strings.Join([]string{
+		body["vnp_RequestId"], body["vnp_Version"], body["vnp_Command"], body["vnp_TmnCode"],
+		body["vnp_TxnRef"], body["vnp_TransactionDate"], body["vnp_CreateDate"], body["vnp_IpAddr"],
+		body["vnp_OrderInfo"],
+	}, "|"))
+
+	resp, err := v.call(ctx, body)
+	if err != nil {
+		return Result{}, err
+	}
+	if !v.verify(strings.Join([]string{
+		resp.ResponseID, resp.Command, resp.ResponseCode, resp.Message, resp.TmnCode,
+		resp.TxnRef, resp.Amount, resp.BankCode, resp.PayDate, resp.TransactionNo,
+		resp.TransactionType, resp.TransactionStatus, resp.OrderInfo, resp.PromotionCode,
+		resp.PromotionAmount,
+	}, "|"), resp.SecureHash) {
+		return Result{}, ErrInvalidSignature
+	}
+	if resp.ResponseCode != "00" {
+		return Result{}, &GatewayError{Code: resp.ResponseCode, Message: resp.Message}
+	}
+
+	result := resp.result()
+	switch resp.TransactionStatus {
+	case "00":
+		result.Status = StatusSucceeded
+	case "01":
+		result.Status = StatusPending
+	case "05", "06":
+		result.Status = StatusRefunded
+	default:
+		result.Status = StatusFailed
+	}
+	return result, nil
+}
+
+func (v *VNPay) Refund(ctx context.Context, req RefundRequest) (Result, error) {
+	now := time.Now().In(vnpayLocation).Format(vnpayTimeFormat)
+	body := map[string]string{
+		"vnp_RequestId":       requestID(),
+		"vnp_Version":         vnpayVersion,
+		"vnp_Command":         "refund",
+		"vnp_TmnCode":         v.cfg.TmnCode,
+		"vnp_TransactionType": "02",
+		"vnp_TxnRef":          req.TxnRef,
+		"vnp_Amount":          strconv.FormatInt(req.Amount*100, 10),
+		"vnp_OrderInfo":       req.OrderInfo,
+		"vnp_TransactionNo":   req.TransactionNo,
+		"vnp_TransactionDate": req.CreatedAt.In(vnpayLocation).Format(vnpayTimeFormat),
+		"vnp_CreateBy":        req.CreatedBy,
+		"vnp_CreateDate":      now,
+		"vnp_IpAddr":          req.ClientIP,
+	}
+	body["vnp_SecureHash"] = v.sign(strings.Join([]string{
+		body["vnp_RequestId"], body["vnp_Version"], body["vnp_Command"], body["vnp_TmnCode"],
+		body["vnp_TransactionType"], body["vnp_TxnRef"], body["vnp_Amount"], body["vnp_TransactionNo"],
+		body["vnp_TransactionDate"], body["vnp_CreateBy"], body["vnp_CreateDate"], body["vnp_IpAddr"],
+		body["vnp_OrderInfo"],
+	}, "|"))
+
+	resp, err := v.call(ctx, body)
+	if err != nil {
+		return Result{}, err
+	}
+	if !v.verify(strings.Join([]string{
+		resp.ResponseID, resp.Command, resp.ResponseCode, resp.Message, resp.TmnCode,
+		resp.TxnRef, resp.Amount, resp.BankCode, resp.PayDate, resp.TransactionNo,
+		resp.TransactionType, resp.TransactionStatus, resp.OrderInfo,
+	}, "|"), resp.SecureHash) {
+		return Result{}, ErrInvalidSignature
+	}
+	if resp.ResponseCode != "00" {
+		return Result{}, &GatewayError{Code: resp.ResponseCode, Message: resp.Message}
+	}
+
+	result := resp.result()
+	result.Status = StatusRefunded
+	return result, nil
+}
+
+func (v *VNPay) call(ctx context.Context, body map[string]string) (*vnpayResponse, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.cfg.APIURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("vnpay %s: %s: %s", body["vnp_Command"], resp.Status, strings.TrimSpace(string(detail)))
+	}
+
+	var result vnpayResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("vnpay %s: %w", body["vnp_Command"], err)
+	}
+	return &result, nil
+}
+
+func (r *vnpayResponse) result() Result {
+	amount, _ := strconv.ParseInt(r.Amount, 10, 64)
+	return Result{
+		TxnRef:        r.TxnRef,
+		Amount:        amount / 100,
+		TransactionNo: r.TransactionNo,
+		ResponseCode:  r.ResponseCode,
+		PaidAt:        parseVNPayTime(r.PayDate),
+	}
+}
+
+func parseVNPayTime(value string) time.Time {
+	t, err := time.ParseInLocation(vnpayTimeFormat, value, vnpayLocation)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// requestID returns the unique vnp_RequestId every API call needs.
+func requestID() string {
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	return hex.EncodeToString(raw)
+}
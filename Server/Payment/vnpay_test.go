@@ -0,0 +1,201 @@
+package Payment
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"Server/Config"
+)
+
+func testVNPay() *VNPay {
+	return NewVNPay(Config.VNPayConfig{
+		TmnCode:    "TESTTMN",
+		HashSecret: "TESTSECRET",
+		PayURL:     "https://sandbox.vnpayment.vn/paymentv2/vpcpay.html",
+	})
+}
+
+func TestVNPaySign(t *testing.T) {
+	// Worked out independently with HMAC-SHA512 over the same data.
+	const want = "2efd16b3aff04f3496eaa9aa8bf95f7568a81385549be574d2de70919cc42d3b5241847cd15d4c36a8aeb72a7d8cd40447cf57e3dc1e23b4b9ec4b8af3576787"
+	v := testVNPay()
+	data := "vnp_Amount=15000000&vnp_Command=pay&vnp_TxnRef=ORDER1"
+
+	if got := v.sign(data); got != want {
+		t.Errorf("sign = %s, want %s", got, want)
+	}
+	if !v.verify(data, strings.ToUpper(want)) {
+		t.Error("verify rejected an upper case signature")
+	}
+	for _, signature := range []string{"", "zz", want[:len(want)-2], want[:len(want)-1] + "8"} {
+		if v.verify(data, signature) {
+			t.Errorf("verify accepted %q", signature)
+		}
+	}
+}
+
+func TestVNPayCreatePaymentSignature(t *testing.T) {
+	v := testVNPay()
+	created := time.Date(2024, 5, 1, 3, 4, 5, 0, time.UTC)
+	raw, err := v.CreatePayment(context.Background(), PaymentRequest{
+		TxnRef:    "ORDER1",
+		Amount:    150000,
+		OrderInfo: "Thanh toán đơn hàng ORDER1",
+		ReturnURL: "http://localhost:6969/payment/return",
+		ClientIP:  "127.0.0.1",
+		CreatedAt: created,
+		ExpiresAt: created.Add(15 * time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payURL, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := payURL.Query()
+	if got := query.Get("vnp_Amount"); got != "15000000" {
+		t.Errorf("vnp_Amount = %s, want 15000000", got)
+	}
+	if got := query.Get("vnp_CreateDate"); got != "20240501100405" {
+		t.Errorf("vnp_CreateDate = %s, want 20240501100405", got)
+	}
+
+	signature := query.Get("vnp_SecureHash")
+	query.Del("vnp_SecureHash")
+	if !v.verify(query.Encode(), signature) {
+		t.Error("payment URL signature does not verify")
+	}
+}
+
+// signedCallback returns the query of a callback for ORDER1 signed with the
+// test secret, after change has edited the signed parameters.
+func signedCallback(v *VNPay, change func(url.Values)) url.Values {
+	query := url.Values{
+		"vnp_TmnCode":           {"TESTTMN"},
+		"vnp_TxnRef":            {"ORDER1"},
+		"vnp_Amount":            {"15000000"},
+		"vnp_ResponseCode":      {"00"},
+		"vnp_TransactionStatus": {"00"},
+		"vnp_TransactionNo":     {"14123456"},
+		"vnp_PayDate":           {"20240501100405"},
+		"vnp_OrderInfo":         {"Thanh toán đơn hàng ORDER1"},
+	}
+	if change != nil {
+		change(query)
+	}
+	query.Set("vnp_SecureHashType", "HmacSHA512")
+	query.Set("vnp_SecureHash", v.sign(withoutHash(query).Encode()))
+	return query
+}
+
+func withoutHash(query url.Values) url.Values {
+	signed := url.Values{}
+	for key, values := range query {
+		if key != "vnp_SecureHash" && key != "vnp_SecureHashType" {
+			signed[key] = values
+		}
+	}
+	return signed
+}
+
+func TestVNPayVerifyCallback(t *testing.T) {
+	v := testVNPay()
+	paidAt := time.Date(2024, 5, 1, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		query   url.Values
+		want    Result
+		wantErr error
+	}{
+		{
+			name:  "succeeded",
+			query: signedCallback(v, nil),
+			want:  Result{TxnRef: "ORDER1", Amount: 150000, Status: StatusSucceeded, TransactionNo: "14123456", ResponseCode: "00", PaidAt: paidAt},
+		},
+		{
+			name: "cancelled by the customer",
+			query: signedCallback(v, func(q url.Values) {
+				q.Set("vnp_ResponseCode", "24")
+				q.Set("vnp_TransactionStatus", "02")
+			}),
+			want: Result{TxnRef: "ORDER1", Amount: 150000, Status: StatusFailed, TransactionNo: "14123456", ResponseCode: "24", PaidAt: paidAt},
+		},
+		{
+			name: "unsigned parameters are ignored",
+			query: func() url.Values {
+				q := signedCallback(v, nil)
+				q.Set("utm_source", "email")
+				return q
+			}(),
+			want: Result{TxnRef: "ORDER1", Amount: 150000, Status: StatusSucceeded, TransactionNo: "14123456", ResponseCode: "00", PaidAt: paidAt},
+		},
+		{
+			name: "tampered amount",
+			query: func() url.Values {
+				q := signedCallback(v, nil)
+				q.Set("vnp_Amount", "100")
+				return q
+			}(),
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "added signed parameter",
+			query: func() url.Values {
+				q := signedCallback(v, nil)
+				q.Set("vnp_BankCode", "NCB")
+				return q
+			}(),
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "signed with another secret",
+			query: func() url.Values {
+				q := signedCallback(v, nil)
+				other := NewVNPay(Config.VNPayConfig{HashSecret: "OTHER"})
+				q.Set("vnp_SecureHash", other.sign(withoutHash(q).Encode()))
+				return q
+			}(),
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "missing signature",
+			query: func() url.Values {
+				q := signedCallback(v, nil)
+				q.Del("vnp_SecureHash")
+				return q
+			}(),
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "another merchant",
+			query:   signedCallback(v, func(q url.Values) { q.Set("vnp_TmnCode", "OTHERTMN") }),
+			wantErr: ErrInvalidSignature,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := v.VerifyCallback(tt.query)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if !got.PaidAt.Equal(tt.want.PaidAt) {
+				t.Errorf("PaidAt = %v, want %v", got.PaidAt, tt.want.PaidAt)
+			}
+			got.PaidAt, tt.want.PaidAt = time.Time{}, time.Time{}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := v.VerifyCallback(signedCallback(v, func(q url.Values) { q.Set("vnp_Amount", "abc") })); err == nil || errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("invalid amount: error = %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package Pricing
+
+import (
+	"strings"
+
+	"Server/Config"
+	"Server/Models"
+)
+
+// Line is one line of a purchase: the price of every unit together and
+// their shipping weight in grams.
+type Line struct {
+	Amount Models.Money
+	Weight int
+}
+
+// Input is what a purchase is priced from. Discount is the coupon discount
+// already worked out for the lines.
+type Input struct {
+	Lines    []Line
+	Discount Models.Money
+	Province string
+}
+
+// step is one stage of the pipeline. Each stage reads what the earlier
+// ones filled in.
+type step func(p *Pipeline, in Input, b *Models.PriceBreakdown)
+
+// Pipeline prices purchases with the configured shipping rates and VAT. The
+// quote endpoints and checkout share it, so a quote always matches the
+// order it turns into.
+type Pipeline struct {
+	cfg   Config.PricingConfig
+	steps []step
+}
+
+func New(cfg Config.PricingConfig) *Pipeline {
+	return &Pipeline{
+		cfg:   cfg,
+		steps: []step{subtotal, discount, shipping, vat, total},
+	}
+}
+
+// Price runs every stage in order and returns the breakdown.
+func (p *Pipeline) Price(in Input) Models.PriceBreakdown {
+	var b Models.PriceBreakdown
+	for _, run := range p.steps {
+		run(p, in, &b)
+	}
+	return b
+}
+
+func subtotal(p *Pipeline, in Input, b *Models.PriceBreakdown) {
+	for _, line := range in.Lines {
+		b.Subtotal = b.Subtotal.Add(line.Amount)
+	}
+}
+
+func discount(p *Pipeline, in Input, b *Models.PriceBreakdown) {
+	b.Discount = in.Discount.Min(b.Subtotal).Max(Models.Money{})
+}
+
+func shipping(p *Pipeline, in Input, b *Models.PriceBreakdown) {
+	if len(in.Lines) == 0 {
+		return
+	}
+	rate := p.rateFor(in.Province)
+	if rate.FreeOver > 0 && b.Subtotal.Sub(b.Discount).Cmp(money(rate.FreeOver)) >= 0 {
+		return
+	}
+
+	weight := int64(0)
+	for _, line := range in.Lines {
+		weight += int64(line.Weight)
+	}
+	fee := money(rate.Flat)
+	if extra := weight - rate.IncludedWeight; extra > 0 {
+		fee = fee.Add(money(rate.PerKg).Mul(int((extra + 999) / 1000)))
+	}
+	b.ShippingFee = fee
+}
+
+// vat works out the tax on the discounted goods and the shipping fee. When
+// prices include VAT it is the share of that amount that is tax.
+func vat(p *Pipeline, in Input, b *Models.PriceBreakdown) {
+	taxable := b.Subtotal.Sub(b.Discount).Add(b.ShippingFee)
+	rate := p.cfg.VATRate / 100
+	b.VATIncluded = p.cfg.PricesIncludeVAT
+	if b.VATIncluded {
+		b.VAT = taxable.MulRate(rate / (1 + rate))
+	} else {
+		b.VAT = taxable.MulRate(rate)
+	}
+}
+
+func total(p *Pipeline, in Input, b *Models.PriceBreakdown) {
+	b.TotalPrice = b.Subtotal.Sub(b.Discount).Add(b.ShippingFee)
+	if !b.VATIncluded {
+		b.TotalPrice = b.TotalPrice.Add(b.VAT)
+	}
+}
+
+// money turns a configured amount into Money in the shop currency.
+func money(amount int64) Models.Money {
+	return Models.NewMoney(amount, Models.DefaultCurrency)
+}
+
+func (p *Pipeline) rateFor(province string) Config.ShippingRate {
+	province = strings.TrimSpace(province)
+	if province != "" {
+		for _, rule := range p.cfg.Shipping.Provinces {
+			for _, name := range rule.Provinces {
+				if strings.EqualFold(strings.TrimSpace(name), province) {
+					return rule.Rate
+				}
+			}
+		}
+	}
+	return p.cfg.Shipping.Default
+}
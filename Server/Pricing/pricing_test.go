@@ -0,0 +1,109 @@
+package Pricing
+
+import (
+	"testing"
+
+	"Server/Config"
+	"Server/Models"
+)
+
+func testConfig(pricesIncludeVAT bool) Config.PricingConfig {
+	return Config.PricingConfig{
+		VATRate:          10,
+		PricesIncludeVAT: pricesIncludeVAT,
+		Shipping: Config.ShippingConfig{
+			Default: Config.ShippingRate{Flat: 30000, PerKg: 5000, IncludedWeight: 2000, FreeOver: 500000},
+			Provinces: []Config.ProvinceShippingRate{{
+				Provinces: []string{"Hà Nội", "Hồ Chí Minh"},
+				Rate:      Config.ShippingRate{Flat: 15000, PerKg: 2000, IncludedWeight: 1000, FreeOver: 300000},
+			}},
+		},
+	}
+}
+
+func TestPipelinePrice(t *testing.T) {
+	tests := []struct {
+		name             string
+		pricesIncludeVAT bool
+		in               Input
+		want             [5]int64 // subtotal, discount, shipping fee, VAT, total
+	}{
+		{
+			name: "flat rate within included weight",
+			in:   Input{Lines: []Line{{Amount: Models.Dong(200000), Weight: 1500}}},
+			want: [5]int64{200000, 0, 30000, 23000, 253000},
+		},
+		{
+			name: "one gram over is a whole kilogram",
+			in:   Input{Lines: []Line{{Amount: Models.Dong(200000), Weight: 2001}}},
+			want: [5]int64{200000, 0, 35000, 23500, 258500},
+		},
+		{
+			name: "weight of every line counts",
+			in: Input{Lines: []Line{
+				{Amount: Models.Dong(100000), Weight: 2000},
+				{Amount: Models.Dong(100000), Weight: 2500},
+			}},
+			want: [5]int64{200000, 0, 45000, 24500, 269500},
+		},
+		{
+			name: "free over threshold",
+			in:   Input{Lines: []Line{{Amount: Models.Dong(500000), Weight: 9000}}},
+			want: [5]int64{500000, 0, 0, 50000, 550000},
+		},
+		{
+			name: "discount takes the order under the threshold",
+			in:   Input{Lines: []Line{{Amount: Models.Dong(520000), Weight: 1000}}, Discount: Models.Dong(30000)},
+			want: [5]int64{520000, 30000, 30000, 52000, 572000},
+		},
+		{
+			name: "discount is capped at the subtotal",
+			in:   Input{Lines: []Line{{Amount: Models.Dong(50000), Weight: 100}}, Discount: Models.Dong(80000)},
+			want: [5]int64{50000, 50000, 30000, 3000, 33000},
+		},
+		{
+			name: "province rule",
+			in:   Input{Lines: []Line{{Amount: Models.Dong(100000), Weight: 2500}}, Province: " hà nội "},
+			want: [5]int64{100000, 0, 19000, 11900, 130900},
+		},
+		{
+			name: "province rule free over its own threshold",
+			in:   Input{Lines: []Line{{Amount: Models.Dong(300000), Weight: 2500}}, Province: "Hồ Chí Minh"},
+			want: [5]int64{300000, 0, 0, 30000, 330000},
+		},
+		{
+			name: "unknown province uses the default rate",
+			in:   Input{Lines: []Line{{Amount: Models.Dong(100000), Weight: 500}}, Province: "Đà Nẵng"},
+			want: [5]int64{100000, 0, 30000, 13000, 143000},
+		},
+		{
+			name:             "VAT included is the tax share of the total",
+			pricesIncludeVAT: true,
+			in:               Input{Lines: []Line{{Amount: Models.Dong(110000), Weight: 500}}},
+			want:             [5]int64{110000, 0, 30000, 12727, 140000},
+		},
+		{
+			name:             "VAT included after discount",
+			pricesIncludeVAT: true,
+			in:               Input{Lines: []Line{{Amount: Models.Dong(550000), Weight: 500}}, Discount: Models.Dong(50000)},
+			want:             [5]int64{550000, 50000, 0, 45455, 500000},
+		},
+		{
+			name: "nothing to price",
+			in:   Input{},
+			want: [5]int64{0, 0, 0, 0, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New(testConfig(tt.pricesIncludeVAT)).Price(tt.in)
+			got := [5]int64{b.Subtotal.Amount, b.Discount.Amount, b.ShippingFee.Amount, b.VAT.Amount, b.TotalPrice.Amount}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if b.VATIncluded != tt.pricesIncludeVAT {
+				t.Errorf("VATIncluded = %t, want %t", b.VATIncluded, tt.pricesIncludeVAT)
+			}
+		})
+	}
+}
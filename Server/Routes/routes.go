@@ -1,73 +1,155 @@
 package Routes
 
 import (
+	"time"
+
 	"Server/Controllers"
 	"Server/Middleware"
+	"Server/Models"
 
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRoutes(router *gin.Engine) {
+func SetupRoutes(router *gin.Engine, h *Controllers.Handler, auth *Middleware.Auth, limits Middleware.RateLimitStore) {
+	limit := func(name string, perMinute int, key Middleware.RateLimitKey) gin.HandlerFunc {
+		return Middleware.RateLimit(limits, name, perMinute, time.Minute, key)
+	}
+
+	router.POST("/upload", limit("upload", 20, Middleware.ByIP), h.UploadImage)
+
 	api := router.Group("/api")
 	{
 		// User routes
-		api.POST("/register", Controllers.RegisterUser)
-		api.POST("/login", Controllers.LoginUser)
-		api.GET("/users", Middleware.AuthMiddleware(Middleware.Admin), Controllers.GetAllUsers)
-		api.GET("/user/:id", Middleware.AuthMiddleware(Middleware.Admin), Controllers.GetUserByID)
-		api.PUT("/user/:id", Middleware.AuthMiddleware(Middleware.Admin), Controllers.UpdateUser)
-		api.DELETE("/user/:id", Middleware.AuthMiddleware(Middleware.Admin), Controllers.DeleteUser)
+		api.POST("/register", Middleware.RateLimit(limits, "register", 5, time.Hour, Middleware.ByIP), h.RegisterUser)
+		api.POST("/login", limit("login", 10, Middleware.ByIP), h.LoginUser)
+		api.POST("/login/2fa", limit("login-2fa", 10, Middleware.ByIP), h.VerifyLoginTwoFactor)
+		api.POST("/login/2fa/setup", limit("login-2fa", 10, Middleware.ByIP), h.SetupLoginTwoFactor)
+		api.POST("/login/2fa/enable", limit("login-2fa", 10, Middleware.ByIP), h.EnableLoginTwoFactor)
+		api.GET("/oauth/providers", h.GetOAuthProviders)
+		api.GET("/oauth/:provider/start", limit("oauth", 20, Middleware.ByIP), h.StartOAuthLogin)
+		api.GET("/oauth/:provider/callback", limit("oauth", 20, Middleware.ByIP), h.OAuthCallback)
+		api.POST("/oauth/login", limit("oauth-login", 10, Middleware.ByIP), h.CompleteOAuthLogin)
+		api.POST("/token/refresh", limit("refresh", 30, Middleware.ByIP), h.RefreshToken)
+		api.POST("/logout", h.Logout)
+		api.POST("/verify-email", h.VerifyEmail)
+		api.POST("/verify-email/request", auth.Require(), Middleware.RateLimit(limits, "verify-email", 3, time.Hour, Middleware.ByUser), h.RequestEmailVerification)
+		api.POST("/password/forgot", Middleware.RateLimit(limits, "forgot-password", 5, time.Hour, Middleware.ByIP), h.ForgotPassword)
+		api.POST("/password/reset", limit("reset-password", 10, Middleware.ByIP), h.ResetPassword)
+		api.GET("/users", auth.Require(Models.PermUsersManage), h.GetAllUsers)
+		api.GET("/user/:id", auth.Require(Models.PermUsersManage), h.GetUserByID)
+		api.PUT("/user/:id", auth.Require(Models.PermUsersManage), h.UpdateUser)
+		api.DELETE("/user/:id", auth.Require(Models.PermUsersManage), h.DeleteUser)
+		api.POST("/user/:id/logout", auth.Require(Models.PermUsersManage), h.ForceLogoutUser)
+		api.PUT("/user/:id/role", auth.Require(Models.PermUsersManage), h.ChangeUserRole)
+		api.POST("/user/:id/suspend", auth.Require(Models.PermUsersManage), h.SuspendUser)
+		api.POST("/user/:id/reactivate", auth.Require(Models.PermUsersManage), h.ReactivateUser)
+		api.GET("/user/:id/audit", auth.Require(Models.PermUsersManage), h.GetUserAudit)
+
+		// Profile routes
+		api.GET("/me", auth.Require(), h.GetMe)
+		api.PATCH("/me", auth.Require(), h.UpdateMe)
+		api.PUT("/me/password", auth.Require(), limit("change-password", 5, Middleware.ByUser), h.ChangePassword)
+		api.POST("/me/avatar", auth.Require(), limit("upload", 20, Middleware.ByUser), h.UploadAvatar)
+		api.GET("/me/2fa", auth.Require(), h.GetMyTwoFactor)
+		api.POST("/me/2fa/setup", auth.Require(), h.SetupMyTwoFactor)
+		api.POST("/me/2fa/enable", auth.Require(), limit("2fa", 10, Middleware.ByUser), h.EnableMyTwoFactor)
+		api.POST("/me/2fa/disable", auth.Require(), limit("2fa", 10, Middleware.ByUser), h.DisableMyTwoFactor)
+		api.POST("/me/2fa/recovery-codes", auth.Require(), limit("2fa", 10, Middleware.ByUser), h.RegenerateRecoveryCodes)
+
+		// Role routes
+		api.GET("/roles", auth.Require(Models.PermRolesManage), h.GetRoles)
+		api.POST("/roles", auth.Require(Models.PermRolesManage), h.CreateRole)
+		api.PUT("/roles/:id", auth.Require(Models.PermRolesManage), h.UpdateRole)
+		api.PUT("/roles/:id/two-factor", auth.Require(Models.PermRolesManage), h.SetRoleTwoFactor)
+		api.DELETE("/roles/:id", auth.Require(Models.PermRolesManage), h.DeleteRole)
+
+		// Session routes
+		api.GET("/sessions", auth.Require(), h.GetMySessions)
+		api.DELETE("/sessions/others", auth.Require(), h.RevokeOtherSessions)
+		api.DELETE("/sessions/:id", auth.Require(), h.RevokeMySession)
 
 		// ProductCategory routes
-		api.GET("/productcategories", Controllers.GetAllProductCategories)
-		api.GET("/productcategory/:id", Controllers.GetProductCategoryByID)
-		api.POST("/productcategory", Middleware.AuthMiddleware(Middleware.Admin), Controllers.CreateProductCategory)
-		api.PUT("/productcategory/:id", Middleware.AuthMiddleware(Middleware.Admin), Controllers.UpdateProductCategory)
-		api.DELETE("/productcategory/:id", Middleware.AuthMiddleware(Middleware.Admin), Controllers.DeleteProductCategory)
+		api.GET("/productcategories", h.GetAllProductCategories)
+		api.GET("/productcategory/:id", h.GetProductCategoryByID)
+		api.POST("/productcategory", auth.Require(Models.PermCategoriesWrite), h.CreateProductCategory)
+		api.PUT("/productcategory/:id", auth.Require(Models.PermCategoriesWrite), h.UpdateProductCategory)
+		api.DELETE("/productcategory/:id", auth.Require(Models.PermCategoriesWrite), h.DeleteProductCategory)
 
 		// Product routes
-		api.GET("/products", Controllers.GetAllProducts)
-		api.GET("/product/:id", Controllers.GetProductByID)
-		api.POST("/product", Middleware.AuthMiddleware(Middleware.Staff), Controllers.CreateProduct)
-		api.PUT("/product/:id", Middleware.AuthMiddleware(Middleware.Staff), Controllers.UpdateProduct)
-		api.DELETE("/product/:id", Middleware.AuthMiddleware(Middleware.Staff), Controllers.DeleteProduct)
+		api.GET("/products", h.GetAllProducts)
+		api.GET("/product/:id", h.GetProductByID)
+		api.POST("/product", auth.Require(Models.PermProductsWrite), h.CreateProduct)
+		api.PUT("/product/:id", auth.Require(Models.PermProductsWrite), h.UpdateProduct)
+		api.DELETE("/product/:id", auth.Require(Models.PermProductsDelete), h.DeleteProduct)
 
 		// ServiceCategory routes
-		api.GET("/servicecategories", Controllers.GetAllServiceCategories)
-		api.GET("/servicecategory/:id", Controllers.GetServiceCategoryByID)
-		api.POST("/servicecategory", Middleware.AuthMiddleware(Middleware.Admin), Controllers.CreateServiceCategory)
-		api.PUT("/servicecategory/:id", Middleware.AuthMiddleware(Middleware.Admin), Controllers.UpdateServiceCategory)
-		api.DELETE("/servicecategory/:id", Middleware.AuthMiddleware(Middleware.Admin), Controllers.DeleteServiceCategory)
+		api.GET("/servicecategories", h.GetAllServiceCategories)
+		api.GET("/servicecategory/:id", h.GetServiceCategoryByID)
+		api.POST("/servicecategory", auth.Require(Models.PermCategoriesWrite), h.CreateServiceCategory)
+		api.PUT("/servicecategory/:id", auth.Require(Models.PermCategoriesWrite), h.UpdateServiceCategory)
+		api.DELETE("/servicecategory/:id", auth.Require(Models.PermCategoriesWrite), h.DeleteServiceCategory)
 
 		// Service routes
-		api.GET("/services", Controllers.GetAllServices)
-		api.GET("/service/:id", Controllers.GetServiceByID)
-		api.POST("/service", Middleware.AuthMiddleware(Middleware.Staff), Controllers.CreateService)
-		api.PUT("/service/:id", Middleware.AuthMiddleware(Middleware.Staff), Controllers.UpdateService)
-		api.DELETE("/service/:id", Middleware.AuthMiddleware(Middleware.Staff), Controllers.DeleteService)
+		api.GET("/services", h.GetAllServices)
+		api.GET("/service/:id", h.GetServiceByID)
+		api.POST("/service", auth.Require(Models.PermServicesWrite), h.CreateService)
+		api.PUT("/service/:id", auth.Require(Models.PermServicesWrite), h.UpdateService)
+		api.DELETE("/service/:id", auth.Require(Models.PermServicesDelete), h.DeleteService)
+		api.GET("/service/:id/slots", h.GetServiceSlots)
+		api.PUT("/service/:id/schedule", auth.Require(Models.PermServicesWrite), h.UpdateServiceSchedule)
 
 		// Cart routes
-		api.GET("/cart", Middleware.AuthMiddleware(Middleware.Customer), Controllers.GetCart)
-		api.POST("/cart/add", Middleware.AuthMiddleware(Middleware.Customer), Controllers.AddToCart)
-		api.DELETE("/cart/remove", Middleware.AuthMiddleware(Middleware.Customer), Controllers.RemoveFromCart)
-		api.POST("/cart/update", Middleware.AuthMiddleware(Middleware.Customer), Controllers.UpdateCart)
+		api.GET("/cart", auth.Require(), h.GetCart)
+		api.POST("/cart/add", auth.Require(), limit("cart", 60, Middleware.ByUser), h.AddToCart)
+		api.DELETE("/cart/remove", auth.Require(), h.RemoveFromCart)
+		api.POST("/cart/update", auth.Require(), h.UpdateCart)
+		api.POST("/cart/refresh", auth.Require(), h.RefreshCartPrices)
 
 		// Order routes
-		api.POST("/order", Middleware.AuthMiddleware(Middleware.Customer), Controllers.CreateOrder)
-		api.GET("/orders", Middleware.AuthMiddleware(Middleware.Customer), Controllers.GetOrders)
-		api.DELETE("/order/:id", Middleware.AuthMiddleware(Middleware.Customer), Controllers.CancelOrder)
+		api.POST("/order", auth.Require(), limit("order", 10, Middleware.ByUser), h.CreateOrder)
+		api.POST("/order/quote", auth.Require(), limit("quote", 60, Middleware.ByUser), h.QuoteOrder)
+		api.GET("/orders", auth.Require(), h.GetOrders)
+		api.DELETE("/order/:id", auth.Require(), h.CancelOrder)
+		api.PATCH("/order/:id/status", auth.Require(Models.PermOrdersManage), h.UpdateOrderStatus)
+		api.POST("/order/:id/pay", auth.Require(), limit("payment", 10, Middleware.ByUser), h.StartOrderPayment)
+		api.GET("/order/:id/payments", auth.Require(), h.GetOrderPayments)
+
+		// Payment routes
+		api.GET("/payments/:provider/ipn", h.PaymentIPN)
+		api.GET("/payments/:provider/return", h.PaymentReturn)
+		api.POST("/payments/:id/refresh", auth.Require(), limit("payment", 10, Middleware.ByUser), h.RefreshPayment)
+		api.POST("/payments/:id/refund", auth.Require(Models.PermOrdersManage), h.RefundPayment)
 
 		// SelectedItems routes
-		api.GET("/selecteditems", Middleware.AuthMiddleware(Middleware.Customer), Controllers.GetSelectedItems)
-		api.POST("/selecteditems/add", Middleware.AuthMiddleware(Middleware.Customer), Controllers.AddToSelectedItems)
-		api.POST("/selecteditems/addMultiple", Middleware.AuthMiddleware(Middleware.Customer), Controllers.AddMultipleToSelectedItems)
-		api.DELETE("/selecteditems/remove", Middleware.AuthMiddleware(Middleware.Customer), Controllers.RemoveFromSelectedItems)
-		api.POST("/selecteditems/update", Middleware.AuthMiddleware(Middleware.Customer), Controllers.UpdateSelectedItems)
-		api.DELETE("/selecteditems/clear", Middleware.AuthMiddleware(Middleware.Customer), Controllers.ClearSelectedItems)
+		api.GET("/selecteditems", auth.Require(), h.GetSelectedItems)
+		api.POST("/selecteditems/add", auth.Require(), h.AddToSelectedItems)
+		api.POST("/selecteditems/addMultiple", auth.Require(), h.AddMultipleToSelectedItems)
+		api.DELETE("/selecteditems/remove", auth.Require(), h.RemoveFromSelectedItems)
+		api.POST("/selecteditems/update", auth.Require(), h.UpdateSelectedItems)
+		api.DELETE("/selecteditems/clear", auth.Require(), h.ClearSelectedItems)
+		api.POST("/selecteditems/coupon", auth.Require(), limit("coupon", 20, Middleware.ByUser), h.ApplySelectedItemsCoupon)
+		api.DELETE("/selecteditems/coupon", auth.Require(), h.RemoveSelectedItemsCoupon)
 
 		// OrderBookingService routes
-		api.POST("/orderbookingservice", Middleware.AuthMiddleware(Middleware.Customer), Controllers.CreateOrderBookingService)
-		api.GET("/orderbookingservices", Middleware.AuthMiddleware(Middleware.Customer), Controllers.GetOrderBookingServices)
-		api.PATCH("/orderbookingservice/:id/status", Middleware.AuthMiddleware(Middleware.Admin), Controllers.UpdateOrderBookingServiceStatus)
+		api.POST("/orderbookingservice", auth.Require(), limit("booking", 10, Middleware.ByUser), h.CreateOrderBookingService)
+		api.POST("/orderbookingservice/quote", auth.Require(), limit("coupon", 20, Middleware.ByUser), h.QuoteBooking)
+		api.GET("/orderbookingservices", auth.Require(), h.GetOrderBookingServices)
+		api.PATCH("/orderbookingservice/:id/status", auth.Require(Models.PermBookingsManage), h.UpdateOrderBookingServiceStatus)
+		api.PUT("/orderbookingservice/:id/assign", auth.Require(Models.PermBookingsAssign), h.AssignBookingStaff)
+
+		// Coupon routes
+		api.GET("/coupons", auth.Require(Models.PermCouponsManage), h.GetCoupons)
+		api.POST("/coupons", auth.Require(Models.PermCouponsManage), h.CreateCoupon)
+		api.GET("/coupons/:id", auth.Require(Models.PermCouponsManage), h.GetCouponByID)
+		api.PUT("/coupons/:id", auth.Require(Models.PermCouponsManage), h.UpdateCoupon)
+		api.DELETE("/coupons/:id", auth.Require(Models.PermCouponsManage), h.DeleteCoupon)
+
+		// Admin order management routes
+		api.GET("/admin/orders", auth.Require(Models.PermOrdersManage), h.AdminGetOrders)
+		api.GET("/admin/orderbookingservices", auth.Require(Models.PermBookingsRead), h.AdminGetOrderBookingServices)
+
+		// Staff assignment routes
+		api.GET("/staff/assignments", auth.Require(Models.PermBookingsWork), h.GetMyAssignments)
+		api.PATCH("/staff/assignments/:id/status", auth.Require(Models.PermBookingsWork), h.UpdateMyAssignmentStatus)
 	}
 }
@@ -0,0 +1,65 @@
+package Storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cloudinary/cloudinary-go/v2"
+	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+)
+
+type CloudinaryStorage struct {
+	cld *cloudinary.Cloudinary
+}
+
+func NewCloudinaryStorage(cloudName, apiKey, apiSecret string) (*CloudinaryStorage, error) {
+	if cloudName == "" || apiKey == "" || apiSecret == "" {
+		return nil, errors.New("cloudinary cloud name, API key and API secret are required")
+	}
+	cld, err := cloudinary.NewFromParams(cloudName, apiKey, apiSecret)
+	if err != nil {
+		return nil, err
+	}
+	return &CloudinaryStorage{cld: cld}, nil
+}
+
+func (s *CloudinaryStorage) Upload(ctx context.Context, file io.Reader, name string) (StoredImage, error) {
+	publicID := fmt.Sprintf("%d-%s", time.Now().Unix(), strings.TrimSuffix(path.Base(name), path.Ext(name)))
+	result, err := s.cld.Upload.Upload(ctx, file, uploader.UploadParams{PublicID: publicID})
+	if err != nil {
+		return StoredImage{}, err
+	}
+	if result.Error.Message != "" {
+		return StoredImage{}, errors.New(result.Error.Message)
+	}
+	return StoredImage{Key: result.PublicID, URL: result.SecureURL}, nil
+}
+
+func (s *CloudinaryStorage) Delete(ctx context.Context, key string) error {
+	result, err := s.cld.Upload.Destroy(ctx, uploader.DestroyParams{PublicID: key})
+	if err != nil {
+		return err
+	}
+	if result.Result == "not found" {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SignedURL returns a signed delivery URL. Cloudinary signatures do not
+// expire on their own, so expiry is only honoured by token-based
+// authentication configured on the Cloudinary account.
+func (s *CloudinaryStorage) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	image, err := s.cld.Image(key)
+	if err != nil {
+		return "", err
+	}
+	image.Config.URL.Secure = true
+	image.Config.URL.SignURL = true
+	return image.String()
+}
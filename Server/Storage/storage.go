@@ -0,0 +1,78 @@
+package Storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"Server/Config"
+)
+
+// ImageStorage stores uploaded images and hands out URLs to them.
+type ImageStorage interface {
+	// Upload stores the content of file under a key derived from name. The
+	// content must be an image DetectImage accepts.
+	Upload(ctx context.Context, file io.Reader, name string) (StoredImage, error)
+	Delete(ctx context.Context, key string) error
+	// SignedURL returns a signed URL to key carrying expiry. Stored images
+	// are public, so the URL Upload returned keeps working regardless; only
+	// drivers that enforce expiry reject the signed URL once it passes.
+	SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
+
+type StoredImage struct {
+	Key string `json:"key"`
+	URL string `json:"url"`
+}
+
+var (
+	ErrNotFound      = errors.New("image not found")
+	ErrNotImage      = errors.New("file is not a JPEG, PNG, GIF or WebP image")
+	ErrImageTooLarge = errors.New("image is too large")
+)
+
+// MaxImageSize is the largest image that can be uploaded, in bytes.
+const MaxImageSize = 5 << 20
+
+// imageExtensions are the content types that can be uploaded and the
+// extension each is stored with. SVG is left out on purpose: it can carry
+// scripts.
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// DetectImage sniffs the content type of file and returns the extension
+// to store it with, and a reader over the whole content. The name the
+// client gave the file plays no part.
+func DetectImage(file io.Reader) (io.Reader, string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, "", err
+	}
+	head = head[:n]
+
+	extension, ok := imageExtensions[http.DetectContentType(head)]
+	if !ok {
+		return nil, "", ErrNotImage
+	}
+	return io.MultiReader(bytes.NewReader(head), file), extension, nil
+}
+
+// New builds the storage selected by cfg.Driver.
+func New(cfg Config.StorageConfig) (ImageStorage, error) {
+	switch cfg.Driver {
+	case "cloudinary":
+		return NewCloudinaryStorage(cfg.Cloudinary.CloudName, cfg.Cloudinary.APIKey, cfg.Cloudinary.APISecret)
+	case "local":
+		return NewLocalStorage(cfg.Local.Dir, cfg.Local.BaseURL, []byte(cfg.Local.Secret))
+	default:
+		return nil, errors.New("unknown storage driver " + cfg.Driver)
+	}
+}
@@ -0,0 +1,33 @@
+// Command mockoidc is a minimal OpenID Connect provider for trying social
+// login locally. It signs in whoever types an email on its login page, so
+// it must never be exposed publicly.
+//
+//	go run ./cmd/mockoidc -addr :9000 -client-id mock-client
+//
+// and configure the server with
+//
+//	OIDC_PROVIDERS=mock OIDC_MOCK_ISSUER=http://localhost:9000 OIDC_MOCK_CLIENT_ID=mock-client
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"Server/OIDC/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "address to listen on")
+	issuer := flag.String("issuer", "http://localhost:9000", "issuer URL clients reach this provider at")
+	clientID := flag.String("client-id", "mock-client", "client ID to accept")
+	flag.Parse()
+
+	p, err := oidctest.New(*issuer, *clientID)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("mock OIDC provider for client %s at %s", p.ClientID, p.Issuer)
+	log.Fatal(http.ListenAndServe(*addr, p.Handler()))
+}
@@ -0,0 +1,312 @@
+// Command mockpay is a minimal VNPay-style payment gateway for trying
+// online payment locally. It lets whoever opens its payment page pay or
+// cancel without any money involved, so it must never be exposed publicly.
+//
+//	go run ./cmd/mockpay -addr :8090 -tmn-code MOCKSHOP -hash-secret mock-secret
+//
+// and configure the server with
+//
+//	PAYMENT_DRIVER=vnpay VNPAY_TMN_CODE=MOCKSHOP VNPAY_HASH_SECRET=mock-secret
+//
+// After a payment it calls the IPN URL and sends the browser back to the
+// shop. -ipn-repeat sends the IPN more than once and -ipn-delay holds it
+// back so the return URL arrives first, to try out duplicate and
+// out-of-order callbacks.
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const timeFormat = "20060102150405"
+
+var location = time.FixedZone("GMT+7", 7*60*60)
+
+// Transaction statuses as VNPay reports them in vnp_TransactionStatus.
+const (
+	statusPending  = "01"
+	statusPaid     = "00"
+	statusFailed   = "02"
+	statusRefunded = "05"
+)
+
+type transaction struct {
+	amount        string
+	orderInfo     string
+	status        string
+	transactionNo string
+	payDate       string
+}
+
+type gateway struct {
+	tmnCode    string
+	hashSecret string
+	ipnURL     string
+	ipnRepeat  int
+	ipnDelay   time.Duration
+
+	mu           sync.Mutex
+	transactions map[string]*transaction
+	lastNo       int
+}
+
+var payPage = template.Must(template.New("pay").Parse(`<!doctype html>
+<title>Mock VNPay</title>
+<p>{{.OrderInfo}}</p>
+<p>Số tiền: <strong>{{.Amount}} VND</strong></p>
+<form method="post">
+  <button name="result" value="pay">Thanh toán</button>
+  <button name="result" value="cancel">Hủy giao dịch</button>
+</form>`))
+
+func (g *gateway) sign(data string) string {
+	mac := hmac.New(sha512.New, []byte(g.hashSecret))
+	mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signQuery signs every vnp_ parameter of query, sorted by name.
+func (g *gateway) signQuery(query url.Values) string {
+	signed := url.Values{}
+	for key, values := range query {
+		if strings.HasPrefix(key, "vnp_") && key != "vnp_SecureHash" && key != "vnp_SecureHashType" {
+			signed[key] = values
+		}
+	}
+	return g.sign(signed.Encode())
+}
+
+func randomID() string {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		log.Fatal(err)
+	}
+	return hex.EncodeToString(raw)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// pay shows the payment page and, once the customer decides, notifies the
+// shop and sends the browser back to it.
+func (g *gateway) pay(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("vnp_TmnCode") != g.tmnCode || !strings.EqualFold(g.signQuery(query), query.Get("vnp_SecureHash")) {
+		http.Error(w, "invalid signature", http.StatusBadRequest)
+		return
+	}
+	expires, err := time.ParseInLocation(timeFormat, query.Get("vnp_ExpireDate"), location)
+	if err != nil || time.Now().After(expires) {
+		http.Error(w, "payment expired", http.StatusBadRequest)
+		return
+	}
+	amount, err := strconv.ParseInt(query.Get("vnp_Amount"), 10, 64)
+	if err != nil || amount <= 0 {
+		http.Error(w, "invalid amount", http.StatusBadRequest)
+		return
+	}
+	txnRef := query.Get("vnp_TxnRef")
+
+	g.mu.Lock()
+	txn, ok := g.transactions[txnRef]
+	if !ok {
+		txn = &transaction{amount: query.Get("vnp_Amount"), orderInfo: query.Get("vnp_OrderInfo"), status: statusPending}
+		g.transactions[txnRef] = txn
+	}
+	done := txn.status != statusPending
+	g.mu.Unlock()
+	if done {
+		http.Error(w, "transaction already processed", http.StatusConflict)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		payPage.Execute(w, map[string]interface{}{"OrderInfo": query.Get("vnp_OrderInfo"), "Amount": amount / 100})
+		return
+	}
+
+	responseCode := "24"
+	g.mu.Lock()
+	txn.status = statusFailed
+	txn.transactionNo = "0"
+	if r.PostFormValue("result") == "pay" {
+		g.lastNo++
+		responseCode = "00"
+		txn.status = statusPaid
+		txn.transactionNo = strconv.Itoa(14000000 + g.lastNo)
+	}
+	txn.payDate = time.Now().In(location).Format(timeFormat)
+	callback := url.Values{
+		"vnp_Amount":            {txn.amount},
+		"vnp_BankCode":          {"NCB"},
+		"vnp_CardType":          {"ATM"},
+		"vnp_OrderInfo":         {txn.orderInfo},
+		"vnp_PayDate":           {txn.payDate},
+		"vnp_ResponseCode":      {responseCode},
+		"vnp_TmnCode":           {g.tmnCode},
+		"vnp_TransactionNo":     {txn.transactionNo},
+		"vnp_TransactionStatus": {txn.status},
+		"vnp_TxnRef":            {txnRef},
+	}
+	g.mu.Unlock()
+	callback.Set("vnp_SecureHash", g.signQuery(callback))
+
+	if g.ipnURL != "" {
+		go g.notify(callback)
+	}
+
+	target, err := url.Parse(query.Get("vnp_ReturnUrl"))
+	if err != nil {
+		http.Error(w, "invalid vnp_ReturnUrl", http.StatusBadRequest)
+		return
+	}
+	target.RawQuery = callback.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// notify sends the IPN for a finished transaction, as many times as asked.
+func (g *gateway) notify(callback url.Values) {
+	time.Sleep(g.ipnDelay)
+	for i := 0; i < g.ipnRepeat; i++ {
+		resp, err := http.Get(g.ipnURL + "?" + callback.Encode())
+		if err != nil {
+			log.Printf("IPN for %s: %v", callback.Get("vnp_TxnRef"), err)
+			continue
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		log.Printf("IPN for %s: %s %s", callback.Get("vnp_TxnRef"), resp.Status, strings.TrimSpace(string(body)))
+	}
+}
+
+// transaction serves the querydr and refund commands of the merchant API.
+func (g *gateway) transaction(w http.ResponseWriter, r *http.Request) {
+	var req map[string]string
+	if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&req) != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"vnp_ResponseCode": "03", "vnp_Message": "Invalid request format"})
+		return
+	}
+
+	var signedFields []string
+	switch req["vnp_Command"] {
+	case "querydr":
+		signedFields = []string{"vnp_RequestId", "vnp_Version", "vnp_Command", "vnp_TmnCode", "vnp_TxnRef",
+			"vnp_TransactionDate", "vnp_CreateDate", "vnp_IpAddr", "vnp_OrderInfo"}
+	case "refund":
+		signedFields = []string{"vnp_RequestId", "vnp_Version", "vnp_Command", "vnp_TmnCode", "vnp_TransactionType",
+			"vnp_TxnRef", "vnp_Amount", "vnp_TransactionNo", "vnp_TransactionDate", "vnp_CreateBy", "vnp_CreateDate",
+			"vnp_IpAddr", "vnp_OrderInfo"}
+	default:
+		writeJSON(w, http.StatusOK, g.response(req, "03", "Invalid command", nil))
+		return
+	}
+
+	values := make([]string, len(signedFields))
+	for i, field := range signedFields {
+		values[i] = req[field]
+	}
+	if req["vnp_TmnCode"] != g.tmnCode || !strings.EqualFold(g.sign(strings.Join(values, "|")), req["vnp_SecureHash"]) {
+		writeJSON(w, http.StatusOK, g.response(req, "97", "Invalid Checksum", nil))
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	txn, ok := g.transactions[req["vnp_TxnRef"]]
+	switch {
+	case !ok:
+		writeJSON(w, http.StatusOK, g.response(req, "91", "Transaction not found", nil))
+	case req["vnp_Command"] == "querydr":
+		writeJSON(w, http.StatusOK, g.response(req, "00", "Query success", txn))
+	case txn.status == statusRefunded:
+		writeJSON(w, http.StatusOK, g.response(req, "94", "Refund already requested", txn))
+	case txn.status != statusPaid || txn.transactionNo != req["vnp_TransactionNo"]:
+		writeJSON(w, http.StatusOK, g.response(req, "95", "Transaction was not successful", txn))
+	case req["vnp_Amount"] != txn.amount:
+		writeJSON(w, http.StatusOK, g.response(req, "03", "Invalid amount", txn))
+	default:
+		txn.status = statusRefunded
+		writeJSON(w, http.StatusOK, g.response(req, "00", "Refund success", txn))
+	}
+}
+
+// response builds a signed merchant API reply. Refund replies are signed
+// without the promotion fields.
+func (g *gateway) response(req map[string]string, code, message string, txn *transaction) map[string]string {
+	resp := map[string]string{
+		"vnp_ResponseId":   randomID(),
+		"vnp_Command":      req["vnp_Command"],
+		"vnp_ResponseCode": code,
+		"vnp_Message":      message,
+		"vnp_TmnCode":      g.tmnCode,
+		"vnp_TxnRef":       req["vnp_TxnRef"],
+	}
+	if txn != nil {
+		resp["vnp_Amount"] = txn.amount
+		resp["vnp_BankCode"] = "NCB"
+		resp["vnp_PayDate"] = txn.payDate
+		resp["vnp_TransactionNo"] = txn.transactionNo
+		resp["vnp_TransactionType"] = "01"
+		resp["vnp_TransactionStatus"] = txn.status
+		resp["vnp_OrderInfo"] = txn.orderInfo
+		if req["vnp_Command"] == "refund" {
+			resp["vnp_TransactionType"] = req["vnp_TransactionType"]
+		}
+	}
+
+	fields := []string{"vnp_ResponseId", "vnp_Command", "vnp_ResponseCode", "vnp_Message", "vnp_TmnCode",
+		"vnp_TxnRef", "vnp_Amount", "vnp_BankCode", "vnp_PayDate", "vnp_TransactionNo",
+		"vnp_TransactionType", "vnp_TransactionStatus", "vnp_OrderInfo"}
+	if req["vnp_Command"] == "querydr" {
+		fields = append(fields, "vnp_PromotionCode", "vnp_PromotionAmount")
+	}
+	values := make([]string, len(fields))
+	for i, field := range fields {
+		values[i] = resp[field]
+	}
+	resp["vnp_SecureHash"] = g.sign(strings.Join(values, "|"))
+	return resp
+}
+
+func main() {
+	addr := flag.String("addr", ":8090", "address to listen on")
+	tmnCode := flag.String("tmn-code", "MOCKSHOP", "merchant code to accept")
+	hashSecret := flag.String("hash-secret", "mock-secret", "secret shared with the shop")
+	ipnURL := flag.String("ipn-url", "http://localhost:8080/api/payments/vnpay/ipn", "shop URL to notify after a payment, empty to skip")
+	ipnRepeat := flag.Int("ipn-repeat", 1, "how many times to send each IPN")
+	ipnDelay := flag.Duration("ipn-delay", 0, "how long to wait before sending the IPN")
+	flag.Parse()
+
+	g := &gateway{
+		tmnCode:      *tmnCode,
+		hashSecret:   *hashSecret,
+		ipnURL:       *ipnURL,
+		ipnRepeat:    *ipnRepeat,
+		ipnDelay:     *ipnDelay,
+		transactions: map[string]*transaction{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/paymentv2/vpcpay.html", g.pay)
+	mux.HandleFunc("/merchant_webapi/api/transaction", g.transaction)
+
+	log.Printf("mock payment gateway for merchant %s at %s", g.tmnCode, *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}